@@ -119,6 +119,10 @@ const (
 	sockTCP socketType = "tcp"
 )
 
+// dnsReconcileInterval is how often resolv.conf is checked for the changes which were not
+// reported to the DNS monitor
+const dnsReconcileInterval = 5 * time.Minute
+
 func initializeStaticConfig(machineID uuid.UUID) config.StaticConfigManager {
 	staticCfgManager := config.NewFilesystemStaticConfigManager()
 	if err := staticCfgManager.SetRolloutGroup(remote.GenerateRolloutGroup(machineID)); err != nil {
@@ -297,7 +301,13 @@ func main() {
 		httpClientSimple,
 	)
 	gwret := netlinkrouter.Retriever{}
-	dnsSetter := dns.NewSetter(infoSubject)
+	dnsSetter, err := dns.NewSetter(infoSubject, dns.WithAnalytics(daemonEvents.Debugger.DebuggerEvents))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// events are published only with the consent, it can be withdrawn or given at runtime
+	_ = dnsSetter.NotifyAnalyticsConsent(cfg.AnalyticsConsent != config.ConsentDenied)
+	daemonEvents.Settings.AnalyticsConsent.Subscribe(dnsSetter.NotifyAnalyticsConsent)
 	dnsHostSetter := dns.NewHostsFileSetter(dns.HostsFilePath)
 
 	eventsDbPath := filepath.Join(internal.DatFilesPathCommon, "moose.db")
@@ -344,6 +354,14 @@ func main() {
 	httpCallsSubject.Subscribe(analytics.NotifyRequestAPI)
 	configEvents.Subscribe(analytics)
 	daemonEvents.Subscribe(analytics)
+	// detection runs external commands, so it must not delay the daemon start
+	go dnsSetter.DetectManagementService(context.Background())
+	daemonEvents.Settings.ThreatProtectionLite.Subscribe(dnsSetter.NotifyThreatProtectionLite)
+	// state is seeded from the config directly, so that it does not depend on when the settings
+	// are published relative to the subscription
+	_ = dnsSetter.NotifyThreatProtectionLite(cfg.AutoConnectData.ThreatProtectionLite)
+	dnsCtx, dnsCancel := context.WithCancel(context.Background())
+	go dnsSetter.Reconcile(dnsCtx, dnsReconcileInterval)
 
 	firstopen.RegisterNotifier(
 		fsystem,
@@ -763,6 +781,8 @@ func main() {
 			log.Println(internal.ErrorPrefix, "stopping KillSwitch:", err)
 		}
 	}
+	dnsCancel()
+	dnsSetter.Close()
 	if err := analytics.Stop(); err != nil {
 		log.Println(internal.ErrorPrefix, "stopping analytics:", err)
 	}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// addressFamily describes the IP versions which can be used to reach nameservers from the host
+type addressFamily int
+
+const (
+	unknownAddressFamily addressFamily = iota
+	ipv4AddressFamily
+	ipv6AddressFamily
+	dualStackAddressFamily
+)
+
+func (f addressFamily) String() string {
+	switch f {
+	case unknownAddressFamily:
+		return "unknown"
+	case ipv4AddressFamily:
+		return "ipv4"
+	case ipv6AddressFamily:
+		return "ipv6"
+	case dualStackAddressFamily:
+		return "dual_stack"
+	default:
+		return fmt.Sprintf("%d", int(f))
+	}
+}
+
+// addressFamily detects the IP versions usable on the host. IPv4 is usable when there is an IPv4
+// default route, IPv6 when it is enabled in the kernel.
+func (d *DefaultSetter) addressFamily() addressFamily {
+	ipv4, ipv6 := d.hasIPv4Route(), d.isIPv6Enabled()
+	switch {
+	case ipv4 && ipv6:
+		return dualStackAddressFamily
+	case ipv4:
+		return ipv4AddressFamily
+	case ipv6:
+		return ipv6AddressFamily
+	default:
+		return unknownAddressFamily
+	}
+}
+
+// hasIPv4DefaultRoute checks if any of the routing tables contains an IPv4 default route,
+// including the one through the VPN tunnel. The route is assumed to exist when routes can't be
+// listed, so that IPv4 nameservers are never rejected because of that.
+func hasIPv4DefaultRoute() bool {
+	routes, err := netlink.RouteListFiltered(
+		netlink.FAMILY_V4,
+		&netlink.Route{Table: unix.RT_TABLE_UNSPEC},
+		netlink.RT_FILTER_TABLE,
+	)
+	if err != nil {
+		return true
+	}
+	return slices.ContainsFunc(routes, isDefaultRoute)
+}
+
+func isDefaultRoute(route netlink.Route) bool {
+	if route.Dst == nil {
+		return true
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones == 0
+}
+
+// filterIPv6 returns only IPv6 nameservers
+func filterIPv6(nameservers []string) []string {
+	ipv6Nameservers := []string{}
+	for _, nameserver := range nameservers {
+		if !netip.MustParseAddr(nameserver).Unmap().Is4() {
+			ipv6Nameservers = append(ipv6Nameservers, nameserver)
+		}
+	}
+	return ipv6Nameservers
+}
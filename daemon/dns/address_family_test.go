@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func Test_AddressFamily(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		ipv4Route   bool
+		ipv6Enabled bool
+		expected    addressFamily
+	}{
+		{ipv4Route: true, ipv6Enabled: true, expected: dualStackAddressFamily},
+		{ipv4Route: true, ipv6Enabled: false, expected: ipv4AddressFamily},
+		{ipv4Route: false, ipv6Enabled: true, expected: ipv6AddressFamily},
+		{ipv4Route: false, ipv6Enabled: false, expected: unknownAddressFamily},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected.String(), func(t *testing.T) {
+			ds := newTestSetter(&mockAnalytics{})
+			ds.hasIPv4Route = func() bool { return test.ipv4Route }
+			ds.isIPv6Enabled = func() bool { return test.ipv6Enabled }
+			assert.Equal(t, test.expected, ds.addressFamily())
+		})
+	}
+}
+
+func Test_IsDefaultRoute(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	_, defaultDst, _ := net.ParseCIDR("0.0.0.0/0")
+	_, lanDst, _ := net.ParseCIDR("192.168.1.0/24")
+
+	assert.True(t, isDefaultRoute(netlink.Route{}))
+	assert.True(t, isDefaultRoute(netlink.Route{Dst: defaultDst}))
+	assert.False(t, isDefaultRoute(netlink.Route{Dst: lanDst}))
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// configurationAction describes what was done with the requested DNS configuration
+type configurationAction int
+
+const (
+	// appliedAction means that the configuration was written
+	appliedAction configurationAction = iota
+	// skippedAlreadyCorrectAction means that the configuration was already in place, so it was
+	// not written again
+	skippedAlreadyCorrectAction
+)
+
+func (a configurationAction) String() string {
+	switch a {
+	case appliedAction:
+		return "applied"
+	case skippedAlreadyCorrectAction:
+		return "skipped_already_correct"
+	default:
+		return fmt.Sprintf("%d", int(a))
+	}
+}
+
+// appliedChecker is implemented by the methods which can check if resolv.conf already contains
+// the configuration they would write
+type appliedChecker interface {
+	isApplied(content []byte, nameservers []string) bool
+}
+
+// isAlreadyApplied checks if the nameservers were set for the interface by the last Set and
+// resolv.conf still contains them, so that it is not rewritten needlessly. requested are the
+// nameservers passed to Set and nameservers are the ones which would be set.
+func (d *DefaultSetter) isAlreadyApplied(iface string, requested []string, nameservers []string) bool {
+	checker, ok := d.active.(appliedChecker)
+	if !ok || d.iface != iface || d.appliedNamespace != d.networkNamespace ||
+		!slices.Equal(d.nameservers, requested) || !slices.Equal(d.applied, nameservers) {
+		return false
+	}
+	path := d.monitor.filePath
+	if d.appliedNamespace != "" {
+		path = namespaceResolvConfPath(d.appliedNamespace)
+	}
+	scopeToNetworkNamespace(d.active, d.appliedNamespace)
+	content, err := internal.FileRead(path)
+	if err != nil {
+		d.logger.Debug("reading resolv.conf to check if dns is set:", err)
+		return false
+	}
+	return checker.isApplied(content, nameservers)
+}
+
+// isApplied checks if the content is the same as the one Set would write with the current
+// settings, e.g. search domains and option overrides, apart from the header
+func (m *ResolvConfFile) isApplied(content []byte, nameservers []string) bool {
+	if m.written == nil {
+		// resolv.conf was not written by the last Set
+		return false
+	}
+	original, err := m.originalContent()
+	if err != nil && m.appendMode {
+		return false
+	}
+	options := overrideResolvConfOptions(original, m.options, m.optionOverrides)
+	expected, _ := newResolvConfFileContent(original, nameservers, m.searchDomains, options, m.appendMode)
+	// the header is different on every write
+	return resolvConfBody(content) == expected
+}
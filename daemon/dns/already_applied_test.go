@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkingMethod reports the configuration as applied when resolv.conf contains the nameservers
+type checkingMethod struct {
+	recordingMethod
+}
+
+func (m *checkingMethod) isApplied(content []byte, nameservers []string) bool {
+	return sameNameservers(nameserversFromResolvConf(content), nameservers)
+}
+
+func Test_SetSkipsAlreadyAppliedConfiguration(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	calls := []string{}
+	method := &checkingMethod{recordingMethod{name: "method", calls: &calls}}
+	ds := newTestSetter(analytics, method)
+	ds.monitor = newTestMonitor(t, analytics)
+
+	result, err := ds.SetWithResult("nordlynx", testVPNNameservers)
+	require.NoError(t, err)
+	assert.False(t, result.Skipped)
+
+	// resolv.conf contains the nameservers already
+	result, err = ds.SetWithResult("nordlynx", testVPNNameservers)
+	require.NoError(t, err)
+	assert.True(t, result.Skipped)
+	assert.Equal(t, len(testVPNNameservers), result.ResolverCount)
+	assert.Equal(t, []string{"set method"}, calls)
+	require.Len(t, analytics.configuredEvents, 2)
+	assert.Equal(t, appliedAction, analytics.configuredEvents[0].action)
+	assert.Equal(t, skippedAlreadyCorrectAction, analytics.configuredEvents[1].action)
+
+	// different nameservers are written
+	require.NoError(t, ds.Set("nordlynx", []string{"103.86.96.96"}))
+	assert.Equal(t, []string{"set method", "set method"}, calls)
+
+	// configuration is re-applied when resolv.conf was changed
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	require.NoError(t, os.WriteFile(ds.monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	assert.Equal(t, []string{"set method", "set method", "set method", "set method"}, calls)
+}
+
+func Test_SetAlwaysWritesWhenReapplying(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	calls := []string{}
+	method := &checkingMethod{recordingMethod{name: "method", calls: &calls}}
+	ds := newTestSetter(analytics, method)
+	ds.monitor = newTestMonitor(t, analytics)
+
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	ds.reapplyResolvConf()
+	assert.Equal(t, []string{"set method", "set method"}, calls)
+}
+
+func Test_ResolvConfFileIsApplied(t *testing.T) {
+	category.Set(t, category.File)
+
+	nameservers := []string{"103.86.96.100", "103.86.99.100"}
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}}
+	original, err := originalResolvConf()
+	require.NoError(t, err)
+	content, written := newResolvConfFileContent(original, nameservers, nil, nil, false)
+	assert.False(t, file.isApplied([]byte(content), nameservers), "resolv.conf was not written by Set")
+
+	file.written = written
+	assert.True(t, file.isApplied([]byte(content), nameservers))
+	// header differs on every write
+	assert.True(t, file.isApplied([]byte(resolvConfHeader(time.Now(), unmanagedService)+content), nameservers))
+	assert.False(t, file.isApplied([]byte(strings.ReplaceAll(content, "103.86.99.100", "1.1.1.1")), nameservers))
+	// search domains were changed since the last Set
+	file.searchDomains = []string{"corp.example.com"}
+	assert.False(t, file.isApplied([]byte(content), nameservers))
+}
+
+func Test_ResolvConfFileIsAppliedWithOptionOverrides(t *testing.T) {
+	category.Set(t, category.File)
+	useTemporaryNetnsDirs(t)
+
+	path := namespaceResolvConfPath("vrf-blue")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte("nameserver 192.168.1.1\noptions ndots:1 edns0\n"), 0644))
+
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}, namespace: "vrf-blue"}
+	setter := newTestSetter(&mockAnalytics{}, file)
+	require.NoError(t, setter.SetResolvConfNdotsTimeout(5, 2))
+	require.NoError(t, file.Set("lo", testVPNNameservers))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "ndots:5")
+	assert.True(t, file.isApplied(content, testVPNNameservers))
+
+	// overrides were changed since the last Set
+	require.NoError(t, setter.SetResolvConfNdotsTimeout(3, 2))
+	assert.False(t, file.isApplied(content, testVPNNameservers))
+}
@@ -0,0 +1,1131 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/events"
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+const (
+	// dnsPrefix is used to mark DNS related log messages
+	dnsPrefix = "[DNS]"
+	subscope  = "dns"
+	// eventSchemaVersion is the version of the event payloads, it has to be bumped whenever
+	// fields are added, removed or change their meaning, so that the analytics backend can tell
+	// the payloads apart
+	eventSchemaVersion = 2
+	// maxContextPaths is the number of context paths of the biggest event
+	maxContextPaths = 27
+
+	debuggerEventBaseKey                 = "dns"
+	debuggerEventTypeKey                 = debuggerEventBaseKey + ".type"
+	debuggerEventManagementServiceKey    = debuggerEventBaseKey + ".management_service"
+	debuggerEventErrorTypeKey            = debuggerEventBaseKey + ".error_type"
+	debuggerEventCriticalKey             = debuggerEventBaseKey + ".critical"
+	debuggerEventOccurrencesKey          = debuggerEventBaseKey + ".occurrences"
+	debuggerEventSplitRoutingKey         = debuggerEventBaseKey + ".split_routing"
+	debuggerEventResolvedVersionKey      = debuggerEventBaseKey + ".resolved_version"
+	debuggerEventAppendModeKey           = debuggerEventBaseKey + ".append_mode"
+	debuggerEventExclusiveModeKey        = debuggerEventBaseKey + ".exclusive_mode"
+	debuggerEventDryRunKey               = debuggerEventBaseKey + ".dry_run"
+	debuggerEventRetryCountKey           = debuggerEventBaseKey + ".retry_count"
+	debuggerEventLinesAddedKey           = debuggerEventBaseKey + ".lines_added"
+	debuggerEventLinesRemovedKey         = debuggerEventBaseKey + ".lines_removed"
+	debuggerEventNameserversAddedKey     = debuggerEventBaseKey + ".nameservers_added"
+	debuggerEventNameserversRemovedKey   = debuggerEventBaseKey + ".nameservers_removed"
+	debuggerEventSearchDomainsChangedKey = debuggerEventBaseKey + ".search_domains_changed"
+	debuggerEventAddressFamilyKey        = debuggerEventBaseKey + ".address_family"
+	debuggerEventTimeoutKey              = debuggerEventBaseKey + ".timeout"
+	debuggerEventSearchDomainCountKey    = debuggerEventBaseKey + ".search_domain_count"
+	debuggerEventFallbackKey             = debuggerEventBaseKey + ".fallback"
+	debuggerEventThreatProtectionKey     = debuggerEventBaseKey + ".threat_protection"
+	debuggerEventSourceKey               = debuggerEventBaseKey + ".source"
+	debuggerEventResolversRequestedKey   = debuggerEventBaseKey + ".resolvers_requested"
+	debuggerEventResolversWrittenKey     = debuggerEventBaseKey + ".resolvers_written"
+	debuggerEventResolversUnreachableKey = debuggerEventBaseKey + ".resolvers_unreachable"
+	debuggerEventInterfaceIndexKey       = debuggerEventBaseKey + ".interface_index"
+	debuggerEventTriggerKey              = debuggerEventBaseKey + ".trigger"
+	debuggerEventActionKey               = debuggerEventBaseKey + ".action"
+	debuggerEventProfileKey              = debuggerEventBaseKey + ".profile"
+	debuggerEventEtcReadOnlyKey          = debuggerEventBaseKey + ".etc_readonly"
+	debuggerEventIPv6FallbackKey         = debuggerEventBaseKey + ".ipv6_fallback"
+	debuggerEventWriteModeKey            = debuggerEventBaseKey + ".write_mode"
+	debuggerEventNameserverOrderKey      = debuggerEventBaseKey + ".nameserver_order"
+	debuggerEventSampleRateKey           = debuggerEventBaseKey + ".sample_rate"
+	debuggerEventContainerManagedKey     = debuggerEventBaseKey + ".container_managed"
+	debuggerEventNetworkNamespaceKey     = debuggerEventBaseKey + ".network_namespace"
+	debuggerEventGlobalResolversKey      = debuggerEventBaseKey + ".global_resolvers"
+	debuggerEventFsnotifyOpKey           = debuggerEventBaseKey + ".fsnotify_op"
+	debuggerEventDNSSECKey               = debuggerEventBaseKey + ".dnssec"
+	debuggerEventCustomOptionsKey        = debuggerEventBaseKey + ".custom_options"
+	debuggerEventMirroredKey             = debuggerEventBaseKey + ".mirrored"
+	debuggerEventCacheFlushedKey         = debuggerEventBaseKey + ".cache_flushed"
+
+	// resolvConfFallback means that resolv.conf was written directly instead of using the DNS
+	// management service
+	resolvConfFallback = "resolv_conf"
+
+	// eventQueueSize is the number of events waiting to be published, after which the oldest
+	// events are dropped
+	eventQueueSize = 32
+	// defaultRateLimitWindow is the time during which identical resolvconf_overwritten events are
+	// coalesced into a single event
+	defaultRateLimitWindow = 30 * time.Second
+)
+
+// globalPaths defines the common context paths included in all DNS events. They are validated
+// once, when the package is initialized.
+var globalPaths = validContextPaths(defaultLogger{},
+	contextPath("device", contextPathWildcard),
+	contextPath("application", "nordvpnapp", "version"),
+	contextPath("application", "nordvpnapp", "platform"),
+	contextPath("application", "nordvpnapp", "config", "current_state", "is_on_vpn", "value"),
+)
+
+// eventType defines the type of DNS analytics event.
+type eventType int
+
+const (
+	dnsConfiguredEventType eventType = iota
+	dnsConfigurationErrorEventType
+	resolvConfOverwrittenEventType
+	// dnsDetectedEventType reports the service managing DNS detected at daemon start
+	dnsDetectedEventType
+)
+
+func (e eventType) String() string {
+	switch e {
+	case dnsConfiguredEventType:
+		return "dns_configured"
+	case dnsConfigurationErrorEventType:
+		return "dns_configuration_error"
+	case resolvConfOverwrittenEventType:
+		return "resolvconf_overwritten"
+	case dnsDetectedEventType:
+		return "dns_management_detected"
+	default:
+		return fmt.Sprintf("%d", int(e))
+	}
+}
+
+// errorType classifies the failure reported by the DNS configuration error event.
+type errorType int
+
+const (
+	setFailedErrorType errorType = iota
+	permissionDeniedErrorType
+	readOnlyFilesystemErrorType
+	// revertedToOriginalErrorType means that DNS configuration was restored to the pre-VPN
+	// state by a third party while connected
+	revertedToOriginalErrorType
+	// ipv6SetFailedErrorType means that only IPv4 nameservers were set, because setting IPv6
+	// nameservers failed
+	ipv6SetFailedErrorType
+	// dnsOverTLSUnsupportedErrorType means that DNS was set without DNS-over-TLS, because
+	// systemd-resolved does not support it
+	dnsOverTLSUnsupportedErrorType
+	// revertedAfterWriteErrorType means that resolv.conf did not contain the nameservers right
+	// after they were written
+	revertedAfterWriteErrorType
+	// leakDetectedErrorType means that DNS queries are not handled by the VPN nameservers
+	leakDetectedErrorType
+	// fileImmutableErrorType means that resolv.conf was not changed, because the immutable
+	// attribute was set on it by the user
+	fileImmutableErrorType
+	// detectionFailedErrorType means that none of the DNS handling methods is available
+	detectionFailedErrorType
+	// healthCheckFailedErrorType means that DNS did not respond to a few health checks in a row
+	healthCheckFailedErrorType
+	// reapplyLoopErrorType means that re-applying DNS was given up, because resolv.conf was
+	// overwritten right after every re-apply
+	reapplyLoopErrorType
+	// resolversTruncatedErrorType means that some of the nameservers were not written to
+	// resolv.conf, because glibc ignores the nameservers over the limit
+	resolversTruncatedErrorType
+	// watchFailedErrorType means that resolv.conf watcher failed, changes made by third parties
+	// may not be detected
+	watchFailedErrorType
+	// restoreMismatchErrorType means that the pre-VPN nameservers were not used after DNS was
+	// unset, even after unsetting it again
+	restoreMismatchErrorType
+	// resolverUnreachableErrorType means that some of the configured nameservers did not respond
+	// to the probe or refused the connection
+	resolverUnreachableErrorType
+	// watchLimitExceededErrorType means that resolv.conf can't be watched, because the inotify
+	// watch limit was reached, so it is polled instead
+	watchLimitExceededErrorType
+	// globalDNSConflictErrorType means that systemd-resolved has global nameservers configured,
+	// which can be queried instead of the nameservers set for the link
+	globalDNSConflictErrorType
+	// dnssecUnsupportedErrorType means that DNS was set without DNSSEC, because systemd-resolved
+	// does not support it
+	dnssecUnsupportedErrorType
+	// danglingResolvSymlinkErrorType means that resolv.conf was a symlink to a missing file, so
+	// it was recovered before being written
+	danglingResolvSymlinkErrorType
+	// cacheFlushFailedErrorType means that the DNS caches of the host were not flushed after DNS
+	// was set, so answers of the previous nameservers can still be returned
+	cacheFlushFailedErrorType
+	// searchLimitExceededErrorType means that some of the search domains were not written to
+	// resolv.conf, because glibc ignores the search domains over the limits
+	searchLimitExceededErrorType
+)
+
+func (e errorType) String() string {
+	switch e {
+	case setFailedErrorType:
+		return "set_failed"
+	case permissionDeniedErrorType:
+		return "permission_denied"
+	case readOnlyFilesystemErrorType:
+		return "read_only_filesystem"
+	case revertedToOriginalErrorType:
+		return "reverted_to_original"
+	case ipv6SetFailedErrorType:
+		return "ipv6_set_failed"
+	case dnsOverTLSUnsupportedErrorType:
+		return "dot_unsupported"
+	case revertedAfterWriteErrorType:
+		return "reverted_after_write"
+	case leakDetectedErrorType:
+		return "leak_detected"
+	case fileImmutableErrorType:
+		return "file_immutable"
+	case detectionFailedErrorType:
+		return "detection_failed"
+	case healthCheckFailedErrorType:
+		return "health_check_failed"
+	case reapplyLoopErrorType:
+		return "reapply_loop_detected"
+	case resolversTruncatedErrorType:
+		return "resolvers_truncated"
+	case watchFailedErrorType:
+		return "watch_failed"
+	case restoreMismatchErrorType:
+		return "restore_mismatch"
+	case resolverUnreachableErrorType:
+		return "resolver_unreachable"
+	case watchLimitExceededErrorType:
+		return "watch_limit_exceeded"
+	case globalDNSConflictErrorType:
+		return "global_dns_conflict"
+	case dnssecUnsupportedErrorType:
+		return "dnssec_unsupported"
+	case danglingResolvSymlinkErrorType:
+		return "dangling_resolv_symlink"
+	case cacheFlushFailedErrorType:
+		return "cache_flush_failed"
+	case searchLimitExceededErrorType:
+		return "search_limit_exceeded"
+	default:
+		return fmt.Sprintf("%d", int(e))
+	}
+}
+
+// errorTypeFromError classifies an error returned by a DNS handling method.
+func errorTypeFromError(err error) errorType {
+	var dnsErr *DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return dnsErr.Type
+	case errors.Is(err, errResolvConfImmutable):
+		return fileImmutableErrorType
+	case errors.Is(err, syscall.EROFS):
+		return readOnlyFilesystemErrorType
+	case errors.Is(err, fs.ErrPermission):
+		return permissionDeniedErrorType
+	default:
+		return setFailedErrorType
+	}
+}
+
+// dnsManagementService identifies the service responsible for DNS on the system.
+type dnsManagementService int
+
+const (
+	unknownService dnsManagementService = iota
+	systemdResolvedService
+	resolvconfService
+	// unmanagedService means that /etc/resolv.conf is edited directly by NordVPN
+	unmanagedService
+)
+
+func (s dnsManagementService) String() string {
+	switch s {
+	case unknownService:
+		return "unknown"
+	case systemdResolvedService:
+		return "systemd-resolved"
+	case resolvconfService:
+		return "resolvconf"
+	case unmanagedService:
+		return "unmanaged"
+	default:
+		return fmt.Sprintf("%d", int(s))
+	}
+}
+
+type event struct {
+	MessageNamespace  string `json:"namespace"`
+	Subscope          string `json:"subscope"`
+	SchemaVersion     int    `json:"schema_version"`
+	Event             string `json:"event"`
+	ManagementService string `json:"management_service"`
+	// resolvedVersion is reported only in the context paths, when DNS is managed by
+	// systemd-resolved
+	resolvedVersion string
+}
+
+func newEvent(namespace string, eventType eventType, service dnsManagementService) event {
+	return event{
+		MessageNamespace:  namespace,
+		Subscope:          subscope,
+		SchemaVersion:     eventSchemaVersion,
+		Event:             eventType.String(),
+		ManagementService: service.String(),
+	}
+}
+
+// newContextPaths returns an empty slice big enough for the context paths of any of the events,
+// so that building them does not reallocate it
+func newContextPaths() []events.ContextValue {
+	return make([]events.ContextValue, 0, maxContextPaths)
+}
+
+func (e event) toContextPaths() []events.ContextValue {
+	return e.appendContextPaths(newContextPaths())
+}
+
+func (e event) appendContextPaths(contextPaths []events.ContextValue) []events.ContextValue {
+	contextPaths = append(contextPaths,
+		events.ContextValue{Path: debuggerEventTypeKey, Value: e.Event},
+		events.ContextValue{Path: debuggerEventManagementServiceKey, Value: e.ManagementService},
+	)
+	if e.resolvedVersion != "" {
+		contextPaths = append(contextPaths,
+			events.ContextValue{Path: debuggerEventResolvedVersionKey, Value: e.resolvedVersion})
+	}
+	return contextPaths
+}
+
+func (e event) toDebuggerEvent() (*events.DebuggerEvent, error) {
+	return toDebuggerEvent(e, e.toContextPaths())
+}
+
+func (e event) toEventRecord(timestamp time.Time) EventRecord {
+	return EventRecord{
+		Type:              e.Event,
+		ManagementService: e.ManagementService,
+		Timestamp:         timestamp,
+	}
+}
+
+type errorEvent struct {
+	event
+	ErrorType string `json:"error_type"`
+	Critical  bool   `json:"critical"`
+	// RetryCount is the number of retries made before the error was reported
+	RetryCount int `json:"retry_count"`
+	// Timeout is true when the error was caused by the DNS management service not responding
+	Timeout bool `json:"timeout"`
+	// Fallback is the way DNS was set after the error, empty if it was not set
+	Fallback string `json:"fallback"`
+	// ResolversRequested and ResolversWritten are the numbers of nameservers which were requested
+	// and written when some of them were truncated
+	ResolversRequested int `json:"resolvers_requested"`
+	ResolversWritten   int `json:"resolvers_written"`
+
+	// ResolversUnreachable is the number of configured nameservers which did not respond
+	ResolversUnreachable int `json:"resolvers_unreachable"`
+	// GlobalResolvers is the number of global nameservers configured for systemd-resolved
+	GlobalResolvers int `json:"global_resolvers"`
+	// errorType is the type reported as ErrorType
+	errorType errorType
+}
+
+func newErrorEvent(namespace string, service dnsManagementService, errorType errorType, critical bool) errorEvent {
+	return errorEvent{
+		event:     newEvent(namespace, dnsConfigurationErrorEventType, service),
+		ErrorType: errorType.String(),
+		Critical:  critical,
+		errorType: errorType,
+	}
+}
+
+func (e errorEvent) toContextPaths() []events.ContextValue {
+	return append(e.event.appendContextPaths(newContextPaths()),
+		events.ContextValue{Path: debuggerEventErrorTypeKey, Value: e.ErrorType},
+		events.ContextValue{Path: debuggerEventCriticalKey, Value: e.Critical},
+		events.ContextValue{Path: debuggerEventRetryCountKey, Value: e.RetryCount},
+		events.ContextValue{Path: debuggerEventTimeoutKey, Value: e.Timeout},
+		events.ContextValue{Path: debuggerEventFallbackKey, Value: e.Fallback},
+		events.ContextValue{Path: debuggerEventResolversRequestedKey, Value: e.ResolversRequested},
+		events.ContextValue{Path: debuggerEventResolversWrittenKey, Value: e.ResolversWritten},
+		events.ContextValue{Path: debuggerEventResolversUnreachableKey, Value: e.ResolversUnreachable},
+		events.ContextValue{Path: debuggerEventGlobalResolversKey, Value: e.GlobalResolvers},
+	)
+}
+
+func (e errorEvent) toDebuggerEvent() (*events.DebuggerEvent, error) {
+	return toDebuggerEvent(e, e.toContextPaths())
+}
+
+func (e errorEvent) toEventRecord(timestamp time.Time) EventRecord {
+	record := e.event.toEventRecord(timestamp)
+	record.ErrorType = e.ErrorType
+	return record
+}
+
+// configurationDetails describes how DNS was configured
+type configurationDetails struct {
+	// splitRouting is true when only some of the domains are resolved by the VPN nameservers
+	splitRouting bool
+	// appendMode is true when the VPN nameservers were added to the pre-VPN ones
+	appendMode bool
+	// exclusiveMode is true when the nameservers of the other interfaces are ignored by resolvconf
+	exclusiveMode bool
+	// addressFamily are the IP versions usable on the host
+	addressFamily addressFamily
+	// searchDomainCount is the number of search domains set together with the nameservers
+	searchDomainCount int
+	// threatProtection is true when Threat Protection Lite nameservers were requested
+	threatProtection bool
+	// source describes where the nameservers came from
+	source nameserverSource
+	// interfaceIndex is the index of the link DNS was set for, 0 if it is not known
+	interfaceIndex int
+	// trigger describes why DNS was configured
+	trigger configurationTrigger
+	// action describes if the configuration was written
+	action configurationAction
+	// profile is the name of the applied profile, empty when DNS was not set from a profile
+	profile string
+	// etcReadOnly is true when resolv.conf is on a read-only mount, so it was not written directly
+	etcReadOnly bool
+	// ipv6Fallback is true when only the IPv4 nameservers were set, because IPv6 did not work
+	ipv6Fallback bool
+	// writeMode is the way resolv.conf was written, empty when it was not written directly
+	writeMode string
+	// nameserverOrder is the policy the nameservers were ordered with
+	nameserverOrder NameserverOrder
+	// containerManaged is true when resolv.conf is bind mounted by the container runtime
+	containerManaged bool
+	// networkNamespace is the named network namespace DNS was set in, empty for the namespace
+	// of the daemon
+	networkNamespace string
+	// dnssec is DNSSEC mode of the link, empty when it was not set
+	dnssec string
+	// customOptions is true when ndots or timeout set by the caller were written to resolv.conf
+	customOptions bool
+	// mirrored is true when the nameservers were written to resolv.conf in addition to
+	// systemd-resolved
+	mirrored bool
+	// cacheFlushed is true when the DNS caches of the host were flushed after DNS was set
+	cacheFlushed bool
+}
+
+type configuredEvent struct {
+	event
+	SplitRouting bool `json:"split_routing"`
+	AppendMode   bool `json:"append_mode"`
+	// ExclusiveMode is true when the nameservers of the other interfaces are ignored by resolvconf
+	ExclusiveMode bool `json:"exclusive_mode"`
+	// AddressFamily are the IP versions usable on the host
+	AddressFamily string `json:"address_family"`
+	// SearchDomainCount is the number of search domains set together with the nameservers
+	SearchDomainCount int `json:"search_domain_count"`
+	// ThreatProtection is true when Threat Protection Lite nameservers were requested
+	ThreatProtection bool `json:"threat_protection"`
+	// Source describes where the nameservers came from
+	Source string `json:"source"`
+	// InterfaceIndex is the index of the link DNS was set for, 0 if it is not known
+	InterfaceIndex int `json:"interface_index"`
+	// Trigger describes why DNS was configured, e.g. on connect or re-applied later
+	Trigger string `json:"trigger"`
+	// Action is skipped_already_correct when the configuration was not written, because it was
+	// already in place
+	Action string `json:"action"`
+	// Profile is the name of the applied profile, empty when DNS was not set from a profile
+	Profile string `json:"profile"`
+	// EtcReadOnly is true when resolv.conf is on a read-only mount, so it was not written directly
+	EtcReadOnly bool `json:"etc_readonly"`
+	// IPv6Fallback is true when only the IPv4 nameservers were set, because IPv6 did not work
+	IPv6Fallback bool `json:"ipv6_fallback"`
+	// WriteMode is the way resolv.conf was written, empty when it was not written directly
+	WriteMode string `json:"write_mode"`
+	// NameserverOrder is the policy the nameservers were ordered with
+	NameserverOrder string `json:"nameserver_order"`
+	// ContainerManaged is true when resolv.conf is bind mounted by the container runtime
+	ContainerManaged bool `json:"container_managed"`
+	// NetworkNamespace is the named network namespace DNS was set in, empty for the namespace
+	// of the daemon
+	NetworkNamespace string `json:"network_namespace"`
+	// DNSSEC is DNSSEC mode of the link, empty when it was not set, e.g. because DNS is not
+	// managed by systemd-resolved
+	DNSSEC string `json:"dnssec"`
+	// CustomOptions is true when ndots or timeout set by the caller were written to resolv.conf
+	CustomOptions bool `json:"custom_options"`
+	// Mirrored is true when the nameservers were written to resolv.conf in addition to
+	// systemd-resolved, for the applications which do not use its stub resolver
+	Mirrored bool `json:"mirrored"`
+	// CacheFlushed is true when the DNS caches of systemd-resolved or nscd were flushed after DNS
+	// was set
+	CacheFlushed bool `json:"cache_flushed"`
+	// SampleRate means that this event stands for SampleRate events, only one of them was
+	// published
+	SampleRate int `json:"sample_rate"`
+	// DryRun is true when the configuration was not applied
+	DryRun bool `json:"dry_run"`
+}
+
+func newConfiguredEvent(namespace string, service dnsManagementService, details configurationDetails) configuredEvent {
+	return configuredEvent{
+		event:             newEvent(namespace, dnsConfiguredEventType, service),
+		SplitRouting:      details.splitRouting,
+		AppendMode:        details.appendMode,
+		ExclusiveMode:     details.exclusiveMode,
+		AddressFamily:     details.addressFamily.String(),
+		SearchDomainCount: details.searchDomainCount,
+		ThreatProtection:  details.threatProtection,
+		Source:            details.source.String(),
+		InterfaceIndex:    details.interfaceIndex,
+		Trigger:           details.trigger.String(),
+		Action:            details.action.String(),
+		Profile:           details.profile,
+		EtcReadOnly:       details.etcReadOnly,
+		IPv6Fallback:      details.ipv6Fallback,
+		WriteMode:         details.writeMode,
+		NameserverOrder:   details.nameserverOrder.String(),
+		ContainerManaged:  details.containerManaged,
+		NetworkNamespace:  details.networkNamespace,
+		DNSSEC:            details.dnssec,
+		CustomOptions:     details.customOptions,
+		Mirrored:          details.mirrored,
+		CacheFlushed:      details.cacheFlushed,
+		SampleRate:        1,
+	}
+}
+
+func (e configuredEvent) toContextPaths() []events.ContextValue {
+	return append(e.event.appendContextPaths(newContextPaths()),
+		events.ContextValue{Path: debuggerEventSplitRoutingKey, Value: e.SplitRouting},
+		events.ContextValue{Path: debuggerEventAppendModeKey, Value: e.AppendMode},
+		events.ContextValue{Path: debuggerEventExclusiveModeKey, Value: e.ExclusiveMode},
+		events.ContextValue{Path: debuggerEventAddressFamilyKey, Value: e.AddressFamily},
+		events.ContextValue{Path: debuggerEventSearchDomainCountKey, Value: e.SearchDomainCount},
+		events.ContextValue{Path: debuggerEventThreatProtectionKey, Value: e.ThreatProtection},
+		events.ContextValue{Path: debuggerEventSourceKey, Value: e.Source},
+		events.ContextValue{Path: debuggerEventInterfaceIndexKey, Value: e.InterfaceIndex},
+		events.ContextValue{Path: debuggerEventTriggerKey, Value: e.Trigger},
+		events.ContextValue{Path: debuggerEventActionKey, Value: e.Action},
+		events.ContextValue{Path: debuggerEventProfileKey, Value: e.Profile},
+		events.ContextValue{Path: debuggerEventEtcReadOnlyKey, Value: e.EtcReadOnly},
+		events.ContextValue{Path: debuggerEventIPv6FallbackKey, Value: e.IPv6Fallback},
+		events.ContextValue{Path: debuggerEventWriteModeKey, Value: e.WriteMode},
+		events.ContextValue{Path: debuggerEventNameserverOrderKey, Value: e.NameserverOrder},
+		events.ContextValue{Path: debuggerEventContainerManagedKey, Value: e.ContainerManaged},
+		events.ContextValue{Path: debuggerEventNetworkNamespaceKey, Value: e.NetworkNamespace},
+		events.ContextValue{Path: debuggerEventDNSSECKey, Value: e.DNSSEC},
+		events.ContextValue{Path: debuggerEventCustomOptionsKey, Value: e.CustomOptions},
+		events.ContextValue{Path: debuggerEventMirroredKey, Value: e.Mirrored},
+		events.ContextValue{Path: debuggerEventCacheFlushedKey, Value: e.CacheFlushed},
+		events.ContextValue{Path: debuggerEventSampleRateKey, Value: e.SampleRate},
+		events.ContextValue{Path: debuggerEventDryRunKey, Value: e.DryRun},
+	)
+}
+
+func (e configuredEvent) toDebuggerEvent() (*events.DebuggerEvent, error) {
+	return toDebuggerEvent(e, e.toContextPaths())
+}
+
+// coalescedEvent is published in place of identical events reported within the rate limit window
+type coalescedEvent struct {
+	event
+	Occurrences int `json:"occurrences"`
+}
+
+func newCoalescedEvent(namespace string, eventType eventType, service dnsManagementService, occurrences int) coalescedEvent {
+	return coalescedEvent{
+		event:       newEvent(namespace, eventType, service),
+		Occurrences: occurrences,
+	}
+}
+
+func (e coalescedEvent) toContextPaths() []events.ContextValue {
+	return e.appendContextPaths(newContextPaths())
+}
+
+func (e coalescedEvent) appendContextPaths(contextPaths []events.ContextValue) []events.ContextValue {
+	return append(e.event.appendContextPaths(contextPaths),
+		events.ContextValue{Path: debuggerEventOccurrencesKey, Value: e.Occurrences},
+	)
+}
+
+func (e coalescedEvent) toDebuggerEvent() (*events.DebuggerEvent, error) {
+	return toDebuggerEvent(e, e.toContextPaths())
+}
+
+// overwrittenEvent reports changes of resolv.conf made by third parties. Diff and FsnotifyOp
+// describe the last change reported within the rate limit window.
+type overwrittenEvent struct {
+	coalescedEvent
+	resolvConfDiff
+	// FsnotifyOp is the file operation which triggered the event, e.g. WRITE or CHMOD, empty when
+	// the change was not reported by fsnotify
+	FsnotifyOp string `json:"fsnotify_op"`
+}
+
+func newOverwrittenEvent(
+	namespace string,
+	service dnsManagementService,
+	occurrences int,
+	overwrite resolvConfOverwrite,
+) overwrittenEvent {
+	return overwrittenEvent{
+		coalescedEvent: newCoalescedEvent(namespace, resolvConfOverwrittenEventType, service, occurrences),
+		resolvConfDiff: overwrite.diff,
+		FsnotifyOp:     overwrite.op,
+	}
+}
+
+// toContextPaths does not include the raw content, it is only available in the event payload
+func (e overwrittenEvent) toContextPaths() []events.ContextValue {
+	return append(e.coalescedEvent.appendContextPaths(newContextPaths()),
+		events.ContextValue{Path: debuggerEventLinesAddedKey, Value: e.LinesAdded},
+		events.ContextValue{Path: debuggerEventLinesRemovedKey, Value: e.LinesRemoved},
+		events.ContextValue{Path: debuggerEventNameserversAddedKey, Value: e.NameserversAdded},
+		events.ContextValue{Path: debuggerEventNameserversRemovedKey, Value: e.NameserversRemoved},
+		events.ContextValue{Path: debuggerEventSearchDomainsChangedKey, Value: e.SearchDomainsChanged},
+		events.ContextValue{Path: debuggerEventFsnotifyOpKey, Value: e.FsnotifyOp},
+	)
+}
+
+func (e overwrittenEvent) toDebuggerEvent() (*events.DebuggerEvent, error) {
+	return toDebuggerEvent(e, e.toContextPaths())
+}
+
+func toDebuggerEvent(payload any, contextPaths []events.ContextValue) (*events.DebuggerEvent, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling event: %w", err)
+	}
+	event := events.NewDebuggerEvent(string(jsonData)).WithGlobalContextPaths(globalPaths...)
+	// context paths are built for this event only, so they are not copied
+	event.KeyBasedContextPaths = contextPaths
+	return event, nil
+}
+
+// debuggerEventPayload is implemented by all of the DNS events
+type debuggerEventPayload interface {
+	toDebuggerEvent() (*events.DebuggerEvent, error)
+	toEventRecord(timestamp time.Time) EventRecord
+}
+
+// analytics reports the outcome of DNS configuration. Events are not published when the context
+// passed to the emit methods is canceled, e.g. during shutdown.
+type analytics interface {
+	setManagementService(dnsManagementService)
+	setMetrics(Metrics)
+	// setPublishing stops or resumes publishing of the events, e.g. when the user withdraws or
+	// gives the consent to analytics. The events are recorded in the history either way.
+	setPublishing(enabled bool)
+	// ManagementService returns the service which currently handles DNS
+	ManagementService() dnsManagementService
+	// OnManagementServiceChange registers the callback called after the management service
+	// changes
+	OnManagementServiceChange(callback managementServiceCallback)
+	emitDNSConfiguredEvent(ctx context.Context, details configurationDetails)
+	// emitDNSConfiguredDryRunEvent reports the configuration which would be applied by the
+	// management service
+	emitDNSConfiguredDryRunEvent(ctx context.Context, service dnsManagementService, details configurationDetails)
+	// emitDNSConfigurationErrorEvent reports the error, severity tells whether it is critical
+	emitDNSConfigurationErrorEvent(ctx context.Context, errorType errorType, severity errorSeverity)
+	// emitDNSSetFailedEvent reports the error after all of the retries to set DNS failed
+	emitDNSSetFailedEvent(ctx context.Context, err *DNSError, retryCount int)
+	// emitDNSFallbackEvent reports a non-critical error after DNS was set in the fallback way,
+	// because the management service failed
+	emitDNSFallbackEvent(ctx context.Context, errorType errorType, fallback string)
+	// emitResolversTruncatedEvent reports a non-critical error after only some of the requested
+	// nameservers were written
+	emitResolversTruncatedEvent(ctx context.Context, requested int, written int)
+	// emitResolversUnreachableEvent reports a non-critical error after some of the configured
+	// nameservers did not respond to the probe
+	emitResolversUnreachableEvent(ctx context.Context, count int)
+	// emitGlobalDNSConflictEvent reports a non-critical error after DNS was set with
+	// systemd-resolved, which has global nameservers configured as well
+	emitGlobalDNSConflictEvent(ctx context.Context, count int)
+	// emitResolvConfOverwrittenEvent reports a change of resolv.conf made by a third party, op is
+	// the file operation which triggered it
+	emitResolvConfOverwrittenEvent(ctx context.Context, op string, diff resolvConfDiff)
+	// emitDNSManagementDetectedEvent reports the current management service, unless it was
+	// already reported by the previous event
+	emitDNSManagementDetectedEvent(ctx context.Context)
+	// Snapshot returns the most recent events, from the oldest to the newest
+	Snapshot() []EventRecord
+	// DumpEvents writes the payloads of the most recent events as newline-delimited JSON, from
+	// the oldest to the newest
+	DumpEvents(w io.Writer) error
+	// LastError returns the most recent error event, ok is false if no error event was emitted
+	LastError() (errType errorType, critical bool, emittedAt time.Time, ok bool)
+	// Close stops publishing the events
+	Close()
+}
+
+// managementServiceCallback is called with the previous and the current management service
+type managementServiceCallback func(old dnsManagementService, new dnsManagementService)
+
+// notifyManagementServiceChange calls the callbacks if the service has changed. It must be
+// called without holding any locks, because the callbacks can call back into the package.
+func notifyManagementServiceChange(
+	callbacks []managementServiceCallback,
+	old dnsManagementService,
+	new dnsManagementService,
+) {
+	if old == new {
+		return
+	}
+	for _, callback := range callbacks {
+		callback(old, new)
+	}
+}
+
+// dnsAnalytics publishes events from a separate goroutine, so that a slow publisher never
+// blocks DNS configuration or the resolv.conf monitor
+type dnsAnalytics struct {
+	debugPublisher events.Publisher[events.DebuggerEvent]
+	logger         Logger
+	// namespace is the message namespace of the published events
+	namespace string
+	clock     clock
+	// resolvedVersion returns systemd-resolved version, it is detected only once
+	resolvedVersion   func() string
+	managementService dnsManagementService
+	// serviceCallbacks are called after the management service changes
+	serviceCallbacks []managementServiceCallback
+	// detectedService is the service reported by the last dns_management_detected event, valid
+	// only when isDetectedReported is set
+	detectedService    dnsManagementService
+	isDetectedReported bool
+	// metrics are incremented from the same data as the published events, so they never diverge
+	metrics         Metrics
+	queue           chan events.DebuggerEvent
+	rateLimitWindow time.Duration
+	// occurrences counts rate limited events reported in the current window
+	occurrences map[rateLimitKey]int
+	// lastOverwrites are the last resolv.conf changes reported in the current window
+	lastOverwrites map[rateLimitKey]resolvConfOverwrite
+	// history keeps the most recent events for diagnostics
+	history *eventHistory
+	// sampleRate makes only 1 in sampleRate dns_configured events published, the error events are
+	// never sampled out
+	sampleRate int
+	// configuredCount is the number of dns_configured events emitted, used for sampling
+	configuredCount int
+	// lastError is the most recent error event, valid only when hasLastError is set
+	lastError    lastError
+	hasLastError bool
+	// publishingDisabled is set when the user did not consent to analytics, the events are only
+	// recorded in the history then
+	publishingDisabled bool
+	// done is closed by Close to stop the goroutines publishing the events
+	done   chan struct{}
+	closed bool
+	// workers are the goroutines publishing the queued and the rate limited events
+	workers sync.WaitGroup
+	mu      sync.Mutex
+}
+
+// lastError describes the most recent error event
+type lastError struct {
+	errorType errorType
+	critical  bool
+	emittedAt time.Time
+}
+
+// rateLimitKey identifies events which are considered identical by the rate limiter
+type rateLimitKey struct {
+	eventType         eventType
+	managementService dnsManagementService
+}
+
+func newDNSAnalytics(debugPublisher events.Publisher[events.DebuggerEvent], logger Logger) *dnsAnalytics {
+	return newDNSAnalyticsWithNamespace(debugPublisher, logger, internal.DebugEventMessageNamespace)
+}
+
+// newDNSAnalyticsWithNamespace creates analytics which publish events in the given message
+// namespace, so that differently branded builds can report them separately
+func newDNSAnalyticsWithNamespace(
+	debugPublisher events.Publisher[events.DebuggerEvent],
+	logger Logger,
+	namespace string,
+) *dnsAnalytics {
+	d := &dnsAnalytics{
+		debugPublisher:    debugPublisher,
+		namespace:         namespace,
+		logger:            logger,
+		clock:             realClock{},
+		resolvedVersion:   detectedResolvedVersion,
+		managementService: unknownService,
+		metrics:           noopMetrics{},
+		queue:             make(chan events.DebuggerEvent, eventQueueSize),
+		rateLimitWindow:   defaultRateLimitWindow,
+		occurrences:       map[rateLimitKey]int{},
+		lastOverwrites:    map[rateLimitKey]resolvConfOverwrite{},
+		history:           newEventHistory(eventHistorySize),
+		sampleRate:        1,
+		done:              make(chan struct{}),
+	}
+	d.workers.Add(1)
+	go d.publishQueued()
+	return d
+}
+
+// Close stops publishing the events and waits until the goroutines publishing them return. The
+// events which are still queued or rate limited are dropped.
+func (d *dnsAnalytics) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.done)
+	}
+	d.mu.Unlock()
+	d.workers.Wait()
+}
+
+func (d *dnsAnalytics) setManagementService(service dnsManagementService) {
+	d.mu.Lock()
+	old := d.managementService
+	d.managementService = service
+	callbacks := slices.Clone(d.serviceCallbacks)
+	d.mu.Unlock()
+	notifyManagementServiceChange(callbacks, old, service)
+}
+
+func (d *dnsAnalytics) OnManagementServiceChange(callback managementServiceCallback) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.serviceCallbacks = append(d.serviceCallbacks, callback)
+}
+
+func (d *dnsAnalytics) setMetrics(metrics Metrics) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.metrics = metrics
+}
+
+func (d *dnsAnalytics) setPublishing(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.publishingDisabled = !enabled
+}
+
+func (d *dnsAnalytics) isPublishing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.publishingDisabled
+}
+
+func (d *dnsAnalytics) getMetrics() Metrics {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.metrics
+}
+
+func (d *dnsAnalytics) ManagementService() dnsManagementService {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.managementService
+}
+
+func (d *dnsAnalytics) emitDNSConfiguredEvent(ctx context.Context, details configurationDetails) {
+	if d.canceled(ctx) {
+		return
+	}
+	d.getMetrics().IncCounter(dnsConfiguredTotal, nil)
+	sampleRate, sampled := d.sample()
+	if !sampled {
+		return
+	}
+	service := d.ManagementService()
+	event := newConfiguredEvent(d.namespace, service, details)
+	event.SampleRate = sampleRate
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publish(event)
+}
+
+// sample decides if the dns_configured event is published, the first one of every sampleRate
+// events is. Returns the sample rate the event is published with.
+func (d *dnsAnalytics) sample() (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	count := d.configuredCount
+	d.configuredCount++
+	return d.sampleRate, count%d.sampleRate == 0
+}
+
+func (d *dnsAnalytics) emitDNSConfiguredDryRunEvent(
+	ctx context.Context,
+	service dnsManagementService,
+	details configurationDetails,
+) {
+	if d.canceled(ctx) {
+		return
+	}
+	event := newConfiguredEvent(d.namespace, service, details)
+	event.DryRun = true
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publish(event)
+}
+
+func (d *dnsAnalytics) emitDNSConfigurationErrorEvent(
+	ctx context.Context,
+	errorType errorType,
+	severity errorSeverity,
+) {
+	if d.canceled(ctx) {
+		return
+	}
+	service := d.ManagementService()
+	event := newErrorEvent(d.namespace, service, errorType, severity.critical(errorType, service))
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publishError(event)
+}
+
+func (d *dnsAnalytics) emitDNSSetFailedEvent(ctx context.Context, err *DNSError, retryCount int) {
+	if d.canceled(ctx) {
+		return
+	}
+	event := newErrorEvent(d.namespace, err.ManagementService, err.Type, err.Critical)
+	event.RetryCount = retryCount
+	event.Timeout = err.Timeout
+	event.resolvedVersion = d.resolvedVersionFor(err.ManagementService)
+	d.publishError(event)
+}
+
+func (d *dnsAnalytics) emitDNSFallbackEvent(ctx context.Context, errorType errorType, fallback string) {
+	if d.canceled(ctx) {
+		return
+	}
+	service := d.ManagementService()
+	// DNS was set in the fallback way
+	event := newErrorEvent(d.namespace, service, errorType, false)
+	event.Fallback = fallback
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publishError(event)
+}
+
+func (d *dnsAnalytics) emitResolversTruncatedEvent(ctx context.Context, requested int, written int) {
+	if d.canceled(ctx) {
+		return
+	}
+	service := d.ManagementService()
+	event := newErrorEvent(d.namespace, service, resolversTruncatedErrorType, false)
+	event.ResolversRequested = requested
+	event.ResolversWritten = written
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publishError(event)
+}
+
+func (d *dnsAnalytics) emitResolversUnreachableEvent(ctx context.Context, count int) {
+	if d.canceled(ctx) {
+		return
+	}
+	service := d.ManagementService()
+	event := newErrorEvent(d.namespace, service, resolverUnreachableErrorType, false)
+	event.ResolversUnreachable = count
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publishError(event)
+}
+
+func (d *dnsAnalytics) emitGlobalDNSConflictEvent(ctx context.Context, count int) {
+	if d.canceled(ctx) {
+		return
+	}
+	service := d.ManagementService()
+	event := newErrorEvent(d.namespace, service, globalDNSConflictErrorType, false)
+	event.GlobalResolvers = count
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publishError(event)
+}
+
+// publishError counts the error event and records it as the last error before publishing it
+func (d *dnsAnalytics) publishError(event errorEvent) {
+	d.getMetrics().IncCounter(dnsErrorsTotal, errorLabels(event))
+	d.mu.Lock()
+	d.lastError = lastError{errorType: event.errorType, critical: event.Critical, emittedAt: d.clock.Now()}
+	d.hasLastError = true
+	d.mu.Unlock()
+	d.publish(event)
+}
+
+// LastError returns the most recent error event, so that it can be shown in the status without
+// parsing the logs. ok is false if no error event was emitted.
+func (d *dnsAnalytics) LastError() (errType errorType, critical bool, emittedAt time.Time, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.hasLastError {
+		return 0, false, time.Time{}, false
+	}
+	return d.lastError.errorType, d.lastError.critical, d.lastError.emittedAt, true
+}
+
+func (d *dnsAnalytics) emitDNSManagementDetectedEvent(ctx context.Context) {
+	if d.canceled(ctx) {
+		return
+	}
+	d.mu.Lock()
+	service := d.managementService
+	if d.isDetectedReported && d.detectedService == service {
+		d.mu.Unlock()
+		d.logger.Debug("management service did not change, detection not reported:", service)
+		return
+	}
+	d.detectedService, d.isDetectedReported = service, true
+	d.mu.Unlock()
+
+	event := newEvent(d.namespace, dnsDetectedEventType, service)
+	event.resolvedVersion = d.resolvedVersionFor(service)
+	d.publish(event)
+}
+
+func (d *dnsAnalytics) Snapshot() []EventRecord {
+	return d.history.snapshot()
+}
+
+func (d *dnsAnalytics) DumpEvents(w io.Writer) error {
+	return d.history.dump(w)
+}
+
+// canceled checks if the event should be dropped, because its context was canceled
+func (d *dnsAnalytics) canceled(ctx context.Context) bool {
+	if err := ctx.Err(); err != nil {
+		d.logger.Debug("event not published:", err)
+		return true
+	}
+	return false
+}
+
+// resolvedVersionFor returns systemd-resolved version if it manages DNS or empty string otherwise
+func (d *dnsAnalytics) resolvedVersionFor(service dnsManagementService) string {
+	if service != systemdResolvedService {
+		return ""
+	}
+	return d.resolvedVersion()
+}
+
+// emitResolvConfOverwrittenEvent is rate limited, because some systems rewrite resolv.conf every
+// few seconds. Events reported within the window are published as a single event with the diff
+// of the last change when it closes.
+func (d *dnsAnalytics) emitResolvConfOverwrittenEvent(ctx context.Context, op string, diff resolvConfDiff) {
+	if d.canceled(ctx) {
+		return
+	}
+	// every overwrite is counted, including the ones coalesced into a single event. The metrics
+	// sink is called without holding the lock, because it is external code.
+	d.getMetrics().IncCounter(dnsOverwritesTotal, nil)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := rateLimitKey{
+		eventType:         resolvConfOverwrittenEventType,
+		managementService: d.managementService,
+	}
+	d.lastOverwrites[key] = resolvConfOverwrite{op: op, diff: diff}
+	d.rateLimit(key)
+}
+
+// rateLimit counts the event and opens a new window if there is none for the given key. Must be
+// called with d.mu locked.
+func (d *dnsAnalytics) rateLimit(key rateLimitKey) {
+	d.occurrences[key]++
+	if d.occurrences[key] > 1 || d.closed {
+		return
+	}
+	timer := d.clock.NewTimer(d.rateLimitWindow)
+	d.workers.Add(1)
+	go func() {
+		defer d.workers.Done()
+		select {
+		case <-timer.C():
+			d.closeWindow(key)
+		case <-d.done:
+			timer.Stop()
+		}
+	}()
+}
+
+func (d *dnsAnalytics) closeWindow(key rateLimitKey) {
+	d.mu.Lock()
+	occurrences := d.occurrences[key]
+	overwrite := d.lastOverwrites[key]
+	delete(d.occurrences, key)
+	delete(d.lastOverwrites, key)
+	d.mu.Unlock()
+
+	if occurrences == 0 {
+		return
+	}
+	d.publish(newOverwrittenEvent(d.namespace, key.managementService, occurrences, overwrite))
+}
+
+// publish creates the debugger event and queues it without blocking. When the queue is full, the oldest event is dropped.
+func (d *dnsAnalytics) publish(payload debuggerEventPayload) {
+	event, err := payload.toDebuggerEvent()
+	jsonData := ""
+	if err == nil {
+		jsonData = event.JsonData
+	}
+	d.history.add(payload.toEventRecord(d.clock.Now()), jsonData)
+	if err != nil {
+		d.logger.Error("failed to create event:", err)
+		return
+	}
+	select {
+	case <-d.done:
+		// analytics were closed, the event is only kept in the history
+		return
+	default:
+	}
+	if !d.isPublishing() {
+		// user did not consent to analytics, the event is only kept in the history
+		return
+	}
+	d.logger.Debug("publishing event:", event.JsonData)
+	for {
+		select {
+		case d.queue <- *event:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-d.queue:
+			d.logger.Warn("event queue is full, dropping event:", dropped.JsonData)
+		default:
+		}
+	}
+}
+
+func (d *dnsAnalytics) publishQueued() {
+	defer d.workers.Done()
+	for {
+		select {
+		case event := <-d.queue:
+			// the events queued before the consent was withdrawn are not published either
+			if d.isPublishing() {
+				d.debugPublisher.Publish(event)
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/NordSecurity/nordvpn-linux/events"
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// EventCatalog describes all of the DNS analytics events, so that analytics backend schemas
+// can be kept in sync with the events published by the app.
+type EventCatalog struct {
+	Namespace          string              `json:"namespace"`
+	Subscope           string              `json:"subscope"`
+	SchemaVersion      int                 `json:"schema_version"`
+	Events             []EventDefinition   `json:"events"`
+	Enums              map[string][]string `json:"enums"`
+	GlobalContextPaths []string            `json:"global_context_paths"`
+}
+
+// EventDefinition describes a single DNS analytics event type.
+type EventDefinition struct {
+	Event        string   `json:"event"`
+	Fields       []string `json:"fields"`
+	ContextPaths []string `json:"context_paths"`
+}
+
+type contextPathsProvider interface {
+	toContextPaths() []events.ContextValue
+}
+
+// eventPayload returns an example payload published for the given event type, which includes
+// all of the optional fields and context paths
+func eventPayload(eventType eventType) contextPathsProvider {
+	switch eventType {
+	case dnsConfiguredEventType:
+		event := newConfiguredEvent(internal.DebugEventMessageNamespace, systemdResolvedService, configurationDetails{})
+		event.resolvedVersion = unknownResolvedVersion
+		return event
+	case dnsConfigurationErrorEventType:
+		event := newErrorEvent(internal.DebugEventMessageNamespace, systemdResolvedService, setFailedErrorType, false)
+		event.Fallback = resolvConfFallback
+		event.ResolversRequested = maxResolvConfNameservers + 1
+		event.ResolversWritten = maxResolvConfNameservers
+		event.ResolversUnreachable = 1
+		event.GlobalResolvers = 1
+		event.resolvedVersion = unknownResolvedVersion
+		return event
+	case dnsDetectedEventType:
+		event := newEvent(internal.DebugEventMessageNamespace, eventType, systemdResolvedService)
+		event.resolvedVersion = unknownResolvedVersion
+		return event
+	case resolvConfOverwrittenEventType:
+		return newOverwrittenEvent(internal.DebugEventMessageNamespace, unknownService, 1, resolvConfOverwrite{
+			op:   "WRITE",
+			diff: resolvConfDiff{PreviousContent: "-", Content: "-"},
+		})
+	default:
+		return newEvent(internal.DebugEventMessageNamespace, eventType, unknownService)
+	}
+}
+
+// NewEventCatalog builds the catalog from the event definitions used in the code.
+func NewEventCatalog() EventCatalog {
+	catalog := EventCatalog{
+		Namespace:     internal.DebugEventMessageNamespace,
+		Subscope:      subscope,
+		SchemaVersion: eventSchemaVersion,
+		Events:        []EventDefinition{},
+		Enums: map[string][]string{
+			"event":              enumValues[eventType](),
+			"error_type":         enumValues[errorType](),
+			"management_service": enumValues[dnsManagementService](),
+			"address_family":     enumValues[addressFamily](),
+			"source":             enumValues[nameserverSource](),
+			"trigger":            enumValues[configurationTrigger](),
+			"action":             enumValues[configurationAction](),
+			"write_mode":         enumValues[ResolvConfWriteMode](),
+			"nameserver_order":   enumValues[NameserverOrder](),
+			"dnssec":             enumValues[DNSSECMode](),
+		},
+		GlobalContextPaths: globalPaths,
+	}
+
+	for _, t := range enumMembers[eventType]() {
+		payload := eventPayload(t)
+		definition := EventDefinition{
+			Event:        t.String(),
+			Fields:       jsonFields(reflect.TypeOf(payload)),
+			ContextPaths: []string{},
+		}
+		for _, value := range payload.toContextPaths() {
+			definition.ContextPaths = append(definition.ContextPaths, value.Path)
+		}
+		catalog.Events = append(catalog.Events, definition)
+	}
+	return catalog
+}
+
+// EventCatalogJSON returns the DNS analytics event catalog in JSON format.
+func EventCatalogJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(NewEventCatalog(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshaling dns event catalog: %w", err)
+	}
+	return data, nil
+}
+
+type enum interface {
+	~int
+	fmt.Stringer
+}
+
+// enumMembers lists all of the enum values. Enums are expected to be defined with iota, so the
+// first value without a name marks the end of the enum.
+func enumMembers[T enum]() []T {
+	members := []T{}
+	for value := T(0); value.String() != strconv.Itoa(int(value)); value++ {
+		members = append(members, value)
+	}
+	return members
+}
+
+// enumValues lists string representations of all of the enum values.
+func enumValues[T enum]() []string {
+	values := []string{}
+	for _, member := range enumMembers[T]() {
+		values = append(values, member.String())
+	}
+	return values
+}
+
+// jsonFields returns names of the fields serialized to JSON, including embedded structs fields
+func jsonFields(t reflect.Type) []string {
+	fields := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			fields = append(fields, jsonFields(field.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
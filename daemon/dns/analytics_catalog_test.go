@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EventCatalog(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	data, err := EventCatalogJSON()
+	require.NoError(t, err)
+
+	var catalog EventCatalog
+	require.NoError(t, json.Unmarshal(data, &catalog))
+
+	assert.Equal(t, internal.DebugEventMessageNamespace, catalog.Namespace)
+	assert.Equal(t, subscope, catalog.Subscope)
+	assert.Equal(t, eventSchemaVersion, catalog.SchemaVersion)
+	assert.Equal(t, globalPaths, catalog.GlobalContextPaths)
+
+	baseFields := []string{"namespace", "subscope", "schema_version", "event", "management_service"}
+	baseContextPaths := []string{debuggerEventTypeKey, debuggerEventManagementServiceKey}
+	assert.Equal(t, []EventDefinition{
+		{
+			Event: "dns_configured",
+			Fields: append(baseFields, "split_routing", "append_mode", "exclusive_mode", "address_family",
+				"search_domain_count", "threat_protection", "source", "interface_index", "trigger", "action",
+				"profile", "etc_readonly", "ipv6_fallback", "write_mode", "nameserver_order",
+				"container_managed", "network_namespace", "dnssec", "custom_options", "mirrored",
+				"cache_flushed", "sample_rate", "dry_run"),
+			ContextPaths: append(baseContextPaths, debuggerEventResolvedVersionKey,
+				debuggerEventSplitRoutingKey, debuggerEventAppendModeKey, debuggerEventExclusiveModeKey,
+				debuggerEventAddressFamilyKey, debuggerEventSearchDomainCountKey,
+				debuggerEventThreatProtectionKey, debuggerEventSourceKey, debuggerEventInterfaceIndexKey,
+				debuggerEventTriggerKey, debuggerEventActionKey, debuggerEventProfileKey,
+				debuggerEventEtcReadOnlyKey, debuggerEventIPv6FallbackKey, debuggerEventWriteModeKey,
+				debuggerEventNameserverOrderKey,
+				debuggerEventContainerManagedKey, debuggerEventNetworkNamespaceKey, debuggerEventDNSSECKey,
+				debuggerEventCustomOptionsKey, debuggerEventMirroredKey, debuggerEventCacheFlushedKey,
+				debuggerEventSampleRateKey, debuggerEventDryRunKey),
+		},
+		{
+			Event: "dns_configuration_error",
+			Fields: append(baseFields, "error_type", "critical", "retry_count", "timeout", "fallback",
+				"resolvers_requested", "resolvers_written", "resolvers_unreachable", "global_resolvers"),
+			ContextPaths: append(baseContextPaths, debuggerEventResolvedVersionKey,
+				debuggerEventErrorTypeKey, debuggerEventCriticalKey, debuggerEventRetryCountKey,
+				debuggerEventTimeoutKey, debuggerEventFallbackKey, debuggerEventResolversRequestedKey,
+				debuggerEventResolversWrittenKey, debuggerEventResolversUnreachableKey,
+				debuggerEventGlobalResolversKey),
+		},
+		{
+			Event: "resolvconf_overwritten",
+			Fields: append(baseFields, "occurrences", "lines_added", "lines_removed",
+				"nameservers_added", "nameservers_removed", "search_domains_changed",
+				"previous_content", "content", "fsnotify_op"),
+			ContextPaths: append(baseContextPaths, debuggerEventOccurrencesKey,
+				debuggerEventLinesAddedKey, debuggerEventLinesRemovedKey,
+				debuggerEventNameserversAddedKey, debuggerEventNameserversRemovedKey,
+				debuggerEventSearchDomainsChangedKey, debuggerEventFsnotifyOpKey),
+		},
+		{
+			Event:        "dns_management_detected",
+			Fields:       baseFields,
+			ContextPaths: append(baseContextPaths, debuggerEventResolvedVersionKey),
+		},
+	}, catalog.Events)
+
+	assert.Equal(t, []string{
+		"set_failed",
+		"permission_denied",
+		"read_only_filesystem",
+		"reverted_to_original",
+		"ipv6_set_failed",
+		"dot_unsupported",
+		"reverted_after_write",
+		"leak_detected",
+		"file_immutable",
+		"detection_failed",
+		"health_check_failed",
+		"reapply_loop_detected",
+		"resolvers_truncated",
+		"watch_failed",
+		"restore_mismatch",
+		"resolver_unreachable",
+		"watch_limit_exceeded",
+		"global_dns_conflict",
+		"dnssec_unsupported",
+		"dangling_resolv_symlink",
+		"cache_flush_failed",
+		"search_limit_exceeded",
+	}, catalog.Enums["error_type"])
+	assert.Equal(t, []string{
+		"unknown",
+		"systemd-resolved",
+		"resolvconf",
+		"unmanaged",
+	}, catalog.Enums["management_service"])
+	assert.Equal(t, []string{
+		"unknown",
+		"ipv4",
+		"ipv6",
+		"dual_stack",
+	}, catalog.Enums["address_family"])
+	assert.Equal(t, []string{
+		"requested",
+		"env_override",
+	}, catalog.Enums["source"])
+	assert.Equal(t, []string{
+		"connect",
+		"reapply",
+		"reconcile",
+		"refresh",
+		"profile",
+	}, catalog.Enums["trigger"])
+	assert.Equal(t, []string{
+		"applied",
+		"skipped_already_correct",
+	}, catalog.Enums["action"])
+	assert.Equal(t, []string{"atomic_rename", "in_place"}, catalog.Enums["write_mode"])
+	assert.Equal(t, []string{"preserve", "ipv6_first", "ipv4_first"}, catalog.Enums["nameserver_order"])
+	assert.Equal(t, []string{"allow-downgrade", "yes"}, catalog.Enums["dnssec"])
+}
+
+func Test_EventCatalogMatchesPublishedEvents(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	catalog := NewEventCatalog()
+	for _, definition := range catalog.Events {
+		t.Run(definition.Event, func(t *testing.T) {
+			payload := eventPayload(eventTypeByName(t, definition.Event))
+			data, err := json.Marshal(payload)
+			require.NoError(t, err)
+
+			var published map[string]any
+			require.NoError(t, json.Unmarshal(data, &published))
+			assert.Len(t, published, len(definition.Fields))
+			for _, field := range definition.Fields {
+				assert.Contains(t, published, field)
+			}
+		})
+	}
+}
+
+func Test_EventSchemaVersionPinsFields(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	// fingerprints of the payload fields by the schema version, when the test fails bump
+	// eventSchemaVersion and pin the new fingerprint to it
+	fingerprints := map[int]string{
+		2: "75c59a88eee9a97a2ebef72f39a8748359bd57ae675675ba8f159779739e6488",
+	}
+
+	hash := sha256.New()
+	for _, definition := range NewEventCatalog().Events {
+		hash.Write([]byte(definition.Event + ":" + strings.Join(definition.Fields, ",") + "\n"))
+	}
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+	for version, pinned := range fingerprints {
+		if version != eventSchemaVersion {
+			assert.NotEqual(t, pinned, fingerprint, "fields match schema version %d", version)
+		}
+	}
+	assert.Equal(t, fingerprints[eventSchemaVersion], fingerprint,
+		"payload fields changed without bumping eventSchemaVersion")
+}
+
+func eventTypeByName(t *testing.T, name string) eventType {
+	t.Helper()
+	for _, member := range enumMembers[eventType]() {
+		if member.String() == name {
+			return member
+		}
+	}
+	t.Fatalf("unknown event type %s", name)
+	return 0
+}
+
+func Test_EventContextPathsFitPresizedSlice(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	for _, eventType := range enumMembers[eventType]() {
+		assert.LessOrEqual(t, len(eventPayload(eventType).toContextPaths()), maxContextPaths, eventType.String())
+	}
+}
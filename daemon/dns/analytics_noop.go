@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// noopAnalytics is used when analytics are disabled by the user. No events are created or
+// published, only the management service and the last error are tracked, because DNS handling
+// and the status depend on them. The events are still recorded in the history, which never leaves
+// the host, so that they can be included in diagnostic archives.
+type noopAnalytics struct {
+	managementService dnsManagementService
+	serviceCallbacks  []managementServiceCallback
+	history           *eventHistory
+	clock             clock
+	// lastError is the most recent error event, valid only when hasLastError is set
+	lastError    lastError
+	hasLastError bool
+	mu           sync.Mutex
+}
+
+func newNoopAnalytics() *noopAnalytics {
+	return &noopAnalytics{
+		managementService: unknownService,
+		history:           newEventHistory(eventHistorySize),
+		clock:             realClock{},
+	}
+}
+
+func (n *noopAnalytics) setManagementService(service dnsManagementService) {
+	n.mu.Lock()
+	old := n.managementService
+	n.managementService = service
+	callbacks := slices.Clone(n.serviceCallbacks)
+	n.mu.Unlock()
+	notifyManagementServiceChange(callbacks, old, service)
+}
+
+func (n *noopAnalytics) OnManagementServiceChange(callback managementServiceCallback) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.serviceCallbacks = append(n.serviceCallbacks, callback)
+}
+
+func (n *noopAnalytics) ManagementService() dnsManagementService {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.managementService
+}
+
+func (*noopAnalytics) setMetrics(Metrics) {}
+
+func (*noopAnalytics) setPublishing(bool) {}
+
+func (n *noopAnalytics) emitDNSConfiguredEvent(ctx context.Context, _ configurationDetails) {
+	n.record(ctx, dnsConfiguredEventType, n.ManagementService(), "")
+}
+
+func (n *noopAnalytics) emitDNSConfiguredDryRunEvent(
+	ctx context.Context,
+	service dnsManagementService,
+	_ configurationDetails,
+) {
+	n.record(ctx, dnsConfiguredEventType, service, "")
+}
+
+func (n *noopAnalytics) emitDNSConfigurationErrorEvent(
+	ctx context.Context,
+	errorType errorType,
+	severity errorSeverity,
+) {
+	service := n.ManagementService()
+	n.recordError(ctx, service, errorType, severity.critical(errorType, service))
+}
+
+func (n *noopAnalytics) emitDNSSetFailedEvent(ctx context.Context, err *DNSError, _ int) {
+	n.recordError(ctx, err.ManagementService, err.Type, err.Critical)
+}
+
+func (n *noopAnalytics) emitDNSFallbackEvent(ctx context.Context, errorType errorType, _ string) {
+	service := n.ManagementService()
+	n.recordError(ctx, service, errorType, false)
+}
+
+func (n *noopAnalytics) emitResolversTruncatedEvent(ctx context.Context, _ int, _ int) {
+	n.recordError(ctx, n.ManagementService(), resolversTruncatedErrorType, false)
+}
+
+func (n *noopAnalytics) emitResolversUnreachableEvent(ctx context.Context, _ int) {
+	n.recordError(ctx, n.ManagementService(), resolverUnreachableErrorType, false)
+}
+
+func (n *noopAnalytics) emitGlobalDNSConflictEvent(ctx context.Context, _ int) {
+	n.recordError(ctx, n.ManagementService(), globalDNSConflictErrorType, false)
+}
+
+func (n *noopAnalytics) emitResolvConfOverwrittenEvent(ctx context.Context, _ string, _ resolvConfDiff) {
+	n.record(ctx, resolvConfOverwrittenEventType, n.ManagementService(), "")
+}
+
+func (n *noopAnalytics) emitDNSManagementDetectedEvent(ctx context.Context) {
+	n.record(ctx, dnsDetectedEventType, n.ManagementService(), "")
+}
+
+func (n *noopAnalytics) Snapshot() []EventRecord {
+	return n.history.snapshot()
+}
+
+// DumpEvents writes the recorded events as newline-delimited JSON, there are no event payloads
+// when analytics are disabled
+func (n *noopAnalytics) DumpEvents(w io.Writer) error {
+	return n.history.dump(w)
+}
+
+func (n *noopAnalytics) LastError() (errType errorType, critical bool, emittedAt time.Time, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.hasLastError {
+		return 0, false, time.Time{}, false
+	}
+	return n.lastError.errorType, n.lastError.critical, n.lastError.emittedAt, true
+}
+
+// Close does nothing, the events are not published
+func (*noopAnalytics) Close() {}
+
+// recordError records the error event and keeps it as the last error
+func (n *noopAnalytics) recordError(
+	ctx context.Context,
+	service dnsManagementService,
+	errorType errorType,
+	critical bool,
+) {
+	if ctx.Err() != nil {
+		return
+	}
+	n.mu.Lock()
+	n.lastError = lastError{errorType: errorType, critical: critical, emittedAt: n.clock.Now()}
+	n.hasLastError = true
+	n.mu.Unlock()
+	n.record(ctx, dnsConfigurationErrorEventType, service, errorType.String())
+}
+
+// record adds the event to the history, errorType is empty for the events which are not errors.
+// Events with canceled context are dropped, the same as when analytics are enabled.
+func (n *noopAnalytics) record(
+	ctx context.Context,
+	eventType eventType,
+	service dnsManagementService,
+	errorType string,
+) {
+	if ctx.Err() != nil {
+		return
+	}
+	record := EventRecord{
+		Type:              eventType.String(),
+		ManagementService: service.String(),
+		ErrorType:         errorType,
+		Timestamp:         n.clock.Now(),
+	}
+	payload := ""
+	if data, err := json.Marshal(record); err == nil {
+		payload = string(data)
+	}
+	n.history.add(record, payload)
+}
@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NoopAnalyticsMonitor(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := newNoopAnalytics()
+	monitor := newTestMonitor(t, analytics)
+	reapplied := make(chan struct{}, 1)
+	monitor.setReapply(func() { reapplied <- struct{}{} })
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+
+	// change is handled the same way, but it is only recorded in the history
+	select {
+	case <-reapplied:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dns was not re-applied")
+	}
+	records := analytics.Snapshot()
+	require.Len(t, records, 1)
+	assert.Equal(t, resolvConfOverwrittenEventType.String(), records[0].Type)
+}
+
+func Test_NoopAnalyticsSetter(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ds := newTestSetter(&mockAnalytics{}, &MockMethod{err: errors.New("set failed")}, &MockMethod{})
+	analytics := newNoopAnalytics()
+	clock := newFakeClock()
+	analytics.clock = clock
+	ds.analytics = analytics
+	ds.retries = 0
+
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	assert.Equal(t, unknownService.String(), ds.ManagementService())
+	ds.analytics.emitDNSConfigurationErrorEvent(context.Background(), leakDetectedErrorType, severityByType)
+
+	// recent events are kept for diagnostics even when analytics are disabled
+	expected := []EventRecord{
+		{Type: "dns_configured", ManagementService: "unknown", Timestamp: clock.Now()},
+		{
+			Type:              "dns_configuration_error",
+			ManagementService: "unknown",
+			ErrorType:         leakDetectedErrorType.String(),
+			Timestamp:         clock.Now(),
+		},
+	}
+	assert.Equal(t, expected, ds.RecentEvents())
+
+	var dump bytes.Buffer
+	require.NoError(t, ds.DumpEvents(&dump))
+	lines := strings.Split(strings.TrimSpace(dump.String()), "\n")
+	require.Len(t, lines, 2)
+	var record EventRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, expected[1], record)
+}
@@ -0,0 +1,1401 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/events"
+	"github.com/NordSecurity/nordvpn-linux/internal"
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockErrorEvent struct {
+	errorType  errorType
+	critical   bool
+	retryCount int
+	timeout    bool
+	fallback   string
+	// resolversRequested and resolversWritten are set for truncated nameservers
+	resolversRequested int
+	resolversWritten   int
+	// resolversUnreachable is set for nameservers which did not respond to the probe
+	resolversUnreachable int
+	// globalResolvers is set for the global nameservers of systemd-resolved
+	globalResolvers int
+}
+
+type mockAnalytics struct {
+	managementService dnsManagementService
+	configuredEvents  []configurationDetails
+	dryRunEvents      []dnsManagementService
+	errorEvents       []mockErrorEvent
+	overwrittenEvents []resolvConfDiff
+	// publishingDisabled is set by setPublishing
+	publishingDisabled bool
+	// overwriteOps are the fsnotify operations of overwrittenEvents
+	overwriteOps []string
+	// detectedEvents are the management services reported as detected
+	detectedEvents   []dnsManagementService
+	serviceCallbacks []managementServiceCallback
+	// emitted is notified about every emitted event
+	emitted chan struct{}
+	mu      sync.Mutex
+}
+
+// notify must be called with m.mu locked
+func (m *mockAnalytics) notify() {
+	if m.emitted == nil {
+		m.emitted = make(chan struct{}, 100)
+	}
+	select {
+	case m.emitted <- struct{}{}:
+	default:
+	}
+}
+
+// waitForEvent waits until any event is emitted
+func (m *mockAnalytics) waitForEvent(t *testing.T) {
+	t.Helper()
+	m.mu.Lock()
+	if m.emitted == nil {
+		m.emitted = make(chan struct{}, 100)
+	}
+	emitted := m.emitted
+	m.mu.Unlock()
+
+	select {
+	case <-emitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not emitted")
+	}
+}
+
+func (m *mockAnalytics) setManagementService(service dnsManagementService) {
+	m.mu.Lock()
+	old := m.managementService
+	m.managementService = service
+	callbacks := slices.Clone(m.serviceCallbacks)
+	m.mu.Unlock()
+	notifyManagementServiceChange(callbacks, old, service)
+}
+
+func (m *mockAnalytics) OnManagementServiceChange(callback managementServiceCallback) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.serviceCallbacks = append(m.serviceCallbacks, callback)
+}
+
+func (m *mockAnalytics) setMetrics(Metrics) {}
+
+func (m *mockAnalytics) setPublishing(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.publishingDisabled = !enabled
+}
+
+func (m *mockAnalytics) ManagementService() dnsManagementService {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.managementService
+}
+
+func (m *mockAnalytics) emitDNSConfiguredEvent(ctx context.Context, details configurationDetails) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configuredEvents = append(m.configuredEvents, details)
+	m.notify()
+}
+
+func (m *mockAnalytics) emitDNSConfiguredDryRunEvent(ctx context.Context, service dnsManagementService, details configurationDetails) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dryRunEvents = append(m.dryRunEvents, service)
+	m.notify()
+}
+
+func (m *mockAnalytics) emitDNSConfigurationErrorEvent(ctx context.Context, errorType errorType, severity errorSeverity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorEvents = append(m.errorEvents, mockErrorEvent{
+		errorType: errorType,
+		critical:  severity.critical(errorType, m.managementService),
+	})
+	m.notify()
+}
+
+func (m *mockAnalytics) emitDNSSetFailedEvent(ctx context.Context, err *DNSError, retryCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorEvents = append(m.errorEvents,
+		mockErrorEvent{errorType: err.Type, critical: err.Critical, retryCount: retryCount, timeout: err.Timeout})
+	m.notify()
+}
+
+func (m *mockAnalytics) emitDNSFallbackEvent(ctx context.Context, errorType errorType, fallback string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorEvents = append(m.errorEvents,
+		mockErrorEvent{errorType: errorType, critical: false, fallback: fallback})
+	m.notify()
+}
+
+func (m *mockAnalytics) emitResolversTruncatedEvent(ctx context.Context, requested int, written int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorEvents = append(m.errorEvents, mockErrorEvent{
+		errorType:          resolversTruncatedErrorType,
+		resolversRequested: requested,
+		resolversWritten:   written,
+	})
+	m.notify()
+}
+
+func (m *mockAnalytics) emitResolversUnreachableEvent(ctx context.Context, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorEvents = append(m.errorEvents, mockErrorEvent{
+		errorType:            resolverUnreachableErrorType,
+		resolversUnreachable: count,
+	})
+	m.notify()
+}
+
+func (m *mockAnalytics) emitGlobalDNSConflictEvent(ctx context.Context, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorEvents = append(m.errorEvents, mockErrorEvent{
+		errorType:       globalDNSConflictErrorType,
+		globalResolvers: count,
+	})
+	m.notify()
+}
+
+func (m *mockAnalytics) emitResolvConfOverwrittenEvent(ctx context.Context, op string, diff resolvConfDiff) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.overwrittenEvents = append(m.overwrittenEvents, diff)
+	m.overwriteOps = append(m.overwriteOps, op)
+	m.notify()
+}
+
+func (m *mockAnalytics) emitDNSManagementDetectedEvent(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.detectedEvents = append(m.detectedEvents, m.managementService)
+	m.notify()
+}
+
+func (m *mockAnalytics) Snapshot() []EventRecord { return nil }
+
+func (m *mockAnalytics) DumpEvents(io.Writer) error { return nil }
+
+func (m *mockAnalytics) LastError() (errorType, bool, time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.errorEvents) == 0 {
+		return 0, false, time.Time{}, false
+	}
+	last := m.errorEvents[len(m.errorEvents)-1]
+	return last.errorType, last.critical, time.Time{}, true
+}
+
+func (*mockAnalytics) Close() {}
+
+func (m *mockAnalytics) getOverwriteOps() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.overwriteOps)
+}
+
+func (m *mockAnalytics) getOverwrittenEvents() []resolvConfDiff {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.overwrittenEvents)
+}
+
+func (m *mockAnalytics) getErrorEvents() []mockErrorEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.errorEvents)
+}
+
+// newTestDNSAnalytics creates analytics which are closed when the test finishes
+func newTestDNSAnalytics(
+	t *testing.T,
+	debugPublisher events.Publisher[events.DebuggerEvent],
+	logger Logger,
+) *dnsAnalytics {
+	return newTestDNSAnalyticsWithNamespace(t, debugPublisher, logger, internal.DebugEventMessageNamespace)
+}
+
+func newTestDNSAnalyticsWithNamespace(
+	t *testing.T,
+	debugPublisher events.Publisher[events.DebuggerEvent],
+	logger Logger,
+	namespace string,
+) *dnsAnalytics {
+	t.Helper()
+	analytics := newDNSAnalyticsWithNamespace(debugPublisher, logger, namespace)
+	t.Cleanup(analytics.Close)
+	return analytics
+}
+
+type mockDebuggerPublisher struct {
+	events []events.DebuggerEvent
+	mu     sync.Mutex
+}
+
+func (m *mockDebuggerPublisher) Publish(event events.DebuggerEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+}
+
+// waitForEvents waits until count events are published and returns them
+func (m *mockDebuggerPublisher) waitForEvents(t *testing.T, count int) []events.DebuggerEvent {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return len(m.events) >= count
+	}, time.Second, time.Millisecond)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	require.Len(t, m.events, count)
+	return slices.Clone(m.events)
+}
+
+// blockingDebuggerPublisher blocks on publishing until it is released
+type blockingDebuggerPublisher struct {
+	published chan events.DebuggerEvent
+	release   chan struct{}
+}
+
+func (b *blockingDebuggerPublisher) Publish(event events.DebuggerEvent) {
+	b.published <- event
+	<-b.release
+}
+
+type mockLogMessage struct {
+	level   string
+	message string
+}
+
+type mockLogger struct {
+	messages []mockLogMessage
+	mu       sync.Mutex
+}
+
+func (m *mockLogger) log(level string, v []any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, mockLogMessage{level: level, message: fmt.Sprintln(v...)})
+}
+
+func (m *mockLogger) Debug(v ...any) { m.log("debug", v) }
+func (m *mockLogger) Info(v ...any)  { m.log("info", v) }
+func (m *mockLogger) Warn(v ...any)  { m.log("warn", v) }
+func (m *mockLogger) Error(v ...any) { m.log("error", v) }
+
+func (m *mockLogger) getMessages() []mockLogMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.messages)
+}
+
+func contextValue(t *testing.T, event events.DebuggerEvent, path string) any {
+	t.Helper()
+	for _, value := range event.KeyBasedContextPaths {
+		if value.Path == path {
+			return value.Value
+		}
+	}
+	t.Fatalf("context path %s not found", path)
+	return nil
+}
+
+func Test_ManagementService(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+	assert.Equal(t, unknownService, analytics.ManagementService())
+
+	var wg sync.WaitGroup
+	for _, service := range enumMembers[dnsManagementService]() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			analytics.setManagementService(service)
+			_ = analytics.ManagementService()
+		}()
+	}
+	wg.Wait()
+
+	analytics.setManagementService(resolvconfService)
+	assert.Equal(t, resolvconfService, analytics.ManagementService())
+	analytics.setManagementService(unmanagedService)
+	assert.Equal(t, unmanagedService, analytics.ManagementService())
+}
+
+func Test_OnManagementServiceChange(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	type transition struct {
+		old dnsManagementService
+		new dnsManagementService
+	}
+	tests := []struct {
+		name      string
+		analytics analytics
+	}{
+		{name: "analytics enabled", analytics: newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})},
+		{name: "analytics disabled", analytics: newNoopAnalytics()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transitions := []transition{}
+			test.analytics.OnManagementServiceChange(func(old, new dnsManagementService) {
+				// calling back must not deadlock
+				assert.Equal(t, new, test.analytics.ManagementService())
+				transitions = append(transitions, transition{old: old, new: new})
+			})
+
+			test.analytics.setManagementService(systemdResolvedService)
+			test.analytics.setManagementService(systemdResolvedService)
+			test.analytics.setManagementService(unmanagedService)
+			test.analytics.setManagementService(unmanagedService)
+
+			assert.Equal(t, []transition{
+				{old: unknownService, new: systemdResolvedService},
+				{old: systemdResolvedService, new: unmanagedService},
+			}, transitions)
+		})
+	}
+}
+
+func Test_emitDNSConfiguredEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.resolvedVersion = func() string { return "255" }
+	analytics.setManagementService(systemdResolvedService)
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{
+		splitRouting:      true,
+		addressFamily:     dualStackAddressFamily,
+		searchDomainCount: 2,
+	})
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, map[string]any{
+		"namespace":           internal.DebugEventMessageNamespace,
+		"subscope":            "dns",
+		"schema_version":      float64(2),
+		"event":               "dns_configured",
+		"management_service":  "systemd-resolved",
+		"split_routing":       true,
+		"append_mode":         false,
+		"exclusive_mode":      false,
+		"address_family":      "dual_stack",
+		"search_domain_count": float64(2),
+		"threat_protection":   false,
+		"source":              "requested",
+		"interface_index":     float64(0),
+		"trigger":             "connect",
+		"action":              "applied",
+		"profile":             "",
+		"etc_readonly":        false,
+		"ipv6_fallback":       false,
+		"write_mode":          "",
+		"nameserver_order":    "preserve",
+		"container_managed":   false,
+		"network_namespace":   "",
+		"dnssec":              "",
+		"custom_options":      false,
+		"mirrored":            false,
+		"cache_flushed":       false,
+		"sample_rate":         float64(1),
+		"dry_run":             false,
+	}, payload)
+
+	assert.Equal(t, "dns_configured", contextValue(t, event, debuggerEventTypeKey))
+	assert.Equal(t, "systemd-resolved", contextValue(t, event, debuggerEventManagementServiceKey))
+	assert.Equal(t, true, contextValue(t, event, debuggerEventSplitRoutingKey))
+	assert.Equal(t, "255", contextValue(t, event, debuggerEventResolvedVersionKey))
+	assert.Equal(t, "dual_stack", contextValue(t, event, debuggerEventAddressFamilyKey))
+	assert.Equal(t, 2, contextValue(t, event, debuggerEventSearchDomainCountKey))
+	assert.Equal(t, "requested", contextValue(t, event, debuggerEventSourceKey))
+	assert.Equal(t, "connect", contextValue(t, event, debuggerEventTriggerKey))
+	assert.Equal(t, false, contextValue(t, event, debuggerEventDryRunKey))
+}
+
+func Test_emitDNSConfiguredEventSampled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.sampleRate = 10
+	for i := 0; i < 100; i++ {
+		analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+		if i%20 == 0 {
+			analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+		}
+	}
+
+	configured, errorEvents := 0, 0
+	for _, event := range publisher.waitForEvents(t, 15) {
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+		switch payload["event"] {
+		case "dns_configured":
+			configured++
+			assert.Equal(t, float64(10), payload["sample_rate"])
+			assert.Equal(t, 10, contextValue(t, event, debuggerEventSampleRateKey))
+		case "dns_configuration_error":
+			errorEvents++
+		}
+	}
+	assert.Equal(t, 10, configured)
+	// errors are never sampled out
+	assert.Equal(t, 5, errorEvents)
+}
+
+func Test_emitDNSConfiguredEventThreatProtection(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	for _, enabled := range []bool{true, false} {
+		publisher := &mockDebuggerPublisher{}
+		analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+		analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{threatProtection: enabled})
+
+		event := publisher.waitForEvents(t, 1)[0]
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+		assert.Equal(t, enabled, payload["threat_protection"])
+		assert.Equal(t, enabled, contextValue(t, event, debuggerEventThreatProtectionKey))
+	}
+}
+
+func Test_AnalyticsWithCustomNamespace(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalyticsWithNamespace(t, publisher, defaultLogger{}, "custom-namespace")
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+	analytics.emitDNSManagementDetectedEvent(context.Background())
+
+	for _, event := range publisher.waitForEvents(t, 3) {
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+		assert.Equal(t, "custom-namespace", payload["namespace"])
+		assert.Equal(t, "dns", payload["subscope"])
+	}
+}
+
+func Test_emitDNSConfiguredDryRunEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.setManagementService(systemdResolvedService)
+	analytics.emitDNSConfiguredDryRunEvent(context.Background(), unmanagedService, configurationDetails{appendMode: true})
+
+	event := publisher.waitForEvents(t, 1)[0]
+	assert.Equal(t, true, contextValue(t, event, debuggerEventDryRunKey))
+	assert.Equal(t, true, contextValue(t, event, debuggerEventAppendModeKey))
+	assert.Equal(t, "unmanaged", contextValue(t, event, debuggerEventManagementServiceKey))
+	assert.Equal(t, systemdResolvedService, analytics.ManagementService(),
+		"dry run must not change the management service")
+	assert.Equal(t, globalPaths, event.GeneralContextPaths)
+}
+
+func Test_emitDNSManagementDetectedEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.resolvedVersion = func() string { return "255" }
+	analytics.setManagementService(systemdResolvedService)
+	analytics.emitDNSManagementDetectedEvent(context.Background())
+	analytics.setManagementService(unmanagedService)
+	analytics.emitDNSManagementDetectedEvent(context.Background())
+
+	published := publisher.waitForEvents(t, 2)
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(published[0].JsonData), &payload))
+	assert.Equal(t, map[string]any{
+		"namespace":          internal.DebugEventMessageNamespace,
+		"subscope":           "dns",
+		"schema_version":     float64(2),
+		"event":              "dns_management_detected",
+		"management_service": "systemd-resolved",
+	}, payload)
+	assert.Equal(t, "dns_management_detected", contextValue(t, published[0], debuggerEventTypeKey))
+	assert.Equal(t, "systemd-resolved", contextValue(t, published[0], debuggerEventManagementServiceKey))
+	assert.Equal(t, "255", contextValue(t, published[0], debuggerEventResolvedVersionKey))
+	assert.Equal(t, globalPaths, published[0].GeneralContextPaths)
+
+	assert.Equal(t, "unmanaged", contextValue(t, published[1], debuggerEventManagementServiceKey))
+	for _, value := range published[1].KeyBasedContextPaths {
+		assert.NotEqual(t, debuggerEventResolvedVersionKey, value.Path,
+			"version is reported only for systemd-resolved")
+	}
+}
+
+func Test_emitDNSManagementDetectedEventDeduplicated(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	for i := 0; i < 3; i++ {
+		analytics.setManagementService(unmanagedService)
+		analytics.emitDNSManagementDetectedEvent(context.Background())
+		assert.Equal(t, unmanagedService, analytics.ManagementService())
+	}
+	analytics.setManagementService(resolvconfService)
+	analytics.emitDNSManagementDetectedEvent(context.Background())
+
+	// events are published in order, so duplicates would be published before the last event
+	published := publisher.waitForEvents(t, 2)
+	assert.Equal(t, "unmanaged", contextValue(t, published[0], debuggerEventManagementServiceKey))
+	assert.Equal(t, "resolvconf", contextValue(t, published[1], debuggerEventManagementServiceKey))
+}
+
+func Test_emitResolvConfOverwrittenEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	clock := newFakeClock()
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.clock = clock
+	analytics.setManagementService(unmanagedService)
+	analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{LinesAdded: 1, NameserversRemoved: 2})
+	// only the diff of the last change in the window is reported
+	analytics.emitResolvConfOverwrittenEvent(context.Background(), "CREATE|CHMOD", resolvConfDiff{
+		LinesAdded:           2,
+		LinesRemoved:         3,
+		NameserversAdded:     1,
+		NameserversRemoved:   2,
+		SearchDomainsChanged: true,
+	})
+	clock.Advance(defaultRateLimitWindow)
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, "resolvconf_overwritten", payload["event"])
+	assert.Equal(t, "unmanaged", payload["management_service"])
+	assert.Equal(t, float64(2), payload["occurrences"])
+	assert.Equal(t, float64(2), payload["lines_added"])
+	assert.Equal(t, float64(1), payload["nameservers_added"])
+	assert.Equal(t, true, payload["search_domains_changed"])
+	assert.Equal(t, "CREATE|CHMOD", payload["fsnotify_op"])
+	assert.NotContains(t, payload, "content")
+	assert.NotContains(t, payload, "previous_content")
+	assert.Equal(t, "resolvconf_overwritten", contextValue(t, event, debuggerEventTypeKey))
+	assert.Equal(t, 2, contextValue(t, event, debuggerEventOccurrencesKey))
+	assert.Equal(t, 3, contextValue(t, event, debuggerEventLinesRemovedKey))
+	assert.Equal(t, 2, contextValue(t, event, debuggerEventNameserversRemovedKey))
+	assert.Equal(t, "CREATE|CHMOD", contextValue(t, event, debuggerEventFsnotifyOpKey))
+}
+
+func Test_emitResolvConfOverwrittenEventRateLimited(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	clock := newFakeClock()
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.clock = clock
+	analytics.setManagementService(unmanagedService)
+	for i := 0; i < 10; i++ {
+		analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+		clock.Advance(time.Second)
+	}
+	// events for a different management service are not coalesced with the previous ones
+	analytics.setManagementService(resolvconfService)
+	analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+	assert.Equal(t, 2, clock.pendingTimers())
+
+	// window of the first event closes 30s after it was reported
+	clock.Advance(defaultRateLimitWindow - 10*time.Second - time.Nanosecond)
+	assert.Equal(t, 2, clock.pendingTimers())
+	clock.Advance(time.Nanosecond)
+	assert.Equal(t, 1, clock.pendingTimers())
+	event := publisher.waitForEvents(t, 1)[0]
+	assert.Equal(t, "unmanaged", contextValue(t, event, debuggerEventManagementServiceKey))
+	assert.Equal(t, 10, contextValue(t, event, debuggerEventOccurrencesKey))
+
+	clock.Advance(10 * time.Second)
+	event = publisher.waitForEvents(t, 2)[1]
+	assert.Equal(t, "resolvconf", contextValue(t, event, debuggerEventManagementServiceKey))
+	assert.Equal(t, 1, contextValue(t, event, debuggerEventOccurrencesKey))
+
+	// next window starts with the next event
+	analytics.setManagementService(unmanagedService)
+	analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+	clock.Advance(defaultRateLimitWindow)
+	event = publisher.waitForEvents(t, 3)[2]
+	assert.Equal(t, 1, contextValue(t, event, debuggerEventOccurrencesKey))
+}
+
+func Test_emitDNSConfigurationErrorEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name      string
+		service   dnsManagementService
+		errorType errorType
+		severity  errorSeverity
+		critical  bool
+	}{
+		{
+			name:      "critical set failure",
+			service:   unmanagedService,
+			errorType: setFailedErrorType,
+			critical:  true,
+		},
+		{
+			name:      "non critical read only file system",
+			service:   unknownService,
+			errorType: readOnlyFilesystemErrorType,
+			severity:  severityNonCritical,
+			critical:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			publisher := &mockDebuggerPublisher{}
+			analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+			analytics.setManagementService(test.service)
+			analytics.emitDNSConfigurationErrorEvent(context.Background(), test.errorType, test.severity)
+
+			event := publisher.waitForEvents(t, 1)[0]
+
+			var payload map[string]any
+			require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+			assert.Equal(t, map[string]any{
+				"namespace":             internal.DebugEventMessageNamespace,
+				"subscope":              "dns",
+				"schema_version":        float64(2),
+				"event":                 "dns_configuration_error",
+				"management_service":    test.service.String(),
+				"error_type":            test.errorType.String(),
+				"critical":              test.critical,
+				"retry_count":           float64(0),
+				"timeout":               false,
+				"fallback":              "",
+				"resolvers_requested":   float64(0),
+				"resolvers_written":     float64(0),
+				"resolvers_unreachable": float64(0),
+				"global_resolvers":      float64(0),
+			}, payload)
+
+			assert.Equal(t, "dns_configuration_error", contextValue(t, event, debuggerEventTypeKey))
+			assert.Equal(t, test.service.String(), contextValue(t, event, debuggerEventManagementServiceKey))
+			assert.Equal(t, test.errorType.String(), contextValue(t, event, debuggerEventErrorTypeKey))
+			assert.Equal(t, test.critical, contextValue(t, event, debuggerEventCriticalKey))
+			for _, value := range event.KeyBasedContextPaths {
+				assert.NotEqual(t, debuggerEventResolvedVersionKey, value.Path,
+					"version is reported only for systemd-resolved")
+			}
+		})
+	}
+}
+
+func Test_emitResolversTruncatedEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.setManagementService(unmanagedService)
+	analytics.emitResolversTruncatedEvent(context.Background(), 5, 3)
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, "resolvers_truncated", payload["error_type"])
+	assert.Equal(t, false, payload["critical"])
+	assert.Equal(t, float64(5), payload["resolvers_requested"])
+	assert.Equal(t, float64(3), payload["resolvers_written"])
+	assert.Equal(t, 5, contextValue(t, event, debuggerEventResolversRequestedKey))
+	assert.Equal(t, 3, contextValue(t, event, debuggerEventResolversWrittenKey))
+}
+
+func Test_emitResolversUnreachableEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.setManagementService(unmanagedService)
+	analytics.emitResolversUnreachableEvent(context.Background(), 2)
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, "resolver_unreachable", payload["error_type"])
+	assert.Equal(t, false, payload["critical"])
+	assert.Equal(t, float64(2), payload["resolvers_unreachable"])
+	assert.Equal(t, 2, contextValue(t, event, debuggerEventResolversUnreachableKey))
+}
+
+func Test_emitGlobalDNSConflictEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.setManagementService(systemdResolvedService)
+	analytics.emitGlobalDNSConflictEvent(context.Background(), 2)
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, "global_dns_conflict", payload["error_type"])
+	assert.Equal(t, false, payload["critical"])
+	assert.Equal(t, float64(2), payload["global_resolvers"])
+	assert.Equal(t, 2, contextValue(t, event, debuggerEventGlobalResolversKey))
+}
+
+func Test_emitDNSSetFailedEvent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.emitDNSSetFailedEvent(context.Background(),
+		newDNSError(os.ErrPermission, unknownService), 3)
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, "permission_denied", payload["error_type"])
+	assert.Equal(t, true, payload["critical"])
+	assert.Equal(t, float64(3), payload["retry_count"])
+	assert.Equal(t, true, contextValue(t, event, debuggerEventCriticalKey))
+	assert.Equal(t, 3, contextValue(t, event, debuggerEventRetryCountKey))
+	assert.Equal(t, false, contextValue(t, event, debuggerEventTimeoutKey))
+}
+
+func Test_emitDNSSetFailedEventTimeout(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.resolvedVersion = func() string { return "255" }
+	analytics.setManagementService(systemdResolvedService)
+	err := newDNSError(fmt.Errorf("%w: %w", errDBusTimeout, context.DeadlineExceeded), systemdResolvedService)
+	analytics.emitDNSSetFailedEvent(context.Background(), err, 0)
+
+	event := publisher.waitForEvents(t, 1)[0]
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.JsonData), &payload))
+	assert.Equal(t, "set_failed", payload["error_type"])
+	assert.Equal(t, true, payload["critical"])
+	assert.Equal(t, true, payload["timeout"])
+	assert.Equal(t, true, contextValue(t, event, debuggerEventTimeoutKey))
+	assert.Equal(t, "255", contextValue(t, event, debuggerEventResolvedVersionKey))
+}
+
+func Test_LastError(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	clock := newFakeClock()
+	analytics.clock = clock
+
+	_, _, _, ok := analytics.LastError()
+	assert.False(t, ok)
+
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), watchFailedErrorType, severityNonCritical)
+	clock.Advance(time.Minute)
+	analytics.emitDNSSetFailedEvent(context.Background(),
+		newDNSError(os.ErrPermission, systemdResolvedService), 0)
+	publisher.waitForEvents(t, 2)
+
+	errType, critical, emittedAt, ok := analytics.LastError()
+	assert.True(t, ok)
+	assert.Equal(t, permissionDeniedErrorType, errType)
+	assert.True(t, critical)
+	assert.Equal(t, clock.Now(), emittedAt)
+}
+
+func Test_LastErrorAnalyticsDisabled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := newNoopAnalytics()
+	clock := newFakeClock()
+	analytics.clock = clock
+	ds := newTestSetter(&mockAnalytics{})
+	ds.analytics = analytics
+
+	_, _, _, ok := ds.LastError()
+	assert.False(t, ok)
+
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), watchFailedErrorType, severityNonCritical)
+	clock.Advance(time.Minute)
+	analytics.emitDNSSetFailedEvent(context.Background(),
+		newDNSError(os.ErrPermission, systemdResolvedService), 0)
+	// canceled events are not recorded
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	analytics.emitDNSConfigurationErrorEvent(ctx, leakDetectedErrorType, severityByType)
+
+	errType, critical, emittedAt, ok := ds.LastError()
+	assert.True(t, ok)
+	assert.Equal(t, permissionDeniedErrorType.String(), errType)
+	assert.True(t, critical)
+	assert.Equal(t, clock.Now(), emittedAt)
+}
+
+func Test_errorTypeFromError(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name      string
+		err       error
+		errorType errorType
+	}{
+		{
+			name:      "read only file system",
+			err:       fmt.Errorf("writing file: %w", &os.PathError{Op: "open", Path: resolvconfFilePath, Err: syscall.EROFS}),
+			errorType: readOnlyFilesystemErrorType,
+		},
+		{
+			name:      "permission denied",
+			err:       fmt.Errorf("writing file: %w", &os.PathError{Op: "open", Path: resolvconfFilePath, Err: syscall.EACCES}),
+			errorType: permissionDeniedErrorType,
+		},
+		{
+			name:      "operation not permitted",
+			err:       fmt.Errorf("writing file: %w", syscall.EPERM),
+			errorType: permissionDeniedErrorType,
+		},
+		{
+			name:      "classified error",
+			err:       fmt.Errorf("setting dns: %w", &DNSError{Type: fileImmutableErrorType, Err: syscall.EPERM}),
+			errorType: fileImmutableErrorType,
+		},
+		{
+			name:      "other error",
+			err:       errors.New("dbus is not available"),
+			errorType: setFailedErrorType,
+		},
+		{
+			name:      "no error",
+			err:       nil,
+			errorType: setFailedErrorType,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.errorType, errorTypeFromError(test.err))
+		})
+	}
+}
+
+func Test_EmitDoesNotBlockOnPublisher(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &blockingDebuggerPublisher{
+		published: make(chan events.DebuggerEvent, 1),
+		release:   make(chan struct{}),
+	}
+	defer close(publisher.release)
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	// wait until the publisher is blocked on the first event
+	<-publisher.published
+
+	done := make(chan struct{})
+	go func() {
+		analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+		analytics.setManagementService(unmanagedService)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emitting event blocked on the publisher")
+	}
+}
+
+func Test_EmitDropsOldestEventsWhenQueueIsFull(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &blockingDebuggerPublisher{
+		published: make(chan events.DebuggerEvent, eventQueueSize*2),
+		release:   make(chan struct{}),
+	}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	// first event is taken from the queue and blocks the publisher
+	<-publisher.published
+	for i := 0; i < eventQueueSize; i++ {
+		analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	}
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+	close(publisher.release)
+
+	published := []events.DebuggerEvent{}
+	for i := 0; i < eventQueueSize; i++ {
+		published = append(published, <-publisher.published)
+	}
+	// the newest event is kept and the oldest one is dropped
+	assert.Contains(t, published[len(published)-1].JsonData, "dns_configuration_error")
+	assert.Equal(t, 0, len(publisher.published))
+}
+
+func Test_SnapshotKeepsMostRecentEvents(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	clock := newFakeClock()
+	analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+	analytics.clock = clock
+	assert.Empty(t, analytics.Snapshot())
+
+	start := clock.Now()
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	clock.Advance(time.Second)
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	analytics.setManagementService(unmanagedService)
+	for i := 0; i < eventHistorySize; i++ {
+		clock.Advance(time.Second)
+		analytics.emitDNSConfigurationErrorEvent(context.Background(), leakDetectedErrorType, severityByType)
+	}
+
+	snapshot := analytics.Snapshot()
+	require.Len(t, snapshot, eventHistorySize)
+	// both configured events were evicted
+	for i, record := range snapshot {
+		assert.Equal(t, EventRecord{
+			Type:              "dns_configuration_error",
+			ManagementService: "unmanaged",
+			ErrorType:         "leak_detected",
+			Timestamp:         start.Add(time.Duration(i+2) * time.Second),
+		}, record)
+	}
+
+	// snapshot is a copy
+	snapshot[0].Type = ""
+	assert.Equal(t, "dns_configuration_error", analytics.Snapshot()[0].Type)
+}
+
+func Test_DumpEvents(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	var buf bytes.Buffer
+	require.NoError(t, analytics.DumpEvents(&buf))
+	assert.Empty(t, buf.String())
+
+	analytics.setManagementService(systemdResolvedService)
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{profile: "lan"})
+	analytics.emitResolversTruncatedEvent(context.Background(), 5, 3)
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), leakDetectedErrorType, severityByType)
+	published := publisher.waitForEvents(t, 3)
+
+	require.NoError(t, analytics.DumpEvents(&buf))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	expected := []map[string]any{
+		{"event": "dns_configured", "profile": "lan"},
+		{"event": "dns_configuration_error", "error_type": "resolvers_truncated", "resolvers_written": float64(3)},
+		{"event": "dns_configuration_error", "error_type": "leak_detected", "critical": true},
+	}
+	for i, line := range lines {
+		// lines are the same as the published payloads
+		assert.Equal(t, published[i].JsonData, line)
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &payload))
+		assert.Equal(t, "systemd-resolved", payload["management_service"])
+		for key, value := range expected[i] {
+			assert.Equal(t, value, payload[key], key)
+		}
+	}
+}
+
+func Test_DumpEventsKeepsMostRecentEvents(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	for i := 0; i < eventHistorySize; i++ {
+		analytics.emitResolversTruncatedEvent(context.Background(), i+1, 0)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, analytics.DumpEvents(&buf))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, eventHistorySize)
+	// configured event was evicted, the order is kept
+	for i, line := range lines {
+		var payload errorEvent
+		require.NoError(t, json.Unmarshal([]byte(line), &payload))
+		assert.Equal(t, i+1, payload.ResolversRequested)
+	}
+
+	assert.Error(t, analytics.DumpEvents(failingWriter{}))
+}
+
+// failingWriter fails all of the writes
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func Test_AnalyticsLogsPublishedEventsAtDebugLevel(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	logger := &mockLogger{}
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, logger)
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	publisher.waitForEvents(t, 1)
+
+	messages := logger.getMessages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "debug", messages[0].level)
+	assert.Contains(t, messages[0].message, "publishing event:")
+}
+
+func Test_AnalyticsDoesNotPublishWithCanceledContext(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	logger := &mockLogger{}
+	publisher := &mockDebuggerPublisher{}
+	clock := newFakeClock()
+	analytics := newTestDNSAnalytics(t, publisher, logger)
+	analytics.clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	analytics.emitDNSConfiguredEvent(ctx, configurationDetails{})
+	analytics.emitDNSConfiguredDryRunEvent(ctx, unmanagedService, configurationDetails{})
+	analytics.emitDNSConfigurationErrorEvent(ctx, setFailedErrorType, severityByType)
+	analytics.emitDNSSetFailedEvent(ctx, newDNSError(errors.New("failed"), unknownService), 3)
+	analytics.emitResolvConfOverwrittenEvent(ctx, "WRITE", resolvConfDiff{})
+	assert.Equal(t, 0, clock.pendingTimers())
+
+	messages := logger.getMessages()
+	require.Len(t, messages, 5)
+	for _, message := range messages {
+		assert.Equal(t, "debug", message.level)
+		assert.Contains(t, message.message, context.Canceled.Error())
+	}
+
+	// events are published in order, so the canceled events would be published before this one
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{splitRouting: true})
+	event := publisher.waitForEvents(t, 1)[0]
+	assert.Equal(t, true, contextValue(t, event, debuggerEventSplitRoutingKey))
+}
+
+// Test_AnalyticsConcurrentUse is meant to be run with the race detector, the monitor, health
+// checks and connect flows call analytics from different goroutines
+func Test_AnalyticsConcurrentUse(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	const goroutines = 16
+	const iterations = 50
+
+	metrics := &fakeMetrics{}
+	clock := newFakeClock()
+	analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+	analytics.resolvedVersion = func() string { return "255" }
+	analytics.clock = clock
+	analytics.setMetrics(metrics)
+	services := enumMembers[dnsManagementService]()
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				analytics.setManagementService(services[(i+j)%len(services)])
+				analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+				analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+				analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{LinesAdded: j})
+				analytics.emitDNSManagementDetectedEvent(context.Background())
+				_ = analytics.ManagementService()
+				_ = analytics.Snapshot()
+				if j%10 == 0 {
+					// rate limit windows are closed while new events are reported
+					clock.Advance(defaultRateLimitWindow)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	clock.Advance(defaultRateLimitWindow)
+
+	counts := map[string]int{}
+	metrics.mu.Lock()
+	for _, counter := range metrics.counters {
+		counts[counter.name]++
+	}
+	metrics.mu.Unlock()
+	assert.Equal(t, map[string]int{
+		dnsConfiguredTotal: goroutines * iterations,
+		dnsErrorsTotal:     goroutines * iterations,
+		dnsOverwritesTotal: goroutines * iterations,
+	}, counts)
+	assert.Len(t, analytics.Snapshot(), eventHistorySize)
+	assert.Equal(t, 0, clock.pendingTimers())
+}
+
+func Test_ContextPaths(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	base := event{Event: "event", ManagementService: "systemd-resolved", resolvedVersion: "255"}
+	baseContextPaths := []events.ContextValue{
+		{Path: debuggerEventTypeKey, Value: "event"},
+		{Path: debuggerEventManagementServiceKey, Value: "systemd-resolved"},
+		{Path: debuggerEventResolvedVersionKey, Value: "255"},
+	}
+	tests := []struct {
+		name         string
+		payload      interface{ toContextPaths() []events.ContextValue }
+		contextPaths []events.ContextValue
+	}{
+		{
+			name:         "event",
+			payload:      base,
+			contextPaths: baseContextPaths,
+		},
+		{
+			name:    "event without resolved version",
+			payload: event{Event: "event", ManagementService: "unmanaged"},
+			contextPaths: []events.ContextValue{
+				{Path: debuggerEventTypeKey, Value: "event"},
+				{Path: debuggerEventManagementServiceKey, Value: "unmanaged"},
+			},
+		},
+		{
+			name: "error event",
+			payload: errorEvent{
+				event:              base,
+				ErrorType:          "set_failed",
+				Critical:           true,
+				RetryCount:         2,
+				Timeout:            true,
+				Fallback:           "resolv.conf",
+				ResolversRequested: 5,
+				ResolversWritten:   3,
+
+				ResolversUnreachable: 1,
+				GlobalResolvers:      2,
+			},
+			contextPaths: append(slices.Clone(baseContextPaths),
+				events.ContextValue{Path: debuggerEventErrorTypeKey, Value: "set_failed"},
+				events.ContextValue{Path: debuggerEventCriticalKey, Value: true},
+				events.ContextValue{Path: debuggerEventRetryCountKey, Value: 2},
+				events.ContextValue{Path: debuggerEventTimeoutKey, Value: true},
+				events.ContextValue{Path: debuggerEventFallbackKey, Value: "resolv.conf"},
+				events.ContextValue{Path: debuggerEventResolversRequestedKey, Value: 5},
+				events.ContextValue{Path: debuggerEventResolversWrittenKey, Value: 3},
+				events.ContextValue{Path: debuggerEventResolversUnreachableKey, Value: 1},
+				events.ContextValue{Path: debuggerEventGlobalResolversKey, Value: 2},
+			),
+		},
+		{
+			name: "configured event",
+			payload: configuredEvent{
+				event:             base,
+				SplitRouting:      true,
+				AppendMode:        true,
+				ExclusiveMode:     true,
+				AddressFamily:     "dual_stack",
+				SearchDomainCount: 2,
+				ThreatProtection:  true,
+				Source:            "requested",
+				InterfaceIndex:    7,
+				Trigger:           "connect",
+				Action:            "skipped_already_correct",
+				Profile:           "lan",
+				EtcReadOnly:       true,
+				IPv6Fallback:      true,
+				WriteMode:         "in_place",
+				NameserverOrder:   "ipv6_first",
+				ContainerManaged:  true,
+				NetworkNamespace:  "vrf-blue",
+				DNSSEC:            "yes",
+				CustomOptions:     true,
+				Mirrored:          true,
+				CacheFlushed:      true,
+				SampleRate:        10,
+				DryRun:            true,
+			},
+			contextPaths: append(slices.Clone(baseContextPaths),
+				events.ContextValue{Path: debuggerEventSplitRoutingKey, Value: true},
+				events.ContextValue{Path: debuggerEventAppendModeKey, Value: true},
+				events.ContextValue{Path: debuggerEventExclusiveModeKey, Value: true},
+				events.ContextValue{Path: debuggerEventAddressFamilyKey, Value: "dual_stack"},
+				events.ContextValue{Path: debuggerEventSearchDomainCountKey, Value: 2},
+				events.ContextValue{Path: debuggerEventThreatProtectionKey, Value: true},
+				events.ContextValue{Path: debuggerEventSourceKey, Value: "requested"},
+				events.ContextValue{Path: debuggerEventInterfaceIndexKey, Value: 7},
+				events.ContextValue{Path: debuggerEventTriggerKey, Value: "connect"},
+				events.ContextValue{Path: debuggerEventActionKey, Value: "skipped_already_correct"},
+				events.ContextValue{Path: debuggerEventProfileKey, Value: "lan"},
+				events.ContextValue{Path: debuggerEventEtcReadOnlyKey, Value: true},
+				events.ContextValue{Path: debuggerEventIPv6FallbackKey, Value: true},
+				events.ContextValue{Path: debuggerEventWriteModeKey, Value: "in_place"},
+				events.ContextValue{Path: debuggerEventNameserverOrderKey, Value: "ipv6_first"},
+				events.ContextValue{Path: debuggerEventContainerManagedKey, Value: true},
+				events.ContextValue{Path: debuggerEventNetworkNamespaceKey, Value: "vrf-blue"},
+				events.ContextValue{Path: debuggerEventDNSSECKey, Value: "yes"},
+				events.ContextValue{Path: debuggerEventCustomOptionsKey, Value: true},
+				events.ContextValue{Path: debuggerEventMirroredKey, Value: true},
+				events.ContextValue{Path: debuggerEventCacheFlushedKey, Value: true},
+				events.ContextValue{Path: debuggerEventSampleRateKey, Value: 10},
+				events.ContextValue{Path: debuggerEventDryRunKey, Value: true},
+			),
+		},
+		{
+			name:    "coalesced event",
+			payload: coalescedEvent{event: base, Occurrences: 4},
+			contextPaths: append(slices.Clone(baseContextPaths),
+				events.ContextValue{Path: debuggerEventOccurrencesKey, Value: 4},
+			),
+		},
+		{
+			name: "overwritten event",
+			payload: overwrittenEvent{
+				coalescedEvent: coalescedEvent{event: base, Occurrences: 4},
+				resolvConfDiff: resolvConfDiff{
+					LinesAdded:           1,
+					LinesRemoved:         2,
+					NameserversAdded:     3,
+					NameserversRemoved:   4,
+					SearchDomainsChanged: true,
+					Content:              "nameserver 1.1.1.1",
+				},
+				FsnotifyOp: "WRITE",
+			},
+			contextPaths: append(slices.Clone(baseContextPaths),
+				events.ContextValue{Path: debuggerEventOccurrencesKey, Value: 4},
+				events.ContextValue{Path: debuggerEventLinesAddedKey, Value: 1},
+				events.ContextValue{Path: debuggerEventLinesRemovedKey, Value: 2},
+				events.ContextValue{Path: debuggerEventNameserversAddedKey, Value: 3},
+				events.ContextValue{Path: debuggerEventNameserversRemovedKey, Value: 4},
+				events.ContextValue{Path: debuggerEventSearchDomainsChangedKey, Value: true},
+				events.ContextValue{Path: debuggerEventFsnotifyOpKey, Value: "WRITE"},
+			),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.contextPaths, test.payload.toContextPaths())
+		})
+	}
+}
+
+func Benchmark_ErrorEventToDebuggerEvent(b *testing.B) {
+	event := newErrorEvent(internal.DebugEventMessageNamespace, systemdResolvedService, setFailedErrorType, true)
+	event.resolvedVersion = "255"
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := event.toDebuggerEvent(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ErrorEventToContextPaths(b *testing.B) {
+	event := newErrorEvent(internal.DebugEventMessageNamespace, systemdResolvedService, setFailedErrorType, true)
+	event.resolvedVersion = "255"
+	b.ReportAllocs()
+	for b.Loop() {
+		_ = event.toContextPaths()
+	}
+}
+
+func Test_AnalyticsCloseStopsPublishing(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	clock := newFakeClock()
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.clock = clock
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+	publisher.waitForEvents(t, 1)
+	// the rate limit window is open when the analytics are closed
+	analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+	require.Equal(t, 1, clock.pendingTimers())
+
+	closed := make(chan struct{})
+	go func() {
+		analytics.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("analytics goroutines did not stop")
+	}
+	assert.Zero(t, clock.pendingTimers())
+
+	// events emitted after closing are only kept in the history
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+	analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+	assert.Len(t, analytics.Snapshot(), 2)
+	publisher.mu.Lock()
+	assert.Len(t, publisher.events, 1)
+	publisher.mu.Unlock()
+	// closing again does not block
+	analytics.Close()
+}
+
+func Test_AnalyticsPublishingFollowsConsent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	publisher := &mockDebuggerPublisher{}
+	analytics := newTestDNSAnalytics(t, publisher, defaultLogger{})
+	analytics.clock = newFakeClock()
+	ds := newTestSetter(&mockAnalytics{})
+	ds.analytics = analytics
+
+	require.NoError(t, ds.NotifyAnalyticsConsent(false))
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+	require.NoError(t, ds.NotifyAnalyticsConsent(true))
+	analytics.emitDNSConfigurationErrorEvent(context.Background(), permissionDeniedErrorType, severityByType)
+
+	// events are published in order, so the first one was dropped when the second one arrives
+	publisher.waitForEvents(t, 1)
+	assert.Len(t, analytics.Snapshot(), 2, "events must be recorded in the history without the consent")
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	require.Len(t, publisher.events, 1)
+	assert.Contains(t, publisher.events[0].JsonData, "permission_denied")
+}
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// execNscd defines nscd executable, which caches the host lookups on some distributions
+const execNscd = "nscd"
+
+// cacheFlusher clears the DNS caches of the host, so that the answers of the nameservers used
+// before DNS was set are not returned anymore
+type cacheFlusher struct {
+	busctl   func(ctx context.Context, args ...string) ([]byte, error)
+	run      func(ctx context.Context, name string, args ...string) ([]byte, error)
+	lookPath func(file string) (string, error)
+}
+
+func newCacheFlusher() cacheFlusher {
+	return cacheFlusher{busctl: runBusctl, run: runCommand, lookPath: exec.LookPath}
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	// #nosec G204 -- only the constant commands are run
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// flush clears the cache of systemd-resolved when it manages DNS and the hosts cache of nscd
+// when it is installed. Returns true if all of them were flushed, the caches which failed to
+// flush are reported in the error.
+func (f cacheFlusher) flush(ctx context.Context, resolved bool) (bool, error) {
+	flushed := false
+	var errs []error
+	if resolved {
+		out, err := f.busctl(ctx,
+			"call",
+			"org.freedesktop.resolve1",
+			"/org/freedesktop/resolve1",
+			"org.freedesktop.resolve1.Manager",
+			"FlushCaches",
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("flushing systemd-resolved caches via dbus: %s: %w",
+				strings.TrimSpace(string(out)), err))
+		} else {
+			flushed = true
+		}
+	}
+	if _, err := f.lookPath(execNscd); err == nil {
+		if out, err := f.run(ctx, execNscd, "--invalidate", "hosts"); err != nil {
+			errs = append(errs, fmt.Errorf("invalidating nscd hosts cache: %s: %w", strings.TrimSpace(string(out)), err))
+		} else {
+			flushed = true
+		}
+	}
+	return flushed && len(errs) == 0, errors.Join(errs...)
+}
+
+// SetFlushCaches enables clearing the DNS caches of systemd-resolved and nscd after DNS is set,
+// so that the answers cached before e.g. a server switch are not used. Flushing is best-effort,
+// failures are only reported. The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetFlushCaches(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flushCaches = enabled
+}
+
+// flushesResolvedCaches checks if the method flushes the caches of systemd-resolved itself when
+// it sets DNS, so that they are not flushed again. resolvectl is used e.g. under snap, where
+// busctl is not available.
+func flushesResolvedCaches(method Method) bool {
+	switch method.(type) {
+	case *Resolved, *Resolvectl:
+		return true
+	default:
+		return false
+	}
+}
+
+// flushDNSCaches flushes the caches after DNS was set with the method, when it is enabled. The
+// D-Bus calls are limited by the timeout set with SetDBusTimeout. Returns true if the caches
+// were flushed, including by the method itself.
+func (d *DefaultSetter) flushDNSCaches(method Method) bool {
+	if !d.flushCaches {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.dbusTimeout)
+	defer cancel()
+	flushedByMethod := flushesResolvedCaches(method)
+	resolved := managementServiceForMethod(method) == systemdResolvedService && !flushedByMethod
+	flushed, err := d.cacheFlusher.flush(ctx, resolved)
+	if err != nil {
+		d.logger.Warn("flushing dns caches:", err)
+		d.analytics.emitDNSConfigurationErrorEvent(context.Background(), cacheFlushFailedErrorType, severityByType)
+		return false
+	}
+	return flushed || flushedByMethod
+}
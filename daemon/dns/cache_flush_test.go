@@ -0,0 +1,177 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCacheFlusher creates cacheFlusher recording the commands to calls. nscd is installed
+// when nscd is true.
+func newTestCacheFlusher(busctl *mockBusctl, calls *[]string, nscd bool, nscdErr error) cacheFlusher {
+	return cacheFlusher{
+		busctl: busctl.run,
+		run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			*calls = append(*calls, name)
+			return nil, nscdErr
+		},
+		lookPath: func(file string) (string, error) {
+			if nscd {
+				return "/usr/sbin/" + file, nil
+			}
+			return "", exec.ErrNotFound
+		},
+	}
+}
+
+func Test_CacheFlusher(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name     string
+		resolved bool
+		nscd     bool
+		failing  []string
+		nscdErr  error
+		methods  []string
+		commands []string
+		flushed  bool
+		err      bool
+	}{
+		{name: "nothing to flush"},
+		{name: "systemd-resolved", resolved: true, methods: []string{"FlushCaches"}, flushed: true},
+		{name: "nscd", nscd: true, commands: []string{execNscd}, flushed: true},
+		{
+			name:     "both",
+			resolved: true,
+			nscd:     true,
+			methods:  []string{"FlushCaches"},
+			commands: []string{execNscd},
+			flushed:  true,
+		},
+		{
+			name:     "systemd-resolved fails",
+			resolved: true,
+			nscd:     true,
+			failing:  []string{"FlushCaches"},
+			methods:  []string{"FlushCaches"},
+			commands: []string{execNscd},
+			err:      true,
+		},
+		{
+			name:     "nscd fails",
+			nscd:     true,
+			nscdErr:  errors.New("nscd not running"),
+			commands: []string{execNscd},
+			err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			busctl := &mockBusctl{failing: test.failing}
+			commands := []string{}
+			flusher := newTestCacheFlusher(busctl, &commands, test.nscd, test.nscdErr)
+
+			flushed, err := flusher.flush(context.Background(), test.resolved)
+			assert.Equal(t, test.flushed, flushed)
+			assert.Equal(t, test.err, err != nil)
+			assert.Equal(t, len(test.methods), len(busctl.methods()))
+			for _, method := range test.methods {
+				assert.Contains(t, busctl.methods(), method)
+			}
+			assert.Equal(t, len(test.commands), len(commands))
+		})
+	}
+}
+
+func Test_SetFlushesCaches(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	analytics := &mockAnalytics{}
+	busctl := &mockBusctl{}
+	commands := []string{}
+	ds := newTestSetter(analytics, &resolvedMethod{recordingMethod{name: "resolved", calls: &calls}})
+	ds.cacheFlusher = newTestCacheFlusher(busctl, &commands, true, nil)
+
+	// flushing is disabled by default
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Empty(t, busctl.methods())
+	assert.Empty(t, commands)
+
+	ds.SetFlushCaches(true)
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.96"}))
+	assert.Equal(t, []string{"FlushCaches"}, busctl.methods())
+	assert.Equal(t, []string{execNscd}, commands)
+	require.Len(t, analytics.configuredEvents, 2)
+	assert.False(t, analytics.configuredEvents[0].cacheFlushed)
+	assert.True(t, analytics.configuredEvents[1].cacheFlushed)
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_SetCacheFlushFailureIsNotFatal(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &resolvedMethod{recordingMethod{name: "resolved", calls: &calls}})
+	ds.cacheFlusher = newTestCacheFlusher(&mockBusctl{failing: []string{"FlushCaches"}}, &[]string{}, false, nil)
+	ds.SetFlushCaches(true)
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.NotNil(t, ds.active)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.False(t, analytics.configuredEvents[0].cacheFlushed)
+	assert.Equal(t, []mockErrorEvent{{errorType: cacheFlushFailedErrorType}}, analytics.getErrorEvents())
+}
+
+func Test_SetWithResolvedDoesNotFlushItsCachesAgain(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.busctl = (&mockBusctl{}).run
+	resolved.resolvedVersion = func() string { return "255" }
+	busctl := &mockBusctl{}
+	commands := []string{}
+	ds := newTestSetter(analytics, resolved)
+	ds.cacheFlusher = newTestCacheFlusher(busctl, &commands, true, nil)
+	ds.SetFlushCaches(true)
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	// caches of systemd-resolved were flushed by Set
+	assert.Empty(t, busctl.methods())
+	assert.Equal(t, []string{execNscd}, commands)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.True(t, analytics.configuredEvents[0].cacheFlushed)
+	assert.True(t, flushesResolvedCaches(&Resolvectl{}))
+}
+
+func Test_FlushCachesUsesDBusTimeout(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	busctl := &mockBusctl{}
+	var timeout time.Duration
+	ds := newTestSetter(&mockAnalytics{}, &resolvedMethod{recordingMethod{name: "resolved", calls: &calls}})
+	ds.cacheFlusher = newTestCacheFlusher(busctl, &[]string{}, false, nil)
+	ds.cacheFlusher.busctl = func(ctx context.Context, args ...string) ([]byte, error) {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		timeout = time.Until(deadline)
+		return busctl.run(ctx, args...)
+	}
+	ds.SetFlushCaches(true)
+	ds.SetDBusTimeout(time.Second)
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"FlushCaches"}, busctl.methods())
+	assert.LessOrEqual(t, timeout, time.Second)
+	assert.Greater(t, timeout, time.Duration(0))
+}
@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// envCanaryDomain replaces the domain resolved by the DNS checks when it was not provided to
+	// the constructor, e.g. so that QA can point them at a test endpoint
+	envCanaryDomain = "NORDVPN_DNS_CANARY_DOMAIN"
+	// defaultCanaryDomain is resolved by the DNS checks when envCanaryDomain is not set
+	defaultCanaryDomain = "nordvpn.com"
+)
+
+// validateCanaryDomain checks that the domain can be resolved by the DNS checks. Single label
+// names are rejected, because they are completed with the search domains.
+func validateCanaryDomain(domain string) (string, error) {
+	if strings.HasPrefix(domain, "~") {
+		return "", fmt.Errorf("invalid canary domain %q", domain)
+	}
+	name, err := normalizeDomain(domain)
+	if err != nil {
+		return "", err
+	}
+	if name == catchAllDomain || !strings.Contains(name, ".") {
+		return "", fmt.Errorf("invalid canary domain %q", domain)
+	}
+	return name, nil
+}
+
+// canaryDomainFromEnv returns the domain from envCanaryDomain, or defaultCanaryDomain if it is
+// not set or it is malformed
+func canaryDomainFromEnv(lookupEnv func(key string) (string, bool), logger Logger) string {
+	value, ok := lookupEnv(envCanaryDomain)
+	if !ok || strings.TrimSpace(value) == "" {
+		return defaultCanaryDomain
+	}
+	domain, err := validateCanaryDomain(strings.TrimSpace(value))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("ignoring %s:", envCanaryDomain), err)
+		return defaultCanaryDomain
+	}
+	return domain
+}
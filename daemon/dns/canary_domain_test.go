@@ -0,0 +1,105 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/events"
+	"github.com/NordSecurity/nordvpn-linux/events/subs"
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateCanaryDomain(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		domain   string
+		expected string
+		valid    bool
+	}{
+		{domain: "nordvpn.com", expected: "nordvpn.com", valid: true},
+		{domain: "Canary.QA.Example.com.", expected: "canary.qa.example.com", valid: true},
+		{domain: ""},
+		{domain: "."},
+		{domain: "localhost"},
+		{domain: "~example.com"},
+		{domain: "-bad.example.com"},
+		{domain: "bad domain.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.domain, func(t *testing.T) {
+			domain, err := validateCanaryDomain(test.domain)
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, domain)
+		})
+	}
+}
+
+func Test_CanaryDomainFromEnv(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name     string
+		value    string
+		set      bool
+		expected string
+	}{
+		{name: "not set", expected: defaultCanaryDomain},
+		{name: "empty", set: true, expected: defaultCanaryDomain},
+		{name: "custom", value: "canary.qa.example.com", set: true, expected: "canary.qa.example.com"},
+		{name: "malformed", value: "not a domain", set: true, expected: defaultCanaryDomain},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				if key != envCanaryDomain {
+					return "", false
+				}
+				return test.value, test.set
+			}
+			assert.Equal(t, test.expected, canaryDomainFromEnv(lookupEnv, defaultLogger{}))
+		})
+	}
+}
+
+func Test_HealthCheckQueriesCanaryDomain(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	t.Setenv(envCanaryDomain, "canary.qa.example.com")
+	ds, err := NewSetter(&subs.Subject[string]{})
+	require.NoError(t, err)
+	t.Cleanup(ds.Close)
+	hosts := []string{}
+	ds.hostLookup = fakeHostLookup{hosts: &hosts}
+
+	require.NoError(t, ds.HealthCheck(context.Background()))
+	assert.Equal(t, []string{"canary.qa.example.com"}, hosts)
+}
+
+func Test_NewSetterWithCanaryDomain(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	_, err := NewSetter(&subs.Subject[string]{},
+		WithAnalytics(&subs.Subject[events.DebuggerEvent]{}), WithCanaryDomain("localhost"))
+	assert.Error(t, err)
+
+	ds, err := NewSetter(&subs.Subject[string]{},
+		WithAnalytics(&subs.Subject[events.DebuggerEvent]{}), WithCanaryDomain("Canary.QA.Example.com."))
+	require.NoError(t, err)
+	t.Cleanup(ds.Close)
+	hosts := []string{}
+	ds.hostLookup = fakeHostLookup{hosts: &hosts}
+	require.NoError(t, ds.HealthCheck(context.Background()))
+	assert.Equal(t, []string{"canary.qa.example.com"}, hosts)
+
+	// canary domain does not resolve to the answering resolver, so the leak check does not use it
+	assert.False(t, ds.IsLeakCheckEnabled())
+	_, err = ds.CheckDNSLeak(context.Background())
+	assert.ErrorIs(t, err, errLeakCheckDisabled)
+}
@@ -0,0 +1,46 @@
+package dns
+
+import "time"
+
+// clock provides time to the DNS package, so that timers can be controlled in tests
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) timer
+	NewTicker(d time.Duration) ticker
+}
+
+// timer is the subset of time.Timer used by the DNS package
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// ticker is the subset of time.Ticker used by the DNS package
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock uses the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (realClock) NewTimer(d time.Duration) timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
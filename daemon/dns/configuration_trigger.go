@@ -0,0 +1,37 @@
+package dns
+
+import "fmt"
+
+// configurationTrigger describes why DNS was configured, so that a configuration done on connect
+// can be told apart from the ones re-applied later, e.g. in a fight with another DNS manager
+type configurationTrigger int
+
+const (
+	// connectTrigger is DNS set by the caller of Set, when connecting to VPN
+	connectTrigger configurationTrigger = iota
+	// reapplyTrigger is DNS re-applied after resolv.conf was changed by a third party
+	reapplyTrigger
+	// reconcileTrigger is DNS re-applied by the periodic resolv.conf check
+	reconcileTrigger
+	// refreshTrigger is DNS re-applied after the DNS handling method was detected again
+	refreshTrigger
+	// profileTrigger is DNS set by the caller of ApplyProfile
+	profileTrigger
+)
+
+func (t configurationTrigger) String() string {
+	switch t {
+	case connectTrigger:
+		return "connect"
+	case reapplyTrigger:
+		return "reapply"
+	case reconcileTrigger:
+		return "reconcile"
+	case refreshTrigger:
+		return "refresh"
+	case profileTrigger:
+		return "profile"
+	default:
+		return fmt.Sprintf("%d", int(t))
+	}
+}
@@ -0,0 +1,35 @@
+package dns
+
+import (
+	"strings"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// mountinfoPath lists the mounts of the daemon mount namespace
+const mountinfoPath = "/proc/self/mountinfo"
+
+// isContainerManagedResolvConf checks if resolv.conf is bind mounted, which is how Docker and
+// Podman provide it to the containers. Changes to it are managed by the container runtime, they
+// may not persist and the file can't be replaced.
+func isContainerManagedResolvConf() bool {
+	return isBindMounted(mountinfoPath, resolvconfFilePath)
+}
+
+// isBindMounted checks if the file at path is a mount point according to mountinfo, files can be
+// mount points only when they are bind mounted
+func isBindMounted(mountinfo string, path string) bool {
+	content, err := internal.FileRead(mountinfo)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		// 5th field is the mount point, e.g.
+		// 543 522 8:1 /var/lib/docker/containers/<id>/resolv.conf /etc/resolv.conf rw - ext4 /dev/sda1 rw
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == path {
+			return true
+		}
+	}
+	return false
+}
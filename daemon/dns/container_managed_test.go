@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testHostMountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+23 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+24 22 0:22 / /run rw,nosuid,nodev shared:5 - tmpfs tmpfs rw,mode=755
+`
+	testContainerMountinfo = `522 443 0:48 / / rw,relatime - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/A
+523 522 0:51 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+543 522 8:1 /var/lib/docker/containers/0123/resolv.conf /etc/resolv.conf rw,relatime - ext4 /dev/sda1 rw
+544 522 8:1 /var/lib/docker/containers/0123/hostname /etc/hostname rw,relatime - ext4 /dev/sda1 rw
+`
+)
+
+func Test_IsBindMounted(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		name       string
+		mountinfo  string
+		bindMounts bool
+	}{
+		{name: "host", mountinfo: testHostMountinfo},
+		{name: "container", mountinfo: testContainerMountinfo, bindMounts: true},
+		{name: "empty", mountinfo: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mountinfo")
+			require.NoError(t, os.WriteFile(path, []byte(test.mountinfo), 0644))
+			assert.Equal(t, test.bindMounts, isBindMounted(path, "/etc/resolv.conf"))
+		})
+	}
+	assert.False(t, isBindMounted(filepath.Join(t.TempDir(), "missing"), "/etc/resolv.conf"))
+}
+
+func Test_SetInContainer(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &fileMethod{recordingMethod{name: "file", calls: &calls}})
+	ds.isContainerManaged = func() bool { return true }
+
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.100"}))
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.True(t, analytics.configuredEvents[0].containerManaged)
+}
+
+func Test_SetInContainerFails(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls, setErr: errors.New("device or resource busy")}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, file)
+	ds.isContainerManaged = func() bool { return true }
+
+	assert.Error(t, ds.Set("lo", []string{"103.86.96.100"}))
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: setFailedErrorType, critical: true}},
+		analytics.getErrorEvents())
+}
+
+func Test_ResolvConfFileWritesBindMountInPlace(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	file := &ResolvConfFile{written: []string{"103.86.96.100"}}
+	assert.Equal(t, AtomicRenameWriteMode, file.activeWriteMode())
+	// bind mounted file can't be replaced by renaming
+	file.bindMounted = true
+	assert.Equal(t, InPlaceWriteMode, file.activeWriteMode())
+	assert.Equal(t, "in_place", writeModeApplied(file))
+}
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// contextPathWildcard matches all of the context paths under the prefix, e.g. device.*
+const contextPathWildcard = "*"
+
+// contextPathSegment matches a single segment of a context path
+var contextPathSegment = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// contextPath joins the segments into a context path, e.g. contextPath("device", "*")
+func contextPath(segments ...string) string {
+	return strings.Join(segments, ".")
+}
+
+// validateContextPath checks that the path consists of dot separated segments made of lowercase
+// letters, digits and underscores. Only the last segment can be the wildcard.
+func validateContextPath(path string) error {
+	segments := strings.Split(path, ".")
+	for idx, segment := range segments {
+		if segment == contextPathWildcard && idx == len(segments)-1 && idx > 0 {
+			continue
+		}
+		if !contextPathSegment.MatchString(segment) {
+			return fmt.Errorf("invalid context path %q, segment %q is malformed", path, segment)
+		}
+	}
+	return nil
+}
+
+// validContextPaths returns the paths which are well formed. Invalid ones are logged and
+// skipped, so that they do not break the context of the events.
+func validContextPaths(logger Logger, paths ...string) []string {
+	valid := []string{}
+	for _, path := range paths {
+		if err := validateContextPath(path); err != nil {
+			logger.Error("skipping global context path:", err)
+			continue
+		}
+		valid = append(valid, path)
+	}
+	return valid
+}
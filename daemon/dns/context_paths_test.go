@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ValidateContextPath(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{path: "device.*", valid: true},
+		{path: "application.nordvpnapp.version", valid: true},
+		{path: "application.nordvpnapp.config.current_state.is_on_vpn.value", valid: true},
+		{path: "dns", valid: true},
+		{path: ""},
+		{path: "*"},
+		{path: "device.*.name"},
+		{path: "device.**"},
+		{path: "device*"},
+		{path: "device."},
+		{path: ".device"},
+		{path: "application..version"},
+		{path: "application.nordvpnapp.Version"},
+		{path: "application.nordvpnapp.version "},
+		{path: "application/nordvpnapp/version"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			assert.Equal(t, test.valid, validateContextPath(test.path) == nil)
+		})
+	}
+}
+
+func Test_ValidContextPaths(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t,
+		[]string{"device.*", "application.nordvpnapp.version"},
+		validContextPaths(defaultLogger{}, "device.*", "device..name", "application.nordvpnapp.version"))
+	assert.Equal(t, "application.nordvpnapp.version", contextPath("application", "nordvpnapp", "version"))
+}
+
+func Test_GlobalPathsAreValid(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, []string{
+		"device.*",
+		"application.nordvpnapp.version",
+		"application.nordvpnapp.platform",
+		"application.nordvpnapp.config.current_state.is_on_vpn.value",
+	}, globalPaths)
+}
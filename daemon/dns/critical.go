@@ -0,0 +1,44 @@
+package dns
+
+// errorSeverity tells how the criticality of the reported error is decided
+type errorSeverity int
+
+const (
+	// severityByType decides the criticality from the error type with isCritical
+	severityByType errorSeverity = iota
+	// severityNonCritical reports the error as non-critical regardless of its type, because DNS
+	// was still set in a fallback way or it keeps working
+	severityNonCritical
+)
+
+// critical checks if the error is reported as critical
+func (s errorSeverity) critical(errorType errorType, service dnsManagementService) bool {
+	return s == severityByType && isCritical(errorType, service)
+}
+
+// isCritical decides if the error is reported as critical, which means that DNS is left unset
+// or is not used for the lookups. The errors after which DNS works, only partially or without
+// some feature, are not critical.
+func isCritical(errorType errorType, service dnsManagementService) bool {
+	switch errorType {
+	case ipv6SetFailedErrorType,
+		dnsOverTLSUnsupportedErrorType,
+		healthCheckFailedErrorType,
+		resolversTruncatedErrorType,
+		restoreMismatchErrorType,
+		resolverUnreachableErrorType,
+		watchLimitExceededErrorType,
+		globalDNSConflictErrorType,
+		dnssecUnsupportedErrorType,
+		danglingResolvSymlinkErrorType,
+		cacheFlushFailedErrorType,
+		searchLimitExceededErrorType:
+		return false
+	case watchFailedErrorType, revertedToOriginalErrorType, reapplyLoopErrorType:
+		// systemd-resolved keeps using the nameservers of the link even when resolv.conf, e.g.
+		// the mirrored one, is changed by a third party
+		return service != systemdResolvedService
+	default:
+		return true
+	}
+}
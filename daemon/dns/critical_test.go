@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IsCritical(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name      string
+		errorType errorType
+		service   dnsManagementService
+		severity  errorSeverity
+		critical  bool
+	}{
+		{
+			name:      "set failed on systemd-resolved without fallback",
+			errorType: setFailedErrorType,
+			service:   systemdResolvedService,
+			critical:  true,
+		},
+		{
+			name:      "set failed on unmanaged with fallback",
+			errorType: setFailedErrorType,
+			service:   unmanagedService,
+			severity:  severityNonCritical,
+		},
+		{
+			name:      "set failed on unmanaged without fallback",
+			errorType: setFailedErrorType,
+			service:   unmanagedService,
+			critical:  true,
+		},
+		{
+			name:      "read only file system with fallback",
+			errorType: readOnlyFilesystemErrorType,
+			service:   unknownService,
+			severity:  severityNonCritical,
+		},
+		{
+			name:      "read only file system without fallback",
+			errorType: readOnlyFilesystemErrorType,
+			service:   unknownService,
+			critical:  true,
+		},
+		{
+			name:      "permission denied",
+			errorType: permissionDeniedErrorType,
+			service:   systemdResolvedService,
+			critical:  true,
+		},
+		{
+			name:      "leak detected",
+			errorType: leakDetectedErrorType,
+			service:   systemdResolvedService,
+			critical:  true,
+		},
+		{
+			name:      "immutable resolv.conf",
+			errorType: fileImmutableErrorType,
+			service:   unmanagedService,
+			critical:  true,
+		},
+		{
+			name:      "ipv6 set failed",
+			errorType: ipv6SetFailedErrorType,
+			service:   systemdResolvedService,
+		},
+		{
+			name:      "resolvers truncated",
+			errorType: resolversTruncatedErrorType,
+			service:   unmanagedService,
+		},
+		{
+			name:      "health check failed",
+			errorType: healthCheckFailedErrorType,
+			service:   unmanagedService,
+		},
+		{
+			name:      "reverted to original on unmanaged",
+			errorType: revertedToOriginalErrorType,
+			service:   unmanagedService,
+			critical:  true,
+		},
+		{
+			name:      "reverted to original on systemd-resolved",
+			errorType: revertedToOriginalErrorType,
+			service:   systemdResolvedService,
+		},
+		{
+			name:      "watch failed on unknown",
+			errorType: watchFailedErrorType,
+			service:   unknownService,
+			critical:  true,
+		},
+		{
+			name:      "watch failed and recreated",
+			errorType: watchFailedErrorType,
+			service:   unmanagedService,
+			severity:  severityNonCritical,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.critical, test.severity.critical(test.errorType, test.service))
+		})
+	}
+}
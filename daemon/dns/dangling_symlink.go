@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// danglingSymlinkTarget returns the target of the symlink at path when the target does not
+// exist, e.g. when resolv.conf points to the stub file of systemd-resolved which is not running
+func danglingSymlinkTarget(path string) (string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		return "", false
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return target, true
+}
+
+// recoverDanglingSymlink makes resolv.conf at path readable and writable again when it is a
+// dangling symlink, so that it can be backed up and written. In place writes keep the file for
+// the tools watching it, so the target is recreated. Otherwise the symlink is replaced with an
+// empty file.
+func (m *ResolvConfFile) recoverDanglingSymlink(path string) error {
+	target, dangling := danglingSymlinkTarget(path)
+	if !dangling {
+		return nil
+	}
+	m.logger.Warn(fmt.Sprintf("%s is a symlink to missing %s, recovering it", path, target))
+	m.analytics.emitDNSConfigurationErrorEvent(context.Background(), danglingResolvSymlinkErrorType, severityByType)
+
+	if m.activeWriteMode() == InPlaceWriteMode {
+		if err := internal.FileWrite(target, nil, internal.PermUserRWGroupROthersR); err != nil {
+			return fmt.Errorf("recreating resolv.conf symlink target: %w", err)
+		}
+		return nil
+	}
+	// renaming over the dangling symlink replaces the symlink itself
+	if err := writeFileAtomically(path, nil, internal.PermUserRWGroupROthersR); err != nil {
+		return fmt.Errorf("replacing resolv.conf symlink: %w", err)
+	}
+	return nil
+}
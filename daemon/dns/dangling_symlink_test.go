@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DanglingSymlinkTarget(t *testing.T) {
+	category.Set(t, category.File)
+
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular")
+	require.NoError(t, os.WriteFile(regular, []byte(testVPNResolvConf), 0644))
+	valid := filepath.Join(dir, "valid")
+	require.NoError(t, os.Symlink(regular, valid))
+	danglingAbsolute := filepath.Join(dir, "dangling-absolute")
+	require.NoError(t, os.Symlink(filepath.Join(dir, "stub-resolv.conf"), danglingAbsolute))
+	danglingRelative := filepath.Join(dir, "dangling-relative")
+	require.NoError(t, os.Symlink("run/stub-resolv.conf", danglingRelative))
+
+	tests := []struct {
+		name     string
+		path     string
+		target   string
+		dangling bool
+	}{
+		{name: "regular file", path: regular},
+		{name: "valid symlink", path: valid},
+		{name: "missing", path: filepath.Join(dir, "missing")},
+		{
+			name:     "dangling absolute symlink",
+			path:     danglingAbsolute,
+			target:   filepath.Join(dir, "stub-resolv.conf"),
+			dangling: true,
+		},
+		{
+			name:     "dangling relative symlink",
+			path:     danglingRelative,
+			target:   filepath.Join(dir, "run", "stub-resolv.conf"),
+			dangling: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, dangling := danglingSymlinkTarget(test.path)
+			assert.Equal(t, test.dangling, dangling)
+			assert.Equal(t, test.target, target)
+		})
+	}
+}
+
+func Test_RecoverDanglingSymlink(t *testing.T) {
+	category.Set(t, category.File)
+
+	for _, mode := range enumMembers[ResolvConfWriteMode]() {
+		t.Run(mode.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "stub-resolv.conf")
+			path := filepath.Join(dir, "resolv.conf")
+			require.NoError(t, os.Symlink(target, path))
+
+			analytics := &mockAnalytics{}
+			file := &ResolvConfFile{logger: defaultLogger{}, analytics: analytics, writeMode: mode}
+			require.NoError(t, file.recoverDanglingSymlink(path))
+			assert.Equal(t,
+				[]mockErrorEvent{{errorType: danglingResolvSymlinkErrorType}},
+				analytics.getErrorEvents())
+
+			// recovered file is written like any other
+			_, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, writeResolvConf(path, []byte(testVPNResolvConf), mode))
+
+			info, err := os.Lstat(path)
+			require.NoError(t, err)
+			if mode == InPlaceWriteMode {
+				assert.NotZero(t, info.Mode()&os.ModeSymlink, "symlink should be kept")
+				content, err := os.ReadFile(target)
+				require.NoError(t, err)
+				assert.Equal(t, testVPNResolvConf, string(content))
+			} else {
+				assert.True(t, info.Mode().IsRegular(), "symlink should be replaced")
+				assert.NoFileExists(t, target)
+			}
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, testVPNResolvConf, string(content))
+
+			// nothing to recover anymore
+			require.NoError(t, file.recoverDanglingSymlink(path))
+			assert.Len(t, analytics.getErrorEvents(), 1)
+		})
+	}
+}
@@ -4,13 +4,48 @@ Package dns is responsible for configuring dns on various Linux distros.
 package dns
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/NordSecurity/nordvpn-linux/events"
 	"github.com/NordSecurity/nordvpn-linux/internal"
+	"github.com/NordSecurity/nordvpn-linux/kernel"
+)
+
+var (
+	errInvalidNameserver  = errors.New("invalid nameserver address")
+	errLoopbackNameserver = errors.New("loopback nameserver address")
+	// errNoIPv6Nameservers means that the host can reach only IPv6 nameservers, but none of them
+	// were provided
+	errNoIPv6Nameservers = errors.New("host has no IPv4 route and no IPv6 nameservers were provided")
+	// errSetSuperseded means that DNS was set or unset by another call while setting it was retried
+	errSetSuperseded = errors.New("dns was changed by another call while setting it was retried")
+)
+
+const (
+	netIPv6DisabledParameter = "net.ipv6.conf.all.disable_ipv6"
+
+	// defaultSetRetries is the number of times setting DNS is retried before it is reported as
+	// failed
+	defaultSetRetries = 3
+	// setRetryBaseDelay is the delay before the first retry
+	setRetryBaseDelay = 500 * time.Millisecond
+	// detectionQuietPeriod is how long detection of the management service is retried before it
+	// is reported as failed, e.g. systemd-resolved may still be starting right after boot
+	detectionQuietPeriod = 10 * time.Second
+	// detectionRetryInterval is the delay between the detection attempts
+	detectionRetryInterval = 2 * time.Second
 )
 
 // Setter is responsible for configuring DNS.
@@ -43,18 +78,139 @@ backed up and modified directly by NordVPN.
 type DefaultSetter struct {
 	publisher events.Publisher[string]
 	methods   []Method
+	analytics analytics
+	logger    Logger
+	monitor   *resolvConfFileWatcherMonitor
+	// isIPv6Enabled checks if IPv6 is enabled on the host
+	isIPv6Enabled func() bool
+	// hasIPv4Route checks if the host has an IPv4 default route
+	hasIPv4Route func() bool
+	// isIPv6Reachable probes the IPv6 nameserver at address by resolving the domain
+	isIPv6Reachable func(address string, domain string) bool
+	// isResolvedDetected checks if systemd-resolved manages resolv.conf on the host
+	isResolvedDetected func() bool
+	// isEtcReadOnly checks if resolv.conf is on a read-only mount, then it is not written directly
+	isEtcReadOnly func() bool
+	// isContainerManaged checks if resolv.conf is bind mounted by the container runtime
+	isContainerManaged func() bool
+	// globalDNS returns the global nameservers configured for systemd-resolved
+	globalDNS func() []string
+	// interfaceByName finds the interface DNS is set for
+	interfaceByName func(name string) (*net.Interface, error)
+	// resolvConfPath is read for the effective resolvers
+	resolvConfPath string
+	// lookupEnv reads the environment, it is used for the nameservers override
+	lookupEnv      func(key string) (string, bool)
+	clock          clock
+	resolverLookup answeringResolverLookup
+	hostLookup     hostLookup
+	// canaryDomain is resolved by the health checks and the resolver probes
+	canaryDomain string
+	// leakCheckHostname is resolved by the leak check, the leak check is disabled when it is empty
+	leakCheckHostname string
+	// healthCheckFailures is the number of consecutive failed health checks
+	healthCheckFailures int
+	// threatProtection is true when Threat Protection Lite is enabled, it changes the nameservers
+	// passed to Set
+	threatProtection bool
+	// reconcileJitter is the fraction of the reconciliation interval by which every check is moved
+	reconcileJitter float64
+	// jitterSource randomizes the reconciliation interval, it is seeded in tests
+	jitterSource *rand.Rand
+	// retries is the number of times setting DNS is retried when all of the methods fail
+	retries    int
+	retryDelay CalculateRetryDelayForAttempt
+	// probeTimeout limits probing of a nameserver by ProbeResolvers
+	probeTimeout time.Duration
+	// probeConcurrency is the number of nameservers probed at once by ProbeResolvers
+	probeConcurrency int
+	queryNameserver  nameserverQuery
+	// queryCheckingDisabled retries the failed health check with DNSSEC checking disabled
+	queryCheckingDisabled nameserverQuery
+	// dnsPort is the port of the nameservers queried by Lookup
+	dnsPort string
+	// preVPNResolvers are the nameservers used by the system before DNS was set, nil if they
+	// could not be read
+	preVPNResolvers []string
+	// iface, nameservers and active describe the last successful Set and
+	// are used by Refresh to re-apply the configuration
+	iface       string
+	nameservers []string
+	active      Method
+	// source describes where nameservers came from, they are re-applied with the same source
+	source nameserverSource
+	// applied are the nameservers set on the host by the last successful Set
+	applied []string
+	// profile is the name of the applied profile, empty when DNS was set with Set
+	profile string
+	// ipv6Fallback is true when only the IPv4 nameservers were used by the last Set, because
+	// IPv6 nameservers were unreachable
+	ipv6Fallback bool
+	// nameserverOrder is the policy of ordering the nameservers before they are set
+	nameserverOrder NameserverOrder
+	// networkNamespace is the named network namespace DNS is set in, empty for the namespace
+	// of the daemon
+	networkNamespace string
+	// appliedNamespace is the network namespace DNS was set in by the last successful Set, it
+	// is unset in the same one
+	appliedNamespace string
+	enterNamespace   namespaceEntererFunc
+	// mirrorResolvConf writes the nameservers to resolv.conf also when DNS is set with
+	// systemd-resolved
+	mirrorResolvConf bool
+	// mirrored is the method which wrote resolv.conf in addition to systemd-resolved, nil when
+	// resolv.conf is not mirrored
+	mirrored Method
+	// flushCaches clears the DNS caches of the host after DNS is set
+	flushCaches  bool
+	cacheFlusher cacheFlusher
+	// dbusTimeout limits the D-Bus calls made after DNS is set, e.g. flushing the caches
+	dbusTimeout time.Duration
+	// cacheFlushed is true when the caches were flushed after the last Set
+	cacheFlushed bool
+	// generation is incremented every time DNS is set or unset, so that a retried Set does not
+	// override the calls made while it waited for the retry
+	generation uint64
+	mu         sync.Mutex
 }
 
-func NewSetter(publisher events.Publisher[string]) *DefaultSetter {
+func newSetter(publisher events.Publisher[string], logger Logger, analytics analytics) *DefaultSetter {
 	ds := DefaultSetter{
-		publisher: publisher,
-		methods:   []Method{},
+		publisher:             publisher,
+		methods:               []Method{},
+		analytics:             analytics,
+		logger:                logger,
+		monitor:               newResolvConfFileWatcherMonitor(analytics, logger),
+		isIPv6Enabled:         isIPv6Enabled,
+		hasIPv4Route:          hasIPv4DefaultRoute,
+		isResolvedDetected:    isResolvedDetected,
+		isEtcReadOnly:         isResolvConfReadOnly,
+		isContainerManaged:    isContainerManagedResolvConf,
+		globalDNS:             globalResolvedNameservers,
+		enterNamespace:        enterNetworkNamespace,
+		lookupEnv:             os.LookupEnv,
+		interfaceByName:       net.InterfaceByName,
+		resolvConfPath:        resolvconfFilePath,
+		resolverLookup:        systemResolverLookup{},
+		hostLookup:            systemResolverLookup{},
+		canaryDomain:          canaryDomainFromEnv(os.LookupEnv, logger),
+		leakCheckHostname:     leakCheckHostnameFromEnv(os.LookupEnv, logger),
+		dnsPort:               defaultDNSPort,
+		probeConcurrency:      defaultProbeConcurrency,
+		queryNameserver:       queryNameserver,
+		queryCheckingDisabled: queryNameserverCheckingDisabled,
+		clock:                 realClock{},
+		reconcileJitter:       defaultReconcileJitter,
+		jitterSource:          rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		cacheFlusher:          newCacheFlusher(),
+		dbusTimeout:           defaultDBusTimeout,
 	}
-	ds.methods = append(ds.methods, &Resolved{})
+	ds.methods = append(ds.methods, newResolved(analytics, logger))
 	// Resolvectl is part of systemd-resolved, but is used under Snap, where some restrictions apply
-	ds.methods = append(ds.methods, &Resolvectl{})
-	ds.methods = append(ds.methods, &Resolvconf{})
-	ds.methods = append(ds.methods, &ResolvConfFile{})
+	ds.methods = append(ds.methods, &Resolvectl{logger: logger, timeout: defaultDBusTimeout})
+	ds.methods = append(ds.methods, newResolvconf(logger))
+	ds.methods = append(ds.methods, &ResolvConfFile{logger: logger, analytics: analytics, clock: realClock{}})
+	ds.applyRetryPolicy(DefaultRetryPolicy())
 	return &ds
 }
 
@@ -62,44 +218,946 @@ func NewSetter(publisher events.Publisher[string]) *DefaultSetter {
 // Also, backup current DNS settings (only in case of direct resolv.conf edit).
 // Backup is not overridden, so its safe to call this function multiple times in a row.
 func (d *DefaultSetter) Set(iface string, nameservers []string) error {
+	_, err := d.SetWithResult(iface, nameservers)
+	return err
+}
+
+// SetWithResult sets DNS like Set and describes what was applied
+func (d *DefaultSetter) SetWithResult(iface string, nameservers []string) (SetResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.profile = ""
+	return d.set(iface, nameservers, requestedSource, connectTrigger)
+}
+
+func (d *DefaultSetter) set(
+	iface string,
+	nameservers []string,
+	source nameserverSource,
+	trigger configurationTrigger,
+) (SetResult, error) {
 	d.publisher.Publish(
 		"setting dns to " + strings.Join(nameservers, " "),
 	)
+	d.generation++
 
-	if len(nameservers) == 0 {
-		return errors.New("nameservers not provided")
+	requested, origin := nameservers, source
+	plan, err := d.planNameservers(nameservers, source)
+	d.ipv6Fallback = plan.ipv6Fallback
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidNameserver):
+			d.logger.Error("dns not set, nameservers were rejected:", err)
+			d.analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+		case errors.Is(err, errNoIPv6Nameservers):
+			d.logger.Error("dns not set:", err)
+			d.analytics.emitDNSConfigurationErrorEvent(context.Background(), detectionFailedErrorType, severityByType)
+		}
+		return SetResult{}, err
+	}
+	nameservers, source = plan.nameservers, plan.source
+	ipv4Nameservers := filterIPv4(nameservers)
+
+	if trigger == connectTrigger && d.isAlreadyApplied(iface, requested, nameservers) {
+		d.logger.Info("dns is already set, skipping")
+		d.analytics.emitDNSConfiguredEvent(context.Background(),
+			d.describeConfiguration(d.active, iface, source, trigger, skippedAlreadyCorrectAction))
+		result := d.setResult(d.active, d.applied, nameservers)
+		result.Skipped = true
+		return result, nil
+	}
+
+	if d.active == nil {
+		d.capturePreVPNResolvers()
+	}
+	// our own changes must not be reported as third party changes, the monitor keeps watching
+	// the current configuration when none of the methods succeeds
+	d.monitor.Pause()
+	defer d.monitor.Resume()
+	// failures right after boot are often transient, e.g. D-Bus is not up yet
+	for attempt := 0; ; attempt++ {
+		result, err := d.setWithAvailableMethod(iface, requested, nameservers, ipv4Nameservers, source, trigger)
+		if err == nil {
+			d.source = origin
+			return result, nil
+		}
+		if attempt >= d.retries {
+			if errors.Is(err, errEtcReadOnly) {
+				d.logger.Error("dns not set, resolv.conf is on a read-only file system and " +
+					"systemd-resolved is not available")
+			}
+			dnsErr := newDNSError(err, d.analytics.ManagementService())
+			d.analytics.emitDNSSetFailedEvent(context.Background(), dnsErr, attempt)
+			return SetResult{}, fmt.Errorf("dns not set, no dns setting method is available: %w", dnsErr)
+		}
+		delay := d.retryDelay(attempt)
+		d.logger.Warn(fmt.Sprintf("setting dns failed, retrying in %v", delay))
+		if !d.waitForRetry(delay) {
+			d.logger.Info("dns was changed while waiting for the retry, giving up")
+			return SetResult{}, errSetSuperseded
+		}
 	}
+}
+
+// nameserverPlan describes the nameservers applied for the requested ones
+type nameserverPlan struct {
+	nameservers []string
+	source      nameserverSource
+	// ipv6Fallback is true when only the IPv4 nameservers are applied, because IPv6 did not work
+	ipv6Fallback bool
+}
 
+// planNameservers returns the nameservers applied for the requested ones, after the override from
+// the environment, validation and ordering. It does not change the state of the setter, so that
+// DryRun plans the same nameservers as Set. Must be called with mu locked.
+func (d *DefaultSetter) planNameservers(nameservers []string, source nameserverSource) (nameserverPlan, error) {
+	if override := d.nameserversOverride(); override != nil {
+		d.logger.Warn(fmt.Sprintf("nameservers overridden by %s:", envDNSServers), override)
+		nameservers = override
+		source = envOverrideSource
+	}
+	// the first nameserver provided is the primary one, regardless of the ordering policy
+	primary := ""
+	if len(nameservers) > 0 {
+		primary = nameservers[0]
+	}
+	usable, ipv6Fallback, err := d.usableNameservers(nameservers)
+	if err != nil {
+		return nameserverPlan{}, err
+	}
+	return nameserverPlan{
+		nameservers:  keepPrimaryNameserver(usable, primary),
+		source:       source,
+		ipv6Fallback: ipv6Fallback,
+	}, nil
+}
+
+// waitForRetry waits for the delay with mu unlocked, so that the other calls are not blocked by
+// the backoff. Returns false if DNS was set or unset by another call in the meantime. Must be
+// called with mu locked.
+func (d *DefaultSetter) waitForRetry(delay time.Duration) bool {
+	generation := d.generation
+	d.mu.Unlock()
+	<-d.clock.After(delay)
+	d.mu.Lock()
+	return d.generation == generation
+}
+
+// setWithAvailableMethod sets DNS with the first method which succeeds. Returns the error of the
+// last method if all of them fail. requested are the nameservers passed to Set, which are
+// re-applied by Refresh, and source describes where the nameservers came from.
+func (d *DefaultSetter) setWithAvailableMethod(
+	iface string,
+	requested []string,
+	nameservers []string,
+	ipv4Nameservers []string,
+	source nameserverSource,
+	trigger configurationTrigger,
+) (SetResult, error) {
+	lastErr := errors.New("no dns setting methods")
+	// resolvedErr is the error of systemd-resolved methods, when they fail even though
+	// systemd-resolved manages DNS on the host
+	var resolvedErr error
+	etcReadOnly := d.isEtcReadOnly()
+	containerManaged := d.isContainerManaged()
+	if containerManaged {
+		d.logger.Info("resolv.conf is bind mounted by the container runtime, it is written in place")
+	}
 	for _, method := range d.methods {
+		if file, ok := method.(*ResolvConfFile); ok {
+			file.bindMounted = containerManaged
+		}
+	}
+	for _, method := range d.methods {
+		if etcReadOnly && writesResolvConf(method) {
+			d.logger.Info("resolv.conf is on a read-only file system, skipping:", method.Name())
+			continue
+		}
+		if d.networkNamespace != "" && !supportsNetworkNamespace(method) {
+			d.logger.Info("dns can't be set inside a network namespace, skipping:", method.Name())
+			lastErr = fmt.Errorf("%s: %w", method.Name(), errNetworkNamespaceUnsupported)
+			continue
+		}
 		d.publisher.Publish("set dns for interface [" + iface + "] using: " + method.Name())
-		if err := method.Set(iface, nameservers); err != nil {
-			log.Println(internal.ErrorPrefix, fmt.Errorf("setting dns with %s: %w", method.Name(), err))
+		var applied []string
+		err := d.inNetworkNamespace(d.networkNamespace, method, func() (err error) {
+			applied, err = d.setWithMethod(method, iface, nameservers, ipv4Nameservers)
+			return err
+		})
+		if err != nil {
+			d.logger.Error(fmt.Errorf("setting dns with %s: %w", method.Name(), err))
+			lastErr = err
+			if managementServiceForMethod(method) == systemdResolvedService && d.isResolvedDetected() {
+				resolvedErr = err
+			}
 			continue
 		}
-		return nil
+		previous, previousIface, previousNamespace := d.active, d.iface, d.appliedNamespace
+		d.iface = iface
+		d.nameservers = slices.Clone(requested)
+		d.active = method
+		d.applied = slices.Clone(applied)
+		d.appliedNamespace = d.networkNamespace
+		d.updateMirror(method, iface, applied)
+		// the configuration of another method, or of another namespace, is removed only after
+		// the new one is live, so that there is no moment without nameservers
+		if previous != nil && (previous != method || previousNamespace != d.appliedNamespace) &&
+			previous != d.mirrored {
+			d.unsetReplaced(previous, previousIface, previousNamespace)
+		}
+		d.cacheFlushed = d.flushDNSCaches(method)
+		d.analytics.setManagementService(managementServiceForMethod(method))
+		if len(applied) != len(nameservers) {
+			d.analytics.emitDNSConfigurationErrorEvent(context.Background(), ipv6SetFailedErrorType, severityByType)
+		}
+		if resolvedErr != nil && managementServiceForMethod(method) == unmanagedService {
+			d.logger.Warn("systemd-resolved is not reachable, resolv.conf was written directly:", resolvedErr)
+			d.analytics.emitDNSFallbackEvent(context.Background(), errorTypeFromError(resolvedErr), resolvConfFallback)
+		}
+		d.analytics.emitDNSConfiguredEvent(context.Background(),
+			d.describeConfiguration(method, iface, source, trigger, appliedAction))
+		if managementServiceForMethod(method) == systemdResolvedService {
+			d.checkGlobalDNSConflict()
+		}
+		result := d.setResult(method, applied, nameservers)
+		// in append mode, resolv.conf contains pre-VPN nameservers as well
+		if file, ok := method.(*ResolvConfFile); ok && file.written != nil && d.appliedNamespace == "" {
+			d.verifyResolvConf(file.written)
+			// changes of our own write can still be delivered to the monitor
+			d.monitor.expectWrite(file.content)
+			// resolv.conf is managed by NordVPN, so other tools should not change it
+			if err := d.monitor.Start(file.written); err != nil {
+				d.logger.Warn("starting resolv.conf monitor:", err)
+			}
+		} else {
+			// resolv.conf is not managed by NordVPN anymore
+			d.monitor.Stop()
+		}
+		return result, nil
 	}
+	if containerManaged {
+		d.logger.Error("dns not set, resolv.conf is managed by the container runtime, " +
+			"configure dns of the container instead, e.g. with 'docker run --dns'")
+	}
+	if etcReadOnly {
+		return SetResult{}, fmt.Errorf("%w: %w", errEtcReadOnly, lastErr)
+	}
+	return SetResult{}, lastErr
+}
+
+// unsetReplaced unsets DNS set with the method before it was replaced by another one
+func (d *DefaultSetter) unsetReplaced(method Method, iface string, namespace string) {
+	d.publisher.Publish("unset dns for interface [" + iface + "] using: " + method.Name())
+	if err := d.inNetworkNamespace(namespace, method, func() error { return method.Unset(iface) }); err != nil {
+		d.logger.Warn(fmt.Errorf("unsetting dns with %s: %w", method.Name(), err))
+	}
+}
 
-	return fmt.Errorf("dns not set, no dns setting method is available")
+// describeConfiguration returns the details of the configuration set with the method
+func (d *DefaultSetter) describeConfiguration(
+	method Method,
+	iface string,
+	source nameserverSource,
+	trigger configurationTrigger,
+	action configurationAction,
+) configurationDetails {
+	return configurationDetails{
+		splitRouting:      isSplitRoutingApplied(method),
+		appendMode:        isAppendModeApplied(method),
+		exclusiveMode:     isExclusiveModeApplied(method),
+		addressFamily:     d.addressFamily(),
+		searchDomainCount: searchDomainCount(method),
+		threatProtection:  d.threatProtection,
+		source:            source,
+		interfaceIndex:    d.interfaceIndex(iface),
+		trigger:           trigger,
+		action:            action,
+		profile:           d.profile,
+		etcReadOnly:       d.isEtcReadOnly(),
+		ipv6Fallback:      d.ipv6Fallback,
+		writeMode:         writeModeApplied(method),
+		nameserverOrder:   d.nameserverOrder,
+		containerManaged:  d.isContainerManaged(),
+		networkNamespace:  d.networkNamespace,
+		dnssec:            dnssecModeApplied(method),
+		customOptions:     isCustomOptionsApplied(method),
+		mirrored:          d.mirrored != nil,
+		cacheFlushed:      d.cacheFlushed,
+	}
+}
+
+// interfaceIndex returns the index of the interface, or 0 if it does not exist, e.g. when the
+// method does not need it
+func (d *DefaultSetter) interfaceIndex(iface string) int {
+	link, err := d.interfaceByName(iface)
+	if err != nil {
+		d.logger.Debug("getting interface index:", err)
+		return 0
+	}
+	return link.Index
+}
+
+// setRetryDelay doubles the delay after every failed attempt
+func setRetryDelay(attempt int) time.Duration {
+	return exponentialRetryDelay(setRetryBaseDelay)(attempt)
+}
+
+// exponentialRetryDelay starts with the base delay and doubles it after every failed attempt
+func exponentialRetryDelay(base time.Duration) CalculateRetryDelayForAttempt {
+	return func(attempt int) time.Duration {
+		return base << attempt
+	}
+}
+
+// usableNameservers validates the nameservers and returns the ones which can be set on the host.
+// ipv6Fallback is true when the IPv6 nameservers were left out, because they are unreachable.
+func (d *DefaultSetter) usableNameservers(nameservers []string) (usable []string, ipv6Fallback bool, err error) {
+	if len(nameservers) == 0 {
+		return nil, false, errors.New("nameservers not provided")
+	}
+
+	if err := validateNameservers(nameservers); err != nil {
+		return nil, false, err
+	}
+	addresses := make([]netip.Addr, len(nameservers))
+	for idx, nameserver := range nameservers {
+		addresses[idx] = netip.MustParseAddr(nameserver)
+	}
+	if err := validateResolvers(addresses); err != nil {
+		if !errors.Is(err, errLoopbackNameserver) || !d.isLoopbackAllowed() {
+			return nil, false, fmt.Errorf("%w: %w", errInvalidNameserver, err)
+		}
+		d.logger.Warn("setting loopback nameservers, they work only with a local resolver:", err)
+	}
+	nameservers = orderNameservers(nameservers, d.nameserverOrder)
+
+	ipv4Nameservers := filterIPv4(nameservers)
+	if len(ipv4Nameservers) != len(nameservers) && !d.isIPv6Enabled() {
+		// IPv6 nameservers are not usable, but they are not an error either
+		d.logger.Info("IPv6 is disabled, skipping IPv6 nameservers")
+		if len(ipv4Nameservers) == 0 {
+			return nil, false, errors.New("only IPv6 nameservers provided, but IPv6 is disabled")
+		}
+		return ipv4Nameservers, false, nil
+	}
+	if d.addressFamily() == ipv6AddressFamily {
+		// IPv4 nameservers are kept in case the route appears later, but IPv6 ones are preferred
+		ipv6Nameservers := filterIPv6(nameservers)
+		if len(ipv6Nameservers) == 0 {
+			return nil, false, errNoIPv6Nameservers
+		}
+		d.logger.Info("host has no IPv4 route, preferring IPv6 nameservers")
+		return append(ipv6Nameservers, ipv4Nameservers...), false, nil
+	}
+	usable, ipv6Fallback = d.ipv6FallbackNameservers(nameservers, ipv4Nameservers)
+	return usable, ipv6Fallback, nil
+}
+
+// setWithMethod sets the nameservers using the given method. If it fails for a mix of IPv4 and
+// IPv6 nameservers, only the IPv4 nameservers are set, so that DNS works at least partially.
+// Returns the nameservers which were set.
+func (d *DefaultSetter) setWithMethod(
+	method Method,
+	iface string,
+	nameservers []string,
+	ipv4Nameservers []string,
+) ([]string, error) {
+	err := method.Set(iface, nameservers)
+	if err == nil {
+		return nameservers, nil
+	}
+	if len(ipv4Nameservers) == 0 || len(ipv4Nameservers) == len(nameservers) {
+		return nil, err
+	}
+
+	d.logger.Warn(
+		fmt.Errorf("setting ipv6 dns with %s, falling back to ipv4 only: %w", method.Name(), err))
+	if err := method.Set(iface, ipv4Nameservers); err != nil {
+		return nil, err
+	}
+	return ipv4Nameservers, nil
+}
+
+// verifyResolvConf checks if resolv.conf contains the nameservers written by NordVPN, because
+// other DNS managers can revert it right after it is written
+func (d *DefaultSetter) verifyResolvConf(expected []string) {
+	content, err := internal.FileRead(d.monitor.filePath)
+	if err != nil {
+		d.logger.Warn("reading resolv.conf for verification:", err)
+		return
+	}
+	if !sameNameservers(nameserversFromResolvConf(content), expected) {
+		d.logger.Warn("resolv.conf was reverted right after writing it")
+		d.analytics.emitDNSConfigurationErrorEvent(context.Background(), revertedAfterWriteErrorType, severityByType)
+	}
+}
+
+// isLoopbackAllowed checks if loopback nameservers can be set, which is the case only when
+// resolv.conf is edited directly, so that a local resolver can keep running next to NordVPN
+func (d *DefaultSetter) isLoopbackAllowed() bool {
+	for _, method := range d.methods {
+		if isAppendModeApplied(method) {
+			return true
+		}
+	}
+	return d.analytics.ManagementService() == unmanagedService
+}
+
+// validateNameservers checks if all of the nameservers are valid IPv4 or IPv6 addresses, so
+// that user provided (custom) nameservers are not silently dropped by the DNS handling methods
+func validateNameservers(nameservers []string) error {
+	for _, nameserver := range nameservers {
+		if _, err := netip.ParseAddr(nameserver); err != nil {
+			return fmt.Errorf("%w %q: %w", errInvalidNameserver, nameserver, err)
+		}
+	}
+	return nil
+}
+
+// validateResolvers rejects the addresses which break name resolution when they are set as
+// nameservers. Loopback addresses are reported with errLoopbackNameserver, because they are
+// usable only when a local resolver is running.
+func validateResolvers(addresses []netip.Addr) error {
+	loopback := []netip.Addr{}
+	for _, address := range addresses {
+		switch {
+		case address.IsUnspecified():
+			return fmt.Errorf("unspecified address %s", address)
+		case address.IsMulticast():
+			return fmt.Errorf("multicast address %s", address)
+		case address.IsLoopback():
+			loopback = append(loopback, address)
+		}
+	}
+	if len(loopback) > 0 {
+		return fmt.Errorf("%w %v", errLoopbackNameserver, loopback)
+	}
+	return nil
+}
+
+// filterIPv4 returns only IPv4 nameservers. Nameservers must be already validated.
+func filterIPv4(nameservers []string) []string {
+	ipv4Nameservers := []string{}
+	for _, nameserver := range nameservers {
+		if netip.MustParseAddr(nameserver).Unmap().Is4() {
+			ipv4Nameservers = append(ipv4Nameservers, nameserver)
+		}
+	}
+	return ipv4Nameservers
+}
+
+// isIPv6Enabled checks if IPv6 is enabled on the host
+func isIPv6Enabled() bool {
+	params, err := kernel.Parameter(netIPv6DisabledParameter)
+	if err != nil {
+		// parameter is missing when IPv6 module is not loaded
+		return false
+	}
+	return params[netIPv6DisabledParameter] == 0
+}
+
+func isSplitRoutingApplied(method Method) bool {
+	resolved, ok := method.(*Resolved)
+	return ok && len(resolved.routingDomains) > 0
+}
+
+func isAppendModeApplied(method Method) bool {
+	file, ok := method.(*ResolvConfFile)
+	return ok && file.appendMode
+}
+
+// writeModeApplied returns the way resolv.conf was written, it is empty when the method does not
+// write it directly
+func writeModeApplied(method Method) string {
+	if file, ok := method.(*ResolvConfFile); ok && file.written != nil {
+		return file.activeWriteMode().String()
+	}
+	return ""
+}
+
+// isCustomOptionsApplied checks if ndots or timeout set by the caller were written to resolv.conf
+func isCustomOptionsApplied(method Method) bool {
+	file, ok := method.(*ResolvConfFile)
+	return ok && file.written != nil && len(file.optionOverrides) > 0
+}
+
+func isExclusiveModeApplied(method Method) bool {
+	resolvconf, ok := method.(*Resolvconf)
+	return ok && resolvconf.exclusive
+}
+
+// managedMethod is implemented by the methods which know the service managing DNS while they
+// are used, so that new methods are reported in analytics without changes to the setter
+type managedMethod interface {
+	managementService() dnsManagementService
+}
+
+// availabilityChecker is implemented by the methods which can check if they are usable on the
+// host without changing the DNS configuration
+type availabilityChecker interface {
+	available() error
+}
+
+func managementServiceForMethod(method Method) dnsManagementService {
+	if managed, ok := method.(managedMethod); ok {
+		return managed.managementService()
+	}
+	return unknownService
+}
+
+// OnManagementServiceChange registers the callback called with the names of the previous and
+// the current DNS management service, e.g. systemd-resolved, after the service changes
+func (d *DefaultSetter) OnManagementServiceChange(callback func(old string, new string)) {
+	d.analytics.OnManagementServiceChange(func(old, new dnsManagementService) {
+		callback(old.String(), new.String())
+	})
+}
+
+// DetectManagementService detects the service which will manage DNS without changing the
+// configuration and reports it in analytics. It is meant to be called once at daemon start.
+// When DNS is already set, the service of the used method is reported. Right after boot the
+// services may still be starting, so detection is retried for detectionQuietPeriod before it is
+// reported as failed.
+func (d *DefaultSetter) DetectManagementService(ctx context.Context) {
+	deadline := d.clock.Now().Add(detectionQuietPeriod)
+	for {
+		if d.detectManagementService(ctx) {
+			return
+		}
+		if !d.clock.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.clock.After(detectionRetryInterval):
+		}
+	}
+	d.logger.Warn("dns management service was not detected")
+	// DNS is not set at this point, so nothing stops working because of it
+	d.analytics.emitDNSConfigurationErrorEvent(ctx, detectionFailedErrorType, severityNonCritical)
+}
+
+// detectManagementService reports the service of the first available method. Returns false if
+// none of the methods is available.
+func (d *DefaultSetter) detectManagementService(ctx context.Context) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active != nil {
+		d.analytics.emitDNSManagementDetectedEvent(ctx)
+		return true
+	}
+
+	for _, method := range d.methods {
+		checker, ok := method.(availabilityChecker)
+		if !ok {
+			continue
+		}
+		if err := checker.available(); err != nil {
+			d.logger.Debug(fmt.Errorf("%s is not available: %w", method.Name(), err))
+			continue
+		}
+		service := managementServiceForMethod(method)
+		d.logger.Info("detected dns management service:", service)
+		d.analytics.setManagementService(service)
+		d.analytics.emitDNSManagementDetectedEvent(ctx)
+		return true
+	}
+	d.logger.Debug("dns management service was not detected yet")
+	return false
 }
 
 // Unset DNS for network interface, restore DNS from a backup, if backup
 // is available, and remove the backup on success.
 func (d *DefaultSetter) Unset(iface string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	d.publisher.Publish("unsetting DNS")
+	d.generation++
 
+	d.monitor.Stop()
+	d.monitor.resetReapplies()
+	d.iface = ""
+	d.nameservers = nil
+	d.active = nil
+	d.applied = nil
+	namespace := d.appliedNamespace
+	d.appliedNamespace = ""
+	if d.mirrored != nil {
+		d.unsetMirror(d.mirrored, iface, namespace)
+		d.mirrored = nil
+	}
 	for _, method := range d.methods {
 		d.publisher.Publish("unset dns for interface [" + iface + "] using: " + method.Name())
-		if err := method.Unset(iface); err != nil {
-			log.Println(internal.ErrorPrefix, fmt.Errorf("unsetting dns with %s: %w", method.Name(), err))
+		if err := d.inNetworkNamespace(namespace, method, func() error { return method.Unset(iface) }); err != nil {
+			d.logger.Error(fmt.Errorf("unsetting dns with %s: %w", method.Name(), err))
+			continue
+		}
+		d.verifyRestore(method, iface)
+		return nil
+	}
+	d.preVPNResolvers = nil
+
+	return nil
+}
+
+// ManagementService returns name of the service which handles DNS while it is set by NordVPN,
+// e.g. systemd-resolved, or unmanaged when resolv.conf is edited directly.
+func (d *DefaultSetter) ManagementService() string {
+	return d.analytics.ManagementService().String()
+}
+
+// LastError returns the type of the most recent DNS error, e.g. permission_denied, so that it
+// can be shown in the status without parsing the logs. critical is true when DNS was left unset
+// or set incorrectly. ok is false if no error happened since the daemon started.
+func (d *DefaultSetter) LastError() (errorType string, critical bool, emittedAt time.Time, ok bool) {
+	errType, critical, emittedAt, ok := d.analytics.LastError()
+	if !ok {
+		return "", false, time.Time{}, false
+	}
+	return errType.String(), critical, emittedAt, true
+}
+
+// RecentEvents returns the most recent DNS analytics events, from the oldest to the newest, so
+// that they can be included in diagnostic archives. Events are recorded even when analytics are
+// disabled.
+func (d *DefaultSetter) RecentEvents() []EventRecord {
+	return d.analytics.Snapshot()
+}
+
+// DumpEvents writes the most recent DNS analytics events to w as newline-delimited JSON, from the
+// oldest to the newest, e.g. for support bundles. Every line is the payload of the published
+// event.
+func (d *DefaultSetter) DumpEvents(w io.Writer) error {
+	return d.analytics.DumpEvents(w)
+}
+
+// SetDBusTimeout limits the time systemd-resolved has to apply or revert DNS configuration and
+// to flush its caches. When it does not respond in time, DNS is set with the next available
+// method. Takes effect the next time DNS is set or unset.
+func (d *DefaultSetter) SetDBusTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dbusTimeout = timeout
+	for _, method := range d.methods {
+		switch method := method.(type) {
+		case *Resolved:
+			method.timeout = timeout
+		case *Resolvectl:
+			method.timeout = timeout
+		}
+	}
+}
+
+// Close stops publishing the analytics events, it is called when the daemon stops. The events
+// emitted afterwards are only kept in the history.
+func (d *DefaultSetter) Close() {
+	d.analytics.Close()
+}
+
+// SetMetrics sets the sink of the DNS counters, which are incremented together with the DNS
+// analytics events.
+func (d *DefaultSetter) SetMetrics(metrics Metrics) {
+	d.analytics.setMetrics(metrics)
+}
+
+// NotifyAnalyticsConsent stops or resumes publishing of the DNS analytics events when the user
+// withdraws or gives the consent to analytics. The recent events are kept in memory for
+// diagnostics either way, see RecentEvents.
+func (d *DefaultSetter) NotifyAnalyticsConsent(enabled bool) error {
+	d.analytics.setPublishing(enabled)
+	return nil
+}
+
+// SetDNSOverTLS enables DNS-over-TLS when systemd-resolved is used. serverNames maps nameserver
+// addresses to their TLS server names, DNS-over-TLS is disabled when it is empty. The change
+// takes effect the next time DNS is set.
+func (d *DefaultSetter) SetDNSOverTLS(serverNames map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if resolved, ok := method.(*Resolved); ok {
+			resolved.tlsServerNames = maps.Clone(serverNames)
+		}
+	}
+}
+
+// SetResolvConfContentReporting includes raw resolv.conf content in the analytics events
+// reporting its changes by third parties. By default only a redacted summary of the changes is
+// reported, because the content may contain internal hostnames, so it should be enabled only
+// for debugging.
+func (d *DefaultSetter) SetResolvConfContentReporting(enabled bool) {
+	d.monitor.setIncludeContent(enabled)
+}
+
+// SetResolvConfReapply makes NordVPN set DNS again when resolv.conf edited by it directly is
+// overwritten by another tool. Re-applying is given up when resolv.conf keeps being
+// overwritten, so that NordVPN does not fight with another DNS manager.
+func (d *DefaultSetter) SetResolvConfReapply(enabled bool) {
+	if enabled {
+		d.monitor.setReapply(d.reapplyResolvConf)
+	} else {
+		d.monitor.setReapply(nil)
+	}
+}
+
+// reapplyResolvConf sets the last configuration set with Set again
+func (d *DefaultSetter) reapplyResolvConf() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active == nil {
+		return
+	}
+	if _, err := d.set(d.iface, d.nameservers, d.source, reapplyTrigger); err != nil {
+		d.logger.Error("re-applying dns:", err)
+	}
+}
+
+// SetResolvConfAppendMode keeps the pre-VPN nameservers when resolv.conf is edited directly and
+// adds the VPN nameservers after them, e.g. for hosts running a local caching resolver. The
+// change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetResolvConfAppendMode(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if file, ok := method.(*ResolvConfFile); ok {
+			file.appendMode = enabled
+		}
+	}
+}
+
+// SetResolvConfOptions sets the options written to resolv.conf when it is edited directly. The
+// options of the original resolv.conf, e.g. edns0 or ndots:2, are kept when options is nil and
+// removed when it is empty. The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetResolvConfOptions(options []string) error {
+	if err := validateResolvConfOptions(options); err != nil {
+		return fmt.Errorf("validating resolv.conf options: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if file, ok := method.(*ResolvConfFile); ok {
+			file.options = slices.Clone(options)
+		}
+	}
+	return nil
+}
+
+// SetResolvConfNdotsTimeout sets ndots and timeout options written to resolv.conf when it is
+// edited directly, e.g. for the networks with short search domains. They replace the same options
+// of the original resolv.conf and of SetResolvConfOptions. ndots must be in range 0-15 and timeout
+// in range 1-30 seconds, KeepResolvConfOption leaves the option unchanged. The change takes effect
+// the next time DNS is set.
+func (d *DefaultSetter) SetResolvConfNdotsTimeout(ndots int, timeout int) error {
+	overrides, err := ndotsTimeoutOptions(ndots, timeout)
+	if err != nil {
+		return fmt.Errorf("validating resolv.conf options: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if file, ok := method.(*ResolvConfFile); ok {
+			file.optionOverrides = overrides
+		}
+	}
+	return nil
+}
+
+// SetResolvConfWriteMode sets the way resolv.conf is written when it is edited directly. Atomic
+// rename is used by default, in-place writes are meant for the hosts where other tools watch
+// resolv.conf or hold it open. The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetResolvConfWriteMode(mode ResolvConfWriteMode) error {
+	if !slices.Contains(enumMembers[ResolvConfWriteMode](), mode) {
+		return fmt.Errorf("unknown resolv.conf write mode %s", mode)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if file, ok := method.(*ResolvConfFile); ok {
+			file.writeMode = mode
+		}
+	}
+	return nil
+}
+
+// SetNameserverOrder sets the policy of ordering the nameservers before they are set. The
+// provided order is preserved by default. Whatever the policy, the first nameserver provided is
+// kept among the nameservers used by glibc. The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetNameserverOrder(order NameserverOrder) error {
+	if !slices.Contains(enumMembers[NameserverOrder](), order) {
+		return fmt.Errorf("unknown nameserver order %s", order)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nameserverOrder = order
+	return nil
+}
+
+// SetResolvconfExclusiveMode makes the VPN nameservers the only ones used while DNS is managed
+// by openresolv, the nameservers of the other interfaces are ignored. It is enabled by default.
+// The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetResolvconfExclusiveMode(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if resolvconf, ok := method.(*Resolvconf); ok {
+			resolvconf.exclusive = enabled
+		}
+	}
+}
+
+// SetRetries sets the number of times setting DNS is retried when all of the methods fail. The
+// critical error is reported only after the last retry fails.
+func (d *DefaultSetter) SetRetries(retries int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retries = max(retries, 0)
+}
+
+// SetRoutingDomains configures split DNS when systemd-resolved is used. domains maps domain
+// suffixes to the nameservers resolving them, "~." routes all of the remaining domains to the
+// VPN nameservers. Split DNS is disabled when domains is empty. The change takes effect the next
+// time DNS is set.
+func (d *DefaultSetter) SetRoutingDomains(domains map[string][]string) error {
+	normalized, err := normalizeRoutingDomains(domains)
+	if err != nil {
+		return fmt.Errorf("validating routing domains: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if resolved, ok := method.(*Resolved); ok {
+			if err := validateRoutes(normalized, resolved.linkRoutes); err != nil {
+				return fmt.Errorf("validating routing domains: %w", err)
+			}
+		}
+	}
+	for _, method := range d.methods {
+		if resolved, ok := method.(*Resolved); ok {
+			resolved.routingDomains = normalized
+		}
+	}
+	return nil
+}
+
+// SetInterfaceRoutingDomains routes the domains to the nameservers of iface instead of the VPN
+// ones when systemd-resolved is used, e.g. so that meshnet names are resolved by the meshnet
+// resolver. domains maps domain suffixes to the nameservers resolving them, the routes of iface
+// are removed when it is empty. A domain can be routed to a single interface only. The change
+// takes effect the next time DNS is set.
+func (d *DefaultSetter) SetInterfaceRoutingDomains(iface string, domains map[string][]string) error {
+	if iface == "" {
+		return errors.New("validating routing domains: no interface")
+	}
+	normalized, err := normalizeRoutingDomains(domains)
+	if err != nil {
+		return fmt.Errorf("validating routing domains of %s: %w", iface, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		resolved, ok := method.(*Resolved)
+		if !ok {
 			continue
 		}
+		routes := maps.Clone(resolved.linkRoutes)
+		if routes == nil {
+			routes = map[string]map[string][]string{}
+		}
+		delete(routes, iface)
+		if len(normalized) > 0 {
+			routes[iface] = normalized
+		}
+		if err := validateRoutes(resolved.routingDomains, routes); err != nil {
+			return fmt.Errorf("validating routing domains of %s: %w", iface, err)
+		}
+		resolved.linkRoutes = routes
+	}
+	return nil
+}
+
+// NotifyThreatProtectionLite records whether Threat Protection Lite is enabled, so that DNS
+// issues can be attributed to it in analytics. It does not change the nameservers.
+func (d *DefaultSetter) NotifyThreatProtectionLite(enabled bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threatProtection = enabled
+	return nil
+}
+
+// SetSearchDomains configures the domains used for completing single label names when
+// systemd-resolved is used or resolv.conf is edited directly. Search domains are removed when
+// domains is empty. Only the domains within the glibc limits are written to resolv.conf. The
+// change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetSearchDomains(domains []string) error {
+	normalized, err := normalizeSearchDomains(domains)
+	if err != nil {
+		return fmt.Errorf("validating search domains: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		switch method := method.(type) {
+		case *Resolved:
+			method.searchDomains = normalized
+		case *ResolvConfFile:
+			method.searchDomains = normalized
+		}
+	}
+	return nil
+}
+
+// Refresh detects the DNS handling method again and re-applies the last
+// configuration set with Set. It is meant to be called after system changes
+// (e.g. systemd-resolved got installed or started) which may change the
+// method to be used. Configuration of the previously used method is reverted
+// before the new one is applied, so that two methods never manage DNS at once.
+// Nothing is done if DNS is not set.
+func (d *DefaultSetter) Refresh() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active == nil {
 		return nil
 	}
+	return d.refresh()
+}
+
+// refresh sets DNS again with the same nameservers, must be called with mu locked while DNS is
+// set. The new configuration replaces the current one, which is unset only when another method
+// is used or setting fails, so that lookups keep working during the refresh.
+func (d *DefaultSetter) refresh() error {
+	d.publisher.Publish("refreshing dns for interface [" + d.iface + "]")
+	previous := d.active
+
+	if _, err := d.set(d.iface, d.nameservers, d.source, refreshTrigger); err != nil {
+		if errors.Is(err, errSetSuperseded) {
+			// configuration belongs to the call made in the meantime
+			return fmt.Errorf("refreshing dns: %w", err)
+		}
+		d.unsetReplaced(previous, d.iface, d.appliedNamespace)
+		d.iface = ""
+		d.nameservers = nil
+		d.active = nil
+		d.applied = nil
+		return fmt.Errorf("refreshing dns: %w", err)
+	}
 
+	if d.active != previous {
+		d.publisher.Publish("dns method changed from " + previous.Name() + " to " + d.active.Name())
+	}
 	return nil
 }
 
-// RestoreResolvConfFile try to restore resolv.conf if target file contains Nordvpn changes
+// RestoreResolvConfFile try to restore resolv.conf if target file contains Nordvpn changes. The
+// resolvconf record left behind by the previous daemon run is removed as well.
 func RestoreResolvConfFile() {
-	tryToRestoreDNS()
+	tryToRestoreDNS(defaultLogger{})
+	newResolvconf(defaultLogger{}).removeStaleRecord("")
 }
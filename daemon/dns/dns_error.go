@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errResolvConfImmutable means that resolv.conf was not written, because the user has set the
+// immutable attribute on it
+var errResolvConfImmutable = errors.New("resolv.conf is immutable")
+
+// DNSError is an error of setting DNS classified the same way as in the analytics events, so
+// that the callers can branch on it with errors.As and still reach the underlying cause.
+type DNSError struct {
+	// Type is reported as the error_type of the analytics events
+	Type errorType
+	// ManagementService is the service managing DNS on the host when the error happened
+	ManagementService dnsManagementService
+	// Critical is true when DNS was left unset or set incorrectly
+	Critical bool
+	// Timeout is true when the management service did not respond in time
+	Timeout bool
+	// Err is the underlying cause
+	Err error
+}
+
+// newDNSError classifies err, the classification of err is kept when it already is a DNSError.
+// DNS was not set in any way, so criticality is decided without a fallback.
+func newDNSError(err error, service dnsManagementService) *DNSError {
+	var dnsErr *DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr
+	}
+	errorType := errorTypeFromError(err)
+	return &DNSError{
+		Type:              errorType,
+		ManagementService: service,
+		Critical:          isCritical(errorType, service),
+		Timeout:           errors.Is(err, errDBusTimeout),
+		Err:               err,
+	}
+}
+
+func (e *DNSError) Error() string {
+	return fmt.Sprintf("%s (%s): %v", e.Type, e.ManagementService, e.Err)
+}
+
+func (e *DNSError) Unwrap() error {
+	return e.Err
+}
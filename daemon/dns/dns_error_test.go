@@ -0,0 +1,53 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DNSErrorUnwrap(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	cause := &os.PathError{Op: "open", Path: resolvconfFilePath, Err: syscall.EROFS}
+	err := fmt.Errorf("setting dns: %w", newDNSError(cause, unmanagedService))
+
+	var dnsErr *DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.Equal(t, readOnlyFilesystemErrorType, dnsErr.Type)
+	assert.Equal(t, unmanagedService, dnsErr.ManagementService)
+	assert.True(t, dnsErr.Critical)
+	assert.ErrorIs(t, err, syscall.EROFS)
+	assert.Equal(t, "read_only_filesystem (unmanaged): "+cause.Error(), dnsErr.Error())
+}
+
+func Test_NewDNSErrorKeepsClassification(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	classified := &DNSError{Type: fileImmutableErrorType, ManagementService: unmanagedService, Err: syscall.EPERM}
+	dnsErr := newDNSError(fmt.Errorf("setting dns: %w", classified), systemdResolvedService)
+	assert.Same(t, classified, dnsErr)
+	assert.Equal(t, fileImmutableErrorType, errorTypeFromError(dnsErr))
+}
+
+func Test_SetFailureReturnsDNSError(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{managementService: unmanagedService}
+	ds := newTestSetter(analytics, &MockMethod{err: fmt.Errorf("writing file: %w", syscall.EACCES)})
+	err := ds.Set("lo", []string{"103.86.96.100"})
+
+	var dnsErr *DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, errors.Is(err, syscall.EACCES))
+	assert.Equal(t, unmanagedService, dnsErr.ManagementService)
+	// the type reported in the event is the one returned to the caller
+	assert.Equal(t, []mockErrorEvent{{errorType: dnsErr.Type, critical: true}}, analytics.getErrorEvents())
+	assert.Equal(t, permissionDeniedErrorType, dnsErr.Type)
+}
@@ -2,10 +2,12 @@ package dns
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -25,21 +27,138 @@ const (
 	resolconfInterfaceFilePath = "/etc/resolvconf/interface-order"
 )
 
+var (
+	// resolvconfRecordPath stores the name of the record added to resolvconf, so that it can be
+	// removed after the daemon was stopped without unsetting DNS
+	resolvconfRecordPath = filepath.Join(internal.BakFilesPath, "resolvconf-record")
+)
+
 // Resolvconf based DNS handling method
-type Resolvconf struct{}
+type Resolvconf struct {
+	logger Logger
+	// exclusive registers the nameservers with -x, so that openresolv ignores the nameservers
+	// of the other interfaces while they are set
+	exclusive bool
+	// recordPath stores the name of the record added by Set
+	recordPath string
+	// run executes resolvconf with the input passed to its stdin
+	run func(stdin string, args ...string) ([]byte, error)
+}
+
+func newResolvconf(logger Logger) *Resolvconf {
+	return &Resolvconf{
+		logger:     logger,
+		exclusive:  true,
+		recordPath: resolvconfRecordPath,
+		run:        runResolvconf,
+	}
+}
+
+func runResolvconf(stdin string, args ...string) ([]byte, error) {
+	// #nosec G204 -- the code would have failed already if iface did not belong
+	// to an actual network interface on the system
+	cmd := exec.Command(execResolvconf, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.CombinedOutput()
+}
 
 func (m *Resolvconf) Set(iface string, nameservers []string) error {
-	return setDNSWithResolvconf(iface, nameservers)
+	record, err := resolvconfRecordName(iface)
+	if err != nil {
+		return err
+	}
+	m.removeStaleRecord(record)
+	out, err := m.run(resolvconfRecord(nameservers), resolvconfAddArgs(record, m.exclusive)...)
+	if err != nil {
+		return fmt.Errorf("setting dns with resolvconf: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	if err := internal.FileWrite(m.recordPath, []byte(record), internal.PermUserRWGroupROthersR); err != nil {
+		m.logger.Warn("storing resolvconf record name:", err)
+	}
+	return nil
 }
 
 func (m *Resolvconf) Unset(iface string) error {
-	return unsetDNSWithResolvconf(iface)
+	record, err := resolvconfRecordName(iface)
+	if err != nil {
+		return err
+	}
+	if err := m.deleteRecord(record); err != nil {
+		return err
+	}
+	if err := os.Remove(m.recordPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.logger.Warn("removing resolvconf record name:", err)
+	}
+	return nil
+}
+
+// removeStaleRecord deletes the record left behind when the daemon was stopped without unsetting
+// DNS, e.g. after a crash. In exclusive mode it would keep the nameservers of the other
+// interfaces ignored. The current record is kept, because it is replaced when added again.
+func (m *Resolvconf) removeStaleRecord(current string) {
+	content, err := os.ReadFile(m.recordPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			m.logger.Warn("reading resolvconf record name:", err)
+		}
+		return
+	}
+	stale := strings.TrimSpace(string(content))
+	if stale == "" || stale == current {
+		return
+	}
+	m.logger.Warn("removing stale resolvconf record:", stale)
+	if err := m.deleteRecord(stale); err != nil {
+		m.logger.Warn(err)
+		return
+	}
+	if err := os.Remove(m.recordPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.logger.Warn("removing resolvconf record name:", err)
+	}
+}
+
+func (m *Resolvconf) deleteRecord(record string) error {
+	args := resolvconfDeleteArgs(record)
+	out, err := m.run("", args...)
+	if err != nil {
+		return fmt.Errorf("calling %s: %s", commandString(execResolvconf, args...), strings.Trim(string(out), "\n"))
+	}
+	return nil
+}
+
+// resolvconfRecordName returns the name of the record added to resolvconf for the interface
+func resolvconfRecordName(iface string) (string, error) {
+	prefix, err := resolvconfIfacePrefix(resolconfInterfaceFilePath)
+	if err != nil {
+		return "", fmt.Errorf("determining interface prefix: %w", err)
+	}
+	return prefix + iface, nil
+}
+
+// resolvconfAddArgs returns resolvconf arguments for adding the record with the highest
+// priority. Nameservers of the other records are ignored in exclusive mode.
+func resolvconfAddArgs(record string, exclusive bool) []string {
+	args := []string{"-a", record, "-m", "0"}
+	if exclusive {
+		args = append(args, "-x")
+	}
+	return args
+}
+
+// resolvconfDeleteArgs returns resolvconf arguments for deleting the record, which does not
+// fail if the record does not exist
+func resolvconfDeleteArgs(record string) []string {
+	return []string{"-d", record, "-f"}
 }
 
 func (m *Resolvconf) Name() string {
 	return "resolvconf"
 }
 
+func (m *Resolvconf) managementService() dnsManagementService {
+	return resolvconfService
+}
+
 func resolvconfIfacePrefix(filePath string) (string, error) {
 	if internal.FileExists(filePath) {
 		// #nosec G304 - file path/name is constant
@@ -67,40 +186,30 @@ func checkForEntry(data io.Reader) string {
 	return ""
 }
 
-func setDNSWithResolvconf(iface string, addresses []string) error {
-	var addrs = make([]string, len(addresses))
-	for idx, address := range addresses {
-		addrs[idx] = "nameserver " + address
-	}
-	content := strings.Join(addrs, "\n")
-	prefix, err := resolvconfIfacePrefix(resolconfInterfaceFilePath)
-	if err != nil {
-		return fmt.Errorf("determining interface prefix: %w", err)
-	}
-
-	// #nosec G204 -- the code would have failed already if iface did not belong
-	// to an actual network interface on the system
-	cmd := exec.Command(execResolvconf, "-a", prefix+iface, "-m", "0", "-x")
-	cmd.Stdin = strings.NewReader(content)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("setting dns with resolvconf: %s: %w", strings.TrimSpace(string(out)), err)
-	}
-	return nil
+func (m *Resolvconf) available() error {
+	_, err := exec.LookPath(execResolvconf)
+	return err
 }
 
-func unsetDNSWithResolvconf(iface string) error {
-	prefix, err := resolvconfIfacePrefix(resolconfInterfaceFilePath)
+func (m *Resolvconf) DryRun(iface string, nameservers []string) ([]string, error) {
+	if err := m.available(); err != nil {
+		return nil, err
+	}
+	record, err := resolvconfRecordName(iface)
 	if err != nil {
-		return fmt.Errorf("determining interface prefix: %w", err)
+		return nil, err
 	}
+	return []string{
+		commandString(execResolvconf, resolvconfAddArgs(record, m.exclusive)...) +
+			" <<EOF\n" + resolvconfRecord(nameservers) + "\nEOF",
+	}, nil
+}
 
-	// #nosec G204 -- the code would have failed already if iface did not belong
-	// to an actual network interface on the system
-	cmd := exec.Command(execResolvconf, "-d", prefix+iface, "-f")
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("calling %s: %s", cmd.String(), strings.Trim(string(out), "\n"))
+// resolvconfRecord returns the record passed to resolvconf
+func resolvconfRecord(addresses []string) string {
+	var addrs = make([]string, len(addresses))
+	for idx, address := range addresses {
+		addrs[idx] = "nameserver " + address
 	}
-	return nil
+	return strings.Join(addrs, "\n")
 }
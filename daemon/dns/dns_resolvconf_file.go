@@ -1,15 +1,19 @@
 package dns
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"net"
 	"net/netip"
+	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/NordSecurity/nordvpn-linux/daemon/routes/netlink"
 	"github.com/NordSecurity/nordvpn-linux/internal"
+	"golang.org/x/sys/unix"
 )
 
 // Files
@@ -18,8 +22,18 @@ const (
 	resolvconfFilePath = "/etc/resolv.conf"
 	// resolvconfFileMark mark resolv.conf file content to recorgnize
 	resolvconfFileMark = "# Generated by NordVPN"
+	// resolvconfFileHeader is the first line of resolv.conf written by NordVPN, it starts with the
+	// mark, so that the files written by the older versions are recognized as well
+	resolvconfFileHeader = resolvconfFileMark + " - do not edit"
+	// resolvconfFileWrittenMark starts the header line describing when and how resolv.conf was
+	// written
+	resolvconfFileWrittenMark = "# Written at"
 	// resolvconfFileContent is simple dns settings to restore as a last option
 	resolvconfFileContent = "#restored\nnameserver %s\n"
+	// maxResolvConfNameservers is the number of nameservers used by glibc, the rest are ignored
+	maxResolvConfNameservers = 3
+	// fsImmutableFlag is FS_IMMUTABLE_FL inode flag from linux/fs.h
+	fsImmutableFlag = 0x00000010
 )
 
 var (
@@ -29,69 +43,489 @@ var (
 
 // Direct file resolv.conf editing based DNS handling method.
 // This is last fallback method if others are not available
-type ResolvConfFile struct{}
+type ResolvConfFile struct {
+	logger    Logger
+	analytics analytics
+	// appendMode adds the nameservers after the pre-VPN ones instead of replacing them, so that
+	// e.g. a local caching resolver keeps working
+	appendMode bool
+	// searchDomains are written to the search line
+	searchDomains []string
+	// options replace the options of the original resolv.conf, which are kept when options is nil
+	options []string
+	// optionOverrides are ndots and timeout options set by the caller, they replace the options of
+	// the same name
+	optionOverrides []string
+	// written are the nameservers written to resolv.conf by the last Set
+	written []string
+	// content is resolv.conf content written by the last Set
+	content []byte
+	// clock provides the time written to the header, the system time is used when it is nil
+	clock clock
+	// writeMode is the way resolv.conf is written, see ResolvConfWriteMode for the tradeoffs
+	writeMode ResolvConfWriteMode
+	// bindMounted is set when resolv.conf is bind mounted by the container runtime
+	bindMounted bool
+	// namespace is the network namespace resolv.conf is written for, it is set by the setter
+	// before every call. Empty for the namespace of the daemon.
+	namespace string
+}
 
 func (m *ResolvConfFile) Set(iface string, nameservers []string) error {
-	return setDNSinResolvconfFile(nameservers)
+	if m.namespace != "" {
+		return m.setInNamespace(nameservers)
+	}
+	if immutable := isResolvConfImmutable(m.logger); immutable {
+		// file is locked by the user and we respect that
+		m.logger.Warn("dns not set, resolv.conf file is immutable, " +
+			"remove the attribute with 'chattr -i " + resolvconfFilePath + "' to use NordVPN DNS")
+		m.written, m.content = nil, nil
+		return newDNSError(errResolvConfImmutable, m.managementService())
+	}
+	if err := m.recoverDanglingSymlink(resolvconfFilePath); err != nil {
+		return err
+	}
+	header := resolvConfHeader(m.now(), m.managementService())
+	written, content, err := setDNSinResolvconfFile(
+		m.logger, header, nameservers, m.searchDomains, m.options, m.optionOverrides, m.appendMode, m.activeWriteMode())
+	m.written, m.content = written, content
+	if err == nil && written != nil {
+		m.reportTruncated(nameservers, written)
+		m.reportSearchLimit()
+	}
+	return err
+}
+
+// setInNamespace writes resolv.conf of the network namespace, it is not monitored nor locked,
+// because only the programs run in the namespace use it
+func (m *ResolvConfFile) setInNamespace(nameservers []string) error {
+	m.written, m.content = nil, nil
+	if err := backupNamespaceResolvConf(m.namespace); err != nil {
+		return fmt.Errorf("backing up dns: %w", err)
+	}
+	original, err := m.originalContent()
+	if err != nil {
+		if m.appendMode {
+			return err
+		}
+		m.logger.Warn("resolv.conf options will not be preserved:", err)
+	}
+	options := overrideResolvConfOptions(original, m.options, m.optionOverrides)
+	content, written := newResolvConfFileContent(original, nameservers, m.searchDomains, options, m.appendMode)
+	content = resolvConfHeader(m.now(), m.managementService()) + content
+	path := namespaceResolvConfPath(m.namespace)
+	if err := os.MkdirAll(filepath.Dir(path), internal.PermUserRWXGroupRXOthersRX); err != nil {
+		return fmt.Errorf("creating network namespace configuration directory: %w", err)
+	}
+	if err := writeResolvConf(path, []byte(content), m.activeWriteMode()); err != nil {
+		return err
+	}
+	m.written, m.content = written, []byte(content)
+	m.reportTruncated(nameservers, written)
+	m.reportSearchLimit()
+	return nil
+}
+
+// reportTruncated reports the nameservers which were not written, because resolv.conf nameserver
+// limit was reached
+func (m *ResolvConfFile) reportTruncated(nameservers []string, written []string) {
+	missing := slices.DeleteFunc(slices.Clone(nameservers), func(address string) bool {
+		return slices.Contains(written, address)
+	})
+	if len(missing) == 0 {
+		return
+	}
+	m.logger.Warn("resolv.conf nameserver limit reached, nameservers not set:", missing)
+	m.analytics.emitResolversTruncatedEvent(context.Background(),
+		len(nameservers), len(nameservers)-len(missing))
+}
+
+// reportSearchLimit reports the search domains which were not written, because resolv.conf search
+// list limits were reached. In append mode, the search domains of the original resolv.conf are
+// written first.
+func (m *ResolvConfFile) reportSearchLimit() {
+	if len(m.searchDomains) == 0 {
+		return
+	}
+	domains := m.searchDomains
+	if m.appendMode {
+		original, err := m.originalContent()
+		if err != nil {
+			return
+		}
+		domains = mergeSearchDomains(original, domains)
+	}
+	limited := limitSearchDomains(domains)
+	if len(limited) == len(domains) {
+		return
+	}
+	m.logger.Warn("resolv.conf search list limit reached, search domains not set:", domains[len(limited):])
+	m.analytics.emitDNSConfigurationErrorEvent(context.Background(),
+		searchLimitExceededErrorType, severityByType)
+}
+
+// activeWriteMode returns the way resolv.conf is written. Bind mounted file can't be replaced, so
+// it is always written in place.
+func (m *ResolvConfFile) activeWriteMode() ResolvConfWriteMode {
+	if m.bindMounted {
+		return InPlaceWriteMode
+	}
+	return m.writeMode
+}
+
+func (m *ResolvConfFile) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	return m.clock.Now()
+}
+
+// isResolvConfImmutable checks if the immutable attribute was set on resolv.conf by the user.
+// NordVPN sets the attribute as well, but then resolv.conf contains the NordVPN mark.
+func isResolvConfImmutable(logger Logger) bool {
+	out, err := internal.FileRead(resolvconfFilePath)
+	if err != nil || isOwnResolvConf(out) {
+		return false
+	}
+	immutable, err := isFileImmutable(resolvconfFilePath)
+	if err != nil {
+		// not all of the file systems support the file flags ioctl
+		logger.Debug("checking resolv.conf flags:", err)
+		return internal.IsFileLocked(resolvconfFilePath)
+	}
+	return immutable
+}
+
+// isFileImmutable checks if the immutable attribute is set on the file, e.g. with chattr +i
+func isFileImmutable(path string) (bool, error) {
+	// #nosec G304 -- no input comes from the user
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	flags, err := unix.IoctlGetUint32(int(file.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return false, fmt.Errorf("getting file flags: %w", err)
+	}
+	return hasImmutableFlag(flags), nil
+}
+
+// hasImmutableFlag checks if the immutable bit is set in the inode flags
+func hasImmutableFlag(flags uint32) bool {
+	return flags&fsImmutableFlag != 0
 }
 
 func (m *ResolvConfFile) Unset(iface string) error {
-	return unsetDNSinResolvconfFile()
+	if m.namespace != "" {
+		return unsetDNSinNamespaceResolvConf(m.logger, m.namespace)
+	}
+	return unsetDNSinResolvconfFile(m.logger)
 }
 
 func (m *ResolvConfFile) Name() string {
 	return "resolv.conf, default"
 }
 
-func setDNSinResolvconfFile(addresses []string) error {
-	if internal.FileExists(resolvconfFilePath) {
-		if out, err := internal.FileRead(resolvconfFilePath); err == nil &&
-			strings.Contains(string(out), resolvconfFileMark) {
-			// while connected to vpn, dns may be changed then need
-			// to rewrite file with new nameservers - need to check
-			// if target file contains our mark and that means is locked by us
-		} else {
-			if internal.IsFileLocked(resolvconfFilePath) {
-				// here we assume file is locked by user and we respect that
-				log.Println(internal.WarningPrefix, "dns not set, resolv.conf file is locked (immutable)")
-				return nil
-			}
+func (m *ResolvConfFile) managementService() dnsManagementService {
+	return unmanagedService
+}
+
+// available always succeeds, because resolv.conf is edited as the last resort
+func (m *ResolvConfFile) available() error {
+	return nil
+}
+
+func (m *ResolvConfFile) DryRun(iface string, nameservers []string) ([]string, error) {
+	return m.dryRun(m.namespace, nameservers)
+}
+
+// dryRun returns the content which would be written to resolv.conf of the network namespace
+func (m *ResolvConfFile) dryRun(namespace string, nameservers []string) ([]string, error) {
+	original, err := originalContentIn(namespace)
+	if err != nil {
+		if m.appendMode {
+			return nil, err
 		}
-		if !internal.FileWritable(resolvconfFilePath) {
-			log.Println(internal.WarningPrefix, "dns not set, resolv.conf file is not writable")
-			return nil
+		m.logger.Warn("resolv.conf options will not be preserved:", err)
+	}
+	path := resolvconfFilePath
+	if namespace != "" {
+		path = namespaceResolvConfPath(namespace)
+	}
+	options := overrideResolvConfOptions(original, m.options, m.optionOverrides)
+	content, _ := newResolvConfFileContent(original, nameservers, m.searchDomains, options, m.appendMode)
+	header := resolvConfHeader(m.now(), m.managementService())
+	return []string{"write " + path + ":\n" + header + content}, nil
+}
+
+// originalContent returns the pre-VPN resolv.conf content of the namespace the method is scoped to
+func (m *ResolvConfFile) originalContent() ([]byte, error) {
+	return originalContentIn(m.namespace)
+}
+
+// originalContentIn returns the pre-VPN resolv.conf content of the network namespace
+func originalContentIn(namespace string) ([]byte, error) {
+	if namespace != "" {
+		return originalNamespaceResolvConf(namespace)
+	}
+	return originalResolvConf()
+}
+
+// resolvConfHeader returns the comment lines starting resolv.conf written by NordVPN, so that
+// the users and the other programs know who owns the file
+func resolvConfHeader(writtenAt time.Time, service dnsManagementService) string {
+	return fmt.Sprintf("%s\n%s %s, management service: %s\n", resolvconfFileHeader,
+		resolvconfFileWrittenMark, writtenAt.UTC().Format(time.RFC3339), service)
+}
+
+// isResolvConfHeaderLine checks if the line is a part of the header written by NordVPN
+func isResolvConfHeaderLine(line string) bool {
+	return strings.HasPrefix(line, resolvconfFileMark) || strings.HasPrefix(line, resolvconfFileWrittenMark)
+}
+
+// isOwnResolvConf checks if resolv.conf content bears the NordVPN header, otherwise the file was
+// taken over by another program
+func isOwnResolvConf(content []byte) bool {
+	return strings.Contains(string(content), resolvconfFileMark)
+}
+
+// resolvConfBody returns resolv.conf content without the header written by NordVPN
+func resolvConfBody(content []byte) string {
+	body := string(content)
+	for {
+		line, rest, found := strings.Cut(body, "\n")
+		if !found || !isResolvConfHeaderLine(line) {
+			return body
 		}
+		body = rest
 	}
-	err := backupDNS()
-	if err != nil {
-		return fmt.Errorf("backing up dns: %w", err)
+}
+
+// newResolvConfFileContent returns resolv.conf content written by NordVPN, without the header,
+// and the nameservers in it. original is the pre-VPN resolv.conf content, its options and sortlist are carried forward
+// unless options override them.
+func newResolvConfFileContent(
+	original []byte,
+	addresses []string,
+	searchDomains []string,
+	options []string,
+	appendMode bool,
+) (string, []string) {
+	if appendMode {
+		return appendedResolvConfFileContent(original, addresses, searchDomains, options)
 	}
-	return resetDNSinResolvconfFile(addresses)
+	directives := parseResolvConfDirectives(original)
+	if options != nil {
+		directives.options = options
+	}
+	return resolvConfFileContent(addresses, searchDomains, directives), limitResolvConfNameservers(addresses)
 }
 
-func resetDNSinResolvconfFile(addresses []string) error {
+// resolvConfFileContent returns resolv.conf content written by NordVPN, without the header. Only
+// the nameservers used by glibc are written.
+func resolvConfFileContent(addresses []string, searchDomains []string, directives resolvConfDirectives) string {
+	addresses = limitResolvConfNameservers(addresses)
 	var addrs = make([]string, len(addresses))
 	for idx, address := range addresses {
 		addrs[idx] = "nameserver " + address
 	}
+	if len(searchDomains) > 0 {
+		addrs = append(addrs, searchLine(limitSearchDomains(searchDomains)))
+	}
+	addrs = append(addrs, directives.lines()...)
+	return strings.Join(addrs, "\n") + "\n"
+}
+
+// limitResolvConfNameservers returns the nameservers used by glibc, the rest of them are ignored
+func limitResolvConfNameservers(nameservers []string) []string {
+	return nameservers[:min(len(nameservers), maxResolvConfNameservers)]
+}
+
+func searchLine(domains []string) string {
+	return "search " + strings.Join(domains, " ")
+}
+
+// appendedResolvConfFileContent returns the original resolv.conf content with the addresses
+// added after its nameservers. Duplicates are removed and only the nameservers used by glibc are
+// kept. Other lines keep their order, nameservers are placed where the first original nameserver
+// was, or at the end if there were none. Search domains are added after the original ones in
+// the same way. Original options are replaced by options, unless options is nil. Returns the
+// content without the header and the nameservers in it.
+func appendedResolvConfFileContent(
+	original []byte,
+	addresses []string,
+	searchDomains []string,
+	options []string,
+) (string, []string) {
+	nameservers := []string{}
+	for _, address := range append(nameserversFromResolvConf(original), addresses...) {
+		if !slices.Contains(nameservers, address) {
+			nameservers = append(nameservers, address)
+		}
+	}
+	nameservers = limitResolvConfNameservers(nameservers)
+
+	nameserverLines := make([]string, len(nameservers))
+	for idx, address := range nameservers {
+		nameserverLines[idx] = "nameserver " + address
+	}
+	domains := limitSearchDomains(mergeSearchDomains(original, searchDomains))
+
+	lines := []string{}
+	inserted, searchInserted, optionsInserted := false, len(searchDomains) == 0, options == nil
+	for _, line := range strings.Split(strings.TrimRight(string(original), "\r\n"), "\n") {
+		line = normalizeResolvConfLine(line)
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if !inserted {
+				lines = append(lines, nameserverLines...)
+				inserted = true
+			}
+			continue
+		}
+		if len(searchDomains) > 0 && len(fields) >= 2 && (fields[0] == "search" || fields[0] == "domain") {
+			if !searchInserted {
+				lines = append(lines, searchLine(domains))
+				searchInserted = true
+			}
+			continue
+		}
+		if options != nil && len(fields) >= 1 && fields[0] == "options" {
+			if !optionsInserted && len(options) > 0 {
+				lines = append(lines, optionsLine(options))
+			}
+			optionsInserted = true
+			continue
+		}
+		if isResolvConfHeaderLine(line) || (line == "" && len(lines) == 0) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !inserted {
+		lines = append(lines, nameserverLines...)
+	}
+	if !searchInserted {
+		lines = append(lines, searchLine(domains))
+	}
+	if !optionsInserted && len(options) > 0 {
+		lines = append(lines, optionsLine(options))
+	}
+	return strings.Join(lines, "\n") + "\n", nameservers
+}
+
+// originalResolvConf returns the pre-VPN resolv.conf content, which is the backup if resolv.conf
+// was already changed by NordVPN
+func originalResolvConf() ([]byte, error) {
+	path := resolvconfFilePath
+	if internal.FileExists(resolvconfBackupPath) {
+		path = resolvconfBackupPath
+	}
+	content, err := internal.FileRead(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading original resolv.conf: %w", err)
+	}
+	return content, nil
+}
+
+// readOriginalResolvConf returns the pre-VPN resolv.conf content. It is required in append mode,
+// otherwise only the options are lost when it can't be read.
+func readOriginalResolvConf(logger Logger, appendMode bool) ([]byte, error) {
+	original, err := originalResolvConf()
+	if err != nil {
+		if appendMode {
+			return nil, err
+		}
+		logger.Warn("resolv.conf options will not be preserved:", err)
+	}
+	return original, nil
+}
 
+// setDNSinResolvconfFile writes resolv.conf starting with the header. Returns the nameservers and
+// the content written to resolv.conf, or nil if it was not changed.
+func setDNSinResolvconfFile(
+	logger Logger,
+	header string,
+	addresses []string,
+	searchDomains []string,
+	options []string,
+	optionOverrides []string,
+	appendMode bool,
+	writeMode ResolvConfWriteMode,
+) ([]string, []byte, error) {
+	if internal.FileExists(resolvconfFilePath) {
+		// file locked by the user is checked by the caller, if it contains our mark it is
+		// locked by us and needs to be rewritten with the new nameservers
+		if err := checkFileWritable(resolvconfFilePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	err := backupDNS()
+	if err != nil {
+		return nil, nil, fmt.Errorf("backing up dns: %w", err)
+	}
+
+	original, err := readOriginalResolvConf(logger, appendMode)
+	if err != nil {
+		return nil, nil, err
+	}
+	options = overrideResolvConfOptions(original, options, optionOverrides)
+	content, written := newResolvConfFileContent(original, addresses, searchDomains, options, appendMode)
+	content = header + content
+	if err := resetDNSinResolvconfFile(content, writeMode); err != nil {
+		return nil, nil, err
+	}
+	return written, []byte(content), nil
+}
+
+func resetDNSinResolvconfFile(content string, writeMode ResolvConfWriteMode) error {
 	// set DNS
 	_ = internal.FileUnlock(resolvconfFilePath)
 	defer internal.FileLock(resolvconfFilePath)
-	content := resolvconfFileMark + "\n" + strings.Join(addrs, "\n") + "\n"
-	return internal.FileWrite(resolvconfFilePath, []byte(content), internal.PermUserRWGroupROthersR)
+	return writeResolvConf(resolvconfFilePath, []byte(content), writeMode)
 }
 
-func unsetDNSinResolvconfFile() error {
-	out, err := internal.FileRead(resolvconfFilePath)
+// normalizeResolvConfLine removes leading and trailing whitespace, including the carriage return
+// of CRLF line endings, and separates the fields of directives with single spaces
+func normalizeResolvConfLine(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return line
+	}
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// nameserversFromResolvConf returns addresses of nameservers listed in resolv.conf content.
+// Fields can be separated by any whitespace and lines can end with CRLF.
+func nameserversFromResolvConf(content []byte) []string {
+	nameservers := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			nameservers = append(nameservers, fields[1])
+		}
+	}
+	return nameservers
+}
+
+func unsetDNSinResolvconfFile(logger Logger) error {
+	return restoreOwnResolvConf(logger, resolvconfFilePath, resolvconfBackupPath)
+}
+
+// restoreOwnResolvConf restores resolv.conf at path from the backup only if it still bears the
+// NordVPN header, otherwise another program took over the file and its changes must be kept
+func restoreOwnResolvConf(logger Logger, path string, backupPath string) error {
+	out, err := internal.FileRead(path)
 	if err != nil {
 		return fmt.Errorf("reading resolv.conf: %w", err)
 	}
-	if strings.Contains(string(out), resolvconfFileMark) {
-		_ = internal.FileUnlock(resolvconfFilePath)
-		return restoreDNS()
+	if !isOwnResolvConf(out) {
+		logger.Info("resolv.conf was taken over by another program, not restoring it")
+		return nil
 	}
-	return nil
+	_ = internal.FileUnlock(path)
+	return restoreDNS(logger, path, backupPath)
 }
 
 func backupDNS() error {
@@ -105,15 +539,15 @@ func backupDNS() error {
 	return internal.FileWrite(resolvconfBackupPath, out, internal.PermUserRWGroupROthersR)
 }
 
-func restoreDNS() error {
-	if err := restoreFromBackup(); err != nil {
-		log.Println(internal.WarningPrefix, fmt.Errorf("failed restoring resolv.conf from backup: %w", err))
-		restoreWithSimpleSettings()
+func restoreDNS(logger Logger, path string, backupPath string) error {
+	if err := restoreFromBackup(path, backupPath); err != nil {
+		logger.Warn(fmt.Errorf("failed restoring resolv.conf from backup: %w", err))
+		restoreWithSimpleSettings(logger, path)
 	}
 	return nil
 }
 
-func tryToRestoreDNS() {
+func tryToRestoreDNS(logger Logger) {
 	// if target is symlink, probably it is managed by other software - do nothing
 	if internal.IsSymLink(resolvconfFilePath) {
 		return
@@ -123,38 +557,38 @@ func tryToRestoreDNS() {
 	// if backup does not exists, create simple dns settings file
 	out, err := internal.FileRead(resolvconfFilePath)
 	if err != nil {
-		log.Println(internal.ErrorPrefix, fmt.Errorf("reading resolv.conf: %w", err))
+		logger.Error(fmt.Errorf("reading resolv.conf: %w", err))
 		return
 	}
-	if !strings.Contains(string(out), resolvconfFileMark) {
+	if !isOwnResolvConf(out) {
 		return
 	}
 
-	log.Println(internal.WarningPrefix, "/etc/resolv.conf contains our changes - need to fix this")
+	logger.Warn("/etc/resolv.conf contains our changes - need to fix this")
 
 	// try to unlock, if file contains our changes - it was locked by us
 	_ = internal.FileUnlock(resolvconfFilePath)
 
-	if err := restoreFromBackup(); err != nil {
-		log.Println(internal.WarningPrefix, fmt.Errorf("failed restoring resolv.conf from backup: %w", err))
-		restoreWithSimpleSettings()
+	if err := restoreFromBackup(resolvconfFilePath, resolvconfBackupPath); err != nil {
+		logger.Warn(fmt.Errorf("failed restoring resolv.conf from backup: %w", err))
+		restoreWithSimpleSettings(logger, resolvconfFilePath)
 	}
 }
 
-func restoreFromBackup() error {
+func restoreFromBackup(path string, backupPath string) error {
 	// restore from backup if backup file exists
-	if internal.FileExists(resolvconfBackupPath) {
-		backup, err := internal.FileRead(resolvconfBackupPath)
+	if internal.FileExists(backupPath) {
+		backup, err := internal.FileRead(backupPath)
 		// try to remove backup
-		_ = internal.FileDelete(resolvconfBackupPath)
+		_ = internal.FileDelete(backupPath)
 		if err != nil {
 			return fmt.Errorf("reading backup resolv.conf: %w", err)
 		} else {
 			// last check if backup does not have our changes
-			if strings.Contains(string(backup), resolvconfFileMark) {
+			if isOwnResolvConf(backup) {
 				return fmt.Errorf("resolv.conf backup contains our changes - do not restore from it")
 			} else {
-				if err := internal.FileWrite(resolvconfFilePath, backup, internal.PermUserRWGroupROthersR); err != nil {
+				if err := internal.FileWrite(path, backup, internal.PermUserRWGroupROthersR); err != nil {
 					return fmt.Errorf("restore from backup resolv.conf: %w", err)
 				} else {
 					// succeeded with backup restore
@@ -166,20 +600,20 @@ func restoreFromBackup() error {
 	return fmt.Errorf("resolv.conf backup not found")
 }
 
-func restoreWithSimpleSettings() {
+func restoreWithSimpleSettings(logger Logger, path string) {
 	// there is no backup, but we need to fix dns settings
 	ip, err := discoverNameserverIp()
 	if err != nil {
-		log.Println(internal.ErrorPrefix, fmt.Errorf("discovering nameserver: %w", err))
+		logger.Error(fmt.Errorf("discovering nameserver: %w", err))
 		// this is very-very last option and hope this will not happen
 		ip = netip.MustParseAddr("1.1.1.1")
 	}
 
-	log.Println(internal.WarningPrefix, "/etc/resolv.conf restore with nameserver:", ip)
+	logger.Warn("/etc/resolv.conf restore with nameserver:", ip)
 
 	content := fmt.Sprintf(resolvconfFileContent, ip)
-	if err := internal.FileWrite(resolvconfFilePath, []byte(content), internal.PermUserRWGroupROthersR); err != nil {
-		log.Println(internal.ErrorPrefix, fmt.Errorf("writing simple resolv.conf: %w", err))
+	if err := internal.FileWrite(path, []byte(content), internal.PermUserRWGroupROthersR); err != nil {
+		logger.Error(fmt.Errorf("writing simple resolv.conf: %w", err))
 	}
 }
 
@@ -0,0 +1,359 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AppendedResolvConfFileContent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name          string
+		original      string
+		addresses     []string
+		searchDomains []string
+		content       string
+		nameservers   []string
+	}{
+		{
+			name:      "local resolver",
+			original:  "# local cache\nnameserver 127.0.0.1\noptions edns0 trust-ad\nsearch lan\n",
+			addresses: []string{"103.86.96.100", "103.86.99.100"},
+			content: "# local cache\nnameserver 127.0.0.1\nnameserver 103.86.96.100\n" +
+				"nameserver 103.86.99.100\noptions edns0 trust-ad\nsearch lan\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100", "103.86.99.100"},
+		},
+		{
+			name:        "duplicates are removed",
+			original:    "nameserver 103.86.96.100\nnameserver 127.0.0.1\nnameserver 127.0.0.1\n",
+			addresses:   []string{"103.86.96.100", "103.86.99.100"},
+			content:     "nameserver 103.86.96.100\nnameserver 127.0.0.1\nnameserver 103.86.99.100\n",
+			nameservers: []string{"103.86.96.100", "127.0.0.1", "103.86.99.100"},
+		},
+		{
+			name:        "nameservers are capped",
+			original:    "search lan\nnameserver 127.0.0.1\noptions rotate\nnameserver 192.168.1.1\n",
+			addresses:   []string{"103.86.96.100", "103.86.99.100"},
+			content:     "search lan\nnameserver 127.0.0.1\nnameserver 192.168.1.1\nnameserver 103.86.96.100\noptions rotate\n",
+			nameservers: []string{"127.0.0.1", "192.168.1.1", "103.86.96.100"},
+		},
+		{
+			name:        "no original nameservers",
+			original:    "options edns0\n",
+			addresses:   []string{"103.86.96.100"},
+			content:     "options edns0\nnameserver 103.86.96.100\n",
+			nameservers: []string{"103.86.96.100"},
+		},
+		{
+			name:          "search domains are added to the original ones",
+			original:      "nameserver 127.0.0.1\ndomain home\nsearch lan\noptions rotate\n",
+			addresses:     []string{"103.86.96.100"},
+			searchDomains: []string{"corp.example.com", "lan"},
+			content: "nameserver 127.0.0.1\nnameserver 103.86.96.100\n" +
+				"search lan corp.example.com\noptions rotate\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100"},
+		},
+		{
+			name:          "no original search domains",
+			original:      "nameserver 127.0.0.1\n",
+			addresses:     []string{"103.86.96.100"},
+			searchDomains: []string{"corp.example.com"},
+			content: "nameserver 127.0.0.1\nnameserver 103.86.96.100\n" +
+				"search corp.example.com\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100"},
+		},
+		{
+			name:      "crlf line endings",
+			original:  "# local cache\r\nnameserver 127.0.0.1\r\noptions edns0\r\n",
+			addresses: []string{"103.86.96.100", "127.0.0.1"},
+			content: "# local cache\nnameserver 127.0.0.1\nnameserver 103.86.96.100\n" +
+				"options edns0\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100"},
+		},
+		{
+			name:          "tab delimited",
+			original:      "nameserver\t127.0.0.1 \n\tsearch\tlan\t\noptions\tedns0  rotate\n",
+			addresses:     []string{"103.86.96.100"},
+			searchDomains: []string{"corp.example.com"},
+			content: "nameserver 127.0.0.1\nnameserver 103.86.96.100\n" +
+				"search lan corp.example.com\noptions edns0 rotate\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100"},
+		},
+		{
+			name:        "written by nordvpn with crlf line endings",
+			original:    resolvconfFileMark + "\r\nnameserver 127.0.0.1\r\n\r\n",
+			addresses:   []string{"103.86.96.100"},
+			content:     "nameserver 127.0.0.1\nnameserver 103.86.96.100\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100"},
+		},
+		{
+			name:        "written by nordvpn with header",
+			original:    resolvConfHeader(time.Unix(0, 0), unmanagedService) + "nameserver 127.0.0.1\n",
+			addresses:   []string{"103.86.96.100"},
+			content:     "nameserver 127.0.0.1\nnameserver 103.86.96.100\n",
+			nameservers: []string{"127.0.0.1", "103.86.96.100"},
+		},
+		{
+			name:        "empty original",
+			addresses:   []string{"103.86.96.100"},
+			content:     "nameserver 103.86.96.100\n",
+			nameservers: []string{"103.86.96.100"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, nameservers := appendedResolvConfFileContent([]byte(test.original), test.addresses, test.searchDomains, nil)
+			assert.Equal(t, test.content, content)
+			assert.Equal(t, test.nameservers, nameservers)
+		})
+	}
+}
+
+func Test_ResolvConfFileContent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, "nameserver 103.86.96.100\nnameserver 103.86.99.100\n",
+		resolvConfFileContent([]string{"103.86.96.100", "103.86.99.100"}, nil, resolvConfDirectives{}))
+	assert.Equal(t, "nameserver 103.86.96.100\nsearch corp.example.com example.com\n",
+		resolvConfFileContent([]string{"103.86.96.100"}, []string{"corp.example.com", "example.com"},
+			resolvConfDirectives{}))
+}
+
+func Test_ResolvConfFileTruncatesNameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	nameservers := []string{"103.86.96.100", "103.86.99.100", "1.1.1.1", "1.0.0.1", "8.8.8.8"}
+	analytics := &mockAnalytics{}
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: analytics}
+
+	changes, err := file.DryRun("nordlynx", nameservers)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, []string{"103.86.96.100", "103.86.99.100", "1.1.1.1"},
+		nameserversFromResolvConf([]byte(changes[0])))
+
+	file.reportTruncated(nameservers, limitResolvConfNameservers(nameservers))
+	assert.Equal(t, []mockErrorEvent{{
+		errorType:          resolversTruncatedErrorType,
+		resolversRequested: 5,
+		resolversWritten:   3,
+	}}, analytics.getErrorEvents())
+
+	// nothing is reported when all of the nameservers were written
+	analytics = &mockAnalytics{}
+	file.analytics = analytics
+	file.reportTruncated(nameservers[:3], nameservers[:3])
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_SetResolvConfAppendMode(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	file := &ResolvConfFile{logger: defaultLogger{}}
+	setter := newTestSetter(&mockAnalytics{}, &MockMethod{}, file)
+	assert.False(t, isAppendModeApplied(file))
+
+	setter.SetResolvConfAppendMode(true)
+	assert.True(t, isAppendModeApplied(file))
+	assert.False(t, isAppendModeApplied(&MockMethod{}))
+
+	setter.SetResolvConfAppendMode(false)
+	assert.False(t, isAppendModeApplied(file))
+}
+
+func Test_HasImmutableFlag(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name      string
+		flags     uint32
+		immutable bool
+	}{
+		{name: "no flags", flags: 0},
+		// FS_APPEND_FL and FS_NOATIME_FL
+		{name: "other flags", flags: 0x00000020 | 0x00000080},
+		{name: "immutable", flags: 0x00000010, immutable: true},
+		// FS_EXTENT_FL is set on most of the ext4 files
+		{name: "immutable with other flags", flags: 0x00080000 | 0x00000010, immutable: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.immutable, hasImmutableFlag(test.flags))
+		})
+	}
+}
+
+func Test_NewResolvConfFileContentPreservesOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	original := []byte("nameserver 192.168.1.1\noptions edns0 timeout:2\nsortlist 10.0.0.0\nsearch lan\n")
+	tests := []struct {
+		name       string
+		options    []string
+		appendMode bool
+		content    string
+	}{
+		{
+			name: "original options are kept",
+			content: "nameserver 103.86.96.100\n" +
+				"options edns0 timeout:2\nsortlist 10.0.0.0\n",
+		},
+		{
+			name:    "options are overridden",
+			options: []string{"rotate"},
+			content: "nameserver 103.86.96.100\noptions rotate\nsortlist 10.0.0.0\n",
+		},
+		{
+			name:    "options are removed",
+			options: []string{},
+			content: "nameserver 103.86.96.100\nsortlist 10.0.0.0\n",
+		},
+		{
+			name:       "append mode",
+			appendMode: true,
+			content: "nameserver 192.168.1.1\nnameserver 103.86.96.100\n" +
+				"options edns0 timeout:2\nsortlist 10.0.0.0\nsearch lan\n",
+		},
+		{
+			name:       "options are overridden in append mode",
+			options:    []string{"rotate"},
+			appendMode: true,
+			content: "nameserver 192.168.1.1\nnameserver 103.86.96.100\n" +
+				"options rotate\nsortlist 10.0.0.0\nsearch lan\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, _ := newResolvConfFileContent(original, []string{"103.86.96.100"}, nil, test.options, test.appendMode)
+			assert.Equal(t, test.content, content)
+		})
+	}
+}
+
+func Test_SetResolvConfOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}}
+	setter := newTestSetter(&mockAnalytics{}, file)
+
+	assert.NoError(t, setter.SetResolvConfOptions([]string{"rotate"}))
+	assert.Equal(t, []string{"rotate"}, file.options)
+	assert.Error(t, setter.SetResolvConfOptions([]string{"rotate timeout:1"}))
+	assert.Equal(t, []string{"rotate"}, file.options)
+	assert.NoError(t, setter.SetResolvConfOptions(nil))
+	assert.Nil(t, file.options)
+}
+
+func Test_ResolvConfHeader(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	writtenAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	header := resolvConfHeader(writtenAt, unmanagedService)
+	assert.Equal(t, "# Generated by NordVPN - do not edit\n"+
+		"# Written at 2024-05-01T10:30:00Z, management service: unmanaged\n", header)
+
+	body := resolvConfFileContent([]string{"103.86.96.100"}, nil, resolvConfDirectives{})
+	assert.True(t, isOwnResolvConf([]byte(header+body)))
+	assert.Equal(t, body, resolvConfBody([]byte(header+body)))
+	// files written by the older versions contain only the mark
+	assert.True(t, isOwnResolvConf([]byte(resolvconfFileMark+"\n"+body)))
+	assert.Equal(t, body, resolvConfBody([]byte(resolvconfFileMark+"\n"+body)))
+	assert.False(t, isOwnResolvConf([]byte(body)))
+	assert.Equal(t, body, resolvConfBody([]byte(body)))
+}
+
+func Test_RestoreOwnResolvConf(t *testing.T) {
+	category.Set(t, category.File)
+
+	const original = "nameserver 192.168.1.1\n"
+	own := resolvConfHeader(time.Now(), unmanagedService) + "nameserver 103.86.96.100\n"
+	tests := []struct {
+		name     string
+		content  string
+		restored string
+		backup   bool
+	}{
+		{
+			name:     "written by nordvpn",
+			content:  own,
+			restored: original,
+		},
+		{
+			name:     "written by an older version",
+			content:  resolvconfFileMark + "\nnameserver 103.86.96.100\n",
+			restored: original,
+		},
+		{
+			name:     "taken over by another program",
+			content:  "# Generated by NetworkManager\nnameserver 10.0.0.1\n",
+			restored: "# Generated by NetworkManager\nnameserver 10.0.0.1\n",
+			backup:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "resolv.conf")
+			backupPath := filepath.Join(dir, "resolv.conf.bak")
+			require.NoError(t, os.WriteFile(path, []byte(test.content), 0644))
+			require.NoError(t, os.WriteFile(backupPath, []byte(original), 0644))
+
+			require.NoError(t, restoreOwnResolvConf(defaultLogger{}, path, backupPath))
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, test.restored, string(content))
+			_, err = os.Stat(backupPath)
+			assert.Equal(t, test.backup, err == nil, "backup is removed only after restoring")
+		})
+	}
+}
+
+func Test_SetResolvConfNdotsTimeout(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}}
+	setter := newTestSetter(&mockAnalytics{}, file)
+
+	assert.NoError(t, setter.SetResolvConfNdotsTimeout(2, 5))
+	assert.Equal(t, []string{"ndots:2", "timeout:5"}, file.optionOverrides)
+	assert.Error(t, setter.SetResolvConfNdotsTimeout(16, 5))
+	assert.Error(t, setter.SetResolvConfNdotsTimeout(2, 0))
+	assert.Equal(t, []string{"ndots:2", "timeout:5"}, file.optionOverrides)
+
+	// overrides are reported only when resolv.conf was written
+	assert.False(t, isCustomOptionsApplied(file))
+	file.written = testVPNNameservers
+	assert.True(t, isCustomOptionsApplied(file))
+
+	assert.NoError(t, setter.SetResolvConfNdotsTimeout(KeepResolvConfOption, KeepResolvConfOption))
+	assert.False(t, isCustomOptionsApplied(file))
+}
+
+func Test_ResolvConfFileContentWithNdotsTimeout(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	original := []byte("nameserver 192.168.1.1\noptions edns0 ndots:1\n")
+	overrides := []string{"ndots:3", "timeout:4"}
+
+	content, _ := newResolvConfFileContent(original, testVPNNameservers,
+		nil, overrideResolvConfOptions(original, nil, overrides), false)
+	assert.Contains(t, content, "\noptions edns0 ndots:3 timeout:4\n")
+
+	content, _ = newResolvConfFileContent(original, testVPNNameservers,
+		nil, overrideResolvConfOptions(original, nil, overrides), true)
+	assert.Equal(t, "nameserver 192.168.1.1\n"+
+		"nameserver "+testVPNNameservers[0]+"\n"+
+		"nameserver "+testVPNNameservers[1]+"\n"+
+		"options edns0 ndots:3 timeout:4\n", content)
+}
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockResolvconf records resolvconf calls
+type mockResolvconf struct {
+	calls [][]string
+	stdin []string
+	// failing are the resolvconf flags which fail, e.g. -d
+	failing []string
+}
+
+func (m *mockResolvconf) run(stdin string, args ...string) ([]byte, error) {
+	m.calls = append(m.calls, args)
+	m.stdin = append(m.stdin, stdin)
+	for _, flag := range m.failing {
+		if args[0] == flag {
+			return []byte("resolvconf failed"), errors.New("exit status 1")
+		}
+	}
+	return nil, nil
+}
+
+func newTestResolvconf(t *testing.T) (*Resolvconf, *mockResolvconf) {
+	t.Helper()
+	mock := &mockResolvconf{}
+	resolvconf := newResolvconf(defaultLogger{})
+	resolvconf.recordPath = filepath.Join(t.TempDir(), "resolvconf-record")
+	resolvconf.run = mock.run
+	return resolvconf, mock
+}
+
+func Test_ResolvconfAddArgs(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, []string{"-a", "tun.nordlynx", "-m", "0", "-x"}, resolvconfAddArgs("tun.nordlynx", true))
+	assert.Equal(t, []string{"-a", "tun.nordlynx", "-m", "0"}, resolvconfAddArgs("tun.nordlynx", false))
+	assert.Equal(t, []string{"-d", "tun.nordlynx", "-f"}, resolvconfDeleteArgs("tun.nordlynx"))
+}
+
+func Test_ResolvconfSetAndUnset(t *testing.T) {
+	category.Set(t, category.File)
+
+	record, err := resolvconfRecordName("nordlynx")
+	require.NoError(t, err)
+	resolvconf, mock := newTestResolvconf(t)
+
+	require.NoError(t, resolvconf.Set("nordlynx", []string{"103.86.96.100", "103.86.99.100"}))
+	assert.Equal(t, [][]string{resolvconfAddArgs(record, true)}, mock.calls)
+	assert.Equal(t, []string{"nameserver 103.86.96.100\nnameserver 103.86.99.100"}, mock.stdin)
+	content, err := os.ReadFile(resolvconf.recordPath)
+	require.NoError(t, err)
+	assert.Equal(t, record, string(content))
+
+	require.NoError(t, resolvconf.Unset("nordlynx"))
+	assert.Equal(t, [][]string{resolvconfAddArgs(record, true), resolvconfDeleteArgs(record)}, mock.calls)
+	assert.NoFileExists(t, resolvconf.recordPath)
+}
+
+func Test_ResolvconfUnsetFailure(t *testing.T) {
+	category.Set(t, category.File)
+
+	resolvconf, mock := newTestResolvconf(t)
+	require.NoError(t, resolvconf.Set("nordlynx", []string{"103.86.96.100"}))
+	mock.failing = []string{"-d"}
+
+	assert.Error(t, resolvconf.Unset("nordlynx"))
+	// the record is removed the next time
+	assert.FileExists(t, resolvconf.recordPath)
+}
+
+func Test_ResolvconfRemovesStaleRecord(t *testing.T) {
+	category.Set(t, category.File)
+
+	record, err := resolvconfRecordName("nordlynx")
+	require.NoError(t, err)
+	tests := []struct {
+		name    string
+		stale   string
+		failing []string
+		calls   [][]string
+	}{
+		{
+			name:  "stale record of another interface",
+			stale: "tun.nordtun",
+			calls: [][]string{resolvconfDeleteArgs("tun.nordtun"), resolvconfAddArgs(record, true)},
+		},
+		{
+			name:  "record of the same interface is replaced",
+			stale: record,
+			calls: [][]string{resolvconfAddArgs(record, true)},
+		},
+		{
+			name:    "removing stale record fails",
+			stale:   "tun.nordtun",
+			failing: []string{"-d"},
+			calls:   [][]string{resolvconfDeleteArgs("tun.nordtun"), resolvconfAddArgs(record, true)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolvconf, mock := newTestResolvconf(t)
+			mock.failing = test.failing
+			require.NoError(t, os.WriteFile(resolvconf.recordPath, []byte(test.stale+"\n"), 0644))
+
+			assert.NoError(t, resolvconf.Set("nordlynx", []string{"103.86.96.100"}))
+			assert.Equal(t, test.calls, mock.calls)
+			content, err := os.ReadFile(resolvconf.recordPath)
+			require.NoError(t, err)
+			assert.Equal(t, record, strings.TrimSpace(string(content)))
+		})
+	}
+}
+
+func Test_ResolvconfRemovesStaleRecordAfterRestart(t *testing.T) {
+	category.Set(t, category.File)
+
+	resolvconf, mock := newTestResolvconf(t)
+	// nothing was left behind
+	resolvconf.removeStaleRecord("")
+	assert.Empty(t, mock.calls)
+
+	require.NoError(t, os.WriteFile(resolvconf.recordPath, []byte("tun.nordlynx"), 0644))
+	resolvconf.removeStaleRecord("")
+	assert.Equal(t, [][]string{resolvconfDeleteArgs("tun.nordlynx")}, mock.calls)
+	assert.NoFileExists(t, resolvconf.recordPath)
+}
+
+func Test_SetResolvconfExclusiveMode(t *testing.T) {
+	category.Set(t, category.File)
+
+	record, err := resolvconfRecordName("nordlynx")
+	require.NoError(t, err)
+	analytics := &mockAnalytics{}
+	resolvconf, mock := newTestResolvconf(t)
+	ds := newTestSetter(analytics, resolvconf)
+
+	require.NoError(t, ds.Set("nordlynx", []string{"103.86.96.100"}))
+	ds.SetResolvconfExclusiveMode(false)
+	require.NoError(t, ds.Set("nordlynx", []string{"103.86.96.100"}))
+
+	assert.Equal(t, [][]string{resolvconfAddArgs(record, true), resolvconfAddArgs(record, false)}, mock.calls)
+	require.Len(t, analytics.configuredEvents, 2)
+	assert.True(t, analytics.configuredEvents[0].exclusiveMode)
+	assert.False(t, analytics.configuredEvents[1].exclusiveMode)
+}
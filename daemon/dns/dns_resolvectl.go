@@ -1,12 +1,11 @@
 package dns
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os/exec"
 	"strings"
-
-	"github.com/NordSecurity/nordvpn-linux/internal"
+	"time"
 )
 
 // Executables
@@ -16,60 +15,89 @@ const (
 )
 
 // Systemd-resolved and resolvectl based DNS handling method
-type Resolvectl struct{}
+type Resolvectl struct {
+	logger Logger
+	// timeout limits all of the resolvectl calls made by a single Set or Unset, because they
+	// hang together with systemd-resolved
+	timeout time.Duration
+}
 
 func (m *Resolvectl) Set(iface string, nameservers []string) error {
-	return setDNSWithResolvectl(iface, nameservers)
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	return setDNSWithResolvectl(ctx, m.logger, iface, nameservers)
 }
 
 func (m *Resolvectl) Unset(iface string) error {
-	return unsetDNSWithResolvectl(iface)
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	return unsetDNSWithResolvectl(ctx, m.logger, iface)
 }
 
 func (m *Resolvectl) Name() string {
 	return "resolvectl"
 }
 
-func setDNSWithResolvectl(iface string, addresses []string) error {
+func (m *Resolvectl) managementService() dnsManagementService {
+	return systemdResolvedService
+}
+
+func (m *Resolvectl) available() error {
+	_, err := exec.LookPath(execResolvectl)
+	return err
+}
+
+func (m *Resolvectl) DryRun(iface string, nameservers []string) ([]string, error) {
+	if err := m.available(); err != nil {
+		return nil, err
+	}
+	return []string{
+		commandString(execResolvectl, append([]string{"dns", iface}, nameservers...)...),
+		commandString(execResolvectl, "domain", iface, "~."),
+		commandString(execResolvectl, "default-route", iface, "true"),
+	}, nil
+}
+
+func setDNSWithResolvectl(ctx context.Context, logger Logger, iface string, addresses []string) error {
 	cmdStr := []string{"dns", iface}
 	cmdStr = append(cmdStr, addresses...)
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, cmdStr...).CombinedOutput(); err != nil {
+	if out, err := exec.CommandContext(ctx, execResolvectl, cmdStr...).CombinedOutput(); err != nil {
 		return fmt.Errorf("setting dns with resolvectl: %s: %w", strings.TrimSpace(string(out)), err)
 	}
 	// "Catch-all" domain routing for interface, more here: https://github.com/poettering/systemd/commit/8cedb0aef94da880e61b4c8cfeb7f450f8760ec6
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "domain", iface, "~.").CombinedOutput(); err != nil {
-		log.Println(internal.WarningPrefix, "dns domain routing with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
+	if out, err := exec.CommandContext(ctx, execResolvectl, "domain", iface, "~.").CombinedOutput(); err != nil {
+		logger.Warn("dns domain routing with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
 	}
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "default-route", iface, "true").CombinedOutput(); err != nil {
-		log.Println(internal.WarningPrefix, "dns domain default-route with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
+	if out, err := exec.CommandContext(ctx, execResolvectl, "default-route", iface, "true").CombinedOutput(); err != nil {
+		logger.Warn("dns domain default-route with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
 	}
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "flush-caches").CombinedOutput(); err != nil {
-		log.Println(internal.WarningPrefix, "flushing dns caches resolvectl:", strings.TrimSpace(string(out)), "err:", err)
+	if out, err := exec.CommandContext(ctx, execResolvectl, "flush-caches").CombinedOutput(); err != nil {
+		logger.Warn("flushing dns caches resolvectl:", strings.TrimSpace(string(out)), "err:", err)
 	}
 	return nil
 }
 
-func unsetDNSWithResolvectl(iface string) error {
+func unsetDNSWithResolvectl(ctx context.Context, logger Logger, iface string) error {
 	// Just set empty/no DNS server for interface
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "dns", iface, "").CombinedOutput(); err != nil {
+	if out, err := exec.CommandContext(ctx, execResolvectl, "dns", iface, "").CombinedOutput(); err != nil {
 		return fmt.Errorf("unsetting dns with resolvectl: %s: %w", strings.TrimSpace(string(out)), err)
 	}
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "domain", iface, "").CombinedOutput(); err != nil {
-		log.Println(internal.WarningPrefix, "dns domain routing with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
+	if out, err := exec.CommandContext(ctx, execResolvectl, "domain", iface, "").CombinedOutput(); err != nil {
+		logger.Warn("dns domain routing with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
 	}
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "default-route", iface, "false").CombinedOutput(); err != nil {
-		log.Println(internal.WarningPrefix, "dns domain default-route with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
+	if out, err := exec.CommandContext(ctx, execResolvectl, "default-route", iface, "false").CombinedOutput(); err != nil {
+		logger.Warn("dns domain default-route with resolvectl:", strings.TrimSpace(string(out)), "err:", err)
 	}
 	// #nosec G204 -- input is properly validated
-	if out, err := exec.Command(execResolvectl, "flush-caches").CombinedOutput(); err != nil {
-		log.Println(internal.WarningPrefix, "flushing dns caches resolvectl:", strings.TrimSpace(string(out)), "err:", err)
+	if out, err := exec.CommandContext(ctx, execResolvectl, "flush-caches").CombinedOutput(); err != nil {
+		logger.Warn("flushing dns caches resolvectl:", strings.TrimSpace(string(out)), "err:", err)
 	}
 	return nil
 }
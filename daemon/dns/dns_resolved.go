@@ -1,10 +1,15 @@
 package dns
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"os/exec"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/NordSecurity/nordvpn-linux/internal"
 )
@@ -15,127 +20,270 @@ const (
 	execBusctl = "busctl"
 )
 
+// defaultDBusTimeout limits the time of setting or unsetting DNS via D-Bus, so that a wedged
+// systemd-resolved does not block connecting
+const defaultDBusTimeout = 5 * time.Second
+
+var (
+	// errDBusTimeout means that systemd-resolved did not respond to D-Bus calls in time
+	errDBusTimeout = errors.New("systemd-resolved dbus calls timed out")
+	// errUnknownInterface means that DNS can't be scoped to the interface, because it does not
+	// exist
+	errUnknownInterface = errors.New("unknown interface")
+)
+
 // Systemd-resolved DBUS API based DNS handling method
-type Resolved struct{}
+type Resolved struct {
+	analytics analytics
+	logger    Logger
+	// tlsServerNames enables DNS-over-TLS when not empty. Keys are nameserver addresses and
+	// values are TLS server names used to authenticate them.
+	tlsServerNames map[string]string
+	// routingDomains maps domains to the nameservers resolving them. When empty, all of the
+	// domains are resolved by the link nameservers.
+	routingDomains map[string][]string
+	// linkRoutes maps other links, e.g. the meshnet one, to the domains resolved by their
+	// dedicated nameservers
+	linkRoutes map[string]map[string][]string
+	// routedLinks are the other links configured by the last Set, they are reverted by Unset
+	routedLinks []string
+	// searchDomains are used for completing single label names
+	searchDomains []string
+	// dnssec is DNSSEC validation mode of the link
+	dnssec DNSSECMode
+	// dnssecApplied is true when DNSSEC mode was set by the last Set, it is skipped when
+	// systemd-resolved does not support it
+	dnssecApplied bool
+	// resolvedVersion returns systemd-resolved version, it gates the features of newer versions
+	resolvedVersion func() string
+	// timeout limits all of the D-Bus calls made by a single Set or Unset
+	timeout time.Duration
+	busctl  func(ctx context.Context, args ...string) ([]byte, error)
+}
+
+func newResolved(analytics analytics, logger Logger) *Resolved {
+	return &Resolved{
+		analytics: analytics,
+		logger:    logger,
+		timeout:   defaultDBusTimeout,
+		busctl:    runBusctl,
 
+		resolvedVersion: detectedResolvedVersion,
+	}
+}
+
+// Set returns DNSError, it is reported by the setter only when DNS could not be set with the
+// retries or the other methods either
 func (m *Resolved) Set(iface string, nameservers []string) error {
-	return setDNSWithSystemdResolve(iface, nameservers)
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	tx := newTransaction(m.logger)
+	err := m.setDNSWithSystemdResolve(ctx, tx, iface, nameservers)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		m.logger.Error(fmt.Sprintf("systemd-resolved did not respond within %v:", m.timeout), err)
+		err = fmt.Errorf("%w: %w", errDBusTimeout, err)
+	case tx.rolledBack:
+		m.logger.Error("systemd-resolved link configuration was rolled back:", err)
+	}
+	return newDNSError(err, m.managementService())
 }
 
 func (m *Resolved) Unset(iface string) error {
-	return unsetDNSWithSystemdResolve(iface)
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	err := m.unsetDNSWithSystemdResolve(ctx, iface)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", errDBusTimeout, err)
+	}
+	return err
 }
 
 func (m *Resolved) Name() string {
 	return "resolved"
 }
 
-// setDNSWithSystemdResolve uses systemd-resolve dbus API to manage DNS
-// https://www.freedesktop.org/wiki/Software/systemd/resolved/
-func setDNSWithSystemdResolve(ifname string, addresses []string) error {
-	iface, err := net.InterfaceByName(ifname)
+func (m *Resolved) managementService() dnsManagementService {
+	return systemdResolvedService
+}
+
+// available introspects systemd-resolved, which does not change anything, but fails if it is
+// not available
+func (m *Resolved) available() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	if out, err := m.busctl(ctx, "introspect", "org.freedesktop.resolve1", "/org/freedesktop/resolve1"); err != nil {
+		return fmt.Errorf("introspecting systemd-resolved via dbus: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func (m *Resolved) DryRun(ifname string, addresses []string) ([]string, error) {
+	iface, err := linkByName(ifname)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Set dns
-	args := []string{
-		"call",
-		"org.freedesktop.resolve1",
-		"/org/freedesktop/resolve1",
-		"org.freedesktop.resolve1.Manager",
-		"SetLinkDNS", "ia(iay)", fmt.Sprintf("%d", iface.Index), fmt.Sprintf("%d", len(addresses)),
+	if err := m.available(); err != nil {
+		return nil, err
 	}
-	// prepare addresses for busctl
-	for _, address := range addresses {
-		ip := net.ParseIP(address)
-		if ip4 := ip.To4(); ip4 != nil {
-			ip = ip4
-			args = append(args, "2", "4")
-		} else {
-			args = append(args, "10", "16")
+
+	addresses = linkNameservers(addresses, m.routingDomains)
+	domains := linkRoutingDomains(m.routingDomains)
+	changes := []string{}
+	if len(m.tlsServerNames) > 0 {
+		changes = append(changes, commandString(execBusctl, linkDNSExArgs(iface.Index, addresses, m.tlsServerNames)...))
+	} else {
+		changes = append(changes, commandString(execBusctl, linkDNSArgs(iface.Index, addresses)...))
+	}
+	changes = append(changes,
+		commandString(execBusctl, linkDomainsArgs(iface.Index, domains, m.searchDomains)...),
+		commandString(execBusctl, linkDefaultRouteArgs(iface.Index, slices.Contains(domains, catchAllDomain))...),
+		commandString(execBusctl, linkDNSSECArgs(iface.Index, m.dnssec)...),
+	)
+	for _, name := range slices.Sorted(maps.Keys(m.linkRoutes)) {
+		link, err := linkByName(name)
+		if err != nil {
+			continue
 		}
-		for _, octet := range ip {
-			args = append(args, fmt.Sprintf("%d", octet))
+		for _, args := range linkRoutesArgs(link.Index, m.linkRoutes[name]) {
+			changes = append(changes, commandString(execBusctl, args...))
 		}
 	}
+	return changes, nil
+}
+
+func runBusctl(ctx context.Context, args ...string) ([]byte, error) {
 	// #nosec G204 -- input is properly validated
-	out, err := exec.Command(execBusctl, args...).CombinedOutput()
+	return exec.CommandContext(ctx, execBusctl, args...).CombinedOutput()
+}
+
+// setDNSWithSystemdResolve uses systemd-resolve dbus API to manage DNS
+// https://www.freedesktop.org/wiki/Software/systemd/resolved/
+// Changes are applied as a transaction, so that the link is reverted if any of them fails.
+func (m *Resolved) setDNSWithSystemdResolve(
+	ctx context.Context,
+	tx *transaction,
+	ifname string,
+	addresses []string,
+) error {
+	iface, err := linkByName(ifname)
 	if err != nil {
-		return fmt.Errorf("setting link dns for %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+		return err
+	}
+	m.dnssecApplied = false
+	err = tx.apply(transactionStep{
+		name: "link dns",
+		apply: func() error {
+			return m.setLinkDNS(ctx, iface.Index, iface.Name, linkNameservers(addresses, m.routingDomains))
+		},
+		// reverting the link reverts the following link changes as well
+		rollback: func() error {
+			// context of the transaction may be already expired
+			ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+			defer cancel()
+			return m.revertLink(ctx, iface)
+		},
+	})
+	if err != nil {
+		return err
 	}
 
 	// Set routing domains (more info: https://github.com/poettering/systemd/commit/8cedb0aef94da880e61b4c8cfeb7f450f8760ec6)
-	// #nosec G204 -- input is properly validated
-	out, err = exec.Command(execBusctl,
-		"call",
-		"org.freedesktop.resolve1",
-		"/org/freedesktop/resolve1",
-		"org.freedesktop.resolve1.Manager",
-		"SetLinkDomains", "ia(sb)", fmt.Sprintf("%d", iface.Index), "1", ".", "true",
-	).CombinedOutput()
+	domains := linkRoutingDomains(m.routingDomains)
+	err = tx.apply(transactionStep{
+		name: "link domains",
+		apply: func() error {
+			out, err := m.busctl(ctx, linkDomainsArgs(iface.Index, domains, m.searchDomains)...)
+			if err != nil {
+				return fmt.Errorf("setting link routing domains for %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+			}
+			return nil
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("setting link routing domains for %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+		return err
 	}
 
-	// Set Default route to tunnel interface
-	// #nosec G204 -- input is properly validated
-	out, err = exec.Command(execBusctl,
-		"call",
-		"org.freedesktop.resolve1",
-		"/org/freedesktop/resolve1",
-		"org.freedesktop.resolve1.Manager",
-		"SetLinkDefaultRoute", "ib", fmt.Sprintf("%d", iface.Index), "true",
-	).CombinedOutput()
+	// Set Default route to tunnel interface, unless only some of the domains are routed to it
+	err = tx.apply(transactionStep{
+		name: "link default route",
+		apply: func() error {
+			out, err := m.busctl(ctx, linkDefaultRouteArgs(iface.Index, slices.Contains(domains, catchAllDomain))...)
+			if err != nil {
+				return fmt.Errorf("setting link default route for %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+			}
+			return nil
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("setting link default route for %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+		return err
 	}
 
-	// Use secure DNS extension, but allow to downgrade if it's unsupported
-	// #nosec G204 -- input is properly validated
-	out, err = exec.Command(execBusctl,
-		"call",
-		"org.freedesktop.resolve1",
-		"/org/freedesktop/resolve1",
-		"org.freedesktop.resolve1.Manager",
-		"SetLinkDNSSEC", "is", fmt.Sprintf("%d", iface.Index), "allow-downgrade",
-	).CombinedOutput()
+	// Use secure DNS extension, by default downgrading if it's unsupported by the nameservers
+	err = tx.apply(transactionStep{
+		name: "link dnssec",
+		apply: func() error {
+			return m.setLinkDNSSEC(ctx, iface.Index, iface.Name)
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("setting link dns sec for %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+		return err
+	}
+
+	m.routedLinks = nil
+	for _, name := range slices.Sorted(maps.Keys(m.linkRoutes)) {
+		if err := m.setLinkRoutes(ctx, tx, name, m.linkRoutes[name]); err != nil {
+			return err
+		}
 	}
 
 	links, err := internal.NetworkLinks()
 	if err != nil {
+		tx.rollback()
 		return fmt.Errorf("listing network links: %w", err)
 	}
 	// Setup other links
 	for _, link := range links {
 		// lo is managed by systemd-networkd
 		// vpn and managed links should be ignored
-		if link.Name == "lo" || link.Name == iface.Name || !internal.IsNetworkLinkUnmanaged(link.Name) {
+		// links with routing domains were configured above
+		if link.Name == "lo" || link.Name == iface.Name || !internal.IsNetworkLinkUnmanaged(link.Name) ||
+			slices.Contains(m.routedLinks, link.Name) {
 			continue
 		}
 
-		// Remove domains
-		// #nosec G204 -- input is properly validated
-		out, err = exec.Command(execBusctl,
-			"call",
-			"org.freedesktop.resolve1",
-			"/org/freedesktop/resolve1",
-			"org.freedesktop.resolve1.Manager",
-			"SetLinkDomains", "ia(sb)", fmt.Sprintf("%d", link.Index), "0",
-		).CombinedOutput()
+		// Remove domains, previous domains of the link are not known, so they can't be restored
+		err = tx.apply(transactionStep{
+			name: "link domains of " + link.Name,
+			apply: func() error {
+				out, err := m.busctl(ctx,
+					"call",
+					"org.freedesktop.resolve1",
+					"/org/freedesktop/resolve1",
+					"org.freedesktop.resolve1.Manager",
+					"SetLinkDomains", "ia(sb)", fmt.Sprintf("%d", link.Index), "0",
+				)
+				if err != nil {
+					return fmt.Errorf("setting link domains for %s via dbus: %s: %w", link.Name, strings.TrimSpace(string(out)), err)
+				}
+				return nil
+			},
+		})
 		if err != nil {
-			return fmt.Errorf("setting link domains for %s via dbus: %s: %w", link.Name, strings.TrimSpace(string(out)), err)
+			return err
 		}
 	}
 
-	out, err = exec.Command(execBusctl,
+	// caches are flushed with the new configuration, so it is not rolled back when flushing fails
+	out, err := m.busctl(ctx,
 		"call",
 		"org.freedesktop.resolve1",
 		"/org/freedesktop/resolve1",
 		"org.freedesktop.resolve1.Manager",
 		"FlushCaches",
-	).CombinedOutput()
+	)
 	if err != nil {
 		return fmt.Errorf("flushing local dns caches via dbus: %s: %w", strings.TrimSpace(string(out)), err)
 	}
@@ -143,38 +291,229 @@ func setDNSWithSystemdResolve(ifname string, addresses []string) error {
 	return nil
 }
 
-func unsetDNSWithSystemdResolve(ifname string) error {
-	if ifname == "" {
+// setLinkRoutes routes the domains to their nameservers on the other link, e.g. the meshnet
+// one, so that they are not resolved by the VPN nameservers. The link is not used for resolving
+// any other domains. Missing links are skipped, because they may be created later.
+func (m *Resolved) setLinkRoutes(ctx context.Context, tx *transaction, name string, domains map[string][]string) error {
+	link, err := linkByName(name)
+	if err != nil {
+		m.logger.Warn("skipping routing domains:", err)
 		return nil
 	}
+	for i, args := range linkRoutesArgs(link.Index, domains) {
+		step := transactionStep{
+			name: fmt.Sprintf("routing domains of %s (%s)", link.Name, args[4]),
+			apply: func() error {
+				if out, err := m.busctl(ctx, args...); err != nil {
+					return fmt.Errorf("routing domains to %s via dbus: %s: %w", link.Name, strings.TrimSpace(string(out)), err)
+				}
+				return nil
+			},
+		}
+		if i == 0 {
+			// reverting the link reverts the following link changes as well
+			step.rollback = func() error {
+				// context of the transaction may be already expired
+				ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+				defer cancel()
+				return m.revertLink(ctx, link)
+			}
+		}
+		if err := tx.apply(step); err != nil {
+			return err
+		}
+	}
+	m.routedLinks = append(m.routedLinks, link.Name)
+	return nil
+}
 
-	iface, err := net.InterfaceByName(ifname)
+// linkRoutesArgs prepares busctl arguments for the calls routing the domains to the link
+// nameservers. The link does not become the default route, so the other domains are still
+// resolved by the VPN nameservers.
+func linkRoutesArgs(index int, domains map[string][]string) [][]string {
+	return [][]string{
+		linkDNSArgs(index, linkNameservers(nil, domains)),
+		linkDomainsArgs(index, linkRoutingDomains(domains), nil),
+		linkDefaultRouteArgs(index, false),
+	}
+}
+
+// setLinkDNS sets the nameservers for the link. When DNS-over-TLS is enabled, but not supported
+// by systemd-resolved, nameservers are set without it.
+func (m *Resolved) setLinkDNS(ctx context.Context, index int, name string, addresses []string) error {
+	if len(m.tlsServerNames) > 0 {
+		err := m.setLinkDNSOverTLS(ctx, index, name, addresses)
+		if err == nil {
+			return nil
+		}
+		m.logger.Warn("DNS-over-TLS is not available, falling back to plain DNS:", err)
+		m.analytics.emitDNSConfigurationErrorEvent(context.Background(), dnsOverTLSUnsupportedErrorType, severityByType)
+	}
+
+	if out, err := m.busctl(ctx, linkDNSArgs(index, addresses)...); err != nil {
+		return fmt.Errorf("setting link dns for %s via dbus: %s: %w", name, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// setLinkDNSOverTLS sets the nameservers together with their TLS server names and enables
+// DNS-over-TLS for the link. Both methods are available since systemd v246.
+func (m *Resolved) setLinkDNSOverTLS(ctx context.Context, index int, name string, addresses []string) error {
+	if out, err := m.busctl(ctx, linkDNSExArgs(index, addresses, m.tlsServerNames)...); err != nil {
+		return fmt.Errorf("setting link dns ex for %s via dbus: %s: %w", name, strings.TrimSpace(string(out)), err)
+	}
+	out, err := m.busctl(ctx,
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDNSOverTLS", "is", fmt.Sprintf("%d", index), "yes",
+	)
 	if err != nil {
-		return err
+		return fmt.Errorf("setting link dns over tls for %s via dbus: %s: %w", name, strings.TrimSpace(string(out)), err)
 	}
+	return nil
+}
 
-	// #nosec G204 -- input is properly validated
-	out, err := exec.Command(execBusctl,
+// linkDNSArgs prepares busctl arguments for the SetLinkDNS call
+func linkDNSArgs(index int, addresses []string) []string {
+	args := []string{
 		"call",
 		"org.freedesktop.resolve1",
 		"/org/freedesktop/resolve1",
 		"org.freedesktop.resolve1.Manager",
-		"RevertLink", "i", fmt.Sprintf("%d", iface.Index),
-	).CombinedOutput()
+		"SetLinkDNS", "ia(iay)", fmt.Sprintf("%d", index), fmt.Sprintf("%d", len(addresses)),
+	}
+	for _, address := range addresses {
+		args = append(args, addressArgs(address)...)
+	}
+	return args
+}
+
+// linkDNSExArgs prepares busctl arguments for the SetLinkDNSEx call. Default DNS port is used.
+func linkDNSExArgs(index int, addresses []string, serverNames map[string]string) []string {
+	args := []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDNSEx", "ia(iayqs)", fmt.Sprintf("%d", index), fmt.Sprintf("%d", len(addresses)),
+	}
+	for _, address := range addresses {
+		args = append(args, addressArgs(address)...)
+		args = append(args, "0", serverNames[address])
+	}
+	return args
+}
+
+// linkDomainsArgs prepares busctl arguments for the SetLinkDomains call. Routing domains are
+// routing only domains, so they are not used for completing single label names, unlike search
+// domains.
+func linkDomainsArgs(index int, routingDomains []string, searchDomains []string) []string {
+	args := []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDomains", "ia(sb)", fmt.Sprintf("%d", index),
+		fmt.Sprintf("%d", len(routingDomains)+len(searchDomains)),
+	}
+	for _, domain := range routingDomains {
+		args = append(args, domain, "true")
+	}
+	for _, domain := range searchDomains {
+		args = append(args, domain, "false")
+	}
+	return args
+}
+
+// linkDefaultRouteArgs prepares busctl arguments for the SetLinkDefaultRoute call
+func linkDefaultRouteArgs(index int, enabled bool) []string {
+	return []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDefaultRoute", "ib", fmt.Sprintf("%d", index), fmt.Sprintf("%t", enabled),
+	}
+}
+
+// addressArgs prepares address family and bytes of the address for busctl
+func addressArgs(address string) []string {
+	args := []string{}
+	ip := net.ParseIP(address)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		args = append(args, "2", "4")
+	} else {
+		args = append(args, "10", "16")
+	}
+	for _, octet := range ip {
+		args = append(args, fmt.Sprintf("%d", octet))
+	}
+	return args
+}
+
+func (m *Resolved) unsetDNSWithSystemdResolve(ctx context.Context, ifname string) error {
+	if ifname == "" {
+		return nil
+	}
+
+	iface, err := linkByName(ifname)
 	if err != nil {
-		return fmt.Errorf("reverting link %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+		return err
 	}
 
-	out, err = exec.Command(execBusctl,
+	if err := m.revertLink(ctx, iface); err != nil {
+		return err
+	}
+	for _, name := range m.routedLinks {
+		link, err := linkByName(name)
+		if err != nil {
+			// configuration of the removed link is gone with it
+			continue
+		}
+		if err := m.revertLink(ctx, link); err != nil {
+			m.logger.Warn("reverting routing domains:", err)
+		}
+	}
+	m.routedLinks = nil
+
+	out, err := m.busctl(ctx,
 		"call",
 		"org.freedesktop.resolve1",
 		"/org/freedesktop/resolve1",
 		"org.freedesktop.resolve1.Manager",
 		"FlushCaches",
-	).CombinedOutput()
+	)
 	if err != nil {
 		return fmt.Errorf("flushing local dns caches via dbus: %s: %w", strings.TrimSpace(string(out)), err)
 	}
 
 	return nil
 }
+
+// revertLink reverts all of the DNS settings of the link
+func (m *Resolved) revertLink(ctx context.Context, iface *net.Interface) error {
+	out, err := m.busctl(ctx,
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"RevertLink", "i", fmt.Sprintf("%d", iface.Index),
+	)
+	if err != nil {
+		return fmt.Errorf("reverting link %s via dbus: %s: %w", iface.Name, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// linkByName returns the link DNS is scoped to. DNS is never set globally, so that the other
+// links keep their own DNS configuration.
+func linkByName(ifname string) (*net.Interface, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", errUnknownInterface, ifname, err)
+	}
+	return iface, nil
+}
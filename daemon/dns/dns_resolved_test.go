@@ -0,0 +1,413 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockBusctl struct {
+	calls [][]string
+	// failing methods of the resolved D-Bus API
+	failing []string
+}
+
+func (m *mockBusctl) run(ctx context.Context, args ...string) ([]byte, error) {
+	m.calls = append(m.calls, args)
+	for _, method := range m.failing {
+		if args[4] == method {
+			return []byte("Unknown method " + method), errors.New("exit status 1")
+		}
+	}
+	return nil, nil
+}
+
+func (m *mockBusctl) methods() []string {
+	methods := []string{}
+	for _, call := range m.calls {
+		methods = append(methods, call[4])
+	}
+	return methods
+}
+
+func Test_LinkDNSArgs(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDNS", "ia(iay)", "3", "2",
+		"2", "4", "103", "86", "96", "100",
+		"10", "16", "32", "1", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "0", "1",
+	}, linkDNSArgs(3, []string{"103.86.96.100", "2001::1"}))
+}
+
+func Test_LinkDNSExArgs(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	serverNames := map[string]string{"103.86.96.100": "dns1.example.com"}
+	assert.Equal(t, []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDNSEx", "ia(iayqs)", "3", "2",
+		"2", "4", "103", "86", "96", "100", "0", "dns1.example.com",
+		"2", "4", "103", "86", "99", "100", "0", "",
+	}, linkDNSExArgs(3, []string{"103.86.96.100", "103.86.99.100"}, serverNames))
+}
+
+func Test_LinkDomainsArgs(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	prefix := []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDomains", "ia(sb)", "3",
+	}
+	assert.Equal(t, append(prefix, "1", ".", "true"), linkDomainsArgs(3, linkRoutingDomains(nil), nil))
+	assert.Equal(t, append(prefix, "2", "corp", "true", "example.com", "true"),
+		linkDomainsArgs(3, []string{"corp", "example.com"}, nil))
+	assert.Equal(t, append(prefix, "3", ".", "true", "corp.example.com", "false", "example.com", "false"),
+		linkDomainsArgs(3, linkRoutingDomains(nil), []string{"corp.example.com", "example.com"}))
+}
+
+func Test_ResolvedSetLinkDNS(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	serverNames := map[string]string{"103.86.96.100": "dns1.example.com"}
+	tests := []struct {
+		name           string
+		tlsServerNames map[string]string
+		failing        []string
+		methods        []string
+		errorEvents    []mockErrorEvent
+		err            bool
+	}{
+		{
+			name:    "plain dns",
+			methods: []string{"SetLinkDNS"},
+		},
+		{
+			name:           "dns over tls",
+			tlsServerNames: serverNames,
+			methods:        []string{"SetLinkDNSEx", "SetLinkDNSOverTLS"},
+		},
+		{
+			name:           "dns ex not supported",
+			tlsServerNames: serverNames,
+			failing:        []string{"SetLinkDNSEx"},
+			methods:        []string{"SetLinkDNSEx", "SetLinkDNS"},
+			errorEvents:    []mockErrorEvent{{errorType: dnsOverTLSUnsupportedErrorType, critical: false}},
+		},
+		{
+			name:           "dns over tls not supported",
+			tlsServerNames: serverNames,
+			failing:        []string{"SetLinkDNSOverTLS"},
+			methods:        []string{"SetLinkDNSEx", "SetLinkDNSOverTLS", "SetLinkDNS"},
+			errorEvents:    []mockErrorEvent{{errorType: dnsOverTLSUnsupportedErrorType, critical: false}},
+		},
+		{
+			name:           "fallback fails",
+			tlsServerNames: serverNames,
+			failing:        []string{"SetLinkDNSEx", "SetLinkDNS"},
+			methods:        []string{"SetLinkDNSEx", "SetLinkDNS"},
+			errorEvents:    []mockErrorEvent{{errorType: dnsOverTLSUnsupportedErrorType, critical: false}},
+			err:            true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			busctl := &mockBusctl{failing: test.failing}
+			resolved := newResolved(analytics, defaultLogger{})
+			resolved.busctl = busctl.run
+			resolved.tlsServerNames = test.tlsServerNames
+
+			err := resolved.setLinkDNS(context.Background(), 3, "nordlynx", []string{"103.86.96.100"})
+			assert.Equal(t, test.err, err != nil)
+			assert.Equal(t, test.methods, busctl.methods())
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+		})
+	}
+}
+
+func Test_ResolvedSetTimeout(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.timeout = 10 * time.Millisecond
+	// wedged systemd-resolved never responds
+	resolved.busctl = func(ctx context.Context, args ...string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	done := make(chan error)
+	go func() { done <- resolved.Set("lo", []string{"103.86.96.100"}) }()
+
+	var dnsErr *DNSError
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, errDBusTimeout)
+		require.ErrorAs(t, err, &dnsErr)
+		assert.Equal(t, setFailedErrorType, dnsErr.Type)
+		assert.True(t, dnsErr.Timeout)
+		assert.True(t, dnsErr.Critical)
+	case <-time.After(5 * time.Second):
+		t.Fatal("setting dns was not interrupted by the timeout")
+	}
+	// the error is reported by the setter, after the retries and the other methods failed too
+	assert.Empty(t, analytics.getErrorEvents())
+
+	// failures which are not caused by the timeout are not reported as such
+	resolved.busctl = (&mockBusctl{failing: []string{"SetLinkDNS"}}).run
+	err := resolved.Set("lo", []string{"103.86.96.100"})
+	assert.NotErrorIs(t, err, errDBusTimeout)
+	require.ErrorAs(t, err, &dnsErr)
+	assert.False(t, dnsErr.Timeout)
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_SetReportsResolvedTimeoutOnlyWhenRetriesFail(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.resolvedVersion = func() string { return "255" }
+	resolved.timeout = 10 * time.Millisecond
+	busctl := &mockBusctl{}
+	wedged := true
+	resolved.busctl = func(ctx context.Context, args ...string) ([]byte, error) {
+		if wedged {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return busctl.run(ctx, args...)
+	}
+	ds := newTestSetter(analytics, resolved)
+	ds.retries = 1
+	ds.retryDelay = func(int) time.Duration {
+		// systemd-resolved responds again before the retry
+		wedged = false
+		return 0
+	}
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Empty(t, analytics.getErrorEvents())
+
+	wedged = true
+	ds.retryDelay = func(int) time.Duration { return 0 }
+	assert.ErrorIs(t, ds.Set("lo", []string{"103.86.96.96"}), errDBusTimeout)
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: setFailedErrorType, critical: true, retryCount: 1, timeout: true}},
+		analytics.getErrorEvents())
+}
+
+func Test_SetDBusTimeout(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolvectl := &Resolvectl{timeout: defaultDBusTimeout}
+	setter := newTestSetter(analytics, resolved, resolvectl, &MockMethod{})
+	assert.Equal(t, defaultDBusTimeout, resolved.timeout)
+
+	setter.SetDBusTimeout(time.Second)
+	assert.Equal(t, time.Second, resolved.timeout)
+	assert.Equal(t, time.Second, resolvectl.timeout)
+}
+
+func Test_SetDNSOverTLS(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	setter := newTestSetter(analytics, resolved, &MockMethod{})
+
+	serverNames := map[string]string{"103.86.96.100": "dns1.example.com"}
+	setter.SetDNSOverTLS(serverNames)
+	assert.Equal(t, serverNames, resolved.tlsServerNames)
+
+	setter.SetDNSOverTLS(nil)
+	assert.Empty(t, resolved.tlsServerNames)
+}
+
+func Test_SetRoutingDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	setter := newTestSetter(analytics, resolved, &MockMethod{})
+	assert.False(t, isSplitRoutingApplied(resolved))
+
+	assert.NoError(t, setter.SetRoutingDomains(map[string][]string{"~Example.com": {"10.0.0.53"}}))
+	assert.Equal(t, map[string][]string{"example.com": {"10.0.0.53"}}, resolved.routingDomains)
+	assert.True(t, isSplitRoutingApplied(resolved))
+	assert.False(t, isSplitRoutingApplied(&MockMethod{}))
+
+	// invalid configuration does not change the previous one
+	assert.Error(t, setter.SetRoutingDomains(map[string][]string{
+		"example.com":  {"10.0.0.53"},
+		"example.com.": {"10.0.0.54"},
+	}))
+	assert.Equal(t, map[string][]string{"example.com": {"10.0.0.53"}}, resolved.routingDomains)
+
+	assert.NoError(t, setter.SetRoutingDomains(nil))
+	assert.False(t, isSplitRoutingApplied(resolved))
+}
+
+func Test_SetInterfaceRoutingDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	setter := newTestSetter(analytics, resolved, &MockMethod{})
+
+	assert.NoError(t, setter.SetInterfaceRoutingDomains("nordlynx", map[string][]string{"~Nord": {"100.64.0.1"}}))
+	assert.Equal(t,
+		map[string]map[string][]string{"nordlynx": {"nord": {"100.64.0.1"}}},
+		resolved.linkRoutes)
+
+	// domain is already routed to the interface
+	assert.ErrorIs(t, setter.SetRoutingDomains(map[string][]string{"nord": {"103.86.96.100"}}), errConflictingRoute)
+	assert.Empty(t, resolved.routingDomains)
+	assert.ErrorIs(t,
+		setter.SetInterfaceRoutingDomains("eth0", map[string][]string{"nord": {"192.168.1.1"}}),
+		errConflictingRoute)
+	assert.ErrorIs(t,
+		setter.SetInterfaceRoutingDomains("eth0", map[string][]string{".": {"192.168.1.1"}}),
+		errConflictingRoute)
+	assert.Error(t, setter.SetInterfaceRoutingDomains("", map[string][]string{"corp": {"192.168.1.1"}}))
+	assert.NotContains(t, resolved.linkRoutes, "eth0")
+
+	// routes of the interface are replaced
+	assert.NoError(t, setter.SetInterfaceRoutingDomains("nordlynx", map[string][]string{"mesh": {"100.64.0.1"}}))
+	assert.NoError(t, setter.SetRoutingDomains(map[string][]string{"nord": {"103.86.96.100"}}))
+
+	assert.NoError(t, setter.SetInterfaceRoutingDomains("nordlynx", nil))
+	assert.Empty(t, resolved.linkRoutes)
+}
+
+func Test_LinkRoutesArgs(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	domains := map[string][]string{"nord": {"100.64.0.1"}, "mesh.example.com": {"100.64.0.2"}}
+	assert.Equal(t, [][]string{
+		linkDNSArgs(3, []string{"100.64.0.2", "100.64.0.1"}),
+		linkDomainsArgs(3, []string{"mesh.example.com", "nord"}, nil),
+		{
+			"call",
+			"org.freedesktop.resolve1",
+			"/org/freedesktop/resolve1",
+			"org.freedesktop.resolve1.Manager",
+			"SetLinkDefaultRoute", "ib", "3", "false",
+		},
+	}, linkRoutesArgs(3, domains))
+}
+
+func Test_ResolvedSetLinkRoutes(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+	domains := map[string][]string{"nord": {"100.64.0.1"}}
+
+	busctl := &mockBusctl{}
+	resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+	resolved.busctl = busctl.run
+	require.NoError(t, resolved.setLinkRoutes(context.Background(), newTransaction(defaultLogger{}), "lo", domains))
+	assert.Equal(t, linkRoutesArgs(lo.Index, domains), busctl.calls)
+	assert.Equal(t, []string{"lo"}, resolved.routedLinks)
+
+	// routed links are reverted together with the VPN link
+	busctl.calls = nil
+	require.NoError(t, resolved.Unset("lo"))
+	assert.Equal(t, []string{"RevertLink", "RevertLink", "FlushCaches"}, busctl.methods())
+	assert.Empty(t, resolved.routedLinks)
+
+	busctl = &mockBusctl{failing: []string{"SetLinkDefaultRoute"}}
+	resolved.busctl = busctl.run
+	tx := newTransaction(defaultLogger{})
+	assert.Error(t, resolved.setLinkRoutes(context.Background(), tx, "lo", domains))
+	assert.True(t, tx.rolledBack)
+	assert.Equal(t, []string{"SetLinkDNS", "SetLinkDomains", "SetLinkDefaultRoute", "RevertLink"}, busctl.methods())
+	assert.Empty(t, resolved.routedLinks)
+}
+
+func Test_ResolvedSetSkipsMissingRoutedLink(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	busctl := &mockBusctl{}
+	resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+	resolved.busctl = busctl.run
+	resolved.linkRoutes = map[string]map[string][]string{"nonexistent0": {"nord": {"100.64.0.1"}}}
+
+	assert.NoError(t, resolved.Set("lo", []string{"103.86.96.100"}))
+	assert.Empty(t, resolved.routedLinks)
+	assert.NotContains(t, busctl.calls, linkDefaultRouteArgs(0, false))
+}
+
+func Test_ResolvedSetRollsBackLink(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	busctl := &mockBusctl{failing: []string{"SetLinkDomains"}}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.busctl = busctl.run
+
+	err := resolved.Set("lo", []string{"103.86.96.100"})
+	var dnsErr *DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.Equal(t, setFailedErrorType, dnsErr.Type)
+	// nameservers set for the link are reverted
+	assert.Equal(t, []string{"SetLinkDNS", "SetLinkDomains", "RevertLink"}, busctl.methods())
+	// the error is reported by the setter, the fallback method can still succeed
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_ResolvedSetScopedToLink(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+	busctl := &mockBusctl{}
+	resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+	resolved.busctl = busctl.run
+
+	assert.NoError(t, resolved.Set("lo", []string{"103.86.96.100"}))
+	require.NotEmpty(t, busctl.calls)
+	for _, call := range busctl.calls {
+		if call[4] == "FlushCaches" {
+			continue
+		}
+		assert.Equal(t, strconv.Itoa(lo.Index), call[6], call[4])
+	}
+	// all of the queries are routed to the link
+	assert.Equal(t, linkDomainsArgs(lo.Index, linkRoutingDomains(nil), nil), busctl.calls[1])
+}
+
+func Test_ResolvedSetUnknownInterface(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	busctl := &mockBusctl{}
+	resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+	resolved.busctl = busctl.run
+
+	err := resolved.Set("nonexistent0", []string{"103.86.96.100"})
+	assert.ErrorIs(t, err, errUnknownInterface)
+	assert.Empty(t, busctl.calls)
+}
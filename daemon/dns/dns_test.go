@@ -1,13 +1,23 @@
 package dns
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/NordSecurity/nordvpn-linux/events/subs"
 	"github.com/NordSecurity/nordvpn-linux/test/category"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 type MockMethod struct {
@@ -24,39 +34,58 @@ func (m *MockMethod) Name() string {
 	return "mock"
 }
 
-func newDnsSetterGood() Setter {
-	ds := DefaultSetter{
-		publisher: &subs.Subject[string]{},
-		methods:   []Method{},
+// newTestSetter creates DefaultSetter which does not depend on the host configuration
+func newTestSetter(analytics *mockAnalytics, methods ...Method) *DefaultSetter {
+	return &DefaultSetter{
+		publisher:          &subs.Subject[string]{},
+		methods:            methods,
+		analytics:          analytics,
+		logger:             defaultLogger{},
+		monitor:            newResolvConfFileWatcherMonitor(analytics, defaultLogger{}),
+		isIPv6Enabled:      func() bool { return true },
+		hasIPv4Route:       func() bool { return true },
+		isIPv6Reachable:    func(string, string) bool { return true },
+		isResolvedDetected: func() bool { return false },
+		isEtcReadOnly:      func() bool { return false },
+		isContainerManaged: func() bool { return false },
+		globalDNS:          func() []string { return nil },
+		lookupEnv:          func(string) (string, bool) { return "", false },
+		canaryDomain:       defaultCanaryDomain,
+		leakCheckHostname:  testLeakCheckHostname,
+		interfaceByName: func(name string) (*net.Interface, error) {
+			return &net.Interface{Index: 1, Name: name}, nil
+		},
+		retryDelay:       setRetryDelay,
+		probeTimeout:     resolverProbeTimeout,
+		probeConcurrency: defaultProbeConcurrency,
+		queryNameserver:  queryNameserver,
+		queryCheckingDisabled: func(context.Context, string, dnsmessage.Name, QueryType) ([]netip.Addr, error) {
+			return nil, ErrLookupServFail
+		},
+		clock: realClock{},
 	}
-	ds.methods = append(ds.methods, &MockMethod{err: nil})
-	ds.methods = append(ds.methods, &MockMethod{err: errors.New("err1")})
-	return &ds
+}
+
+func newDnsSetterGood() Setter {
+	return newTestSetter(&mockAnalytics{},
+		&MockMethod{err: nil},
+		&MockMethod{err: errors.New("err1")},
+	)
 }
 func newDnsSetterError() Setter {
-	ds := DefaultSetter{
-		publisher: &subs.Subject[string]{},
-		methods:   []Method{},
-	}
-	ds.methods = append(ds.methods, &MockMethod{err: nil})
-	ds.methods = append(ds.methods, &MockMethod{err: errors.New("err1")})
-	return &ds
+	return newTestSetter(&mockAnalytics{},
+		&MockMethod{err: nil},
+		&MockMethod{err: errors.New("err1")},
+	)
 }
 func newDnsSetterNotAvailable() Setter {
-	ds := DefaultSetter{
-		publisher: &subs.Subject[string]{},
-		methods:   []Method{},
-	}
-	ds.methods = append(ds.methods, &MockMethod{err: errors.New("set-err")})
-	ds.methods = append(ds.methods, &MockMethod{err: errors.New("unset-err")})
-	return &ds
+	return newTestSetter(&mockAnalytics{},
+		&MockMethod{err: errors.New("set-err")},
+		&MockMethod{err: errors.New("unset-err")},
+	)
 }
 func newDnsSetterNoMethods() Setter {
-	ds := DefaultSetter{
-		publisher: &subs.Subject[string]{},
-		methods:   nil,
-	}
-	return &ds
+	return newTestSetter(&mockAnalytics{})
 }
 
 func Test_Method(t *testing.T) {
@@ -159,3 +188,969 @@ func Test_CheckForEntry(t *testing.T) {
 		})
 	}
 }
+
+type recordingMethod struct {
+	name   string
+	setErr error
+	// ipv6Err is returned when IPv6 nameservers are set
+	ipv6Err error
+	calls   *[]string
+	lastSet []string
+}
+
+func (m *recordingMethod) Set(iface string, nameservers []string) error {
+	*m.calls = append(*m.calls, "set "+m.name)
+	if m.ipv6Err != nil && len(filterIPv4(nameservers)) != len(nameservers) {
+		return m.ipv6Err
+	}
+	if m.setErr == nil {
+		m.lastSet = nameservers
+	}
+	return m.setErr
+}
+func (m *recordingMethod) Unset(iface string) error {
+	*m.calls = append(*m.calls, "unset "+m.name)
+	return nil
+}
+func (m *recordingMethod) Name() string {
+	return m.name
+}
+
+func Test_Refresh(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	resolved := &recordingMethod{name: "resolved", setErr: errors.New("not running"), calls: &calls}
+	file := &recordingMethod{name: "file", calls: &calls}
+	publisher := &subs.Subject[string]{}
+	messages := []string{}
+	publisher.Subscribe(func(msg string) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	ds := newTestSetter(&mockAnalytics{}, resolved, file)
+	ds.publisher = publisher
+
+	assert.NoError(t, ds.Refresh(), "refresh without dns set should be a no-op")
+	assert.Empty(t, calls)
+
+	assert.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+	assert.Equal(t, []string{"set resolved", "set file"}, calls)
+	assert.Equal(t, file, ds.active)
+
+	// systemd-resolved became available
+	resolved.setErr = nil
+	calls = calls[:0]
+	messages = messages[:0]
+	assert.NoError(t, ds.Refresh())
+	assert.Equal(t, []string{"set resolved", "unset file"}, calls)
+	assert.Equal(t, resolved, ds.active)
+	assert.Equal(t, "nordlynx", ds.iface)
+	assert.Equal(t, []string{"1.1.1.1"}, ds.nameservers)
+	assert.Equal(t, []string{
+		"refreshing dns for interface [nordlynx]",
+		"setting dns to 1.1.1.1",
+		"set dns for interface [nordlynx] using: resolved",
+		"unset dns for interface [nordlynx] using: file",
+		"dns method changed from file to resolved",
+	}, messages)
+
+	assert.NoError(t, ds.Unset("nordlynx"))
+	calls = calls[:0]
+	assert.NoError(t, ds.Refresh(), "refresh after unset should be a no-op")
+	assert.Empty(t, calls)
+}
+
+func Test_RefreshFailure(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	file := &recordingMethod{name: "file", calls: &calls}
+	ds := newTestSetter(&mockAnalytics{}, file)
+
+	assert.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+	file.setErr = errors.New("not writable")
+	assert.Error(t, ds.Refresh())
+	assert.Nil(t, ds.active)
+	assert.Empty(t, ds.nameservers)
+}
+
+func Test_SetEmitsAnalytics(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name             string
+		methods          []Method
+		configuredEvents int
+		errorEvents      []mockErrorEvent
+	}{
+		{
+			name:             "set succeeds",
+			methods:          []Method{&MockMethod{err: errors.New("set-err")}, &MockMethod{}},
+			configuredEvents: 1,
+		},
+		{
+			name:    "read only file system",
+			methods: []Method{&MockMethod{err: fmt.Errorf("writing file: %w", &os.PathError{Err: syscall.EROFS})}},
+			errorEvents: []mockErrorEvent{
+				{errorType: readOnlyFilesystemErrorType, critical: true},
+			},
+		},
+		{
+			name:    "permission denied",
+			methods: []Method{&MockMethod{err: fmt.Errorf("writing file: %w", &os.PathError{Err: syscall.EACCES})}},
+			errorEvents: []mockErrorEvent{
+				{errorType: permissionDeniedErrorType, critical: true},
+			},
+		},
+		{
+			name:    "generic failure",
+			methods: []Method{&MockMethod{err: errors.New("set-err")}},
+			errorEvents: []mockErrorEvent{
+				{errorType: setFailedErrorType, critical: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, test.methods...)
+
+			_ = ds.Set("nordlynx", []string{"1.1.1.1"})
+			assert.Len(t, analytics.configuredEvents, test.configuredEvents)
+			assert.Equal(t, test.errorEvents, analytics.errorEvents)
+		})
+	}
+}
+
+// flakyMethod fails to set DNS the given number of times
+type flakyMethod struct {
+	MockMethod
+	failures int
+	attempts int
+}
+
+func (m *flakyMethod) Set(iface string, nameservers []string) error {
+	m.attempts++
+	if m.attempts <= m.failures {
+		return fmt.Errorf("writing file: %w", &os.PathError{Err: syscall.EACCES})
+	}
+	return nil
+}
+
+func Test_SetRetries(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name             string
+		failures         int
+		err              bool
+		configuredEvents int
+		errorEvents      []mockErrorEvent
+		delays           []int
+	}{
+		{
+			name:             "succeeds on the second attempt",
+			failures:         1,
+			configuredEvents: 1,
+			delays:           []int{0},
+		},
+		{
+			name:     "retries exhausted",
+			failures: 10,
+			err:      true,
+			errorEvents: []mockErrorEvent{
+				{errorType: permissionDeniedErrorType, critical: true, retryCount: 3},
+			},
+			delays: []int{0, 1, 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			method := &flakyMethod{failures: test.failures}
+			ds := newTestSetter(analytics, method)
+			ds.SetRetries(3)
+			delays := []int{}
+			ds.retryDelay = func(attempt int) time.Duration {
+				delays = append(delays, attempt)
+				return 0
+			}
+
+			err := ds.Set("nordlynx", []string{"1.1.1.1"})
+			assert.Equal(t, test.err, err != nil)
+			assert.Len(t, analytics.configuredEvents, test.configuredEvents)
+			assert.Equal(t, test.errorEvents, analytics.errorEvents)
+			assert.Equal(t, test.delays, delays)
+		})
+	}
+}
+
+// waitForTimer waits until the clock has a pending timer, i.e. until Set backs off
+func waitForTimer(t *testing.T, clock *fakeClock) {
+	t.Helper()
+	assert.Eventually(t, func() bool { return clock.pendingTimers() > 0 }, time.Second, time.Millisecond)
+}
+
+func Test_SetRetryBackoffDoesNotBlock(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	method := &flakyMethod{failures: 1}
+	ds := newTestSetter(analytics, method)
+	clock := newFakeClock()
+	ds.clock = clock
+	ds.SetRetries(3)
+
+	done := make(chan error)
+	go func() { done <- ds.Set("nordlynx", []string{"1.1.1.1"}) }()
+	waitForTimer(t, clock)
+
+	// other calls do not wait for the backoff
+	locked := make(chan struct{})
+	go func() {
+		ds.SetRetries(3)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("setter is locked while setting dns backs off")
+	}
+
+	clock.Advance(setRetryBaseDelay)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 2, method.attempts)
+	assert.Len(t, analytics.configuredEvents, 1)
+}
+
+func Test_SetRetrySupersededByUnset(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	method := &flakyMethod{failures: 1}
+	ds := newTestSetter(analytics, method)
+	clock := newFakeClock()
+	ds.clock = clock
+	ds.SetRetries(3)
+
+	done := make(chan error)
+	go func() { done <- ds.Set("nordlynx", []string{"1.1.1.1"}) }()
+	waitForTimer(t, clock)
+	require.NoError(t, ds.Unset("nordlynx"))
+
+	clock.Advance(setRetryBaseDelay)
+	assert.ErrorIs(t, <-done, errSetSuperseded)
+	// dns unset in the meantime is not set again
+	assert.Equal(t, 1, method.attempts)
+	assert.Nil(t, ds.active)
+	assert.Empty(t, analytics.configuredEvents)
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_SetRetryDelay(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, setRetryBaseDelay, setRetryDelay(0))
+	assert.Equal(t, 2*setRetryBaseDelay, setRetryDelay(1))
+	assert.Equal(t, 4*setRetryBaseDelay, setRetryDelay(2))
+}
+
+func Test_ManagementServiceForMethod(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		method  Method
+		service dnsManagementService
+	}{
+		{method: &Resolved{}, service: systemdResolvedService},
+		{method: &Resolvectl{}, service: systemdResolvedService},
+		{method: &Resolvconf{}, service: resolvconfService},
+		{method: &ResolvConfFile{}, service: unmanagedService},
+		{method: &MockMethod{}, service: unknownService},
+		{method: &fakeBackend{service: resolvconfService}, service: resolvconfService},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method.Name(), func(t *testing.T) {
+			assert.Equal(t, test.service, managementServiceForMethod(test.method))
+		})
+	}
+}
+
+// fakeBackend is a DNS handling method managed by the given service
+type fakeBackend struct {
+	MockMethod
+	service dnsManagementService
+	// unavailable is returned when availability of the method is checked
+	unavailable error
+}
+
+func (m *fakeBackend) managementService() dnsManagementService {
+	return m.service
+}
+
+func (m *fakeBackend) available() error {
+	return m.unavailable
+}
+
+func Test_SetReportsThreatProtection(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &MockMethod{})
+	require.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+	assert.NoError(t, ds.NotifyThreatProtectionLite(true))
+	require.NoError(t, ds.Set("nordlynx", []string{"103.86.96.96"}))
+	assert.NoError(t, ds.NotifyThreatProtectionLite(false))
+	require.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+
+	threatProtection := []bool{}
+	for _, details := range analytics.configuredEvents {
+		threatProtection = append(threatProtection, details.threatProtection)
+	}
+	assert.Equal(t, []bool{false, true, false}, threatProtection)
+}
+
+// monitorPausingMethod records if the monitor was paused while DNS was set
+type monitorPausingMethod struct {
+	MockMethod
+	monitor *resolvConfFileWatcherMonitor
+	paused  bool
+}
+
+func (m *monitorPausingMethod) Set(string, []string) error {
+	m.monitor.mu.Lock()
+	defer m.monitor.mu.Unlock()
+	m.paused = m.monitor.pauses > 0
+	return m.err
+}
+
+func Test_SetPausesMonitor(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	method := &monitorPausingMethod{MockMethod: MockMethod{err: errors.New("set failed")}}
+	ds := newTestSetter(analytics, method)
+	ds.retries = 0
+	ds.monitor = newTestMonitor(t, analytics)
+	method.monitor = ds.monitor
+	// monitor watches resolv.conf written by the previous Set
+	require.NoError(t, ds.monitor.Start(testVPNNameservers))
+	defer ds.monitor.Stop()
+
+	assert.Error(t, ds.Set("nordlynx", testVPNNameservers))
+	assert.True(t, method.paused)
+	// previous configuration is still watched
+	ds.monitor.mu.Lock()
+	defer ds.monitor.mu.Unlock()
+	assert.Zero(t, ds.monitor.pauses)
+	assert.NotNil(t, ds.monitor.done)
+	assert.Equal(t, testVPNNameservers, ds.monitor.expected)
+}
+
+func Test_SetReportsTrigger(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &MockMethod{})
+	ds.monitor = newTestMonitor(t, analytics)
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	ds.reapplyResolvConf()
+	require.NoError(t, ds.Refresh())
+	// resolv.conf drifted from the nameservers written by NordVPN
+	ds.monitor.expected = testVPNNameservers
+	require.NoError(t, os.WriteFile(ds.monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+	ds.reconcile(context.Background())
+
+	triggers := []configurationTrigger{}
+	for _, details := range analytics.configuredEvents {
+		triggers = append(triggers, details.trigger)
+	}
+	assert.Equal(t,
+		[]configurationTrigger{connectTrigger, reapplyTrigger, refreshTrigger, reconcileTrigger},
+		triggers)
+}
+
+func Test_SetReportsInterfaceIndex(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &MockMethod{})
+	ds.interfaceByName = func(name string) (*net.Interface, error) {
+		if name != "nordlynx" {
+			return nil, errors.New("no such network interface")
+		}
+		return &net.Interface{Index: 7, Name: name}, nil
+	}
+	require.NoError(t, ds.Set("nordlynx", []string{"103.86.96.100"}))
+	require.NoError(t, ds.Set("nordtun", []string{"103.86.96.100"}))
+
+	require.Len(t, analytics.configuredEvents, 2)
+	assert.Equal(t, 7, analytics.configuredEvents[0].interfaceIndex)
+	// unknown index is not a reason to fail, as not every method needs the interface
+	assert.Equal(t, 0, analytics.configuredEvents[1].interfaceIndex)
+}
+
+func Test_SetFallsBackToResolvConf(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	dbusErr := errors.New("Failed to connect to bus: No such file or directory")
+	tests := []struct {
+		name             string
+		resolvedDetected bool
+		fileErr          error
+		err              bool
+		errorEvents      []mockErrorEvent
+	}{
+		{
+			name:             "fallback succeeds",
+			resolvedDetected: true,
+			errorEvents: []mockErrorEvent{
+				{errorType: setFailedErrorType, critical: false, fallback: resolvConfFallback},
+			},
+		},
+		{
+			name:             "systemd-resolved is not used on the host",
+			resolvedDetected: false,
+		},
+		{
+			name:             "fallback fails",
+			resolvedDetected: true,
+			fileErr:          errors.New("read-only"),
+			err:              true,
+			errorEvents:      []mockErrorEvent{{errorType: setFailedErrorType, critical: true}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics,
+				&fakeBackend{MockMethod: MockMethod{err: dbusErr}, service: systemdResolvedService},
+				&fakeBackend{MockMethod: MockMethod{err: test.fileErr}, service: unmanagedService},
+			)
+			ds.SetRetries(0)
+			ds.isResolvedDetected = func() bool { return test.resolvedDetected }
+
+			err := ds.Set("nordlynx", []string{"1.1.1.1"})
+			assert.Equal(t, test.err, err != nil)
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+		})
+	}
+}
+
+func Test_SetSelectsMethod(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	unavailable := errors.New("not available")
+	for _, service := range enumMembers[dnsManagementService]() {
+		t.Run(service.String(), func(t *testing.T) {
+			methods := []Method{}
+			// services preceding the tested one are not available on the host
+			for _, other := range enumMembers[dnsManagementService]()[:service] {
+				methods = append(methods, &fakeBackend{MockMethod: MockMethod{err: unavailable}, service: other})
+			}
+			selected := &fakeBackend{service: service}
+			methods = append(methods, selected, &fakeBackend{service: unmanagedService})
+
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, methods...)
+			require.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+			assert.Equal(t, selected, ds.active)
+			assert.Equal(t, service, analytics.ManagementService())
+			assert.Len(t, analytics.configuredEvents, 1)
+			assert.Empty(t, analytics.errorEvents)
+		})
+	}
+}
+
+func Test_DetectManagementService(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	unavailable := errors.New("not available")
+	tests := []struct {
+		name        string
+		methods     []Method
+		detected    []dnsManagementService
+		errorEvents []mockErrorEvent
+	}{
+		{
+			name: "first available method",
+			methods: []Method{
+				&fakeBackend{service: systemdResolvedService, unavailable: unavailable},
+				// availability of the method can't be checked
+				&MockMethod{},
+				&fakeBackend{service: resolvconfService},
+				&fakeBackend{service: unmanagedService},
+			},
+			detected: []dnsManagementService{resolvconfService},
+		},
+		{
+			name: "no method available",
+			methods: []Method{
+				&fakeBackend{service: systemdResolvedService, unavailable: unavailable},
+				&MockMethod{},
+			},
+			errorEvents: []mockErrorEvent{{errorType: detectionFailedErrorType, critical: false}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, test.methods...)
+			clock := newFakeClock()
+			ds.clock = clock
+			detectWithClock(t, ds, clock)
+			assert.Equal(t, test.detected, analytics.detectedEvents)
+			assert.Equal(t, test.errorEvents, analytics.errorEvents)
+		})
+	}
+}
+
+// detectWithClock detects the management service, advancing the clock whenever detection waits
+// for the next attempt
+func detectWithClock(t *testing.T, ds *DefaultSetter, clock *fakeClock) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		ds.DetectManagementService(context.Background())
+		close(done)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			t.Fatal("detection did not finish")
+		case <-time.After(time.Millisecond):
+			if clock.pendingTimers() > 0 {
+				clock.Advance(detectionRetryInterval)
+			}
+		}
+	}
+}
+
+// startingBackend is not available until it was checked failures times, like a service which
+// is still starting
+type startingBackend struct {
+	fakeBackend
+	failures int
+	checks   int
+}
+
+func (m *startingBackend) available() error {
+	m.checks++
+	if m.checks <= m.failures {
+		return errors.New("not started yet")
+	}
+	return nil
+}
+
+func Test_DetectManagementServiceQuietPeriod(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := &startingBackend{fakeBackend: fakeBackend{service: systemdResolvedService}, failures: 2}
+	ds := newTestSetter(analytics, resolved)
+	clock := newFakeClock()
+	ds.clock = clock
+
+	detectWithClock(t, ds, clock)
+	assert.Equal(t, 3, resolved.checks)
+	assert.Equal(t, []dnsManagementService{systemdResolvedService}, analytics.detectedEvents)
+	assert.Equal(t, systemdResolvedService, analytics.ManagementService())
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_DetectManagementServiceCanceled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &fakeBackend{service: systemdResolvedService, unavailable: errors.New("not available")})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// canceled detection is not reported as failed
+	ds.DetectManagementService(ctx)
+	assert.Empty(t, analytics.detectedEvents)
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_DetectManagementServiceWhenDNSIsSet(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics,
+		&fakeBackend{service: systemdResolvedService},
+		&fakeBackend{service: unmanagedService},
+	)
+	ds.methods[0].(*fakeBackend).err = errors.New("set-err")
+	require.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+
+	// method used to set DNS is reported, even though the first one is available
+	ds.DetectManagementService(context.Background())
+	assert.Equal(t, []dnsManagementService{unmanagedService}, analytics.detectedEvents)
+}
+
+func Test_SetValidatesNameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name        string
+		nameservers []string
+		isValid     bool
+	}{
+		{
+			name:        "ipv4",
+			nameservers: []string{"192.168.1.53", "1.1.1.1"},
+			isValid:     true,
+		},
+		{
+			name:        "mixed ipv4 and ipv6",
+			nameservers: []string{"192.168.1.53", "2606:4700:4700::1111", "fd00::53"},
+			isValid:     true,
+		},
+		{
+			name:        "malformed address",
+			nameservers: []string{"1.1.1.1", "1.1.1"},
+			isValid:     false,
+		},
+		{
+			name:        "hostname",
+			nameservers: []string{"pi.hole"},
+			isValid:     false,
+		},
+		{
+			name:        "address with port",
+			nameservers: []string{"1.1.1.1:53"},
+			isValid:     false,
+		},
+		{
+			name:        "empty address",
+			nameservers: []string{""},
+			isValid:     false,
+		},
+		{
+			name:        "unspecified address",
+			nameservers: []string{"1.1.1.1", "0.0.0.0"},
+			isValid:     false,
+		},
+		{
+			name:        "multicast address",
+			nameservers: []string{"ff02::fb"},
+			isValid:     false,
+		},
+		{
+			name:        "loopback address",
+			nameservers: []string{"127.0.0.53"},
+			isValid:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, &recordingMethod{name: "file", calls: &calls})
+
+			err := ds.Set("nordlynx", test.nameservers)
+			if test.isValid {
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"set file"}, calls)
+				assert.Len(t, analytics.configuredEvents, 1)
+			} else {
+				assert.ErrorContains(t, err, "invalid nameserver address")
+				assert.Empty(t, calls, "no changes should be made to the system")
+				assert.Empty(t, analytics.configuredEvents)
+				assert.Equal(t,
+					[]mockErrorEvent{{errorType: setFailedErrorType, critical: true}},
+					analytics.getErrorEvents())
+			}
+		})
+	}
+}
+
+func Test_ValidateResolvers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name       string
+		addresses  []string
+		isValid    bool
+		isLoopback bool
+	}{
+		{
+			name:      "dual stack",
+			addresses: []string{"103.86.96.100", "2001:db8::53", "fd00::53", "192.168.1.53"},
+			isValid:   true,
+		},
+		{name: "unspecified ipv4", addresses: []string{"1.1.1.1", "0.0.0.0"}},
+		{name: "unspecified ipv6", addresses: []string{"::"}},
+		{name: "multicast ipv4", addresses: []string{"224.0.0.251"}},
+		{name: "multicast ipv6", addresses: []string{"ff02::fb"}},
+		{name: "loopback ipv4", addresses: []string{"127.0.0.1"}, isLoopback: true},
+		{name: "loopback ipv6", addresses: []string{"1.1.1.1", "::1"}, isLoopback: true},
+		{name: "unspecified with loopback", addresses: []string{"127.0.0.1", "0.0.0.0"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addresses := []netip.Addr{}
+			for _, address := range test.addresses {
+				addresses = append(addresses, netip.MustParseAddr(address))
+			}
+
+			err := validateResolvers(addresses)
+			switch {
+			case test.isValid:
+				assert.NoError(t, err)
+			case test.isLoopback:
+				assert.ErrorIs(t, err, errLoopbackNameserver)
+			default:
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, errLoopbackNameserver)
+			}
+		})
+	}
+}
+
+func Test_SetAllowsLoopbackNameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	t.Run("append mode", func(t *testing.T) {
+		calls := []string{}
+		analytics := &mockAnalytics{}
+		file := &ResolvConfFile{logger: defaultLogger{}, appendMode: true}
+		ds := newTestSetter(analytics, &recordingMethod{name: "file", calls: &calls})
+		ds.methods = append(ds.methods, file)
+
+		assert.NoError(t, ds.Set("nordlynx", []string{"127.0.0.53", "103.86.96.100"}))
+		assert.Equal(t, []string{"set file"}, calls)
+		assert.Empty(t, analytics.getErrorEvents())
+	})
+
+	t.Run("unmanaged", func(t *testing.T) {
+		calls := []string{}
+		analytics := &mockAnalytics{managementService: unmanagedService}
+		ds := newTestSetter(analytics, &recordingMethod{name: "file", calls: &calls})
+
+		assert.NoError(t, ds.Set("nordlynx", []string{"::1"}))
+		assert.Equal(t, []string{"set file"}, calls)
+		assert.Empty(t, analytics.getErrorEvents())
+	})
+}
+
+func Test_SetIPv6(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	dualStack := []string{"103.86.96.100", "2001:db8::53", "103.86.99.100"}
+	ipv4Only := []string{"103.86.96.100", "103.86.99.100"}
+
+	tests := []struct {
+		name        string
+		nameservers []string
+		ipv6Enabled bool
+		ipv6Err     error
+		setErr      error
+		expectedSet []string
+		calls       []string
+		errorEvents []mockErrorEvent
+		isErr       bool
+	}{
+		{
+			name:        "dual stack",
+			nameservers: dualStack,
+			ipv6Enabled: true,
+			expectedSet: dualStack,
+			calls:       []string{"set file"},
+		},
+		{
+			name:        "ipv6 disabled on the host",
+			nameservers: dualStack,
+			ipv6Enabled: false,
+			expectedSet: ipv4Only,
+			calls:       []string{"set file"},
+		},
+		{
+			name:        "only ipv6 nameservers with ipv6 disabled",
+			nameservers: []string{"2001:db8::53"},
+			ipv6Enabled: false,
+			isErr:       true,
+		},
+		{
+			name:        "ipv6 set fails",
+			nameservers: dualStack,
+			ipv6Enabled: true,
+			ipv6Err:     errors.New("ipv6 not supported"),
+			expectedSet: ipv4Only,
+			calls:       []string{"set file", "set file"},
+			errorEvents: []mockErrorEvent{{errorType: ipv6SetFailedErrorType, critical: false}},
+		},
+		{
+			name:        "ipv4 set fails too",
+			nameservers: dualStack,
+			ipv6Enabled: true,
+			ipv6Err:     errors.New("ipv6 not supported"),
+			setErr:      errors.New("not writable"),
+			calls:       []string{"set file", "set file"},
+			errorEvents: []mockErrorEvent{{errorType: setFailedErrorType, critical: true}},
+			isErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			method := &recordingMethod{name: "file", setErr: test.setErr, ipv6Err: test.ipv6Err, calls: &calls}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, method)
+			ds.isIPv6Enabled = func() bool { return test.ipv6Enabled }
+
+			err := ds.Set("nordlynx", test.nameservers)
+			if test.isErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.nameservers, ds.nameservers, "requested nameservers should be kept for refresh")
+			}
+			assert.Equal(t, test.expectedSet, method.lastSet)
+			if test.calls != nil {
+				assert.Equal(t, test.calls, calls)
+			}
+			assert.Equal(t, test.errorEvents, analytics.errorEvents)
+		})
+	}
+}
+
+func Test_SetIPv6OnlyHost(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name          string
+		nameservers   []string
+		expectedSet   []string
+		errorEvents   []mockErrorEvent
+		isErr         bool
+		configuredLen int
+	}{
+		{
+			name:          "ipv6 nameservers are preferred",
+			nameservers:   []string{"103.86.96.100", "2001:db8::53"},
+			expectedSet:   []string{"2001:db8::53", "103.86.96.100"},
+			configuredLen: 1,
+		},
+		{
+			name:          "ipv6 only nameservers",
+			nameservers:   []string{"2001:db8::53"},
+			expectedSet:   []string{"2001:db8::53"},
+			configuredLen: 1,
+		},
+		{
+			name:        "ipv4 only nameservers are rejected",
+			nameservers: []string{"103.86.96.100", "103.86.99.100"},
+			errorEvents: []mockErrorEvent{{errorType: detectionFailedErrorType, critical: true}},
+			isErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			method := &recordingMethod{name: "file", calls: &calls}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, method)
+			ds.hasIPv4Route = func() bool { return false }
+
+			err := ds.Set("nordlynx", test.nameservers)
+			if test.isErr {
+				assert.ErrorIs(t, err, errNoIPv6Nameservers)
+				assert.Empty(t, calls, "no changes should be made to the system")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expectedSet, method.lastSet)
+			assert.Equal(t, test.errorEvents, analytics.errorEvents)
+			require.Len(t, analytics.configuredEvents, test.configuredLen)
+			for _, details := range analytics.configuredEvents {
+				assert.Equal(t, ipv6AddressFamily, details.addressFamily)
+			}
+		})
+	}
+}
+
+func Test_VerifyResolvConf(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		name        string
+		content     string
+		errorEvents []mockErrorEvent
+	}{
+		{
+			name:    "content matches",
+			content: testVPNResolvConf,
+		},
+		{
+			name:        "content reverted",
+			content:     testOriginalResolvConf,
+			errorEvents: []mockErrorEvent{{errorType: revertedAfterWriteErrorType, critical: true}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics)
+			ds.monitor.filePath = filepath.Join(t.TempDir(), "resolv.conf")
+			require.NoError(t, os.WriteFile(ds.monitor.filePath, []byte(test.content), 0644))
+
+			ds.verifyResolvConf(testVPNNameservers)
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+		})
+	}
+}
+
+func Test_OnManagementServiceChangeAfterSet(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ds := newTestSetter(&mockAnalytics{}, &fakeBackend{service: unmanagedService})
+	transitions := []string{}
+	ds.OnManagementServiceChange(func(old, new string) {
+		transitions = append(transitions, old+" -> "+new)
+	})
+
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.100"}))
+	require.NoError(t, ds.Set("lo", []string{"103.86.99.100"}))
+	assert.Equal(t, []string{"unknown -> unmanaged"}, transitions)
+}
+
+func Test_SetReplacesPreviousMethodAfterNewOneIsLive(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	resolved := &recordingMethod{name: "resolved", setErr: errors.New("not running"), calls: &calls}
+	file := &recordingMethod{name: "file", calls: &calls}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, resolved, file)
+
+	require.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+	assert.Equal(t, file, ds.active)
+
+	// same method replaces the nameservers without unsetting them first
+	calls = calls[:0]
+	require.NoError(t, ds.Set("nordlynx", []string{"1.0.0.1"}))
+	assert.Equal(t, []string{"set resolved", "set file"}, calls)
+
+	// previous method is unset only after the new one succeeded
+	resolved.setErr = nil
+	calls = calls[:0]
+	require.NoError(t, ds.Set("nordlynx", []string{"1.1.1.1"}))
+	assert.Equal(t, []string{"set resolved", "unset file"}, calls)
+	assert.Equal(t, resolved, ds.active)
+	assert.Len(t, analytics.configuredEvents, 3)
+}
@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSSECMode is the DNSSEC validation mode of the VPN link when DNS is managed by
+// systemd-resolved
+type DNSSECMode int
+
+const (
+	// AllowDowngradeDNSSECMode validates the answers, but accepts them without validation when
+	// the nameservers do not support DNSSEC
+	AllowDowngradeDNSSECMode DNSSECMode = iota
+	// YesDNSSECMode rejects the answers which fail validation, including the unsigned ones
+	YesDNSSECMode
+)
+
+func (m DNSSECMode) String() string {
+	switch m {
+	case AllowDowngradeDNSSECMode:
+		return "allow-downgrade"
+	case YesDNSSECMode:
+		return "yes"
+	default:
+		return fmt.Sprintf("%d", int(m))
+	}
+}
+
+const (
+	// minLinkDNSSECVersion is the first systemd version configuring DNSSEC per link
+	minLinkDNSSECVersion = 229
+	// resolvedStubAddress is the stub resolver of systemd-resolved, which is queried with DNSSEC
+	// checking disabled to tell validation failures from other ones
+	resolvedStubAddress = "127.0.0.53:53"
+)
+
+// ErrHealthCheckDNSSEC is returned by the health check when the answer failed DNSSEC validation,
+// which means that DNS responds, but the queried domain is not signed correctly
+var ErrHealthCheckDNSSEC = errors.New("dns answer failed dnssec validation")
+
+// detectedResolvedVersion detects systemd-resolved version only once
+var detectedResolvedVersion = sync.OnceValue(detectResolvedVersion)
+
+// supportsLinkDNSSEC checks if the systemd version configures DNSSEC per link. Unknown version
+// is assumed to support it, so that validation is not skipped only because of failed detection.
+func supportsLinkDNSSEC(version string) bool {
+	number, err := strconv.Atoi(version)
+	return err != nil || number >= minLinkDNSSECVersion
+}
+
+// isDNSSECUnsupported checks busctl output for the errors of systemd-resolved which does not
+// know SetLinkDNSSEC or was built without DNSSEC support
+func isDNSSECUnsupported(out []byte) bool {
+	message := strings.ToLower(string(out))
+	return strings.Contains(message, "unknown method") || strings.Contains(message, "not supported")
+}
+
+// setLinkDNSSEC sets DNSSEC mode of the link. DNSSEC is skipped with a non-critical error event
+// when systemd-resolved does not support it, because DNS works without it.
+func (m *Resolved) setLinkDNSSEC(ctx context.Context, index int, name string) error {
+	if version := m.resolvedVersion(); !supportsLinkDNSSEC(version) {
+		m.skipDNSSEC(fmt.Errorf("systemd %s does not configure dnssec per link", version))
+		return nil
+	}
+	out, err := m.busctl(ctx, linkDNSSECArgs(index, m.dnssec)...)
+	switch {
+	case err == nil:
+		m.dnssecApplied = true
+		return nil
+	case isDNSSECUnsupported(out):
+		m.skipDNSSEC(fmt.Errorf("%s: %w", strings.TrimSpace(string(out)), err))
+		return nil
+	default:
+		return fmt.Errorf("setting link dns sec for %s via dbus: %s: %w", name, strings.TrimSpace(string(out)), err)
+	}
+}
+
+func (m *Resolved) skipDNSSEC(err error) {
+	m.logger.Warn("DNSSEC is not available, setting dns without it:", err)
+	m.analytics.emitDNSConfigurationErrorEvent(context.Background(), dnssecUnsupportedErrorType, severityByType)
+}
+
+// linkDNSSECArgs prepares busctl arguments for the SetLinkDNSSEC call
+func linkDNSSECArgs(index int, mode DNSSECMode) []string {
+	return []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDNSSEC", "is", fmt.Sprintf("%d", index), mode.String(),
+	}
+}
+
+// dnssecModeApplied returns DNSSEC mode of the link, it is empty when DNSSEC was not set
+func dnssecModeApplied(method Method) string {
+	if resolved, ok := method.(*Resolved); ok && resolved.dnssecApplied {
+		return resolved.dnssec.String()
+	}
+	return ""
+}
+
+// isDNSSECEnforced checks if the answers failing DNSSEC validation are rejected
+func isDNSSECEnforced(method Method) bool {
+	resolved, ok := method.(*Resolved)
+	return ok && resolved.dnssecApplied && resolved.dnssec == YesDNSSECMode
+}
+
+// isDNSSECValidationFailure checks if the lookup of the domain failed because of DNSSEC
+// validation. systemd-resolved answers such queries with SERVFAIL, the same as when the
+// nameservers fail, so the query is retried with checking disabled, which succeeds only when
+// the validation was the cause.
+func (d *DefaultSetter) isDNSSECValidationFailure(ctx context.Context, domain string, err error) bool {
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsTemporary || dnsErr.IsTimeout || dnsErr.IsNotFound {
+		return false
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domain, ".") + ".")
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	if _, err := d.queryCheckingDisabled(ctx, resolvedStubAddress, name, QueryTypeA); err != nil {
+		d.logger.Debug("dns health check retry with dnssec checking disabled:", err)
+		return false
+	}
+	return true
+}
+
+// SetDNSSEC sets DNSSEC validation mode of the VPN link when systemd-resolved is used.
+// Validation is allowed to downgrade by default, so DNS keeps working with the nameservers not
+// supporting DNSSEC. The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetDNSSEC(mode DNSSECMode) error {
+	if !slices.Contains(enumMembers[DNSSECMode](), mode) {
+		return fmt.Errorf("unknown dnssec mode %s", mode)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if resolved, ok := method.(*Resolved); ok {
+			resolved.dnssec = mode
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func Test_SupportsLinkDNSSEC(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.False(t, supportsLinkDNSSEC("228"))
+	assert.True(t, supportsLinkDNSSEC("229"))
+	assert.True(t, supportsLinkDNSSEC("255"))
+	assert.True(t, supportsLinkDNSSEC(unknownResolvedVersion))
+}
+
+func Test_LinkDNSSECArgs(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, []string{
+		"call",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"SetLinkDNSSEC", "is", "3", "yes",
+	}, linkDNSSECArgs(3, YesDNSSECMode))
+}
+
+func Test_ResolvedSetDNSSEC(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		mode        DNSSECMode
+		version     string
+		failing     []string
+		calls       [][]string
+		applied     string
+		errorEvents []mockErrorEvent
+	}{
+		{
+			name:    "allow downgrade by default",
+			version: "255",
+			calls:   [][]string{linkDNSSECArgs(lo.Index, AllowDowngradeDNSSECMode)},
+			applied: "allow-downgrade",
+		},
+		{
+			name:    "enforced",
+			mode:    YesDNSSECMode,
+			version: "255",
+			calls:   [][]string{linkDNSSECArgs(lo.Index, YesDNSSECMode)},
+			applied: "yes",
+		},
+		{
+			name:        "version without per link dnssec",
+			mode:        YesDNSSECMode,
+			version:     "228",
+			errorEvents: []mockErrorEvent{{errorType: dnssecUnsupportedErrorType}},
+		},
+		{
+			name:        "unknown method",
+			mode:        YesDNSSECMode,
+			version:     unknownResolvedVersion,
+			failing:     []string{"SetLinkDNSSEC"},
+			calls:       [][]string{linkDNSSECArgs(lo.Index, YesDNSSECMode)},
+			errorEvents: []mockErrorEvent{{errorType: dnssecUnsupportedErrorType}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			busctl := &mockBusctl{failing: test.failing}
+			resolved := newResolved(analytics, defaultLogger{})
+			resolved.busctl = busctl.run
+			resolved.resolvedVersion = func() string { return test.version }
+			resolved.dnssec = test.mode
+
+			require.NoError(t, resolved.Set("lo", testVPNNameservers))
+			dnssecCalls := [][]string{}
+			for _, call := range busctl.calls {
+				if call[4] == "SetLinkDNSSEC" {
+					dnssecCalls = append(dnssecCalls, call)
+				}
+			}
+			assert.Equal(t, len(test.calls), len(dnssecCalls))
+			for _, call := range test.calls {
+				assert.Contains(t, dnssecCalls, call)
+			}
+			assert.Equal(t, test.applied, dnssecModeApplied(resolved))
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+		})
+	}
+}
+
+func Test_ResolvedSetDNSSECFails(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	busctl := &mockBusctl{}
+	resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+	resolved.resolvedVersion = func() string { return "255" }
+	resolved.busctl = func(ctx context.Context, args ...string) ([]byte, error) {
+		out, err := busctl.run(ctx, args...)
+		if args[4] == "SetLinkDNSSEC" {
+			return []byte("Access denied"), errors.New("exit status 1")
+		}
+		return out, err
+	}
+
+	// failures other than missing support are not ignored
+	assert.Error(t, resolved.Set("lo", testVPNNameservers))
+	assert.Contains(t, busctl.methods(), "RevertLink")
+	assert.Empty(t, dnssecModeApplied(resolved))
+}
+
+func Test_SetDNSSEC(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.busctl = (&mockBusctl{}).run
+	resolved.resolvedVersion = func() string { return "255" }
+	ds := newTestSetter(analytics, resolved)
+
+	require.NoError(t, ds.SetDNSSEC(YesDNSSECMode))
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.Equal(t, "yes", analytics.configuredEvents[0].dnssec)
+
+	assert.Error(t, ds.SetDNSSEC(DNSSECMode(10)))
+	assert.Equal(t, YesDNSSECMode, resolved.dnssec)
+}
+
+func Test_HealthCheckDNSSECValidationFailure(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	servfail := &net.DNSError{Err: "server misbehaving", Name: defaultCanaryDomain, IsTemporary: true}
+	for _, mode := range []DNSSECMode{AllowDowngradeDNSSECMode, YesDNSSECMode} {
+		t.Run(mode.String(), func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics)
+			ds.active = &Resolved{dnssec: mode, dnssecApplied: true}
+			ds.hostLookup = fakeHostLookup{err: servfail}
+			queried := []string{}
+			// the domain resolves when validation is skipped
+			ds.queryCheckingDisabled = func(
+				_ context.Context, address string, name dnsmessage.Name, _ QueryType,
+			) ([]netip.Addr, error) {
+				queried = append(queried, address+" "+name.String())
+				return []netip.Addr{netip.MustParseAddr("104.16.208.203")}, nil
+			}
+
+			for i := 0; i < healthCheckFailureThreshold; i++ {
+				err := ds.HealthCheck(context.Background())
+				assert.ErrorIs(t, err, servfail)
+				assert.Equal(t, mode == YesDNSSECMode, errors.Is(err, ErrHealthCheckDNSSEC))
+			}
+			if mode == YesDNSSECMode {
+				// failed validation is not a DNS failure
+				assert.Empty(t, analytics.getErrorEvents())
+				assert.Contains(t, queried, resolvedStubAddress+" "+defaultCanaryDomain+".")
+			} else {
+				assert.Len(t, analytics.getErrorEvents(), 1)
+				assert.Empty(t, queried)
+			}
+		})
+	}
+}
+
+func Test_HealthCheckServFailWithDNSSECEnforced(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	servfail := &net.DNSError{Err: "server misbehaving", Name: defaultCanaryDomain, IsTemporary: true}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics)
+	ds.active = &Resolved{dnssec: YesDNSSECMode, dnssecApplied: true}
+	ds.hostLookup = fakeHostLookup{err: servfail}
+	// the upstream fails also when validation is skipped
+	ds.queryCheckingDisabled = func(context.Context, string, dnsmessage.Name, QueryType) ([]netip.Addr, error) {
+		return nil, ErrLookupServFail
+	}
+
+	for i := 0; i < healthCheckFailureThreshold; i++ {
+		err := ds.HealthCheck(context.Background())
+		assert.ErrorIs(t, err, servfail)
+		assert.NotErrorIs(t, err, ErrHealthCheckDNSSEC)
+	}
+	assert.Equal(t, healthCheckFailureThreshold, ds.healthCheckFailures)
+	require.Len(t, analytics.getErrorEvents(), 1)
+	assert.Equal(t, healthCheckFailedErrorType, analytics.getErrorEvents()[0].errorType)
+}
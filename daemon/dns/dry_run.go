@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DryRunResult describes the DNS configuration which would be applied by Set
+type DryRunResult struct {
+	ManagementService string
+	Method            string
+	Nameservers       []string
+	// Changes are the commands or file contents which would be applied
+	Changes []string
+}
+
+func (r DryRunResult) String() string {
+	return fmt.Sprintf("management service: %s\nmethod: %s\nnameservers: %s\nchanges:\n%s",
+		r.ManagementService, r.Method, strings.Join(r.Nameservers, " "), strings.Join(r.Changes, "\n"))
+}
+
+// dryRunner is implemented by the DNS handling methods which can describe their changes
+// without making them
+type dryRunner interface {
+	// DryRun returns the changes which Set would make, or an error if the method is not usable
+	// on the host. It must not modify the system.
+	DryRun(iface string, nameservers []string) ([]string, error)
+}
+
+// DryRun returns the DNS configuration Set would apply without making any changes to the
+// system or to the state of the setter. The nameservers are planned and the first usable method
+// is chosen, as it is done by Set. A dns_configured event marked as dry run is emitted.
+func (d *DefaultSetter) DryRun(iface string, nameservers []string) (DryRunResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	plan, err := d.planNameservers(nameservers, requestedSource)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	nameservers = plan.nameservers
+
+	etcReadOnly := d.isEtcReadOnly()
+	for _, method := range d.methods {
+		runner, ok := method.(dryRunner)
+		if !ok || (etcReadOnly && writesResolvConf(method)) ||
+			(d.networkNamespace != "" && !supportsNetworkNamespace(method)) {
+			continue
+		}
+		changes, err := dryRunInNetworkNamespace(runner, iface, d.networkNamespace, nameservers)
+		if err != nil {
+			d.logger.Debug(fmt.Errorf("dry run with %s: %w", method.Name(), err))
+			continue
+		}
+
+		service := managementServiceForMethod(method)
+		result := DryRunResult{
+			ManagementService: service.String(),
+			Method:            method.Name(),
+			Nameservers:       slices.Clone(nameservers),
+			Changes:           changes,
+		}
+		d.logger.Info("dns dry run:\n" + result.String())
+		details := d.describeConfiguration(method, iface, plan.source, connectTrigger, appliedAction)
+		details.ipv6Fallback = plan.ipv6Fallback
+		d.analytics.emitDNSConfiguredDryRunEvent(context.Background(), service, details)
+		return result, nil
+	}
+	return DryRunResult{}, fmt.Errorf("no dns setting method is available")
+}
+
+// dryRunInNetworkNamespace returns the changes the method would make in the network namespace,
+// without scoping the method to it
+func dryRunInNetworkNamespace(
+	runner dryRunner,
+	iface string,
+	namespace string,
+	nameservers []string,
+) ([]string, error) {
+	if file, ok := runner.(*ResolvConfFile); ok {
+		return file.dryRun(namespace, nameservers)
+	}
+	return runner.DryRun(iface, nameservers)
+}
+
+// commandString formats the command for the dry run result
+func commandString(name string, args ...string) string {
+	quoted := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n") {
+			arg = fmt.Sprintf("%q", arg)
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
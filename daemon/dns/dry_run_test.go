@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dryRunMethod is a recordingMethod which supports dry run
+type dryRunMethod struct {
+	recordingMethod
+	dryRunErr error
+}
+
+func (m *dryRunMethod) DryRun(iface string, nameservers []string) ([]string, error) {
+	*m.calls = append(*m.calls, "dry run "+m.name)
+	if m.dryRunErr != nil {
+		return nil, m.dryRunErr
+	}
+	return []string{m.name + " " + iface}, nil
+}
+
+func Test_DryRun(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	unavailable := &dryRunMethod{
+		recordingMethod: recordingMethod{name: "unavailable", calls: &calls},
+		dryRunErr:       errors.New("not available"),
+	}
+	available := &dryRunMethod{recordingMethod: recordingMethod{name: "available", calls: &calls}}
+	notSupported := &recordingMethod{name: "not supported", calls: &calls}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, notSupported, unavailable, available)
+
+	result, err := ds.DryRun("nordlynx", testVPNNameservers)
+	require.NoError(t, err)
+	assert.Equal(t, DryRunResult{
+		ManagementService: "unknown",
+		Method:            "available",
+		Nameservers:       testVPNNameservers,
+		Changes:           []string{"available nordlynx"},
+	}, result)
+
+	assert.Equal(t, []string{"dry run unavailable", "dry run available"}, calls, "nothing must be set")
+	assert.Nil(t, ds.active)
+	assert.Empty(t, analytics.configuredEvents)
+	assert.Equal(t, []dnsManagementService{unknownService}, analytics.dryRunEvents)
+}
+
+func Test_DryRunPlansNameserversAsSet(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	method := &dryRunMethod{recordingMethod: recordingMethod{name: "available", calls: &calls}}
+	ds := newTestSetter(&mockAnalytics{}, method)
+	ds.lookupEnv = func(key string) (string, bool) {
+		if key == envDNSServers {
+			return "192.168.1.1", true
+		}
+		return "", false
+	}
+
+	result, err := ds.DryRun("nordlynx", testVPNNameservers)
+	require.NoError(t, err)
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	assert.Equal(t, []string{"192.168.1.1"}, result.Nameservers)
+	assert.Equal(t, method.lastSet, result.Nameservers)
+}
+
+func Test_DryRunDoesNotScopeMethodToNetworkNamespace(t *testing.T) {
+	category.Set(t, category.File)
+	useTemporaryNetnsDirs(t)
+
+	file := &ResolvConfFile{logger: defaultLogger{}, clock: newFakeClock()}
+	ds := newTestSetter(&mockAnalytics{}, file)
+	require.NoError(t, ds.SetNetworkNamespace("vrf-blue"))
+
+	result, err := ds.DryRun("nordlynx", testVPNNameservers)
+	require.NoError(t, err)
+	require.Len(t, result.Changes, 1)
+	assert.True(t, strings.HasPrefix(result.Changes[0], "write "+namespaceResolvConfPath("vrf-blue")+":\n"))
+	assert.Empty(t, file.namespace)
+}
+
+func Test_DryRunInvalidNameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &dryRunMethod{recordingMethod: recordingMethod{name: "file", calls: &calls}})
+
+	_, err := ds.DryRun("nordlynx", []string{"not an address"})
+	assert.Error(t, err)
+	assert.Empty(t, calls)
+	assert.Empty(t, analytics.dryRunEvents)
+}
+
+func Test_ResolvConfFileDryRun(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	before, _ := os.ReadFile(resolvconfFilePath)
+	clock := newFakeClock()
+	changes, err := (&ResolvConfFile{logger: defaultLogger{}, clock: clock}).DryRun("nordlynx", testVPNNameservers)
+	assert.NoError(t, err)
+	header := resolvConfHeader(clock.Now(), unmanagedService)
+	assert.Equal(t, []string{"write /etc/resolv.conf:\n" + header + resolvConfBody([]byte(testVPNResolvConf))}, changes)
+	after, _ := os.ReadFile(resolvconfFilePath)
+	assert.Equal(t, before, after)
+}
+
+func Test_CommandString(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, `busctl call 3 "" "a b"`, commandString("busctl", "call", "3", "", "a b"))
+}
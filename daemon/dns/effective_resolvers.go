@@ -0,0 +1,144 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+const (
+	// ResolverSourceResolved means that the resolver was read from systemd-resolved
+	ResolverSourceResolved = "systemd-resolved"
+	// ResolverSourceResolvConf means that the resolver was read from resolv.conf
+	ResolverSourceResolvConf = "resolv.conf"
+)
+
+// EffectiveResolver is a nameserver used by the system
+type EffectiveResolver struct {
+	Address netip.Addr
+	// Source is where the resolver was read from, ResolverSourceResolved or
+	// ResolverSourceResolvConf
+	Source string
+	// LinkIndex is the index of the interface the resolver is set for, 0 for global resolvers
+	LinkIndex int
+}
+
+// EffectiveResolvers returns the nameservers used by the system, no matter if they were set by
+// NordVPN or not. systemd-resolved is queried when it manages resolv.conf, otherwise resolv.conf
+// is read. Nothing is changed, so it is safe to call at any time.
+func (d *DefaultSetter) EffectiveResolvers(ctx context.Context) ([]EffectiveResolver, error) {
+	d.mu.Lock()
+	resolved, resolvConfPath := d.resolvedMethod(), d.resolvConfPath
+	d.mu.Unlock()
+	return d.effectiveResolvers(ctx, resolved, resolvConfPath)
+}
+
+// resolvedMethod returns the systemd-resolved method, or nil if it is not used. Must be called
+// with mu locked.
+func (d *DefaultSetter) resolvedMethod() *Resolved {
+	for _, method := range d.methods {
+		if method, ok := method.(*Resolved); ok {
+			return method
+		}
+	}
+	return nil
+}
+
+// effectiveResolvers returns the nameservers used by the system, systemd-resolved is queried
+// when it manages resolv.conf and resolved is not nil
+func (d *DefaultSetter) effectiveResolvers(
+	ctx context.Context,
+	resolved *Resolved,
+	resolvConfPath string,
+) ([]EffectiveResolver, error) {
+	if resolved != nil && d.isResolvedDetected() {
+		resolvers, err := resolved.resolvers(ctx)
+		if err == nil {
+			return resolvers, nil
+		}
+		d.logger.Warn("querying systemd-resolved for resolvers, reading resolv.conf instead:", err)
+	}
+
+	content, err := internal.FileRead(resolvConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading resolv.conf: %w", err)
+	}
+	resolvers := []EffectiveResolver{}
+	for _, nameserver := range nameserversFromResolvConf(content) {
+		address, err := netip.ParseAddr(nameserver)
+		if err != nil {
+			d.logger.Debug("skipping resolv.conf nameserver:", err)
+			continue
+		}
+		resolvers = append(resolvers, EffectiveResolver{Address: address, Source: ResolverSourceResolvConf})
+	}
+	return resolvers, nil
+}
+
+// resolvers returns the global and per link nameservers of systemd-resolved
+func (m *Resolved) resolvers(ctx context.Context) ([]EffectiveResolver, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	out, err := m.busctl(ctx,
+		"get-property",
+		"org.freedesktop.resolve1",
+		"/org/freedesktop/resolve1",
+		"org.freedesktop.resolve1.Manager",
+		"DNS",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("getting dns property: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return parseResolvedDNSProperty(string(out))
+}
+
+// parseResolvedDNSProperty parses the DNS property printed by busctl, e.g.
+// "a(iiay) 2 0 2 4 1 1 1 1 3 10 16 32 1 ...", where every item is the link index, the address
+// family and the address bytes
+func parseResolvedDNSProperty(output string) ([]EffectiveResolver, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 || fields[0] != "a(iiay)" {
+		return nil, fmt.Errorf("unexpected dns property: %q", output)
+	}
+	values := make([]int, len(fields)-1)
+	for idx, field := range fields[1:] {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("parsing dns property: %w", err)
+		}
+		values[idx] = value
+	}
+
+	errMalformed := errors.New("malformed dns property")
+	count, values := values[0], values[1:]
+	resolvers := []EffectiveResolver{}
+	for range count {
+		if len(values) < 3 {
+			return nil, errMalformed
+		}
+		index, length := values[0], values[2]
+		if length < 0 || len(values) < 3+length {
+			return nil, errMalformed
+		}
+		addressBytes := make([]byte, length)
+		for idx, value := range values[3 : 3+length] {
+			addressBytes[idx] = byte(value)
+		}
+		address, ok := netip.AddrFromSlice(addressBytes)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid address length %d", errMalformed, length)
+		}
+		resolvers = append(resolvers,
+			EffectiveResolver{Address: address, Source: ResolverSourceResolved, LinkIndex: index})
+		values = values[3+length:]
+	}
+	if len(values) != 0 {
+		return nil, errMalformed
+	}
+	return resolvers, nil
+}
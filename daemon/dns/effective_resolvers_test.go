@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const resolvedDNSProperty = "a(iiay) 2 0 2 4 1 1 1 1 " +
+	"5 10 16 32 1 13 184 0 0 0 0 0 0 0 0 0 0 0 1\n"
+
+func Test_ParseResolvedDNSProperty(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	resolvers, err := parseResolvedDNSProperty(resolvedDNSProperty)
+	require.NoError(t, err)
+	assert.Equal(t, []EffectiveResolver{
+		{Address: netip.MustParseAddr("1.1.1.1"), Source: ResolverSourceResolved},
+		{Address: netip.MustParseAddr("2001:db8::1"), Source: ResolverSourceResolved, LinkIndex: 5},
+	}, resolvers)
+
+	resolvers, err = parseResolvedDNSProperty("a(iiay) 0\n")
+	assert.NoError(t, err)
+	assert.Empty(t, resolvers)
+
+	for _, output := range []string{
+		"",
+		"s \"unexpected\"",
+		"a(iiay) 1 0 2 4 1 1 1",
+		"a(iiay) 1 0 2 3 1 1 1",
+		"a(iiay) 1 0 2 4 1 1 1 1 1",
+		"a(iiay) 1 0 2 4 1 x 1 1",
+	} {
+		_, err := parseResolvedDNSProperty(output)
+		assert.Error(t, err, output)
+	}
+}
+
+func Test_EffectiveResolvers(t *testing.T) {
+	category.Set(t, category.File)
+
+	resolvConfPath := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(resolvConfPath,
+		[]byte("nameserver 127.0.0.53\nnameserver invalid\noptions edns0\n"), 0644))
+	fromResolvConf := []EffectiveResolver{
+		{Address: netip.MustParseAddr("127.0.0.53"), Source: ResolverSourceResolvConf},
+	}
+	fromResolved := []EffectiveResolver{
+		{Address: netip.MustParseAddr("1.1.1.1"), Source: ResolverSourceResolved},
+		{Address: netip.MustParseAddr("2001:db8::1"), Source: ResolverSourceResolved, LinkIndex: 5},
+	}
+
+	tests := []struct {
+		name              string
+		resolvedDetected  bool
+		resolvedErr       error
+		resolvers         []EffectiveResolver
+		resolvedQueryMade bool
+	}{
+		{
+			name:              "systemd-resolved",
+			resolvedDetected:  true,
+			resolvers:         fromResolved,
+			resolvedQueryMade: true,
+		},
+		{
+			name:              "systemd-resolved query fails",
+			resolvedDetected:  true,
+			resolvedErr:       errors.New("exit status 1"),
+			resolvers:         fromResolvConf,
+			resolvedQueryMade: true,
+		},
+		{
+			name:      "resolv.conf",
+			resolvers: fromResolvConf,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queried := false
+			resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+			resolved.busctl = func(ctx context.Context, args ...string) ([]byte, error) {
+				queried = true
+				assert.Equal(t, "get-property", args[0])
+				if test.resolvedErr != nil {
+					return nil, test.resolvedErr
+				}
+				return []byte(resolvedDNSProperty), nil
+			}
+			ds := newTestSetter(&mockAnalytics{}, resolved, &MockMethod{})
+			ds.isResolvedDetected = func() bool { return test.resolvedDetected }
+			ds.resolvConfPath = resolvConfPath
+
+			resolvers, err := ds.EffectiveResolvers(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, test.resolvers, resolvers)
+			assert.Equal(t, test.resolvedQueryMade, queried)
+		})
+	}
+}
+
+func Test_EffectiveResolversMissingResolvConf(t *testing.T) {
+	category.Set(t, category.File)
+
+	ds := newTestSetter(&mockAnalytics{}, &MockMethod{})
+	ds.resolvConfPath = filepath.Join(t.TempDir(), "resolv.conf")
+	_, err := ds.EffectiveResolvers(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"syscall"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+	"golang.org/x/sys/unix"
+)
+
+// errEtcReadOnly means that resolv.conf can't be written, because it is on a read-only mount
+var errEtcReadOnly = fmt.Errorf("resolv.conf is on a read-only file system: %w", syscall.EROFS)
+
+// isResolvConfReadOnly checks if /etc or resolv.conf itself is on a read-only mount, e.g. on
+// immutable distributions, where DNS can be configured only through systemd-resolved
+func isResolvConfReadOnly() bool {
+	return isReadOnlyMount(filepath.Dir(resolvconfFilePath)) || isReadOnlyMount(resolvconfFilePath)
+}
+
+// isReadOnlyMount checks if path is on a read-only mount
+func isReadOnlyMount(path string) bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false
+	}
+	return stat.Flags&unix.ST_RDONLY != 0
+}
+
+// checkFileWritable returns a classified error when the file can't be written. Immutable
+// attribute is not checked, because NordVPN sets it on its own resolv.conf as well.
+func checkFileWritable(path string) error {
+	if isReadOnlyMount(path) {
+		return fmt.Errorf("%s is on a read-only file system: %w", path, syscall.EROFS)
+	}
+	if !internal.FileWritable(path) {
+		return fmt.Errorf("%s is not writable: %w", path, fs.ErrPermission)
+	}
+	return nil
+}
+
+// writesResolvConf checks if the method writes resolv.conf directly, so it always fails when
+// resolv.conf is on a read-only mount
+func writesResolvConf(method Method) bool {
+	return managementServiceForMethod(method) == unmanagedService
+}
@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileMethod is a DNS handling method which writes resolv.conf directly
+type fileMethod struct {
+	recordingMethod
+}
+
+func (m *fileMethod) managementService() dnsManagementService {
+	return unmanagedService
+}
+
+func Test_SetBypassesResolvConfFileOnReadOnlyEtc(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls}}
+	resolved := &fakeBackend{service: systemdResolvedService}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, file, resolved)
+	ds.isEtcReadOnly = func() bool { return true }
+
+	result, err := ds.SetWithResult("lo", []string{"103.86.96.100"})
+	require.NoError(t, err)
+
+	assert.Empty(t, calls, "resolv.conf should not be written")
+	assert.Equal(t, systemdResolvedService.String(), result.ManagementService)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.True(t, analytics.configuredEvents[0].etcReadOnly)
+}
+
+func Test_SetOnReadOnlyEtcWithoutResolved(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls}}
+	resolved := &fakeBackend{MockMethod: MockMethod{err: errors.New("not available")}, service: systemdResolvedService}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, resolved, file)
+	ds.isEtcReadOnly = func() bool { return true }
+
+	assert.Error(t, ds.Set("lo", []string{"103.86.96.100"}))
+
+	assert.Empty(t, calls, "resolv.conf should not be written")
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: readOnlyFilesystemErrorType, critical: true}},
+		analytics.getErrorEvents())
+}
+
+func Test_SetWritesResolvConfOnWritableEtc(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, file)
+
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.100"}))
+
+	assert.Equal(t, []string{"set file"}, calls)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.False(t, analytics.configuredEvents[0].etcReadOnly)
+}
+
+func Test_CheckFileWritable(t *testing.T) {
+	category.Set(t, category.File)
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte("nameserver 1.1.1.1\n"), 0644))
+	assert.NoError(t, checkFileWritable(path))
+
+	require.NoError(t, os.Chmod(path, 0444))
+	err := checkFileWritable(path)
+	assert.Error(t, err)
+	assert.Equal(t, permissionDeniedErrorType, errorTypeFromError(err))
+}
+
+func Test_SetFailsOnNotWrittenResolvConf(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name      string
+		err       error
+		errorType errorType
+	}{
+		{
+			name:      "immutable",
+			err:       newDNSError(errResolvConfImmutable, unmanagedService),
+			errorType: fileImmutableErrorType,
+		},
+		{
+			name:      "permission denied",
+			err:       fmt.Errorf("resolv.conf is not writable: %w", fs.ErrPermission),
+			errorType: permissionDeniedErrorType,
+		},
+		{
+			name:      "read only file system",
+			err:       fmt.Errorf("resolv.conf is on a read-only file system: %w", syscall.EROFS),
+			errorType: readOnlyFilesystemErrorType,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			file := &fileMethod{recordingMethod{name: "file", calls: &calls, setErr: test.err}}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, file)
+
+			err := ds.Set("lo", []string{"103.86.96.100"})
+			var dnsErr *DNSError
+			require.ErrorAs(t, err, &dnsErr)
+			assert.Equal(t, test.errorType, dnsErr.Type)
+			assert.Empty(t, analytics.configuredEvents)
+			assert.Equal(t,
+				[]mockErrorEvent{{errorType: test.errorType, critical: true}},
+				analytics.getErrorEvents())
+		})
+	}
+}
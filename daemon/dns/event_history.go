@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// eventHistorySize is the number of the most recent events kept for diagnostics
+const eventHistorySize = 64
+
+// EventRecord describes a DNS event kept in memory for diagnostics
+type EventRecord struct {
+	Type              string    `json:"type"`
+	ManagementService string    `json:"management_service"`
+	ErrorType         string    `json:"error_type,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// eventHistory is a ring buffer of the most recent events. It is filled independently of the
+// analytics publisher, so it is available even when analytics are disabled.
+type eventHistory struct {
+	records []EventRecord
+	// payloads are the JSON payloads of the published events, in the same order as records
+	payloads []string
+	// next is the index the next record is written to
+	next int
+	// full is set once the oldest records start being overwritten
+	full bool
+	mu   sync.Mutex
+}
+
+func newEventHistory(size int) *eventHistory {
+	return &eventHistory{records: make([]EventRecord, size), payloads: make([]string, size)}
+}
+
+// add records the event together with its JSON payload, evicting the oldest one if the history
+// is full. Payload is empty when the event could not be serialized.
+func (h *eventHistory) add(record EventRecord, payload string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) == 0 {
+		return
+	}
+	h.records[h.next] = record
+	h.payloads[h.next] = payload
+	h.next = (h.next + 1) % len(h.records)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// snapshot returns a copy of the recorded events, from the oldest to the newest
+func (h *eventHistory) snapshot() []EventRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]EventRecord{}, h.records[:h.next]...)
+	}
+	return append(append([]EventRecord{}, h.records[h.next:]...), h.records[:h.next]...)
+}
+
+// dump writes the payloads of the recorded events as newline-delimited JSON, from the oldest to
+// the newest. Events without payload are skipped.
+func (h *eventHistory) dump(w io.Writer) error {
+	h.mu.Lock()
+	payloads := append([]string{}, h.payloads[:h.next]...)
+	if h.full {
+		payloads = append(append([]string{}, h.payloads[h.next:]...), payloads...)
+	}
+	h.mu.Unlock()
+
+	for _, payload := range payloads {
+		if payload == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, payload+"\n"); err != nil {
+			return fmt.Errorf("writing dns event: %w", err)
+		}
+	}
+	return nil
+}
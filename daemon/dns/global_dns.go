@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+const (
+	// resolvedConfPath is the main configuration file of systemd-resolved
+	resolvedConfPath = "/etc/systemd/resolved.conf"
+	// resolvedConfDropInDir contains the drop-in files overriding resolvedConfPath
+	resolvedConfDropInDir = "/etc/systemd/resolved.conf.d"
+)
+
+// globalResolvedNameservers returns the global nameservers configured for systemd-resolved
+// with DNS= in resolved.conf and its drop-ins
+func globalResolvedNameservers() []string {
+	return resolvedConfNameservers(resolvedConfPath, resolvedConfDropInDir)
+}
+
+// resolvedConfNameservers reads the DNS= settings of the [Resolve] section from the
+// configuration file and then from the drop-ins in the lexical order, like systemd-resolved
+// does. Missing files are skipped.
+func resolvedConfNameservers(path string, dropInDir string) []string {
+	files := []string{path}
+	// Glob returns the files sorted
+	dropIns, _ := filepath.Glob(filepath.Join(dropInDir, "*.conf"))
+	files = append(files, dropIns...)
+
+	nameservers := []string{}
+	for _, file := range files {
+		content, err := internal.FileRead(file)
+		if err != nil {
+			continue
+		}
+		nameservers = parseResolvedConfDNS(string(content), nameservers)
+	}
+	return nameservers
+}
+
+// parseResolvedConfDNS appends the nameservers of DNS= lines in the content to nameservers.
+// Empty DNS= resets the list set so far.
+func parseResolvedConfDNS(content string, nameservers []string) []string {
+	section := ""
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found || section != "[Resolve]" || strings.TrimSpace(key) != "DNS" {
+			continue
+		}
+		servers := strings.Fields(value)
+		if len(servers) == 0 {
+			nameservers = []string{}
+			continue
+		}
+		nameservers = append(nameservers, servers...)
+	}
+	return nameservers
+}
+
+// checkGlobalDNSConflict reports the global nameservers of systemd-resolved, because they can
+// be used instead of the nameservers set for the link, which leaks DNS queries
+func (d *DefaultSetter) checkGlobalDNSConflict() {
+	nameservers := d.globalDNS()
+	if len(nameservers) == 0 {
+		return
+	}
+	d.logger.Warn("systemd-resolved has global DNS servers configured in resolved.conf, they can be "+
+		"queried instead of the VPN nameservers, consider setting them per link instead:",
+		strings.Join(nameservers, " "))
+	d.analytics.emitGlobalDNSConflictEvent(context.Background(), len(nameservers))
+}
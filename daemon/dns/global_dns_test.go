@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testResolvedConf = `#  This file is part of systemd.
+[Resolve]
+# Some examples of DNS servers which may be used for DNS= and FallbackDNS=:
+DNS=1.1.1.1#cloudflare-dns.com 8.8.8.8
+FallbackDNS=9.9.9.9
+#DNS=8.8.4.4
+`
+
+func Test_ResolvedConfNameservers(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		name        string
+		conf        string
+		dropIns     map[string]string
+		nameservers []string
+	}{
+		{
+			name:        "not configured",
+			conf:        "[Resolve]\n#DNS=\nFallbackDNS=9.9.9.9\n",
+			nameservers: []string{},
+		},
+		{
+			name:        "global dns",
+			conf:        testResolvedConf,
+			nameservers: []string{"1.1.1.1#cloudflare-dns.com", "8.8.8.8"},
+		},
+		{
+			name:        "other section",
+			conf:        "[Other]\nDNS=1.1.1.1\n",
+			nameservers: []string{},
+		},
+		{
+			name: "drop-ins",
+			conf: testResolvedConf,
+			dropIns: map[string]string{
+				"10-reset.conf":  "[Resolve]\nDNS=\n",
+				"20-append.conf": "[Resolve]\nDNS=192.168.1.1\n",
+			},
+			nameservers: []string{"192.168.1.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "resolved.conf")
+			require.NoError(t, os.WriteFile(path, []byte(test.conf), 0644))
+			dropInDir := filepath.Join(dir, "resolved.conf.d")
+			require.NoError(t, os.Mkdir(dropInDir, 0755))
+			for name, content := range test.dropIns {
+				require.NoError(t, os.WriteFile(filepath.Join(dropInDir, name), []byte(content), 0644))
+			}
+
+			assert.Equal(t, test.nameservers, resolvedConfNameservers(path, dropInDir))
+		})
+	}
+	assert.Empty(t, resolvedConfNameservers(filepath.Join(t.TempDir(), "missing"), ""))
+}
+
+func Test_SetWithGlobalDNSConflict(t *testing.T) {
+	category.Set(t, category.File)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "resolved.conf")
+	require.NoError(t, os.WriteFile(path, []byte(testResolvedConf), 0644))
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.busctl = (&mockBusctl{}).run
+	ds := newTestSetter(analytics, resolved)
+	ds.globalDNS = func() []string { return resolvedConfNameservers(path, filepath.Join(dir, "resolved.conf.d")) }
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: globalDNSConflictErrorType, globalResolvers: 2}},
+		analytics.getErrorEvents())
+
+	// global nameservers do not matter when resolv.conf is written directly
+	analytics = &mockAnalytics{}
+	calls := []string{}
+	ds = newTestSetter(analytics, &fileMethod{recordingMethod{name: "file", calls: &calls}})
+	ds.globalDNS = func() []string { return resolvedConfNameservers(path, filepath.Join(dir, "resolved.conf.d")) }
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Empty(t, analytics.getErrorEvents())
+}
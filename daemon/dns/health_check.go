@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// healthCheckTimeout limits the health check, so that it never holds up its caller for long
+	healthCheckTimeout = 2 * time.Second
+	// healthCheckFailureThreshold is the number of consecutive failed health checks after which
+	// the failure is reported in analytics
+	healthCheckFailureThreshold = 3
+)
+
+var (
+	// ErrHealthCheckTimeout is returned by the health check when DNS did not respond in time
+	ErrHealthCheckTimeout = errors.New("dns query timed out")
+	// ErrHealthCheckNXDomain is returned by the health check when the queried domain was not
+	// found, which means that DNS responds, but its answers can't be trusted
+	ErrHealthCheckNXDomain = errors.New("dns query returned nxdomain")
+)
+
+// hostLookup resolves hostnames using the system DNS configuration
+type hostLookup interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+func (systemResolverLookup) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// HealthCheck checks if DNS is responding by resolving the canary domain through the configured
+// resolvers. Returns nil on success, an error wrapping ErrHealthCheckTimeout or
+// ErrHealthCheckNXDomain for those outcomes, or other lookup errors. A non-critical
+// health_check_failed error event is emitted when the check fails a few times in a row. When
+// DNSSEC validation is enforced and the domain resolves with checking disabled, failed
+// validation is returned as ErrHealthCheckDNSSEC and is not counted as a failure, because DNS
+// responds.
+func (d *DefaultSetter) HealthCheck(ctx context.Context) error {
+	lookupCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	_, err := d.hostLookup.LookupHost(lookupCtx, d.canaryDomain)
+	err = classifyHealthCheckError(lookupCtx, d.canaryDomain, err)
+
+	d.mu.Lock()
+	enforced := isDNSSECEnforced(d.active)
+	d.mu.Unlock()
+	// the retry is made without holding the lock, because it queries the network
+	dnssecFailure := err != nil && enforced && d.isDNSSECValidationFailure(ctx, d.canaryDomain, err)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		d.healthCheckFailures = 0
+		return nil
+	}
+	if dnssecFailure {
+		d.logger.Info("dns health check answer failed DNSSEC validation:", err)
+		return fmt.Errorf("%w: %w", ErrHealthCheckDNSSEC, err)
+	}
+	d.healthCheckFailures++
+	if d.healthCheckFailures == healthCheckFailureThreshold {
+		d.logger.Warn("dns health check failed", d.healthCheckFailures, "times in a row:", err)
+		d.analytics.emitDNSConfigurationErrorEvent(ctx, healthCheckFailedErrorType, severityByType)
+	}
+	return err
+}
+
+// classifyHealthCheckError wraps the lookup error with the health check outcome
+func classifyHealthCheckError(ctx context.Context, domain string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return fmt.Errorf("%w: %w", ErrHealthCheckNXDomain, err)
+	case errors.As(err, &dnsErr) && dnsErr.IsTimeout,
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrHealthCheckTimeout, err)
+	default:
+		return fmt.Errorf("resolving %s: %w", domain, err)
+	}
+}
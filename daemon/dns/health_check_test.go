@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHostLookup struct {
+	err error
+	// block makes the lookup wait until the context is done
+	block bool
+	// hosts records the looked up hosts when not nil
+	hosts *[]string
+}
+
+func (f fakeHostLookup) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if f.hosts != nil {
+		*f.hosts = append(*f.hosts, host)
+	}
+	if f.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []string{"104.16.208.203"}, nil
+}
+
+func Test_HealthCheck(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name   string
+		lookup fakeHostLookup
+		err    error
+	}{
+		{
+			name: "dns responds",
+		},
+		{
+			name:   "nxdomain",
+			lookup: fakeHostLookup{err: &net.DNSError{Err: "no such host", Name: defaultCanaryDomain, IsNotFound: true}},
+			err:    ErrHealthCheckNXDomain,
+		},
+		{
+			name:   "resolver timeout",
+			lookup: fakeHostLookup{err: &net.DNSError{Err: "i/o timeout", Name: defaultCanaryDomain, IsTimeout: true}},
+			err:    ErrHealthCheckTimeout,
+		},
+		{
+			name:   "no response before the deadline",
+			lookup: fakeHostLookup{block: true},
+			err:    ErrHealthCheckTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := newTestSetter(&mockAnalytics{})
+			ds.hostLookup = test.lookup
+			// deadline of the caller is respected
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+
+			err := ds.HealthCheck(ctx)
+			if test.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, test.err)
+		})
+	}
+}
+
+func Test_HealthCheckOtherError(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	lookupErr := errors.New("network is unreachable")
+	ds := newTestSetter(&mockAnalytics{})
+	ds.hostLookup = fakeHostLookup{err: lookupErr}
+
+	err := ds.HealthCheck(context.Background())
+	assert.ErrorIs(t, err, lookupErr)
+	assert.NotErrorIs(t, err, ErrHealthCheckTimeout)
+	assert.NotErrorIs(t, err, ErrHealthCheckNXDomain)
+}
+
+func Test_HealthCheckCanceled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ds := newTestSetter(&mockAnalytics{})
+	ds.hostLookup = fakeHostLookup{block: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ds.HealthCheck(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NotErrorIs(t, err, ErrHealthCheckTimeout)
+}
+
+func Test_HealthCheckReportsRepeatedFailures(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics)
+	failing := fakeHostLookup{err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}}
+
+	ds.hostLookup = failing
+	for i := 0; i < healthCheckFailureThreshold-1; i++ {
+		assert.Error(t, ds.HealthCheck(context.Background()))
+	}
+	// success resets the consecutive failures
+	ds.hostLookup = fakeHostLookup{}
+	assert.NoError(t, ds.HealthCheck(context.Background()))
+	ds.hostLookup = failing
+	for i := 0; i < healthCheckFailureThreshold-1; i++ {
+		assert.Error(t, ds.HealthCheck(context.Background()))
+	}
+	assert.Empty(t, analytics.getErrorEvents())
+
+	// failure is reported once per series of failures
+	for i := 0; i < healthCheckFailureThreshold; i++ {
+		assert.Error(t, ds.HealthCheck(context.Background()))
+	}
+	assert.Equal(t, []mockErrorEvent{{errorType: healthCheckFailedErrorType, critical: false}},
+		analytics.getErrorEvents())
+}
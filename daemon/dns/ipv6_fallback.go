@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ipv6ProbeTimeout is the default limit of the probe of an IPv6 nameserver, so that setting DNS is
+// not held up for long on the hosts where IPv6 is enabled, but does not work
+const ipv6ProbeTimeout = 500 * time.Millisecond
+
+// isIPv6NameserverReachable queries the nameserver at address for the domain within the timeout. Hosts which
+// advertise IPv6 without a working IPv6 path make the queries time out or fail with no route,
+// then the IPv6 nameservers only slow down every lookup.
+func isIPv6NameserverReachable(address string, domain string, timeout time.Duration) bool {
+	name, err := dnsmessage.NewName(domain + ".")
+	if err != nil {
+		// nothing to probe with, IPv6 nameservers are not skipped because of it
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = queryNameserver(ctx, address, name, QueryTypeAAAA)
+	return !isResolverUnreachable(err)
+}
+
+// ipv6FallbackNameservers returns only the IPv4 nameservers when the first of the IPv6
+// nameservers is unreachable, otherwise all of the nameservers are returned. fallback is true
+// when the IPv6 nameservers were left out.
+func (d *DefaultSetter) ipv6FallbackNameservers(
+	nameservers []string,
+	ipv4Nameservers []string,
+) (usable []string, fallback bool) {
+	ipv6Nameservers := filterIPv6(nameservers)
+	if len(ipv4Nameservers) == 0 || len(ipv6Nameservers) == 0 {
+		return nameservers, false
+	}
+	if d.isIPv6Reachable(net.JoinHostPort(ipv6Nameservers[0], d.dnsPort), d.canaryDomain) {
+		return nameservers, false
+	}
+	d.logger.Warn("IPv6 nameservers are unreachable, setting only IPv4 nameservers")
+	return ipv4Nameservers, true
+}
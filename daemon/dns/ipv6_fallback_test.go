@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func Test_SetFallsBackToIPv4WhenIPv6IsUnreachable(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	nameservers := []string{"103.86.96.100", "2001:db8::1", "103.86.99.100"}
+	tests := []struct {
+		name          string
+		ipv6Reachable bool
+		set           []string
+		ipv6Fallback  bool
+		probedAddress string
+	}{
+		{
+			name:          "ipv6 reachable",
+			ipv6Reachable: true,
+			set:           nameservers,
+			probedAddress: "[2001:db8::1]:53",
+		},
+		{
+			name:          "ipv6 unreachable",
+			set:           []string{"103.86.96.100", "103.86.99.100"},
+			ipv6Fallback:  true,
+			probedAddress: "[2001:db8::1]:53",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			method := &recordingMethod{name: "method", calls: &calls}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, method)
+			ds.dnsPort = defaultDNSPort
+			probed := []string{}
+			ds.isIPv6Reachable = func(address string, domain string) bool {
+				probed = append(probed, address)
+				assert.Equal(t, defaultCanaryDomain, domain)
+				return test.ipv6Reachable
+			}
+
+			require.NoError(t, ds.Set("lo", nameservers))
+
+			assert.Equal(t, test.set, method.lastSet)
+			assert.Equal(t, []string{test.probedAddress}, probed)
+			require.Len(t, analytics.configuredEvents, 1)
+			assert.Equal(t, test.ipv6Fallback, analytics.configuredEvents[0].ipv6Fallback)
+		})
+	}
+}
+
+func Test_SetDoesNotProbeWithoutIPv6Nameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &MockMethod{})
+	ds.isIPv6Reachable = func(string, string) bool {
+		t.Error("ipv6 nameserver was probed")
+		return false
+	}
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.100"}))
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.False(t, analytics.configuredEvents[0].ipv6Fallback)
+}
+
+func Test_IsIPv6NameserverReachable(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	// the probe is the same for both of the address families
+	server := newMockDNSServer(t, rcode(dnsmessage.RCodeNameError))
+	assert.True(t, isIPv6NameserverReachable(net.JoinHostPort("127.0.0.1", server.port()), defaultCanaryDomain, ipv6ProbeTimeout))
+
+	silent := newMockDNSServer(t, nil)
+	assert.False(t, isIPv6NameserverReachable(net.JoinHostPort("127.0.0.1", silent.port()), defaultCanaryDomain, ipv6ProbeTimeout))
+	// nothing listens on 127.0.0.2
+	assert.False(t, isIPv6NameserverReachable(net.JoinHostPort("127.0.0.2", server.port()), defaultCanaryDomain, ipv6ProbeTimeout))
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// leakCheckTimeout limits the leak check, so that it never holds up connecting for long
+	leakCheckTimeout = 3 * time.Second
+	// envLeakCheckHostname sets the hostname resolved by the leak check. It must resolve to the
+	// address of the resolver which queried it, like whoami services do, so the canary domain
+	// can't be used for it.
+	envLeakCheckHostname = "NORDVPN_DNS_LEAK_CHECK_HOSTNAME"
+)
+
+// errLeakCheckDisabled means that the leak check has no hostname to resolve
+var errLeakCheckDisabled = errors.New("leak check hostname is not configured")
+
+// leakCheckHostnameFromEnv returns the hostname from envLeakCheckHostname, or empty string if it is
+// not set or it is malformed
+func leakCheckHostnameFromEnv(lookupEnv func(key string) (string, bool), logger Logger) string {
+	value, ok := lookupEnv(envLeakCheckHostname)
+	if !ok || strings.TrimSpace(value) == "" {
+		return ""
+	}
+	hostname, err := validateCanaryDomain(strings.TrimSpace(value))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("ignoring %s:", envLeakCheckHostname), err)
+		return ""
+	}
+	return hostname
+}
+
+// answeringResolverLookup finds out which resolver handles DNS queries of the system
+type answeringResolverLookup interface {
+	// LookupAnsweringResolver resolves the hostname, which must resolve to the addresses of the
+	// resolver which queried it
+	LookupAnsweringResolver(ctx context.Context, hostname string) ([]netip.Addr, error)
+}
+
+// systemResolverLookup resolves the hostname using the system DNS configuration
+type systemResolverLookup struct{}
+
+func (systemResolverLookup) LookupAnsweringResolver(ctx context.Context, hostname string) ([]netip.Addr, error) {
+	return net.DefaultResolver.LookupNetIP(ctx, "ip", hostname)
+}
+
+// LeakChecker is implemented by the setters which check if DNS queries go through the
+// nameservers they set
+type LeakChecker interface {
+	// IsLeakCheckEnabled checks if CheckDNSLeak has a hostname to resolve
+	IsLeakCheckEnabled() bool
+	CheckDNSLeak(ctx context.Context) (bool, error)
+}
+
+// IsLeakCheckEnabled checks if the leak check hostname is configured
+func (d *DefaultSetter) IsLeakCheckEnabled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.leakCheckHostname != ""
+}
+
+// CheckDNSLeak checks if DNS queries are handled by the nameservers set by NordVPN, including
+// the ones overriding or added to the requested nameservers. The leak check hostname is resolved,
+// it must resolve to the address of the resolver which queried it. A critical leak_detected error
+// event is emitted if queries are handled by another resolver. Returns true if a leak was
+// detected.
+func (d *DefaultSetter) CheckDNSLeak(ctx context.Context) (bool, error) {
+	d.mu.Lock()
+	expected := slices.Clone(d.applied)
+	hostname := d.leakCheckHostname
+	d.mu.Unlock()
+	if hostname == "" {
+		return false, errLeakCheckDisabled
+	}
+	if len(expected) == 0 {
+		return false, errors.New("dns is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, leakCheckTimeout)
+	defer cancel()
+	answering, err := d.resolverLookup.LookupAnsweringResolver(ctx, hostname)
+	if err != nil {
+		return false, fmt.Errorf("looking up %s: %w", hostname, err)
+	}
+
+	for _, address := range answering {
+		for _, nameserver := range expected {
+			if address.Unmap() == netip.MustParseAddr(nameserver).Unmap() {
+				return false, nil
+			}
+		}
+	}
+	d.logger.Warn("DNS leak detected, queries are handled by", answering, "instead of", expected)
+	d.analytics.emitDNSConfigurationErrorEvent(ctx, leakDetectedErrorType, severityByType)
+	return true, nil
+}
@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLeakCheckHostname is resolved by the leak check of the test setters
+const testLeakCheckHostname = "whoami.example.com"
+
+type fakeResolverLookup struct {
+	answer []netip.Addr
+	// block makes the lookup wait until the context is done
+	block bool
+	// hostnames records the looked up hostnames when not nil
+	hostnames *[]string
+}
+
+func (f fakeResolverLookup) LookupAnsweringResolver(ctx context.Context, hostname string) ([]netip.Addr, error) {
+	if f.hostnames != nil {
+		*f.hostnames = append(*f.hostnames, hostname)
+	}
+	if f.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return f.answer, nil
+}
+
+func Test_CheckDNSLeak(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name        string
+		lookup      fakeResolverLookup
+		leak        bool
+		isErr       bool
+		errorEvents []mockErrorEvent
+	}{
+		{
+			name:   "answered by vpn resolver",
+			lookup: fakeResolverLookup{answer: []netip.Addr{netip.MustParseAddr("103.86.96.100")}},
+		},
+		{
+			name:        "answered by other resolver",
+			lookup:      fakeResolverLookup{answer: []netip.Addr{netip.MustParseAddr("192.168.1.1")}},
+			leak:        true,
+			errorEvents: []mockErrorEvent{{errorType: leakDetectedErrorType, critical: true}},
+		},
+		{
+			name:   "lookup cancelled",
+			lookup: fakeResolverLookup{block: true},
+			isErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, &MockMethod{})
+			ds.resolverLookup = test.lookup
+			require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if test.lookup.block {
+				cancel()
+			}
+			defer cancel()
+
+			leak, err := ds.CheckDNSLeak(ctx)
+			assert.Equal(t, test.isErr, err != nil)
+			assert.Equal(t, test.leak, leak)
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+		})
+	}
+}
+
+func Test_CheckDNSLeakEffectiveNameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name   string
+		setup  func(ds *DefaultSetter)
+		answer string
+	}{
+		{
+			name: "env override",
+			setup: func(ds *DefaultSetter) {
+				ds.lookupEnv = func(key string) (string, bool) {
+					if key == envDNSServers {
+						return "9.9.9.9", true
+					}
+					return "", false
+				}
+			},
+			answer: "9.9.9.9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, &MockMethod{})
+			ds.resolverLookup = fakeResolverLookup{answer: []netip.Addr{netip.MustParseAddr(test.answer)}}
+			test.setup(ds)
+			require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+
+			leak, err := ds.CheckDNSLeak(context.Background())
+			assert.NoError(t, err)
+			assert.False(t, leak)
+			assert.Empty(t, analytics.getErrorEvents())
+		})
+	}
+}
+
+func Test_CheckDNSLeakWithoutDNS(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ds := newTestSetter(&mockAnalytics{}, &MockMethod{})
+	ds.resolverLookup = fakeResolverLookup{}
+	_, err := ds.CheckDNSLeak(context.Background())
+	assert.Error(t, err)
+}
+
+func Test_CheckDNSLeakResolvesLeakCheckHostname(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &MockMethod{})
+	hostnames := []string{}
+	ds.resolverLookup = fakeResolverLookup{
+		answer:    []netip.Addr{netip.MustParseAddr(testVPNNameservers[0])},
+		hostnames: &hostnames,
+	}
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+
+	assert.True(t, ds.IsLeakCheckEnabled())
+	leak, err := ds.CheckDNSLeak(context.Background())
+	require.NoError(t, err)
+	assert.False(t, leak)
+	assert.Equal(t, []string{testLeakCheckHostname}, hostnames)
+}
+
+func Test_CheckDNSLeakDisabled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &MockMethod{})
+	ds.leakCheckHostname = ""
+	hostnames := []string{}
+	ds.resolverLookup = fakeResolverLookup{hostnames: &hostnames}
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+
+	assert.False(t, ds.IsLeakCheckEnabled())
+	leak, err := ds.CheckDNSLeak(context.Background())
+	assert.ErrorIs(t, err, errLeakCheckDisabled)
+	assert.False(t, leak)
+	assert.Empty(t, hostnames)
+	assert.Empty(t, analytics.getErrorEvents(), "no leak must be reported without the leak check hostname")
+}
+
+func Test_LeakCheckHostnameFromEnv(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name     string
+		value    string
+		set      bool
+		expected string
+	}{
+		{name: "not set"},
+		{name: "empty", value: " ", set: true},
+		{name: "valid", value: "Whoami.Example.com.", set: true, expected: "whoami.example.com"},
+		{name: "single label", value: "whoami", set: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookupEnv := func(key string) (string, bool) {
+				if key == envLeakCheckHostname {
+					return test.value, test.set
+				}
+				return "", false
+			}
+			assert.Equal(t, test.expected, leakCheckHostnameFromEnv(lookupEnv, defaultLogger{}))
+		})
+	}
+}
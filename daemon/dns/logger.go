@@ -0,0 +1,28 @@
+package dns
+
+import (
+	"log"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// Logger is used to log messages of the DNS package, so that its verbosity can be controlled
+// separately from the rest of the daemon.
+type Logger interface {
+	Debug(v ...any)
+	Info(v ...any)
+	Warn(v ...any)
+	Error(v ...any)
+}
+
+// defaultLogger logs all of the messages to the standard logger, marking them with a level prefix
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(v ...any) { logWithPrefix(internal.DebugPrefix, v) }
+func (defaultLogger) Info(v ...any)  { logWithPrefix(internal.InfoPrefix, v) }
+func (defaultLogger) Warn(v ...any)  { logWithPrefix(internal.WarningPrefix, v) }
+func (defaultLogger) Error(v ...any) { logWithPrefix(internal.ErrorPrefix, v) }
+
+func logWithPrefix(levelPrefix string, v []any) {
+	log.Println(append([]any{levelPrefix, dnsPrefix}, v...)...)
+}
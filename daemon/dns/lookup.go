@@ -0,0 +1,217 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// lookupTimeout limits the whole lookup, including the retries with the other nameservers
+	lookupTimeout = 5 * time.Second
+	// lookupNameserverTimeout limits the query sent to a single nameserver, so that an
+	// unresponsive nameserver does not use up the whole lookup timeout
+	lookupNameserverTimeout = 2 * time.Second
+	defaultDNSPort          = "53"
+	// maxUDPMessageSize is the maximum size of DNS messages sent over UDP without EDNS0
+	maxUDPMessageSize = 512
+)
+
+var (
+	// ErrLookupTimeout is returned by Lookup when none of the nameservers responded in time
+	ErrLookupTimeout = errors.New("dns query timed out")
+	// ErrLookupNXDomain is returned by Lookup when the queried domain does not exist
+	ErrLookupNXDomain = errors.New("dns query returned nxdomain")
+	// ErrLookupServFail is returned by Lookup when the nameservers failed to resolve the domain
+	ErrLookupServFail = errors.New("dns query returned servfail")
+)
+
+// QueryType is the type of the addresses resolved by Lookup
+type QueryType int
+
+const (
+	// QueryTypeA resolves IPv4 addresses
+	QueryTypeA QueryType = iota
+	// QueryTypeAAAA resolves IPv6 addresses
+	QueryTypeAAAA
+)
+
+func (q QueryType) String() string {
+	switch q {
+	case QueryTypeA:
+		return "A"
+	case QueryTypeAAAA:
+		return "AAAA"
+	default:
+		return fmt.Sprintf("%d", int(q))
+	}
+}
+
+func (q QueryType) recordType() dnsmessage.Type {
+	if q == QueryTypeAAAA {
+		return dnsmessage.TypeAAAA
+	}
+	return dnsmessage.TypeA
+}
+
+// Lookup resolves the host with the nameservers set by NordVPN, bypassing the system resolver,
+// so that the result does not depend on what the system DNS configuration currently points
+// to. Nameservers are queried in order until one of them answers. Returns an error wrapping
+// ErrLookupTimeout, ErrLookupNXDomain or ErrLookupServFail for those outcomes.
+func (d *DefaultSetter) Lookup(ctx context.Context, host string, qtype QueryType) ([]netip.Addr, error) {
+	d.mu.Lock()
+	nameservers := slices.Clone(d.applied)
+	port := d.dnsPort
+	d.mu.Unlock()
+	if len(nameservers) == 0 {
+		return nil, errors.New("dns is not set")
+	}
+
+	// search domains of the system must not be appended to the host
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host %q: %w", host, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	var lastErr error
+	for _, nameserver := range nameservers {
+		addresses, err := queryNameserver(ctx, net.JoinHostPort(nameserver, port), name, qtype)
+		switch {
+		case err == nil:
+			return addresses, nil
+		case errors.Is(err, ErrLookupNXDomain), ctx.Err() != nil:
+			// the other nameservers would not give a different answer or there is no time left
+			return nil, err
+		}
+		d.logger.Debug(fmt.Sprintf("looking up %s with %s:", host, nameserver), err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// queryNameserver sends a single query over UDP to the nameserver at address
+func queryNameserver(
+	ctx context.Context,
+	address string,
+	name dnsmessage.Name,
+	qtype QueryType,
+) ([]netip.Addr, error) {
+	return exchangeQuery(ctx, address, name, qtype, false)
+}
+
+// queryNameserverCheckingDisabled sends a single query over UDP to the nameserver at address with
+// the CD bit set, so that the answer is not rejected when it fails DNSSEC validation
+func queryNameserverCheckingDisabled(
+	ctx context.Context,
+	address string,
+	name dnsmessage.Name,
+	qtype QueryType,
+) ([]netip.Addr, error) {
+	return exchangeQuery(ctx, address, name, qtype, true)
+}
+
+// exchangeQuery sends the query and waits for the response to it
+func exchangeQuery(
+	ctx context.Context,
+	address string,
+	name dnsmessage.Name,
+	qtype QueryType,
+	checkingDisabled bool,
+) ([]netip.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupNameserverTimeout)
+	defer cancel()
+
+	id := uint16(rand.Uint32())
+	query, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true, CheckingDisabled: checkingDisabled},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype.recordType(), Class: dnsmessage.ClassINET},
+		},
+	}).Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing query: %w", err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", address, err)
+	}
+	defer conn.Close()
+	// reading is interrupted when the context is done
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("sending query to %s: %w", address, lookupError(ctx, err))
+	}
+	buf := make([]byte, maxUDPMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("reading response from %s: %w", address, lookupError(ctx, err))
+		}
+		var response dnsmessage.Message
+		if err := response.Unpack(buf[:n]); err != nil || !response.Response || response.ID != id {
+			// not a response to this query, wait for the right one
+			continue
+		}
+		return responseAddresses(response, qtype)
+	}
+}
+
+// lookupError wraps the error with ErrLookupTimeout when the query timed out
+func lookupError(ctx context.Context, err error) error {
+	var netErr net.Error
+	if ctx.Err() != nil || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrLookupTimeout, err)
+	}
+	return err
+}
+
+// responseAddresses returns the addresses of the requested type from the response
+func responseAddresses(response dnsmessage.Message, qtype QueryType) ([]netip.Addr, error) {
+	switch response.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, ErrLookupNXDomain
+	case dnsmessage.RCodeServerFailure:
+		return nil, ErrLookupServFail
+	default:
+		return nil, fmt.Errorf("dns query returned %s", response.RCode)
+	}
+
+	addresses := []netip.Addr{}
+	for _, answer := range response.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			if qtype == QueryTypeA {
+				addresses = append(addresses, netip.AddrFrom4(body.A))
+			}
+		case *dnsmessage.AAAAResource:
+			if qtype == QueryTypeAAAA {
+				addresses = append(addresses, netip.AddrFrom16(body.AAAA))
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		if response.Truncated {
+			return nil, errors.New("dns response truncated")
+		}
+		return nil, fmt.Errorf("no %s records found", qtype)
+	}
+	return addresses, nil
+}
@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mockDNSServer answers DNS queries over UDP on a random local port. Queries are not answered
+// when rcode is nil.
+type mockDNSServer struct {
+	conn    net.PacketConn
+	rcode   *dnsmessage.RCode
+	answers []netip.Addr
+}
+
+func newMockDNSServer(t *testing.T, rcode *dnsmessage.RCode, answers ...netip.Addr) *mockDNSServer {
+	t.Helper()
+	return newMockDNSServerAt(t, "127.0.0.1:0", rcode, answers...)
+}
+
+// newMockDNSServerAt starts the server listening on address
+func newMockDNSServerAt(t *testing.T, address string, rcode *dnsmessage.RCode, answers ...netip.Addr) *mockDNSServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", address)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	server := &mockDNSServer{conn: conn, rcode: rcode, answers: answers}
+	go server.serve()
+	return server
+}
+
+func (s *mockDNSServer) port() string {
+	_, port, _ := net.SplitHostPort(s.conn.LocalAddr().String())
+	return port
+}
+
+func (s *mockDNSServer) serve() {
+	buf := make([]byte, maxUDPMessageSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || s.rcode == nil {
+			continue
+		}
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: *s.rcode},
+			Questions: query.Questions,
+		}
+		header := dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Class: dnsmessage.ClassINET}
+		for _, answer := range s.answers {
+			if answer.Is4() {
+				header.Type = dnsmessage.TypeA
+				response.Answers = append(response.Answers,
+					dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: answer.As4()}})
+			} else {
+				header.Type = dnsmessage.TypeAAAA
+				response.Answers = append(response.Answers,
+					dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: answer.As16()}})
+			}
+		}
+		packed, err := response.Pack()
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(packed, addr)
+	}
+}
+
+func rcode(code dnsmessage.RCode) *dnsmessage.RCode {
+	return &code
+}
+
+func Test_Lookup(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ipv4 := netip.MustParseAddr("103.86.96.100")
+	ipv6 := netip.MustParseAddr("2001:db8::1")
+	tests := []struct {
+		name      string
+		rcode     *dnsmessage.RCode
+		answers   []netip.Addr
+		qtype     QueryType
+		addresses []netip.Addr
+		err       error
+	}{
+		{
+			name:      "ipv4",
+			rcode:     rcode(dnsmessage.RCodeSuccess),
+			answers:   []netip.Addr{ipv4, ipv6},
+			qtype:     QueryTypeA,
+			addresses: []netip.Addr{ipv4},
+		},
+		{
+			name:      "ipv6",
+			rcode:     rcode(dnsmessage.RCodeSuccess),
+			answers:   []netip.Addr{ipv4, ipv6},
+			qtype:     QueryTypeAAAA,
+			addresses: []netip.Addr{ipv6},
+		},
+		{
+			name:  "nxdomain",
+			rcode: rcode(dnsmessage.RCodeNameError),
+			qtype: QueryTypeA,
+			err:   ErrLookupNXDomain,
+		},
+		{
+			name:  "servfail",
+			rcode: rcode(dnsmessage.RCodeServerFailure),
+			qtype: QueryTypeA,
+			err:   ErrLookupServFail,
+		},
+		{
+			name:  "timeout",
+			qtype: QueryTypeA,
+			err:   ErrLookupTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newMockDNSServer(t, test.rcode, test.answers...)
+			ds := newTestSetter(&mockAnalytics{})
+			ds.applied = []string{"127.0.0.1"}
+			ds.dnsPort = server.port()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			addresses, err := ds.Lookup(ctx, "nordvpn.com", test.qtype)
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.addresses, addresses)
+		})
+	}
+}
+
+func Test_LookupCanceled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	server := newMockDNSServer(t, nil)
+	ds := newTestSetter(&mockAnalytics{})
+	ds.applied = []string{"127.0.0.1"}
+	ds.dnsPort = server.port()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := ds.Lookup(ctx, "nordvpn.com", QueryTypeA)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), lookupNameserverTimeout)
+}
+
+func Test_LookupDNSNotSet(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	_, err := newTestSetter(&mockAnalytics{}).Lookup(context.Background(), "nordvpn.com", QueryTypeA)
+	assert.Error(t, err)
+}
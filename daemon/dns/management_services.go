@@ -0,0 +1,55 @@
+package dns
+
+import "fmt"
+
+// ServiceAvailability describes whether a DNS management service supported by NordVPN can be
+// used on the host
+type ServiceAvailability struct {
+	// Service is the name of the management service, e.g. systemd-resolved
+	Service   string
+	Available bool
+	// Reason explains why the service is not available, it is empty when it is available
+	Reason string
+}
+
+// ManagementServices returns the DNS management services supported by NordVPN, in the order they
+// are tried when setting DNS, and whether they are available on the host. The configuration of
+// the host is only read, so it can be used for diagnostics at any time.
+func (d *DefaultSetter) ManagementServices() []ServiceAvailability {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	etcReadOnly := d.isEtcReadOnly()
+	services := []ServiceAvailability{}
+	indexes := map[dnsManagementService]int{}
+	for _, method := range d.methods {
+		service := managementServiceForMethod(method)
+		checker, ok := method.(availabilityChecker)
+		if service == unknownService || !ok {
+			continue
+		}
+		index, seen := indexes[service]
+		if !seen {
+			index = len(services)
+			indexes[service] = index
+			services = append(services, ServiceAvailability{Service: service.String()})
+		}
+		if services[index].Available {
+			// a single method is enough to use the service
+			continue
+		}
+
+		err := checker.available()
+		if err == nil && etcReadOnly && writesResolvConf(method) {
+			err = errEtcReadOnly
+		}
+		if err == nil {
+			services[index].Available, services[index].Reason = true, ""
+			continue
+		}
+		if services[index].Reason != "" {
+			services[index].Reason += "; "
+		}
+		services[index].Reason += fmt.Sprintf("%s: %s", method.Name(), err)
+	}
+	return services
+}
@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ManagementServices(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	errNotRunning := errors.New("not running")
+	tests := []struct {
+		name        string
+		methods     []Method
+		etcReadOnly bool
+		services    []ServiceAvailability
+	}{
+		{
+			name: "all available",
+			methods: []Method{
+				&fakeBackend{service: systemdResolvedService},
+				&fakeBackend{service: resolvconfService},
+				&fakeBackend{service: unmanagedService},
+			},
+			services: []ServiceAvailability{
+				{Service: "systemd-resolved", Available: true},
+				{Service: "resolvconf", Available: true},
+				{Service: "unmanaged", Available: true},
+			},
+		},
+		{
+			name: "systemd-resolved available through the second method",
+			methods: []Method{
+				&fakeBackend{service: systemdResolvedService, unavailable: errNotRunning},
+				&fakeBackend{service: systemdResolvedService},
+				&fakeBackend{service: resolvconfService, unavailable: errNotRunning},
+			},
+			services: []ServiceAvailability{
+				{Service: "systemd-resolved", Available: true},
+				{Service: "resolvconf", Reason: "mock: not running"},
+			},
+		},
+		{
+			name: "none of the methods of systemd-resolved are available",
+			methods: []Method{
+				&fakeBackend{service: systemdResolvedService, unavailable: errNotRunning},
+				&fakeBackend{service: systemdResolvedService, unavailable: errNotRunning},
+			},
+			services: []ServiceAvailability{
+				{Service: "systemd-resolved", Reason: "mock: not running; mock: not running"},
+			},
+		},
+		{
+			name: "read-only resolv.conf",
+			methods: []Method{
+				&fakeBackend{service: systemdResolvedService},
+				&fakeBackend{service: unmanagedService},
+			},
+			etcReadOnly: true,
+			services: []ServiceAvailability{
+				{Service: "systemd-resolved", Available: true},
+				{Service: "unmanaged", Reason: "mock: " + errEtcReadOnly.Error()},
+			},
+		},
+		{
+			name:     "methods without the management service are skipped",
+			methods:  []Method{&MockMethod{}},
+			services: []ServiceAvailability{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := newTestSetter(&mockAnalytics{}, test.methods...)
+			ds.isEtcReadOnly = func() bool { return test.etcReadOnly }
+			assert.Equal(t, test.services, ds.ManagementServices())
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package dns
+
+import "strconv"
+
+// Counters incremented together with the analytics events
+const (
+	dnsOverwritesTotal = "dns_overwrites_total"
+	dnsConfiguredTotal = "dns_configured_total"
+	// dnsErrorsTotal is labeled with error_type and critical
+	dnsErrorsTotal = "dns_errors_total"
+)
+
+// Metrics receives DNS counters, so that they can be exposed e.g. to Prometheus without the DNS
+// package depending on it.
+type Metrics interface {
+	// IncCounter increments the counter with the given name and labels by one
+	IncCounter(name string, labels map[string]string)
+}
+
+// noopMetrics is used when no metrics are collected
+type noopMetrics struct{}
+
+func (noopMetrics) IncCounter(string, map[string]string) {}
+
+// errorLabels returns labels of the errors counter for the published error event
+func errorLabels(event errorEvent) map[string]string {
+	return map[string]string{
+		"error_type": event.ErrorType,
+		"critical":   strconv.FormatBool(event.Critical),
+	}
+}
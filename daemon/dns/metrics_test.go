@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCounter struct {
+	name   string
+	labels map[string]string
+}
+
+type fakeMetrics struct {
+	counters []fakeCounter
+	mu       sync.Mutex
+}
+
+func (m *fakeMetrics) IncCounter(name string, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters = append(m.counters, fakeCounter{name: name, labels: labels})
+}
+
+func Test_AnalyticsIncrementsMetrics(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name     string
+		emit     func(*dnsAnalytics)
+		counters []fakeCounter
+	}{
+		{
+			name: "configured",
+			emit: func(a *dnsAnalytics) {
+				a.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+			},
+			counters: []fakeCounter{{name: dnsConfiguredTotal}},
+		},
+		{
+			name: "dry run is not counted",
+			emit: func(a *dnsAnalytics) {
+				a.emitDNSConfiguredDryRunEvent(context.Background(), unmanagedService, configurationDetails{})
+			},
+		},
+		{
+			name: "non critical error",
+			emit: func(a *dnsAnalytics) {
+				a.emitDNSConfigurationErrorEvent(context.Background(), dnsOverTLSUnsupportedErrorType, severityNonCritical)
+			},
+			counters: []fakeCounter{{
+				name:   dnsErrorsTotal,
+				labels: map[string]string{"error_type": "dot_unsupported", "critical": "false"},
+			}},
+		},
+		{
+			name: "set failed",
+			emit: func(a *dnsAnalytics) {
+				a.emitDNSSetFailedEvent(context.Background(),
+					&DNSError{Type: permissionDeniedErrorType, Critical: true, Err: syscall.EACCES}, 3)
+			},
+			counters: []fakeCounter{{
+				name:   dnsErrorsTotal,
+				labels: map[string]string{"error_type": "permission_denied", "critical": "true"},
+			}},
+		},
+		{
+			name: "every overwrite",
+			emit: func(a *dnsAnalytics) {
+				a.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+				a.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+			},
+			counters: []fakeCounter{{name: dnsOverwritesTotal}, {name: dnsOverwritesTotal}},
+		},
+		{
+			name: "canceled event is not counted",
+			emit: func(a *dnsAnalytics) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				a.emitDNSConfiguredEvent(ctx, configurationDetails{})
+				a.emitDNSConfigurationErrorEvent(ctx, setFailedErrorType, severityByType)
+				a.emitResolvConfOverwrittenEvent(ctx, "WRITE", resolvConfDiff{})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := &fakeMetrics{}
+			analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+			analytics.clock = newFakeClock()
+			analytics.setMetrics(metrics)
+
+			test.emit(analytics)
+			assert.Equal(t, test.counters, metrics.counters)
+		})
+	}
+}
+
+func Test_SetMetrics(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+	ds := newTestSetter(&mockAnalytics{})
+	ds.analytics = analytics
+	// counters are not collected by default
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+
+	metrics := &fakeMetrics{}
+	ds.SetMetrics(metrics)
+	analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+	assert.Equal(t, []fakeCounter{{name: dnsConfiguredTotal}}, metrics.counters)
+}
+
+// reentrantMetrics calls back into the analytics when a counter is incremented
+type reentrantMetrics struct {
+	analytics *dnsAnalytics
+	services  []dnsManagementService
+}
+
+func (m *reentrantMetrics) IncCounter(string, map[string]string) {
+	m.services = append(m.services, m.analytics.ManagementService())
+}
+
+func Test_MetricsAreIncrementedWithoutLock(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := newTestDNSAnalytics(t, &mockDebuggerPublisher{}, defaultLogger{})
+	analytics.clock = newFakeClock()
+	metrics := &reentrantMetrics{analytics: analytics}
+	analytics.setMetrics(metrics)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		analytics.emitDNSConfiguredEvent(context.Background(), configurationDetails{})
+		analytics.emitDNSConfigurationErrorEvent(context.Background(), setFailedErrorType, severityByType)
+		analytics.emitResolvConfOverwrittenEvent(context.Background(), "WRITE", resolvConfDiff{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("metrics sink deadlocked the analytics")
+	}
+	assert.Len(t, metrics.services, 3)
+}
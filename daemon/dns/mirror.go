@@ -0,0 +1,58 @@
+package dns
+
+import "fmt"
+
+// SetResolvConfMirror enables writing the VPN nameservers to resolv.conf also when DNS is set
+// with systemd-resolved, for the applications which read resolv.conf directly instead of
+// querying the stub resolver. The change takes effect the next time DNS is set.
+func (d *DefaultSetter) SetResolvConfMirror(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mirrorResolvConf = enabled
+}
+
+// updateMirror mirrors the nameservers set with the method to resolv.conf when mirroring is
+// enabled and the method is systemd-resolved. resolv.conf mirrored by the previous Set is
+// restored when it is not mirrored anymore.
+func (d *DefaultSetter) updateMirror(method Method, iface string, nameservers []string) {
+	previous := d.mirrored
+	d.mirrored = nil
+	if d.mirrorResolvConf && managementServiceForMethod(method) == systemdResolvedService {
+		d.mirrored = d.mirrorToResolvConf(iface, nameservers)
+	}
+	// resolv.conf written by the method itself must not be restored
+	if previous != nil && previous != d.mirrored && previous != method {
+		d.unsetMirror(previous, iface, d.networkNamespace)
+	}
+}
+
+// mirrorToResolvConf writes the nameservers with the first method editing resolv.conf directly
+// and returns it, or nil when resolv.conf was not written. Failures are only logged, because DNS
+// is already set with systemd-resolved.
+func (d *DefaultSetter) mirrorToResolvConf(iface string, nameservers []string) Method {
+	for _, method := range d.methods {
+		if !writesResolvConf(method) {
+			continue
+		}
+		if d.isEtcReadOnly() {
+			d.logger.Warn("resolv.conf is on a read-only file system, it is not mirrored")
+			return nil
+		}
+		err := d.inNetworkNamespace(d.networkNamespace, method, func() error { return method.Set(iface, nameservers) })
+		if err != nil {
+			d.logger.Warn(fmt.Errorf("mirroring dns to resolv.conf with %s: %w", method.Name(), err))
+			return nil
+		}
+		d.logger.Info("dns mirrored to resolv.conf using:", method.Name())
+		return method
+	}
+	d.logger.Warn("dns not mirrored, resolv.conf can't be written directly")
+	return nil
+}
+
+// unsetMirror restores resolv.conf mirrored with the method
+func (d *DefaultSetter) unsetMirror(method Method, iface string, namespace string) {
+	if err := d.inNetworkNamespace(namespace, method, func() error { return method.Unset(iface) }); err != nil {
+		d.logger.Error(fmt.Errorf("restoring mirrored resolv.conf with %s: %w", method.Name(), err))
+	}
+}
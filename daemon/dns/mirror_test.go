@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type resolvedMethod struct {
+	recordingMethod
+}
+
+func (m *resolvedMethod) managementService() dnsManagementService {
+	return systemdResolvedService
+}
+
+func Test_SetMirrorsResolvConf(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	resolved := &resolvedMethod{recordingMethod{name: "resolved", calls: &calls}}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, resolved, file)
+	ds.SetResolvConfMirror(true)
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"set resolved", "set file"}, calls)
+	assert.Equal(t, testVPNNameservers, resolved.lastSet)
+	assert.Equal(t, testVPNNameservers, file.lastSet)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.True(t, analytics.configuredEvents[0].mirrored)
+
+	calls = calls[:0]
+	require.NoError(t, ds.Unset("lo"))
+	assert.Equal(t, []string{"unset file", "unset resolved"}, calls)
+	assert.Nil(t, ds.mirrored)
+}
+
+func Test_SetWithoutMirror(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	resolved := &resolvedMethod{recordingMethod{name: "resolved", calls: &calls}}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, resolved, file)
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"set resolved"}, calls)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.False(t, analytics.configuredEvents[0].mirrored)
+
+	// mirror is restored when mirroring is disabled
+	ds.SetResolvConfMirror(true)
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.96"}))
+	ds.SetResolvConfMirror(false)
+	calls = calls[:0]
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"set resolved", "unset file"}, calls)
+	assert.Nil(t, ds.mirrored)
+}
+
+func Test_SetMirrorFails(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	resolved := &resolvedMethod{recordingMethod{name: "resolved", calls: &calls}}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls, setErr: errors.New("permission denied")}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, resolved, file)
+	ds.SetResolvConfMirror(true)
+
+	// dns set with systemd-resolved is kept
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"set resolved", "set file"}, calls)
+	assert.Equal(t, resolved, ds.active)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.False(t, analytics.configuredEvents[0].mirrored)
+}
+
+func Test_SetMirrorOnlyForResolved(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	resolved := &resolvedMethod{recordingMethod{name: "resolved", calls: &calls, setErr: errors.New("not running")}}
+	file := &fileMethod{recordingMethod{name: "file", calls: &calls}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, resolved, file)
+	ds.SetResolvConfMirror(true)
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"set resolved", "set file"}, calls)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.False(t, analytics.configuredEvents[0].mirrored)
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+)
+
+// NameserverOrder is the policy of ordering the nameservers before they are set. The order
+// matters for failover, because the nameservers are queried in the order they are set.
+type NameserverOrder int
+
+const (
+	// PreserveNameserverOrder keeps the nameservers in the order they were provided in, the first
+	// one is the primary
+	PreserveNameserverOrder NameserverOrder = iota
+	// IPv6FirstNameserverOrder puts the IPv6 nameservers before the IPv4 ones, e.g. on the hosts
+	// preferring IPv6. The order within each address family is kept.
+	IPv6FirstNameserverOrder
+	// IPv4FirstNameserverOrder puts the IPv4 nameservers before the IPv6 ones. The order within
+	// each address family is kept.
+	IPv4FirstNameserverOrder
+)
+
+func (o NameserverOrder) String() string {
+	switch o {
+	case PreserveNameserverOrder:
+		return "preserve"
+	case IPv6FirstNameserverOrder:
+		return "ipv6_first"
+	case IPv4FirstNameserverOrder:
+		return "ipv4_first"
+	default:
+		return fmt.Sprintf("%d", int(o))
+	}
+}
+
+// orderNameservers returns the nameservers ordered according to the policy. Nameservers must be
+// valid addresses.
+func orderNameservers(nameservers []string, order NameserverOrder) []string {
+	switch order {
+	case IPv6FirstNameserverOrder:
+		return append(filterIPv6(nameservers), filterIPv4(nameservers)...)
+	case IPv4FirstNameserverOrder:
+		return append(filterIPv4(nameservers), filterIPv6(nameservers)...)
+	default:
+		return slices.Clone(nameservers)
+	}
+}
+
+// keepPrimaryNameserver moves the primary nameserver to the last of the nameservers used by
+// glibc when the ordering placed it further, so that it is never truncated away from resolv.conf.
+// Nameservers are returned as they are when the primary is not among them.
+func keepPrimaryNameserver(nameservers []string, primary string) []string {
+	idx := slices.Index(nameservers, primary)
+	if idx < maxResolvConfNameservers {
+		return nameservers
+	}
+	kept := slices.Delete(slices.Clone(nameservers), idx, idx+1)
+	return slices.Insert(kept, maxResolvConfNameservers-1, primary)
+}
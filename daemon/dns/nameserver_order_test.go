@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OrderNameservers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	nameservers := []string{"103.86.96.100", "2400:bb40:4444::100", "103.86.99.100", "2400:bb40:8888::100"}
+	tests := []struct {
+		order    NameserverOrder
+		expected []string
+	}{
+		{
+			order:    PreserveNameserverOrder,
+			expected: nameservers,
+		},
+		{
+			order:    IPv6FirstNameserverOrder,
+			expected: []string{"2400:bb40:4444::100", "2400:bb40:8888::100", "103.86.96.100", "103.86.99.100"},
+		},
+		{
+			order:    IPv4FirstNameserverOrder,
+			expected: []string{"103.86.96.100", "103.86.99.100", "2400:bb40:4444::100", "2400:bb40:8888::100"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.order.String(), func(t *testing.T) {
+			assert.Equal(t, test.expected, orderNameservers(nameservers, test.order))
+		})
+	}
+}
+
+func Test_KeepPrimaryNameserver(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	nameservers := []string{"2400:bb40:4444::100", "2400:bb40:8888::100", "2400:bb40:4444::101", "103.86.96.100"}
+	assert.Equal(t,
+		[]string{"2400:bb40:4444::100", "2400:bb40:8888::100", "103.86.96.100", "2400:bb40:4444::101"},
+		keepPrimaryNameserver(nameservers, "103.86.96.100"))
+	assert.Equal(t, nameservers, keepPrimaryNameserver(nameservers, "2400:bb40:4444::101"))
+	assert.Equal(t, nameservers, keepPrimaryNameserver(nameservers, "103.86.99.100"))
+}
+
+func Test_SetKeepsPrimaryNameserver(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	method := &recordingMethod{name: "method", calls: &calls}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, method)
+	require.NoError(t, ds.SetNameserverOrder(IPv6FirstNameserverOrder))
+
+	require.NoError(t, ds.Set("lo", []string{
+		"103.86.96.100", "2400:bb40:4444::100", "2400:bb40:8888::100", "2400:bb40:4444::101",
+	}))
+	// primary is among the nameservers written to resolv.conf
+	assert.Equal(t,
+		[]string{"2400:bb40:4444::100", "2400:bb40:8888::100", "103.86.96.100", "2400:bb40:4444::101"},
+		method.lastSet)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.Equal(t, IPv6FirstNameserverOrder, analytics.configuredEvents[0].nameserverOrder)
+
+	assert.Error(t, ds.SetNameserverOrder(NameserverOrder(-1)))
+}
@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// envDNSServers overrides the nameservers set by NordVPN. It is a comma separated list of IP
+// addresses, meant for debugging DNS issues without changing the app configuration.
+const envDNSServers = "NORDVPN_DNS_SERVERS"
+
+// nameserverSource describes where the nameservers set on the host came from
+type nameserverSource int
+
+const (
+	// requestedSource are the nameservers passed to Set
+	requestedSource nameserverSource = iota
+	// envOverrideSource are the nameservers from envDNSServers
+	envOverrideSource
+)
+
+func (s nameserverSource) String() string {
+	switch s {
+	case requestedSource:
+		return "requested"
+	case envOverrideSource:
+		return "env_override"
+	default:
+		return fmt.Sprintf("%d", int(s))
+	}
+}
+
+// nameserversOverride returns the nameservers from envDNSServers, or nil if it is not set or it
+// is malformed
+func (d *DefaultSetter) nameserversOverride() []string {
+	value, ok := d.lookupEnv(envDNSServers)
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil
+	}
+	nameservers, err := parseNameserversOverride(value)
+	if err != nil {
+		d.logger.Warn(fmt.Sprintf("ignoring %s:", envDNSServers), err)
+		return nil
+	}
+	return nameservers
+}
+
+// parseNameserversOverride parses a comma separated list of nameservers. Empty items are
+// skipped, e.g. after a trailing comma.
+func parseNameserversOverride(value string) ([]string, error) {
+	nameservers := []string{}
+	addresses := []netip.Addr{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		address, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", errInvalidNameserver, item, err)
+		}
+		nameservers = append(nameservers, address.String())
+		addresses = append(addresses, address)
+	}
+	if len(nameservers) == 0 {
+		return nil, fmt.Errorf("no nameservers in %q", value)
+	}
+	if err := validateResolvers(addresses); err != nil {
+		return nil, err
+	}
+	return nameservers, nil
+}
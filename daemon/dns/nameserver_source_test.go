@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseNameserversOverride(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name        string
+		value       string
+		nameservers []string
+		err         bool
+	}{
+		{name: "single", value: "1.1.1.1", nameservers: []string{"1.1.1.1"}},
+		{
+			name:        "multiple with spaces",
+			value:       " 1.1.1.1 , 2606:4700:4700::1111,",
+			nameservers: []string{"1.1.1.1", "2606:4700:4700::1111"},
+		},
+		{name: "empty", value: " , ", err: true},
+		{name: "hostname", value: "1.1.1.1,dns.example.com", err: true},
+		{name: "unspecified", value: "0.0.0.0", err: true},
+		{name: "multicast", value: "1.1.1.1,224.0.0.1", err: true},
+		{name: "loopback", value: "127.0.0.53", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nameservers, err := parseNameserversOverride(test.value)
+			assert.Equal(t, test.err, err != nil)
+			assert.Equal(t, test.nameservers, nameservers)
+		})
+	}
+}
+
+func Test_SetNameserversOverride(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		nameservers []string
+		source      nameserverSource
+	}{
+		{
+			name:        "not set",
+			nameservers: []string{"103.86.96.100"},
+			source:      requestedSource,
+		},
+		{
+			name:        "overridden",
+			env:         map[string]string{envDNSServers: "1.1.1.1,1.0.0.1"},
+			nameservers: []string{"1.1.1.1", "1.0.0.1"},
+			source:      envOverrideSource,
+		},
+		{
+			name:        "malformed is ignored",
+			env:         map[string]string{envDNSServers: "1.1.1.1,not-an-ip"},
+			nameservers: []string{"103.86.96.100"},
+			source:      requestedSource,
+		},
+		{
+			name:        "invalid is ignored",
+			env:         map[string]string{envDNSServers: "0.0.0.0"},
+			nameservers: []string{"103.86.96.100"},
+			source:      requestedSource,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			method := &recordingMethod{name: "method", calls: &[]string{}}
+			ds := newTestSetter(analytics, method)
+			ds.lookupEnv = func(key string) (string, bool) {
+				value, ok := test.env[key]
+				return value, ok
+			}
+
+			require.NoError(t, ds.Set("nordlynx", []string{"103.86.96.100"}))
+			assert.Equal(t, test.nameservers, method.lastSet)
+			require.Len(t, analytics.configuredEvents, 1)
+			assert.Equal(t, test.source, analytics.configuredEvents[0].source)
+			// requested nameservers are re-applied on refresh, so that the override is read again
+			assert.Equal(t, []string{"103.86.96.100"}, ds.nameservers)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+	"golang.org/x/sys/unix"
+)
+
+// netnsDir is where ip-netns keeps the named network namespaces
+const netnsDir = "/run/netns"
+
+var (
+	// netnsConfigDir is where ip-netns keeps the configuration files of the named network
+	// namespaces
+	netnsConfigDir = "/etc/netns"
+	// netnsBackupDir is where the configuration files of the named network namespaces are backed
+	// up
+	netnsBackupDir = filepath.Join(internal.BakFilesPath, "netns")
+)
+
+// errNetworkNamespaceUnsupported is returned for the methods which can't set DNS inside a network
+// namespace
+var errNetworkNamespaceUnsupported = errors.New("dns can't be set inside a network namespace with this method")
+
+// namespaceEntererFunc switches the calling goroutine to the named network namespace, the
+// returned function switches it back
+type namespaceEntererFunc func(name string) (restore func() error, err error)
+
+// validateNetworkNamespace checks if the name can be used as the name of ip-netns namespace,
+// empty name stands for the namespace of the daemon
+func validateNetworkNamespace(name string) error {
+	if name == "" {
+		return nil
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return fmt.Errorf("invalid network namespace name: %q", name)
+	}
+	if len(name) > unix.NAME_MAX {
+		return fmt.Errorf("network namespace name is longer than %d characters", unix.NAME_MAX)
+	}
+	return nil
+}
+
+// enterNetworkNamespace switches the OS thread of the calling goroutine to the named network
+// namespace. The thread is locked to the goroutine, so the commands started in the meantime run
+// in the namespace as well. It is unlocked only when restore succeeds, otherwise the goroutine
+// must exit while still locked, so that the thread is terminated instead of being reused.
+func enterNetworkNamespace(name string) (func() error, error) {
+	target, err := os.Open(filepath.Join(netnsDir, name))
+	if err != nil {
+		return nil, fmt.Errorf("opening network namespace: %w", err)
+	}
+	defer target.Close()
+
+	runtime.LockOSThread()
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("opening current network namespace: %w", err)
+	}
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		origin.Close()
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("entering network namespace: %w", err)
+	}
+	return func() error {
+		defer origin.Close()
+		if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+			return fmt.Errorf("restoring network namespace: %w", err)
+		}
+		runtime.UnlockOSThread()
+		return nil
+	}, nil
+}
+
+// supportsNetworkNamespace checks if DNS set with the method is scoped to the network namespace.
+// systemd-resolved and resolvconf are reached through the file system and the D-Bus of the host,
+// which is not changed by entering a network namespace, so they would configure the host instead,
+// and the interface indexes of the namespace can name unrelated links of the host.
+func supportsNetworkNamespace(method Method) bool {
+	switch method.(type) {
+	case *Resolved, *Resolvectl, *Resolvconf:
+		return false
+	default:
+		return true
+	}
+}
+
+// scopeToNetworkNamespace makes the method configure DNS of the network namespace, it must be
+// called before every use of the method, because the namespace can change between the calls
+func scopeToNetworkNamespace(method Method, namespace string) {
+	if file, ok := method.(*ResolvConfFile); ok {
+		file.namespace = namespace
+	}
+}
+
+// namespaceResolvConfPath returns resolv.conf of the named network namespace, ip-netns bind mounts
+// it over /etc/resolv.conf for the programs run in the namespace
+func namespaceResolvConfPath(name string) string {
+	return filepath.Join(netnsConfigDir, name, "resolv.conf")
+}
+
+// namespaceResolvConfBackupPath returns where resolv.conf of the named network namespace is backed
+// up before it is written
+func namespaceResolvConfBackupPath(name string) string {
+	return filepath.Join(netnsBackupDir, name, "resolv.conf")
+}
+
+// originalNamespaceResolvConf returns the pre-VPN resolv.conf of the named network namespace. When
+// the namespace does not have its own, it uses resolv.conf of the host.
+func originalNamespaceResolvConf(name string) ([]byte, error) {
+	for _, path := range []string{namespaceResolvConfBackupPath(name), namespaceResolvConfPath(name)} {
+		if internal.FileExists(path) {
+			content, err := internal.FileRead(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading original resolv.conf: %w", err)
+			}
+			return content, nil
+		}
+	}
+	return originalResolvConf()
+}
+
+// backupNamespaceResolvConf backs up resolv.conf of the named network namespace, unless it is
+// already backed up or the namespace does not have its own
+func backupNamespaceResolvConf(name string) error {
+	path, backupPath := namespaceResolvConfPath(name), namespaceResolvConfBackupPath(name)
+	if internal.FileExists(backupPath) || !internal.FileExists(path) {
+		return nil
+	}
+	content, err := internal.FileRead(path)
+	if err != nil {
+		return fmt.Errorf("reading resolv.conf: %w", err)
+	}
+	if isOwnResolvConf(content) {
+		// left behind by NordVPN, e.g. after a crash, so there is nothing to restore
+		return nil
+	}
+	return internal.FileWrite(backupPath, content, internal.PermUserRWGroupROthersR)
+}
+
+// unsetDNSinNamespaceResolvConf restores resolv.conf of the named network namespace from the
+// backup, or removes it if the namespace did not have its own. The file is left alone when another
+// program took it over.
+func unsetDNSinNamespaceResolvConf(logger Logger, name string) error {
+	path, backupPath := namespaceResolvConfPath(name), namespaceResolvConfBackupPath(name)
+	content, err := internal.FileRead(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("reading resolv.conf: %w", err)
+	}
+	if !isOwnResolvConf(content) {
+		logger.Info("resolv.conf of network namespace", name, "was taken over by another program, not restoring it")
+		return nil
+	}
+	if internal.FileExists(backupPath) {
+		return restoreFromBackup(path, backupPath)
+	}
+	if err := internal.FileDelete(path); err != nil {
+		return fmt.Errorf("removing resolv.conf: %w", err)
+	}
+	return nil
+}
+
+// SetNetworkNamespace makes DNS to be set inside the named network namespace, e.g. for VRF or
+// policy routing setups, instead of the namespace of the daemon. The namespace is entered only
+// for the duration of the commands and writes of the DNS setting methods. resolv.conf of the
+// namespace is written to /etc/netns/<name>, systemd-resolved and resolvconf are not used, as
+// they would configure the host. Empty name restores the default. The change takes effect the
+// next time DNS is set.
+func (d *DefaultSetter) SetNetworkNamespace(name string) error {
+	if err := validateNetworkNamespace(name); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.networkNamespace = name
+	return nil
+}
+
+// inNetworkNamespace runs fn for the method inside the network namespace, or in the namespace of
+// the daemon when it is empty. The methods which can't be scoped to a namespace are rejected. The
+// namespace is entered on a dedicated goroutine, which exits still locked to its thread when the
+// namespace can't be restored, so that no other goroutine runs in it.
+func (d *DefaultSetter) inNetworkNamespace(namespace string, method Method, fn func() error) error {
+	scopeToNetworkNamespace(method, namespace)
+	if namespace == "" {
+		return fn()
+	}
+	if !supportsNetworkNamespace(method) {
+		return fmt.Errorf("%s: %w", method.Name(), errNetworkNamespaceUnsupported)
+	}
+	done := make(chan error, 1)
+	go func() {
+		restore, err := d.enterNamespace(namespace)
+		if err != nil {
+			done <- fmt.Errorf("network namespace %s: %w", namespace, err)
+			return
+		}
+		fnErr := fn()
+		if err := restore(); err != nil {
+			d.logger.Error(fmt.Errorf("leaving network namespace %s: %w", namespace, err))
+			fnErr = errors.Join(fnErr, err)
+		}
+		done <- fnErr
+	}()
+	return <-done
+}
@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateNetworkNamespace(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name      string
+		namespace string
+		valid     bool
+	}{
+		{name: "default", namespace: "", valid: true},
+		{name: "named", namespace: "vrf-blue", valid: true},
+		{name: "dot", namespace: "."},
+		{name: "parent", namespace: ".."},
+		{name: "path", namespace: "../../proc/1/ns/net"},
+		{name: "too long", namespace: strings.Repeat("a", 256)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.valid, validateNetworkNamespace(test.namespace) == nil)
+		})
+	}
+}
+
+// stubNamespaceEnterer records entering and leaving the namespaces in calls instead of
+// switching them
+func stubNamespaceEnterer(calls *[]string, enterErr error, restoreErr error) namespaceEntererFunc {
+	return func(name string) (func() error, error) {
+		if enterErr != nil {
+			return nil, enterErr
+		}
+		*calls = append(*calls, "enter "+name)
+		return func() error {
+			*calls = append(*calls, "leave "+name)
+			return restoreErr
+		}, nil
+	}
+}
+
+func Test_SetInNetworkNamespace(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &recordingMethod{name: "method", calls: &calls})
+	ds.enterNamespace = stubNamespaceEnterer(&calls, nil, nil)
+
+	require.NoError(t, ds.SetNetworkNamespace("vrf-blue"))
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"enter vrf-blue", "set method", "leave vrf-blue"}, calls)
+	require.Len(t, analytics.configuredEvents, 1)
+	assert.Equal(t, "vrf-blue", analytics.configuredEvents[0].networkNamespace)
+
+	// DNS is unset in the namespace it was set in
+	calls = calls[:0]
+	require.NoError(t, ds.SetNetworkNamespace(""))
+	require.NoError(t, ds.Unset("lo"))
+	assert.Equal(t, []string{"enter vrf-blue", "unset method", "leave vrf-blue"}, calls)
+
+	calls = calls[:0]
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"set method"}, calls)
+	assert.Empty(t, analytics.configuredEvents[1].networkNamespace)
+
+	assert.Error(t, ds.SetNetworkNamespace("../vrf-blue"))
+	assert.Empty(t, ds.networkNamespace)
+}
+
+func Test_SetInNetworkNamespaceFails(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name       string
+		enterErr   error
+		restoreErr error
+		calls      []string
+	}{
+		{
+			name:     "namespace does not exist",
+			enterErr: errors.New("opening network namespace: no such file or directory"),
+			calls:    []string{},
+		},
+		{
+			name:       "namespace is not restored",
+			restoreErr: errors.New("restoring network namespace: operation not permitted"),
+			calls:      []string{"enter vrf-blue", "set method", "leave vrf-blue"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, &recordingMethod{name: "method", calls: &calls})
+			ds.enterNamespace = stubNamespaceEnterer(&calls, test.enterErr, test.restoreErr)
+			require.NoError(t, ds.SetNetworkNamespace("vrf-blue"))
+
+			assert.Error(t, ds.Set("lo", testVPNNameservers))
+			assert.Equal(t, test.calls, calls)
+			assert.Empty(t, analytics.configuredEvents)
+		})
+	}
+}
+
+func Test_SetInNetworkNamespaceSkipsHostMethods(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	ds := newTestSetter(&mockAnalytics{},
+		&Resolved{}, &Resolvectl{}, &Resolvconf{}, &recordingMethod{name: "method", calls: &calls})
+	ds.enterNamespace = stubNamespaceEnterer(&calls, nil, nil)
+	require.NoError(t, ds.SetNetworkNamespace("vrf-blue"))
+
+	require.NoError(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, []string{"enter vrf-blue", "set method", "leave vrf-blue"}, calls)
+
+	ds = newTestSetter(&mockAnalytics{}, &Resolved{})
+	ds.enterNamespace = stubNamespaceEnterer(&calls, nil, nil)
+	require.NoError(t, ds.SetNetworkNamespace("vrf-blue"))
+	assert.ErrorIs(t, ds.Set("lo", testVPNNameservers), errNetworkNamespaceUnsupported)
+}
+
+// useTemporaryNetnsDirs makes resolv.conf of the network namespaces to be written to a temporary
+// directory
+func useTemporaryNetnsDirs(t *testing.T) {
+	t.Helper()
+	configDir, backupDir := netnsConfigDir, netnsBackupDir
+	netnsConfigDir, netnsBackupDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { netnsConfigDir, netnsBackupDir = configDir, backupDir })
+}
+
+func Test_ResolvConfFileInNetworkNamespace(t *testing.T) {
+	category.Set(t, category.File)
+	useTemporaryNetnsDirs(t)
+
+	const original = "nameserver 192.168.1.1\noptions edns0\n"
+	path := namespaceResolvConfPath("vrf-blue")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(original), 0644))
+
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}, namespace: "vrf-blue"}
+	require.NoError(t, file.Set("lo", testVPNNameservers))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, isOwnResolvConf(content))
+	assert.Equal(t, resolvConfFileContent(testVPNNameservers, nil, parseResolvConfDirectives([]byte(original))),
+		resolvConfBody(content))
+	assert.Equal(t, content, file.content)
+	assert.True(t, file.isApplied(content, testVPNNameservers))
+
+	require.NoError(t, file.Unset("lo"))
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, original, string(content))
+	assert.NoFileExists(t, namespaceResolvConfBackupPath("vrf-blue"))
+}
+
+func Test_ResolvConfFileInNetworkNamespaceWithoutOwnResolvConf(t *testing.T) {
+	category.Set(t, category.File)
+	useTemporaryNetnsDirs(t)
+
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}, namespace: "vrf-blue"}
+	require.NoError(t, file.Set("lo", testVPNNameservers))
+	path := namespaceResolvConfPath("vrf-blue")
+	assert.FileExists(t, path)
+	assert.NoFileExists(t, namespaceResolvConfBackupPath("vrf-blue"))
+
+	// the namespace uses resolv.conf of the host again
+	require.NoError(t, file.Unset("lo"))
+	assert.NoFileExists(t, path)
+}
@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/NordSecurity/nordvpn-linux/events"
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// Option configures DefaultSetter created with NewSetter
+type Option func(*setterOptions)
+
+// setterOptions holds the configuration of DefaultSetter created with NewSetter
+type setterOptions struct {
+	logger Logger
+	// debugPublisher receives the analytics events, they are not published when it is nil
+	debugPublisher    events.Publisher[events.DebuggerEvent]
+	namespace         string
+	sampleRate        int
+	canaryDomain      string
+	leakCheckHostname string
+	retryPolicy       *RetryPolicy
+}
+
+// WithLogger makes the setter log to the given logger instead of the standard logger
+func WithLogger(logger Logger) Option {
+	return func(o *setterOptions) {
+		o.logger = logger
+	}
+}
+
+// WithAnalytics makes the setter publish analytics events to debugPublisher. Without it, the
+// events are only kept in memory for diagnostics, see RecentEvents.
+func WithAnalytics(debugPublisher events.Publisher[events.DebuggerEvent]) Option {
+	return func(o *setterOptions) {
+		o.debugPublisher = debugPublisher
+	}
+}
+
+// WithAnalyticsNamespace publishes the analytics events in the given message namespace, for
+// builds where the DNS package is embedded under a different brand
+func WithAnalyticsNamespace(namespace string) Option {
+	return func(o *setterOptions) {
+		o.namespace = namespace
+	}
+}
+
+// WithSampling publishes only 1 in sampleRate dns_configured events, for the deployments where
+// DNS is reconfigured very often. The error events are always published. Sampling is disabled
+// when sampleRate is lower than 2.
+func WithSampling(sampleRate int) Option {
+	return func(o *setterOptions) {
+		o.sampleRate = sampleRate
+	}
+}
+
+// WithCanaryDomain makes the health checks resolve domain instead of the default one, e.g. so
+// that QA can point them at a test endpoint. It must be a valid multi-label domain.
+func WithCanaryDomain(domain string) Option {
+	return func(o *setterOptions) {
+		o.canaryDomain = domain
+	}
+}
+
+// WithLeakCheckHostname enables the leak check. hostname must resolve to the address of the
+// resolver which queried it and it must be a valid multi-label domain.
+func WithLeakCheckHostname(hostname string) Option {
+	return func(o *setterOptions) {
+		o.leakCheckHostname = hostname
+	}
+}
+
+// WithRetryPolicy times and retries the DNS operations according to the policy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *setterOptions) {
+		o.retryPolicy = &policy
+	}
+}
+
+// NewSetter creates DefaultSetter configured with the options. Returns an error if any of the
+// options is invalid.
+func NewSetter(publisher events.Publisher[string], opts ...Option) (*DefaultSetter, error) {
+	options := setterOptions{
+		logger:     defaultLogger{},
+		namespace:  internal.DebugEventMessageNamespace,
+		sampleRate: 1,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	canaryDomain := ""
+	if options.canaryDomain != "" {
+		name, err := validateCanaryDomain(options.canaryDomain)
+		if err != nil {
+			return nil, fmt.Errorf("validating canary domain: %w", err)
+		}
+		canaryDomain = name
+	}
+	leakCheckHostname := ""
+	if options.leakCheckHostname != "" {
+		name, err := validateCanaryDomain(options.leakCheckHostname)
+		if err != nil {
+			return nil, fmt.Errorf("validating leak check hostname: %w", err)
+		}
+		leakCheckHostname = name
+	}
+
+	var analytics analytics = newNoopAnalytics()
+	if options.debugPublisher != nil {
+		dnsAnalytics := newDNSAnalyticsWithNamespace(options.debugPublisher, options.logger, options.namespace)
+		dnsAnalytics.sampleRate = max(options.sampleRate, 1)
+		analytics = dnsAnalytics
+	}
+	ds := newSetter(publisher, options.logger, analytics)
+	if canaryDomain != "" {
+		ds.canaryDomain = canaryDomain
+	}
+	if leakCheckHostname != "" {
+		ds.leakCheckHostname = leakCheckHostname
+	}
+	if options.retryPolicy != nil {
+		ds.applyRetryPolicy(*options.retryPolicy)
+	}
+	return ds, nil
+}
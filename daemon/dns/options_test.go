@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/events"
+	"github.com/NordSecurity/nordvpn-linux/events/subs"
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewSetterWithoutOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ds, err := NewSetter(&subs.Subject[string]{})
+	require.NoError(t, err)
+	t.Cleanup(ds.Close)
+
+	assert.IsType(t, &noopAnalytics{}, ds.analytics)
+	assert.Equal(t, defaultLogger{}, ds.logger)
+	assert.Equal(t, defaultDBusTimeout, ds.dbusTimeout)
+}
+
+func Test_NewSetterCombinesOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	logger := defaultLogger{}
+	ds, err := NewSetter(&subs.Subject[string]{},
+		WithLogger(logger),
+		WithAnalytics(&subs.Subject[events.DebuggerEvent]{}),
+		WithAnalyticsNamespace("brand"),
+		WithSampling(10),
+		WithCanaryDomain("canary.qa.example.com"),
+		WithLeakCheckHostname("Whoami.Example.com."),
+		WithRetryPolicy(RetryPolicy{DBusTimeout: time.Second, SetRetries: 5}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(ds.Close)
+
+	analytics, ok := ds.analytics.(*dnsAnalytics)
+	require.True(t, ok)
+	assert.Equal(t, "brand", analytics.namespace)
+	assert.Equal(t, 10, analytics.sampleRate)
+	assert.Equal(t, "canary.qa.example.com", ds.canaryDomain)
+	assert.Equal(t, "whoami.example.com", ds.leakCheckHostname)
+	assert.Equal(t, time.Second, ds.dbusTimeout)
+	assert.Equal(t, 5, ds.retries)
+}
+
+func Test_NewSetterInvalidOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	_, err := NewSetter(&subs.Subject[string]{}, WithCanaryDomain("localhost"))
+	assert.Error(t, err)
+	_, err = NewSetter(&subs.Subject[string]{}, WithLeakCheckHostname("whoami"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+)
+
+// maxProfileNameLength limits the profile name reported in the analytics events
+const maxProfileNameLength = 32
+
+// profileName matches the names of the profiles, they are reported in the analytics events, so
+// only lowercase letters, digits, underscores and dashes are allowed
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Profile is a named DNS configuration, so that users can quickly switch between e.g. VPN DNS,
+// Threat Protection DNS and custom LAN DNS. Applying a profile replaces all of the settings of
+// the previous one, so the settings missing from the profile are removed.
+type Profile struct {
+	// Name identifies the profile, e.g. vpn or lan
+	Name        string
+	Nameservers []string
+	// SearchDomains are used for completing single label names, see SetSearchDomains
+	SearchDomains []string
+	// RoutingDomains maps domain suffixes to the nameservers resolving them, see SetRoutingDomains
+	RoutingDomains map[string][]string
+	// DNSOverTLS maps nameserver addresses to their TLS server names, DNS-over-TLS is disabled
+	// when it is empty, see SetDNSOverTLS
+	DNSOverTLS map[string]string
+}
+
+// validateProfileName checks that the name can be reported in the analytics events
+func validateProfileName(name string) error {
+	if len(name) > maxProfileNameLength || !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	return nil
+}
+
+// ApplyProfile sets DNS for iface from the profile. It supersedes the previously applied
+// profile or the configuration set with Set: all of the profile settings are replaced and the
+// method used before is reverted when DNS ends up being set with a different one.
+func (d *DefaultSetter) ApplyProfile(ctx context.Context, iface string, profile Profile) (SetResult, error) {
+	if err := validateProfileName(profile.Name); err != nil {
+		return SetResult{}, fmt.Errorf("validating profile: %w", err)
+	}
+	if len(profile.Nameservers) == 0 {
+		return SetResult{}, errors.New("validating profile: no nameservers")
+	}
+	searchDomains, err := normalizeSearchDomains(profile.SearchDomains)
+	if err != nil {
+		return SetResult{}, fmt.Errorf("validating profile search domains: %w", err)
+	}
+	routingDomains, err := normalizeRoutingDomains(profile.RoutingDomains)
+	if err != nil {
+		return SetResult{}, fmt.Errorf("validating profile routing domains: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return SetResult{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, method := range d.methods {
+		if resolved, ok := method.(*Resolved); ok {
+			if err := validateRoutes(routingDomains, resolved.linkRoutes); err != nil {
+				return SetResult{}, fmt.Errorf("validating profile routing domains: %w", err)
+			}
+		}
+	}
+	for _, method := range d.methods {
+		switch method := method.(type) {
+		case *Resolved:
+			method.searchDomains = searchDomains
+			method.routingDomains = routingDomains
+			method.tlsServerNames = maps.Clone(profile.DNSOverTLS)
+		case *ResolvConfFile:
+			method.searchDomains = searchDomains
+		}
+	}
+
+	d.profile = profile.Name
+	// configuration of the previous profile set with another method is unset by set
+	result, err := d.set(iface, slices.Clone(profile.Nameservers), requestedSource, profileTrigger)
+	if err != nil {
+		if !errors.Is(err, errSetSuperseded) {
+			d.profile = ""
+		}
+		return SetResult{}, fmt.Errorf("applying profile %s: %w", profile.Name, err)
+	}
+	return result, nil
+}
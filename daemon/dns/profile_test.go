@@ -0,0 +1,119 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ApplyProfileReplacesPrevious(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+	busctl := &mockBusctl{}
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	resolved.busctl = busctl.run
+	ds := newTestSetter(analytics, resolved)
+
+	_, err = ds.ApplyProfile(context.Background(), "lo", Profile{
+		Name:           "threat-protection",
+		Nameservers:    []string{"103.86.96.96", "103.86.99.99"},
+		SearchDomains:  []string{"corp.example.com"},
+		RoutingDomains: map[string][]string{"example.com": {"103.86.96.96"}},
+		DNSOverTLS:     map[string]string{"103.86.96.96": "dns.example.com"},
+	})
+	require.NoError(t, err)
+
+	busctl.calls = nil
+	result, err := ds.ApplyProfile(context.Background(), "lo", Profile{
+		Name:        "lan",
+		Nameservers: []string{"192.168.1.1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.ResolverCount)
+
+	// settings of the first profile are not carried over
+	assert.Empty(t, resolved.searchDomains)
+	assert.Empty(t, resolved.routingDomains)
+	assert.Empty(t, resolved.tlsServerNames)
+	assert.Contains(t, busctl.calls, linkDNSArgs(lo.Index, []string{"192.168.1.1"}))
+	assert.Contains(t, busctl.calls, linkDomainsArgs(lo.Index, linkRoutingDomains(nil), nil))
+	assert.NotContains(t, busctl.methods(), "SetLinkDNSEx")
+	assert.Equal(t, []string{"192.168.1.1"}, ds.nameservers)
+
+	require.Len(t, analytics.configuredEvents, 2)
+	assert.Equal(t, "threat-protection", analytics.configuredEvents[0].profile)
+	assert.Equal(t, "lan", analytics.configuredEvents[1].profile)
+	assert.Equal(t, profileTrigger, analytics.configuredEvents[1].trigger)
+	assert.Equal(t, 0, analytics.configuredEvents[1].searchDomainCount)
+}
+
+func Test_ApplyProfileRevertsPreviousMethod(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	first := &recordingMethod{name: "first", calls: &calls}
+	second := &recordingMethod{name: "second", calls: &calls}
+	ds := newTestSetter(&mockAnalytics{}, first, second)
+
+	_, err := ds.ApplyProfile(context.Background(), "lo", Profile{Name: "vpn", Nameservers: []string{"103.86.96.100"}})
+	require.NoError(t, err)
+
+	first.setErr = errors.New("not available")
+	_, err = ds.ApplyProfile(context.Background(), "lo", Profile{Name: "lan", Nameservers: []string{"192.168.1.1"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"set first", "set first", "set second", "unset first"}, calls)
+	assert.Equal(t, []string{"192.168.1.1"}, second.lastSet)
+}
+
+func Test_ApplyProfileInvalid(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name    string
+		profile Profile
+	}{
+		{name: "no name", profile: Profile{Nameservers: []string{"103.86.96.100"}}},
+		{name: "uppercase name", profile: Profile{Name: "LAN", Nameservers: []string{"103.86.96.100"}}},
+		{name: "name with spaces", profile: Profile{Name: "vpn dns", Nameservers: []string{"103.86.96.100"}}},
+		{name: "no nameservers", profile: Profile{Name: "vpn"}},
+		{
+			name:    "invalid search domain",
+			profile: Profile{Name: "vpn", Nameservers: []string{"103.86.96.100"}, SearchDomains: []string{"-"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := []string{}
+			ds := newTestSetter(&mockAnalytics{}, &recordingMethod{name: "method", calls: &calls})
+
+			_, err := ds.ApplyProfile(context.Background(), "lo", test.profile)
+			assert.Error(t, err)
+			assert.Empty(t, calls)
+		})
+	}
+}
+
+func Test_SetAfterProfileIsNotAnnotated(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	calls := []string{}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, &recordingMethod{name: "method", calls: &calls})
+
+	_, err := ds.ApplyProfile(context.Background(), "lo", Profile{Name: "vpn", Nameservers: []string{"103.86.96.100"}})
+	require.NoError(t, err)
+	require.NoError(t, ds.Set("lo", []string{"103.86.99.100"}))
+
+	require.Len(t, analytics.configuredEvents, 2)
+	assert.Equal(t, "vpn", analytics.configuredEvents[0].profile)
+	assert.Equal(t, "", analytics.configuredEvents[1].profile)
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+const (
+	// defaultReconcileJitter is the fraction of the reconciliation interval by which every check
+	// is moved randomly, so that the checks of many hosts do not happen at the same time
+	defaultReconcileJitter = 0.2
+	// maxReconcileJitter keeps the reconciliation interval from getting close to zero
+	maxReconcileJitter = 0.5
+)
+
+// Reconcile periodically checks if resolv.conf written by NordVPN still contains its
+// nameservers and re-applies DNS if it does not. It is a safety net for file systems where
+// changes are not reported to the resolv.conf monitor, e.g. overlayfs in containers. Every
+// check is moved randomly by up to 20% of the interval, see SetReconcileJitter. Blocks until ctx
+// is done.
+func (d *DefaultSetter) Reconcile(ctx context.Context, interval time.Duration) {
+	for {
+		timer := d.clock.NewTimer(d.nextReconcileInterval(interval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+			d.reconcile(ctx)
+		}
+	}
+}
+
+// SetReconcileJitter sets the fraction of the reconciliation interval by which every check is
+// moved randomly, e.g. 0.2 for ±20%. It spreads out the checks, and the events they emit, of the
+// hosts which reconcile DNS on the same interval. Jitter is disabled when it is 0 and it is
+// limited to 0.5. The change takes effect with the next check.
+func (d *DefaultSetter) SetReconcileJitter(jitter float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reconcileJitter = min(max(jitter, 0), maxReconcileJitter)
+}
+
+// nextReconcileInterval returns the time until the next reconciliation check
+func (d *DefaultSetter) nextReconcileInterval(interval time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return jitteredInterval(interval, d.reconcileJitter, d.jitterSource)
+}
+
+// jitteredInterval returns the interval moved randomly by up to the jitter fraction of it in
+// either direction
+func jitteredInterval(interval time.Duration, jitter float64, source *rand.Rand) time.Duration {
+	if jitter <= 0 || source == nil {
+		return interval
+	}
+	offset := (source.Float64()*2 - 1) * jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
+// reconcile re-applies DNS if resolv.conf drifted from the nameservers written by NordVPN
+func (d *DefaultSetter) reconcile(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active == nil || !d.monitor.drifted() {
+		return
+	}
+	d.logger.Warn("resolv.conf no longer contains the nameservers set by NordVPN, re-applying dns")
+	d.analytics.emitDNSConfigurationErrorEvent(ctx, revertedAfterWriteErrorType, severityByType)
+	if _, err := d.set(d.iface, d.nameservers, d.source, reconcileTrigger); err != nil {
+		d.logger.Error("re-applying dns after reconciliation:", err)
+	}
+}
+
+// drifted checks if resolv.conf content differs from the nameservers written by NordVPN. Returns
+// false when the monitor is not running, because then resolv.conf is not managed by NordVPN.
+func (m *resolvConfFileWatcherMonitor) drifted() bool {
+	m.mu.Lock()
+	expected := m.expected
+	m.mu.Unlock()
+	if expected == nil {
+		return false
+	}
+	// missing resolv.conf is a drift as well
+	content, _ := internal.FileRead(m.filePath)
+	return !sameNameservers(nameserversFromResolvConf(content), expected)
+}
@@ -0,0 +1,126 @@
+package dns
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Reconcile(t *testing.T) {
+	category.Set(t, category.File)
+
+	const interval = 30 * time.Second
+	analytics := &mockAnalytics{}
+	calls := []string{}
+	method := &recordingMethod{name: "method", calls: &calls}
+	ds := newTestSetter(analytics, method)
+	clock := newFakeClock()
+	ds.clock = clock
+	ds.monitor = newTestMonitor(t, analytics)
+	require.NoError(t, ds.Set("nordlynx", testVPNNameservers))
+	// resolv.conf written by NordVPN is monitored, but the watcher does not report its changes
+	ds.monitor.expected = testVPNNameservers
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ds.Reconcile(ctx, interval)
+		close(done)
+	}()
+	waitForTimer := func() {
+		require.Eventually(t, func() bool { return clock.pendingTimers() == 1 }, time.Second, time.Millisecond)
+	}
+
+	// nothing is re-applied while resolv.conf contains the expected nameservers
+	waitForTimer()
+	clock.Advance(interval)
+	waitForTimer()
+	assert.Equal(t, []string{"set method"}, calls)
+	assert.Empty(t, analytics.getErrorEvents())
+
+	require.NoError(t, os.WriteFile(ds.monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+	clock.Advance(interval)
+	analytics.waitForEvent(t)
+	waitForTimer()
+	assert.Equal(t, []string{"set method", "set method"}, calls)
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: revertedAfterWriteErrorType, critical: true}},
+		analytics.getErrorEvents())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reconciliation was not stopped")
+	}
+	assert.Equal(t, 0, clock.pendingTimers())
+}
+
+func Test_JitteredInterval(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	const interval = time.Minute
+	source := rand.New(rand.NewPCG(1, 2))
+	intervals := map[time.Duration]bool{}
+	for range 1000 {
+		next := jitteredInterval(interval, defaultReconcileJitter, source)
+		assert.GreaterOrEqual(t, next, interval-12*time.Second)
+		assert.LessOrEqual(t, next, interval+12*time.Second)
+		intervals[next] = true
+	}
+	assert.Greater(t, len(intervals), 1, "interval should be randomized")
+
+	// same seed gives the same intervals
+	first := jitteredInterval(interval, defaultReconcileJitter, rand.New(rand.NewPCG(3, 4)))
+	second := jitteredInterval(interval, defaultReconcileJitter, rand.New(rand.NewPCG(3, 4)))
+	assert.Equal(t, first, second)
+
+	assert.Equal(t, interval, jitteredInterval(interval, 0, source))
+	assert.Equal(t, interval, jitteredInterval(interval, defaultReconcileJitter, nil))
+}
+
+func Test_SetReconcileJitter(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		jitter   float64
+		expected float64
+	}{
+		{jitter: 0.1, expected: 0.1},
+		{jitter: -1, expected: 0},
+		{jitter: 2, expected: maxReconcileJitter},
+	}
+	for _, test := range tests {
+		ds := newTestSetter(&mockAnalytics{})
+		ds.SetReconcileJitter(test.jitter)
+		assert.Equal(t, test.expected, ds.reconcileJitter)
+	}
+}
+
+func Test_ReconcileWithJitter(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	const interval = time.Minute
+	ds := newTestSetter(&mockAnalytics{})
+	clock := newFakeClock()
+	ds.clock = clock
+	ds.jitterSource = rand.New(rand.NewPCG(1, 2))
+	ds.SetReconcileJitter(defaultReconcileJitter)
+	expected := jitteredInterval(interval, defaultReconcileJitter, rand.New(rand.NewPCG(1, 2)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ds.Reconcile(ctx, interval)
+	require.Eventually(t, func() bool { return clock.pendingTimers() == 1 }, time.Second, time.Millisecond)
+
+	clock.Advance(expected - time.Nanosecond)
+	assert.Equal(t, 1, clock.pendingTimers(), "check should not happen before the jittered interval")
+	clock.Advance(time.Nanosecond)
+	require.Eventually(t, func() bool { return clock.pendingTimers() == 1 }, time.Second, time.Millisecond)
+}
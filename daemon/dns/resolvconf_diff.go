@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"slices"
+	"strings"
+)
+
+// resolvConfDiff summarizes the change of resolv.conf. Content is not included by default,
+// because it may contain internal hostnames.
+type resolvConfDiff struct {
+	LinesAdded           int  `json:"lines_added"`
+	LinesRemoved         int  `json:"lines_removed"`
+	NameserversAdded     int  `json:"nameservers_added"`
+	NameserversRemoved   int  `json:"nameservers_removed"`
+	SearchDomainsChanged bool `json:"search_domains_changed"`
+	// PreviousContent and Content are included only when content reporting is enabled for
+	// debugging
+	PreviousContent string `json:"previous_content,omitempty"`
+	Content         string `json:"content,omitempty"`
+}
+
+// resolvConfOverwrite is a change of resolv.conf made by a third party
+type resolvConfOverwrite struct {
+	// op is the file operation reported by fsnotify, e.g. WRITE
+	op   string
+	diff resolvConfDiff
+}
+
+// diffResolvConf compares two versions of resolv.conf line by line, ignoring the order of lines
+func diffResolvConf(previous []byte, current []byte, includeContent bool) resolvConfDiff {
+	diff := resolvConfDiff{SearchDomainsChanged: !slices.Equal(
+		searchDomainsFromResolvConf(previous),
+		searchDomainsFromResolvConf(current),
+	)}
+	diff.LinesAdded, diff.LinesRemoved = countChanges(
+		resolvConfLines(previous),
+		resolvConfLines(current),
+	)
+	diff.NameserversAdded, diff.NameserversRemoved = countChanges(
+		nameserversFromResolvConf(previous),
+		nameserversFromResolvConf(current),
+	)
+	if includeContent {
+		diff.PreviousContent = string(previous)
+		diff.Content = string(current)
+	}
+	return diff
+}
+
+// countChanges returns the number of items which are in current but not in previous and the
+// number of items which are in previous but not in current. Duplicates are counted separately.
+func countChanges(previous []string, current []string) (added int, removed int) {
+	remaining := map[string]int{}
+	for _, item := range previous {
+		remaining[item]++
+	}
+	for _, item := range current {
+		if remaining[item] > 0 {
+			remaining[item]--
+			continue
+		}
+		added++
+	}
+	for _, count := range remaining {
+		removed += count
+	}
+	return added, removed
+}
+
+// resolvConfLines returns non-empty lines of resolv.conf with whitespace normalized
+func resolvConfLines(content []byte) []string {
+	lines := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return lines
+}
+
+// searchDomainsFromResolvConf returns domains from the last search or domain line, which is the
+// one used by the resolver
+func searchDomainsFromResolvConf(content []byte) []string {
+	domains := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && (fields[0] == "search" || fields[0] == "domain") {
+			domains = fields[1:]
+		}
+	}
+	return domains
+}
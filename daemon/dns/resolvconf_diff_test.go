@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DiffResolvConf(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	previous := "# Generated by NordVPN\nnameserver 103.86.96.100\nnameserver 103.86.99.100\nsearch corp.example.com\n"
+	tests := []struct {
+		name     string
+		previous string
+		current  string
+		diff     resolvConfDiff
+	}{
+		{
+			name:     "no changes",
+			previous: previous,
+			current:  previous,
+		},
+		{
+			name:     "whitespace and order are ignored",
+			previous: previous,
+			current:  "search   corp.example.com\nnameserver 103.86.99.100\n\n# Generated by NordVPN\nnameserver\t103.86.96.100\n",
+		},
+		{
+			name:     "nameservers replaced",
+			previous: previous,
+			current:  "nameserver 192.168.1.1\nsearch corp.example.com\n",
+			diff: resolvConfDiff{
+				LinesAdded:         1,
+				LinesRemoved:       3,
+				NameserversAdded:   1,
+				NameserversRemoved: 2,
+			},
+		},
+		{
+			name:     "nameserver added and search domains changed",
+			previous: previous,
+			current:  previous + "nameserver 8.8.8.8\nsearch lan\n",
+			diff: resolvConfDiff{
+				LinesAdded:           2,
+				NameserversAdded:     1,
+				SearchDomainsChanged: true,
+			},
+		},
+		{
+			name:    "file created",
+			current: previous,
+			diff: resolvConfDiff{
+				LinesAdded:           4,
+				NameserversAdded:     2,
+				SearchDomainsChanged: true,
+			},
+		},
+		{
+			name:     "duplicate nameserver",
+			previous: previous,
+			current:  previous + "nameserver 103.86.96.100\n",
+			diff:     resolvConfDiff{LinesAdded: 1, NameserversAdded: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.diff, diffResolvConf([]byte(test.previous), []byte(test.current), false))
+		})
+	}
+}
+
+func Test_DiffResolvConfIncludesContent(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	diff := diffResolvConf([]byte("nameserver 1.1.1.1\n"), []byte("nameserver 8.8.8.8\n"), true)
+	assert.Equal(t, resolvConfDiff{
+		LinesAdded:         1,
+		LinesRemoved:       1,
+		NameserversAdded:   1,
+		NameserversRemoved: 1,
+		PreviousContent:    "nameserver 1.1.1.1\n",
+		Content:            "nameserver 8.8.8.8\n",
+	}, diff)
+}
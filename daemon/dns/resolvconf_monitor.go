@@ -0,0 +1,613 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// reapplyLoopThreshold is the number of re-applies within reapplyLoopWindow after which
+	// re-applying is given up, because another DNS manager keeps overwriting resolv.conf
+	reapplyLoopThreshold = 5
+	reapplyLoopWindow    = time.Minute
+	// maxWatcherRecreations is the number of times the watcher is recreated after it failed,
+	// before monitoring is given up
+	maxWatcherRecreations = 3
+	// defaultOwnWriteGracePeriod is how long after NordVPN wrote resolv.conf its content is not
+	// reported as a third party change
+	defaultOwnWriteGracePeriod = 2 * time.Second
+	// defaultPollInterval is how often resolv.conf is checked when it can't be watched
+	defaultPollInterval = 10 * time.Second
+)
+
+var (
+	// resolvedResolvConfPaths are the files generated by systemd-resolved, which can be written
+	// instead of resolv.conf symlink
+	resolvedResolvConfPaths = []string{
+		"/run/systemd/resolve/resolv.conf",
+		"/run/systemd/resolve/stub-resolv.conf",
+	}
+)
+
+// resolvConfFileWatcherMonitor watches resolv.conf while NordVPN edits it directly and
+// reports changes made to it by third parties.
+type resolvConfFileWatcherMonitor struct {
+	analytics      analytics
+	logger         Logger
+	getWatcherFunc func() (*fsnotify.Watcher, error)
+	// filePath is the authoritative file, its content is checked whenever any of the watched
+	// files changes
+	filePath string
+	// watchPaths are the additional files which changes are handled
+	watchPaths []string
+	backupPath string
+	// expected are the nameservers written by NordVPN
+	expected []string
+	// original are the nameservers configured before connecting to VPN
+	original []string
+	// previous is the last known content of resolv.conf, used to summarize the changes
+	previous []byte
+	// includeContent adds raw resolv.conf content to the reported changes, it may contain
+	// internal hostnames, so it should be enabled only for debugging
+	includeContent bool
+	// reapply is called when resolv.conf was overwritten, nothing is re-applied when it is nil
+	reapply func()
+	// reapplies are the times of re-applies within the last reapplyLoopWindow
+	reapplies []time.Time
+	// reapplyGivenUp is set when resolv.conf was overwritten right after every re-apply
+	reapplyGivenUp bool
+	// pauses is the number of Pause calls not matched by Resume yet, the monitor ignores all of
+	// the changes while it is not zero
+	pauses int
+	// ownWriteGracePeriod is how long the content written by NordVPN is not reported as a change
+	ownWriteGracePeriod time.Duration
+	// ownWrite is the hash of resolv.conf content written by NordVPN, changes to this content are
+	// ignored until ownWriteExpiry
+	ownWrite       [sha256.Size]byte
+	ownWriteExpiry time.Time
+	// pollInterval is how often resolv.conf is checked when the inotify watch limit is reached
+	pollInterval time.Duration
+	clock        clock
+	watcher      *fsnotify.Watcher
+	// cancel stops publishing events of the running monitor
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// monitorOption configures resolvConfFileWatcherMonitor
+type monitorOption func(*resolvConfFileWatcherMonitor)
+
+// withWatcherFactory replaces the function creating fsnotify watchers
+func withWatcherFactory(getWatcherFunc func() (*fsnotify.Watcher, error)) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.getWatcherFunc = getWatcherFunc
+	}
+}
+
+// withResolvConfPath replaces the path of the monitored resolv.conf
+func withResolvConfPath(path string) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.filePath = path
+	}
+}
+
+// withWatchPaths replaces the additional files which changes make the monitor check resolv.conf
+func withWatchPaths(paths ...string) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.watchPaths = paths
+	}
+}
+
+// withBackupPath replaces the path of resolv.conf backup, which pre-VPN nameservers are read from
+func withBackupPath(path string) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.backupPath = path
+	}
+}
+
+// withOwnWriteGracePeriod sets how long the content written by NordVPN is not reported as a
+// third party change
+func withOwnWriteGracePeriod(period time.Duration) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.ownWriteGracePeriod = period
+	}
+}
+
+// withPollInterval sets how often resolv.conf is checked when it can't be watched
+func withPollInterval(interval time.Duration) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.pollInterval = interval
+	}
+}
+
+// withClock replaces the clock used for the grace period, re-apply loop detection and polling
+func withClock(clock clock) monitorOption {
+	return func(m *resolvConfFileWatcherMonitor) {
+		m.clock = clock
+	}
+}
+
+// newResolvConfFileWatcherMonitor creates the monitor of /etc/resolv.conf, options override the
+// defaults
+func newResolvConfFileWatcherMonitor(
+	analytics analytics,
+	logger Logger,
+	opts ...monitorOption,
+) *resolvConfFileWatcherMonitor {
+	monitor := &resolvConfFileWatcherMonitor{
+		analytics:           analytics,
+		logger:              logger,
+		getWatcherFunc:      fsnotify.NewWatcher,
+		filePath:            resolvconfFilePath,
+		watchPaths:          resolvedResolvConfPaths,
+		backupPath:          resolvconfBackupPath,
+		ownWriteGracePeriod: defaultOwnWriteGracePeriod,
+		pollInterval:        defaultPollInterval,
+		clock:               realClock{},
+	}
+	for _, opt := range opts {
+		opt(monitor)
+	}
+	return monitor
+}
+
+// Start monitoring resolv.conf. expected are the nameservers written by NordVPN. Pre-VPN
+// nameservers are taken from the resolv.conf backup. Calling Start while the monitor is
+// running restarts it with the new nameservers. resolv.conf does not have to exist, its
+// creation is reported as a change.
+func (m *resolvConfFileWatcherMonitor) Start(expected []string) error {
+	m.Stop()
+
+	var original []string
+	if backup, err := internal.FileRead(m.backupPath); err == nil {
+		original = nameserversFromResolvConf(backup)
+	} else {
+		m.logger.Warn("reading resolv.conf backup:", err)
+	}
+
+	watcher, target, err := m.newWatcher()
+	limitExceeded := isWatchLimitExceeded(err)
+	if err != nil && !limitExceeded {
+		return err
+	}
+	// file may not exist, then its creation is reported as added lines
+	previous, _ := internal.FileRead(m.filePath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expected = slices.Clone(expected)
+	m.original = original
+	m.previous = previous
+	m.watcher = watcher
+	m.done = make(chan struct{})
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(context.Background())
+	if limitExceeded {
+		m.watchLimitExceeded(ctx, err)
+		go func(done chan struct{}) {
+			defer close(done)
+			m.poll(ctx)
+		}(m.done)
+		return nil
+	}
+	go m.watch(ctx, watcher, m.done, target)
+	return nil
+}
+
+// isWatchLimitExceeded checks if the file could not be watched, because the inotify watch limit
+// was reached
+func isWatchLimitExceeded(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// watchLimitExceeded reports that resolv.conf is polled instead of watched
+func (m *resolvConfFileWatcherMonitor) watchLimitExceeded(ctx context.Context, err error) {
+	m.logger.Error(fmt.Sprintf(
+		"resolv.conf can't be watched, inotify watch limit was reached, checking it every %v "+
+			"instead. Increase fs.inotify.max_user_watches with sysctl to detect changes immediately:",
+		m.pollInterval), err)
+	m.analytics.emitDNSConfigurationErrorEvent(ctx, watchLimitExceededErrorType, severityByType)
+}
+
+// poll checks resolv.conf every pollInterval until the monitor is stopped. Only resolv.conf and
+// its symlink target are checked, changes of the additional watched files are not handled.
+func (m *resolvConfFileWatcherMonitor) poll(ctx context.Context) {
+	ticker := m.clock.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			content, _ := internal.FileRead(m.filePath)
+			m.mu.Lock()
+			changed := !bytes.Equal(content, m.previous)
+			m.mu.Unlock()
+			if changed {
+				m.handleChange(ctx, 0)
+			}
+		}
+	}
+}
+
+// newWatcher creates the watcher of resolv.conf. Returns the path resolv.conf symlink points to,
+// or empty string if resolv.conf is not a symlink.
+func (m *resolvConfFileWatcherMonitor) newWatcher() (*fsnotify.Watcher, string, error) {
+	watcher, err := m.getWatcherFunc()
+	if err != nil {
+		return nil, "", fmt.Errorf("creating watcher: %w", err)
+	}
+	// whole directory is watched, because tools often replace resolv.conf instead of writing to it
+	if err := watcher.Add(filepath.Dir(m.filePath)); err != nil {
+		_ = watcher.Close()
+		return nil, "", fmt.Errorf("adding %s to watcher: %w", m.filePath, err)
+	}
+	target := m.watchTarget(watcher)
+	m.addWatchPaths(watcher)
+	return watcher, target, nil
+}
+
+// Stop monitoring resolv.conf. It is safe to call Stop when the monitor is not running.
+func (m *resolvConfFileWatcherMonitor) Stop() {
+	m.mu.Lock()
+	watcher, done, cancel := m.watcher, m.done, m.cancel
+	m.watcher, m.done, m.cancel = nil, nil, nil
+	m.expected = nil
+	m.mu.Unlock()
+
+	if done == nil {
+		return
+	}
+	// change which is being handled must not be reported after the monitor was stopped
+	cancel()
+	// watcher is nil when resolv.conf is polled
+	if watcher != nil {
+		if err := watcher.Close(); err != nil {
+			m.logger.Warn("closing resolv.conf watcher:", err)
+		}
+	}
+	<-done
+}
+
+// watch handles changes of resolv.conf. target is the path resolv.conf symlink points to, or
+// empty string if resolv.conf is not a symlink. Watcher is recreated when it fails.
+func (m *resolvConfFileWatcherMonitor) watch(
+	ctx context.Context,
+	watcher *fsnotify.Watcher,
+	done chan struct{},
+	target string,
+) {
+	defer close(done)
+	recreations := 0
+	// recreate replaces the failed watcher, watching ends if it returns false
+	recreate := func() bool {
+		if recreations >= maxWatcherRecreations {
+			m.logger.Error("resolv.conf watcher failed too many times, resolv.conf is no longer monitored")
+			m.analytics.emitDNSConfigurationErrorEvent(ctx, watchFailedErrorType, severityByType)
+			return false
+		}
+		recreations++
+		var err error
+		watcher, target, err = m.recreateWatcher(ctx, watcher)
+		switch {
+		case isWatchLimitExceeded(err):
+			m.watchLimitExceeded(ctx, err)
+			m.poll(ctx)
+		case err != nil:
+			m.logger.Error("recreating resolv.conf watcher, resolv.conf is no longer monitored:", err)
+			m.analytics.emitDNSConfigurationErrorEvent(ctx, watchFailedErrorType, severityByType)
+		}
+		return watcher != nil
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				if ctx.Err() == nil && m.watcherFailed(ctx, errors.New("watcher closed")) && recreate() {
+					continue
+				}
+				return
+			}
+			if filepath.Dir(event.Name) == filepath.Dir(m.filePath) {
+				// symlink could have been changed or its target directory created
+				target = m.watchTarget(watcher)
+			}
+			if !m.isWatchedPath(event.Name, target) {
+				continue
+			}
+			// attribute changes are handled as well, because content written by some tools is
+			// reported only together with them
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) ||
+				event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) || event.Has(fsnotify.Chmod) {
+				m.logger.Debug("watched file changed:", event.Name, event.Op)
+				m.handleChange(ctx, event.Op)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				if ctx.Err() == nil && m.watcherFailed(ctx, errors.New("watcher closed")) && recreate() {
+					continue
+				}
+				return
+			}
+			if !m.watcherFailed(ctx, err) {
+				continue
+			}
+			if !recreate() {
+				return
+			}
+		}
+	}
+}
+
+// watcherFailed reports the watcher error. Returns true if the watcher has to be recreated.
+func (m *resolvConfFileWatcherMonitor) watcherFailed(ctx context.Context, err error) bool {
+	m.logger.Error("resolv.conf watcher error:", err)
+	// the watcher keeps running or it is recreated, so the changes are still noticed
+	m.analytics.emitDNSConfigurationErrorEvent(ctx, watchFailedErrorType, severityNonCritical)
+	if errors.Is(err, fsnotify.ErrEventOverflow) {
+		// watcher still works, but some of the changes could have been missed
+		m.handleChange(ctx, 0)
+		return false
+	}
+	return true
+}
+
+// recreateWatcher closes the failed watcher and creates a new one in its place. Returns nil
+// watcher if it can't be created or the monitor was stopped in the meantime.
+func (m *resolvConfFileWatcherMonitor) recreateWatcher(
+	ctx context.Context,
+	failed *fsnotify.Watcher,
+) (*fsnotify.Watcher, string, error) {
+	_ = failed.Close()
+	watcher, target, err := m.newWatcher()
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ctx.Err() != nil || m.watcher != failed {
+		_ = watcher.Close()
+		return nil, "", nil
+	}
+	m.watcher = watcher
+	m.logger.Info("resolv.conf watcher recreated")
+	return watcher, target, nil
+}
+
+// isWatchedPath checks if path is resolv.conf, its symlink target or one of the additional
+// watched files
+func (m *resolvConfFileWatcherMonitor) isWatchedPath(path string, target string) bool {
+	return path == m.filePath || (target != "" && path == target) || slices.Contains(m.watchPaths, path)
+}
+
+// addWatchPaths adds directories of the additional watched files to the watcher. Missing
+// directories are skipped, because the files are specific to some of the DNS managers.
+func (m *resolvConfFileWatcherMonitor) addWatchPaths(watcher *fsnotify.Watcher) {
+	for _, path := range m.watchPaths {
+		dir := filepath.Dir(path)
+		if slices.Contains(watcher.WatchList(), dir) {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			m.logger.Debug(fmt.Sprintf("watching %s: %s", path, err))
+		}
+	}
+}
+
+// watchTarget adds the directory of resolv.conf symlink target to the watcher, because writes to
+// the target are not reported for the symlink. Returns the target, or empty string if
+// resolv.conf is not a symlink.
+func (m *resolvConfFileWatcherMonitor) watchTarget(watcher *fsnotify.Watcher) string {
+	target := symlinkTarget(m.filePath)
+	if target == "" {
+		return ""
+	}
+	dir := filepath.Dir(target)
+	if slices.Contains(watcher.WatchList(), dir) {
+		return target
+	}
+	if err := watcher.Add(dir); err != nil {
+		// directory may not exist yet, adding it is retried on the next change next to resolv.conf
+		m.logger.Warn("watching resolv.conf symlink target:", err)
+	}
+	return target
+}
+
+// isResolvedDetected checks if resolv.conf points to one of the files generated by
+// systemd-resolved
+func isResolvedDetected() bool {
+	return slices.Contains(resolvedResolvConfPaths, symlinkTarget(resolvconfFilePath))
+}
+
+// symlinkTarget returns the path the symlink points to, or empty string if path is not a
+// symlink. Target does not have to exist.
+func symlinkTarget(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		return resolved
+	}
+	return filepath.Clean(target)
+}
+
+// handleChange reports the change of resolv.conf. op is the operation reported by fsnotify, 0 when
+// the change was found otherwise, e.g. by polling.
+func (m *resolvConfFileWatcherMonitor) handleChange(ctx context.Context, op fsnotify.Op) {
+	content, err := internal.FileRead(m.filePath)
+	if err != nil {
+		m.logger.Warn("reading resolv.conf after change:", err)
+	}
+	current := nameserversFromResolvConf(content)
+
+	m.mu.Lock()
+	expected, original, paused := m.expected, m.original, m.pauses > 0
+	ownWrite := m.isOwnWrite(content)
+	diff := diffResolvConf(m.previous, content, m.includeContent)
+	m.previous = content
+	m.mu.Unlock()
+
+	switch {
+	case paused:
+		m.logger.Debug("ignoring resolv.conf change, monitor is paused")
+	case ownWrite:
+		m.logger.Debug("ignoring resolv.conf change made by NordVPN")
+	case sameNameservers(current, expected):
+		// file was written by NordVPN or not changed in a relevant way
+	case len(original) > 0 && sameNameservers(current, original):
+		// content looks like a normal system configuration, but DNS is no longer
+		// going through the VPN
+		m.logger.Warn("resolv.conf was restored to the pre-VPN nameservers")
+		m.analytics.emitDNSConfigurationErrorEvent(ctx, revertedToOriginalErrorType, severityByType)
+		m.tryReapply(ctx)
+	default:
+		m.logger.Warn("resolv.conf was overwritten")
+		m.analytics.emitResolvConfOverwrittenEvent(ctx, fsnotifyOp(op), diff)
+		m.tryReapply(ctx)
+	}
+}
+
+// fsnotifyOp returns the name of the operation, e.g. WRITE or CREATE|CHMOD, or empty string when
+// there is none
+func fsnotifyOp(op fsnotify.Op) string {
+	if op == 0 {
+		return ""
+	}
+	return op.String()
+}
+
+// Pause makes the monitor ignore changes of resolv.conf until Resume is called, e.g. while
+// NordVPN writes it a few times in a row. Changes made while paused are never reported, but the
+// changes made after Resume are reported relative to them. Pauses nest, e.g. when DNS is set
+// again while the previous Set waits for a retry, the monitor stays paused until every Pause is
+// matched by Resume.
+func (m *resolvConfFileWatcherMonitor) Pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pauses++
+}
+
+// Resume reports changes of resolv.conf again after the last of the nested Pause calls. Calling
+// Resume when the monitor is not paused does nothing.
+func (m *resolvConfFileWatcherMonitor) Resume() {
+	content, _ := internal.FileRead(m.filePath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pauses == 0 {
+		return
+	}
+	m.pauses--
+	if m.pauses > 0 {
+		return
+	}
+	// the content written while paused is the baseline of the changes reported after resuming,
+	// even if the events of the writes were not handled yet
+	m.previous = content
+	// changes made while paused can still be delivered after resuming
+	m.setOwnWrite(content)
+}
+
+// expectWrite marks content as written by NordVPN, so that the changes it causes are not reported
+// within the grace period. Write events can be delivered after the monitor was restarted, when
+// the nameservers it expects do not match the written ones anymore.
+func (m *resolvConfFileWatcherMonitor) expectWrite(content []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setOwnWrite(content)
+}
+
+// setOwnWrite starts the grace period of the content, must be called with mu locked
+func (m *resolvConfFileWatcherMonitor) setOwnWrite(content []byte) {
+	m.ownWrite = sha256.Sum256(content)
+	m.ownWriteExpiry = m.clock.Now().Add(m.ownWriteGracePeriod)
+}
+
+// isOwnWrite checks if content was written by NordVPN within the grace period, must be called
+// with mu locked
+func (m *resolvConfFileWatcherMonitor) isOwnWrite(content []byte) bool {
+	if content == nil || !m.clock.Now().Before(m.ownWriteExpiry) {
+		return false
+	}
+	return sha256.Sum256(content) == m.ownWrite
+}
+
+// tryReapply calls the re-apply callback unless it was called too many times recently, which
+// means that another DNS manager overwrites resolv.conf every time it is written. Re-applying
+// is given up then, until resetReapplies is called.
+func (m *resolvConfFileWatcherMonitor) tryReapply(ctx context.Context) {
+	m.mu.Lock()
+	reapply := m.reapply
+	if reapply == nil || m.reapplyGivenUp {
+		m.mu.Unlock()
+		return
+	}
+	now := m.clock.Now()
+	m.reapplies = slices.DeleteFunc(m.reapplies, func(reappliedAt time.Time) bool {
+		return now.Sub(reappliedAt) >= reapplyLoopWindow
+	})
+	if len(m.reapplies) >= reapplyLoopThreshold {
+		m.reapplyGivenUp = true
+		m.mu.Unlock()
+		m.logger.Error(fmt.Sprintf(
+			"resolv.conf was overwritten %d times within %v after re-applying dns, giving up",
+			len(m.reapplies), reapplyLoopWindow))
+		m.analytics.emitDNSConfigurationErrorEvent(ctx, reapplyLoopErrorType, severityByType)
+		return
+	}
+	m.reapplies = append(m.reapplies, now)
+	m.mu.Unlock()
+
+	m.logger.Info("re-applying dns after resolv.conf was overwritten")
+	// re-applying restarts the monitor, so it must not block the watching goroutine
+	go reapply()
+}
+
+// setReapply sets the callback which re-applies DNS after resolv.conf was overwritten, nil
+// disables re-applying
+func (m *resolvConfFileWatcherMonitor) setReapply(reapply func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reapply = reapply
+}
+
+// resetReapplies allows re-applying DNS again after it was given up
+func (m *resolvConfFileWatcherMonitor) resetReapplies() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reapplies = nil
+	m.reapplyGivenUp = false
+}
+
+// setIncludeContent enables reporting raw resolv.conf content together with its changes
+func (m *resolvConfFileWatcherMonitor) setIncludeContent(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.includeContent = enabled
+}
+
+// sameNameservers checks if both lists contain the same nameservers regardless of their order
+func sameNameservers(a []string, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
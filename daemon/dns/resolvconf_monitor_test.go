@@ -0,0 +1,705 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testOriginalResolvConf = "nameserver 192.168.1.1\nsearch lan\n"
+	testVPNResolvConf      = resolvconfFileMark + "\nnameserver 103.86.96.100\nnameserver 103.86.99.100\n"
+)
+
+var testVPNNameservers = []string{"103.86.96.100", "103.86.99.100"}
+
+func newTestMonitor(t *testing.T, analytics analytics, opts ...monitorOption) *resolvConfFileWatcherMonitor {
+	t.Helper()
+	dir := t.TempDir()
+	opts = append([]monitorOption{
+		withResolvConfPath(filepath.Join(dir, "resolv.conf")),
+		withWatchPaths(),
+		withBackupPath(filepath.Join(dir, "resolv.conf.bak")),
+	}, opts...)
+	monitor := newResolvConfFileWatcherMonitor(analytics, defaultLogger{}, opts...)
+	require.NoError(t, os.WriteFile(monitor.backupPath, []byte(testOriginalResolvConf), 0644))
+	require.NoError(t, os.WriteFile(monitor.filePath, []byte(testVPNResolvConf), 0644))
+	return monitor
+}
+
+// replaceFile replaces the file atomically, so that its partial content is never read
+func replaceFile(t *testing.T, path string, content string) {
+	t.Helper()
+	tmpPath := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmpPath, []byte(content), 0644))
+	require.NoError(t, os.Rename(tmpPath, path))
+}
+
+func Test_NewResolvConfFileWatcherMonitorOptions(t *testing.T) {
+	category.Set(t, category.File)
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "resolv.conf")
+	backupPath := filepath.Join(dir, "resolv.conf.bak")
+	require.NoError(t, os.WriteFile(backupPath, []byte(testOriginalResolvConf), 0644))
+	require.NoError(t, os.WriteFile(filePath, []byte(testVPNResolvConf), 0644))
+	clock := newFakeClock()
+	created := 0
+	factory := func() (*fsnotify.Watcher, error) {
+		created++
+		return fsnotify.NewWatcher()
+	}
+
+	analytics := &mockAnalytics{}
+	monitor := newResolvConfFileWatcherMonitor(analytics, defaultLogger{},
+		withWatcherFactory(factory),
+		withResolvConfPath(filePath),
+		withWatchPaths(),
+		withBackupPath(backupPath),
+		withOwnWriteGracePeriod(time.Minute),
+		withClock(clock),
+	)
+	assert.Equal(t, filePath, monitor.filePath)
+	assert.Empty(t, monitor.watchPaths)
+	assert.Equal(t, backupPath, monitor.backupPath)
+	assert.Equal(t, time.Minute, monitor.ownWriteGracePeriod)
+	assert.Equal(t, clock, monitor.clock)
+
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+	assert.Equal(t, 1, created, "watcher should be created by the factory")
+	assert.Equal(t, nameserversFromResolvConf([]byte(testOriginalResolvConf)), monitor.original)
+
+	replaceFile(t, filePath, "nameserver 8.8.8.8\n")
+	analytics.waitForEvent(t)
+	assert.NotEmpty(t, analytics.getOverwrittenEvents())
+}
+
+func Test_NewResolvConfFileWatcherMonitorDefaults(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	monitor := newResolvConfFileWatcherMonitor(&mockAnalytics{}, defaultLogger{})
+	assert.Equal(t, resolvconfFilePath, monitor.filePath)
+	assert.Equal(t, resolvedResolvConfPaths, monitor.watchPaths)
+	assert.Equal(t, resolvconfBackupPath, monitor.backupPath)
+	assert.Equal(t, defaultOwnWriteGracePeriod, monitor.ownWriteGracePeriod)
+	assert.Equal(t, defaultPollInterval, monitor.pollInterval)
+	assert.Equal(t, realClock{}, monitor.clock)
+}
+
+func Test_ResolvConfMonitorOverwrite(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	require.NoError(t, os.WriteFile(monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	analytics.waitForEvent(t)
+	assert.NotEmpty(t, analytics.getOverwrittenEvents())
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_ResolvConfMonitorOverwriteDiff(t *testing.T) {
+	category.Set(t, category.File)
+
+	for _, includeContent := range []bool{false, true} {
+		analytics := &mockAnalytics{}
+		monitor := newTestMonitor(t, analytics)
+		monitor.setIncludeContent(includeContent)
+		require.NoError(t, monitor.Start(testVPNNameservers))
+
+		content := "nameserver 8.8.8.8\nsearch corp.example.com\n"
+		replaceFile(t, monitor.filePath, content)
+
+		analytics.waitForEvent(t)
+		monitor.Stop()
+		expected := resolvConfDiff{
+			LinesAdded:           2,
+			LinesRemoved:         3,
+			NameserversAdded:     1,
+			NameserversRemoved:   2,
+			SearchDomainsChanged: true,
+		}
+		if includeContent {
+			expected.PreviousContent = testVPNResolvConf
+			expected.Content = content
+		}
+		assert.Equal(t, []resolvConfDiff{expected}, analytics.getOverwrittenEvents())
+	}
+}
+
+func Test_ResolvConfMonitorIgnoresOwnWrite(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	clock := newFakeClock()
+	monitor := newTestMonitor(t, analytics, withClock(clock))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	own := "nameserver 10.0.0.1\n"
+	monitor.expectWrite([]byte(own))
+	replaceFile(t, monitor.filePath, own)
+	// change has to be handled before the file is overwritten again, otherwise both writes are
+	// seen as one
+	assert.Eventually(t, func() bool {
+		monitor.mu.Lock()
+		defer monitor.mu.Unlock()
+		return string(monitor.previous) == own
+	}, 5*time.Second, 10*time.Millisecond)
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+
+	analytics.waitForEvent(t)
+	assert.Equal(t, []resolvConfDiff{{
+		LinesAdded:         1,
+		LinesRemoved:       1,
+		NameserversAdded:   1,
+		NameserversRemoved: 1,
+	}}, analytics.getOverwrittenEvents(), "only the third party write should be reported")
+
+	clock.Advance(defaultOwnWriteGracePeriod)
+	replaceFile(t, monitor.filePath, own)
+
+	analytics.waitForEvent(t)
+	assert.Len(t, analytics.getOverwrittenEvents(), 2, "own write should be reported after the grace period")
+}
+
+func Test_ResolvConfMonitorPause(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	monitor.Pause()
+	monitor.Pause()
+	for _, content := range []string{"nameserver 10.0.0.1\n", "nameserver 10.0.0.2\n"} {
+		replaceFile(t, monitor.filePath, content)
+		assert.Eventually(t, func() bool {
+			monitor.mu.Lock()
+			defer monitor.mu.Unlock()
+			return string(monitor.previous) == content
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	monitor.Resume()
+	monitor.Resume()
+
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+	analytics.waitForEvent(t)
+	assert.Equal(t, []resolvConfDiff{{
+		LinesAdded:         1,
+		LinesRemoved:       1,
+		NameserversAdded:   1,
+		NameserversRemoved: 1,
+	}}, analytics.getOverwrittenEvents(), "only the change made after resuming should be reported")
+}
+
+func Test_ResolvConfMonitorNestedPause(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	// e.g. DNS is set again while the previous Set waits for a retry
+	monitor.Pause()
+	monitor.Pause()
+	monitor.Resume()
+	replaceFile(t, monitor.filePath, "nameserver 10.0.0.1\n")
+	assert.Eventually(t, func() bool {
+		monitor.mu.Lock()
+		defer monitor.mu.Unlock()
+		return string(monitor.previous) == "nameserver 10.0.0.1\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, analytics.getOverwrittenEvents(), "monitor should stay paused until the outer Resume")
+
+	monitor.Resume()
+	// more Resume calls than Pause calls must not make the next Pause ineffective
+	monitor.Resume()
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+	analytics.waitForEvent(t)
+	assert.Len(t, analytics.getOverwrittenEvents(), 1)
+
+	monitor.Pause()
+	monitor.mu.Lock()
+	assert.Equal(t, 1, monitor.pauses)
+	monitor.mu.Unlock()
+	monitor.Resume()
+}
+
+func Test_ResolvConfMonitorResumeRefreshesSnapshot(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		name   string
+		paused time.Duration
+	}{
+		{name: "paused shortly", paused: time.Second},
+		{name: "paused for long", paused: time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			clock := newFakeClock()
+			monitor := newTestMonitor(t, analytics, withClock(clock))
+			require.NoError(t, monitor.Start(testVPNNameservers))
+			defer monitor.Stop()
+
+			monitor.Pause()
+			replaceFile(t, monitor.filePath, "nameserver 10.0.0.1\n")
+			assert.Eventually(t, func() bool {
+				monitor.mu.Lock()
+				defer monitor.mu.Unlock()
+				return string(monitor.previous) == "nameserver 10.0.0.1\n"
+			}, 5*time.Second, 10*time.Millisecond)
+			// events of the own write may not be handled before resuming
+			monitor.mu.Lock()
+			monitor.previous = []byte(testVPNResolvConf)
+			monitor.mu.Unlock()
+			clock.Advance(test.paused)
+			monitor.Resume()
+			monitor.mu.Lock()
+			assert.Equal(t, "nameserver 10.0.0.1\n", string(monitor.previous))
+			monitor.mu.Unlock()
+
+			// every overwrite made after resuming is reported relative to the own write
+			replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+			require.Eventually(t, func() bool {
+				return len(analytics.getOverwrittenEvents()) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+			replaceFile(t, monitor.filePath, "nameserver 9.9.9.9\n")
+			require.Eventually(t, func() bool {
+				return len(analytics.getOverwrittenEvents()) == 2
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, []resolvConfDiff{
+				{LinesAdded: 1, LinesRemoved: 1, NameserversAdded: 1, NameserversRemoved: 1},
+				{LinesAdded: 1, LinesRemoved: 1, NameserversAdded: 1, NameserversRemoved: 1},
+			}, analytics.getOverwrittenEvents())
+		})
+	}
+}
+
+func Test_ResolvConfMonitorOverwriteOp(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		name string
+		op   fsnotify.Op
+		want string
+	}{
+		{name: "write", op: fsnotify.Write, want: "WRITE"},
+		{name: "create", op: fsnotify.Create, want: "CREATE"},
+		{name: "rename", op: fsnotify.Rename, want: "RENAME"},
+		{name: "chmod", op: fsnotify.Chmod, want: "CHMOD"},
+		{name: "combined", op: fsnotify.Write | fsnotify.Chmod, want: "WRITE|CHMOD"},
+		{name: "polled", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			monitor := newTestMonitor(t, analytics)
+			monitor.expected = testVPNNameservers
+			monitor.previous = []byte(testVPNResolvConf)
+
+			require.NoError(t, os.WriteFile(monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+			monitor.handleChange(context.Background(), test.op)
+
+			assert.Equal(t, []string{test.want}, analytics.getOverwriteOps())
+		})
+	}
+}
+
+func Test_ResolvConfMonitorReapply(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	reapplied := make(chan struct{}, 1)
+	monitor.setReapply(func() { reapplied <- struct{}{} })
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+
+	select {
+	case <-reapplied:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dns was not re-applied")
+	}
+	assert.NotEmpty(t, analytics.getOverwrittenEvents())
+}
+
+func Test_ResolvConfMonitorWatcherError(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	watchers := make(chan *fsnotify.Watcher, maxWatcherRecreations+1)
+	monitor := newTestMonitor(t, analytics, withWatcherFactory(func() (*fsnotify.Watcher, error) {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			watchers <- watcher
+		}
+		return watcher, err
+	}))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	(<-watchers).Errors <- syscall.ENOSPC
+	analytics.waitForEvent(t)
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: watchFailedErrorType, critical: false}},
+		analytics.getErrorEvents())
+
+	select {
+	case <-watchers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher was not recreated")
+	}
+	// changes are still detected by the recreated watcher
+	require.Eventually(t, func() bool {
+		replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+		return len(analytics.getOverwrittenEvents()) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func Test_ResolvConfMonitorWatcherErrorLimit(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	watchers := make(chan *fsnotify.Watcher, maxWatcherRecreations+1)
+	monitor := newTestMonitor(t, analytics, withWatcherFactory(func() (*fsnotify.Watcher, error) {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			watchers <- watcher
+		}
+		return watcher, err
+	}))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	for i := 0; i <= maxWatcherRecreations; i++ {
+		select {
+		case watcher := <-watchers:
+			watcher.Errors <- syscall.ENOSPC
+		case <-time.After(5 * time.Second):
+			t.Fatal("watcher was not recreated")
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		return len(analytics.getErrorEvents()) == maxWatcherRecreations+2
+	}, 5*time.Second, 10*time.Millisecond)
+	events := analytics.getErrorEvents()
+	assert.Equal(t, mockErrorEvent{errorType: watchFailedErrorType, critical: true}, events[len(events)-1])
+}
+
+func Test_ResolvConfMonitorWatchLimitExceeded(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics,
+		withWatcherFactory(func() (*fsnotify.Watcher, error) {
+			return nil, syscall.ENOSPC
+		}),
+		withPollInterval(10*time.Millisecond))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	assert.Equal(t,
+		[]mockErrorEvent{{errorType: watchLimitExceededErrorType, critical: false}},
+		analytics.getErrorEvents())
+	// changes are detected by polling
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+	require.Eventually(t, func() bool {
+		return len(analytics.getOverwrittenEvents()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_ResolvConfMonitorPollsWithClock(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	clock := newFakeClock()
+	monitor := newTestMonitor(t, analytics,
+		withWatcherFactory(func() (*fsnotify.Watcher, error) {
+			return nil, syscall.ENOSPC
+		}),
+		withClock(clock))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+	require.Eventually(t, func() bool { return clock.pendingTickers() == 1 }, time.Second, time.Millisecond)
+
+	replaceFile(t, monitor.filePath, "nameserver 8.8.8.8\n")
+	// resolv.conf is not checked before the poll interval passes
+	clock.Advance(defaultPollInterval - time.Second)
+	assert.Never(t, func() bool {
+		return len(analytics.getOverwrittenEvents()) > 0
+	}, 50*time.Millisecond, 10*time.Millisecond)
+
+	clock.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		return len(analytics.getOverwrittenEvents()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	monitor.Stop()
+	assert.Zero(t, clock.pendingTickers())
+}
+
+func Test_ResolvConfMonitorWatcherFactoryError(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics, withWatcherFactory(func() (*fsnotify.Watcher, error) {
+		return nil, syscall.EMFILE
+	}))
+	assert.ErrorIs(t, monitor.Start(testVPNNameservers), syscall.EMFILE)
+	assert.Empty(t, analytics.getErrorEvents())
+	monitor.Stop()
+}
+
+func Test_ResolvConfMonitorReapplyLoop(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	clock := newFakeClock()
+	monitor := newResolvConfFileWatcherMonitor(analytics, defaultLogger{}, withClock(clock))
+	reapplied := make(chan struct{}, 2*reapplyLoopThreshold)
+	monitor.setReapply(func() { reapplied <- struct{}{} })
+
+	// re-applies spread over a longer time are not a loop
+	for i := 0; i < 2*reapplyLoopThreshold; i++ {
+		monitor.tryReapply(context.Background())
+		clock.Advance(reapplyLoopWindow / reapplyLoopThreshold)
+	}
+	for i := 0; i < 2*reapplyLoopThreshold; i++ {
+		<-reapplied
+	}
+	assert.Empty(t, analytics.getErrorEvents())
+
+	monitor.resetReapplies()
+	for i := 0; i < reapplyLoopThreshold; i++ {
+		monitor.tryReapply(context.Background())
+	}
+	for i := 0; i < reapplyLoopThreshold; i++ {
+		<-reapplied
+	}
+	assert.Empty(t, analytics.getErrorEvents())
+
+	monitor.tryReapply(context.Background())
+	assert.Equal(t, []mockErrorEvent{{errorType: reapplyLoopErrorType, critical: true}},
+		analytics.getErrorEvents())
+
+	// given up re-applying does not resume after the window passes
+	clock.Advance(2 * reapplyLoopWindow)
+	monitor.tryReapply(context.Background())
+	assert.Len(t, analytics.getErrorEvents(), 1)
+
+	monitor.resetReapplies()
+	monitor.tryReapply(context.Background())
+	<-reapplied
+	assert.Empty(t, reapplied)
+}
+
+func Test_ResolvConfMonitorRevertedToOriginal(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	// replace the file the same way tools restoring the configuration do
+	replaceFile(t, monitor.filePath, testOriginalResolvConf)
+
+	analytics.waitForEvent(t)
+	assert.Equal(t, []mockErrorEvent{{errorType: revertedToOriginalErrorType, critical: true}},
+		analytics.getErrorEvents())
+	assert.Empty(t, analytics.getOverwrittenEvents())
+}
+
+func Test_ResolvConfMonitorIgnoresExpectedContent(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	// same nameservers in a different order
+	replaceFile(t, monitor.filePath, "nameserver 103.86.99.100\nnameserver 103.86.96.100\n")
+	// changes are handled in order, so the previous change is handled once this one is reported
+	replaceFile(t, monitor.filePath, testOriginalResolvConf)
+
+	analytics.waitForEvent(t)
+	assert.Empty(t, analytics.getOverwrittenEvents())
+	assert.Equal(t, []mockErrorEvent{{errorType: revertedToOriginalErrorType, critical: true}},
+		analytics.getErrorEvents()[:1])
+}
+
+func Test_ResolvConfMonitorMissingFile(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, os.Remove(monitor.filePath))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	// file with the expected content is not a change
+	replaceFile(t, monitor.filePath, testVPNResolvConf)
+	require.NoError(t, os.WriteFile(monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	analytics.waitForEvent(t)
+	assert.NotEmpty(t, analytics.getOverwrittenEvents())
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_ResolvConfMonitorStopped(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor := newTestMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	monitor.Stop()
+	// stopping twice is allowed
+	monitor.Stop()
+
+	// watcher is closed and its goroutine finished, so the change can't be handled
+	require.NoError(t, os.WriteFile(monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	assert.Empty(t, analytics.getOverwrittenEvents())
+}
+
+// newTestSymlinkMonitor creates monitor for resolv.conf which is a symlink to a file in a
+// different directory, as it is done by systemd-resolved
+func newTestSymlinkMonitor(t *testing.T, analytics analytics) (*resolvConfFileWatcherMonitor, string) {
+	t.Helper()
+	monitor := newTestMonitor(t, analytics)
+	dir := filepath.Dir(monitor.filePath)
+	target := filepath.Join(dir, "run", "stub-resolv.conf")
+	require.NoError(t, os.Remove(monitor.filePath))
+	require.NoError(t, os.Symlink(target, monitor.filePath))
+	return monitor, target
+}
+
+func Test_ResolvConfMonitorSymlinkTarget(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor, target := newTestSymlinkMonitor(t, analytics)
+	require.NoError(t, os.Mkdir(filepath.Dir(target), 0755))
+	require.NoError(t, os.WriteFile(target, []byte(testVPNResolvConf), 0644))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	require.NoError(t, os.WriteFile(target, []byte("nameserver 8.8.8.8\n"), 0644))
+
+	analytics.waitForEvent(t)
+	assert.NotEmpty(t, analytics.getOverwrittenEvents())
+}
+
+func Test_ResolvConfMonitorSymlinkTargetCreatedLater(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	monitor, target := newTestSymlinkMonitor(t, analytics)
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	require.NoError(t, os.Mkdir(filepath.Dir(target), 0755))
+	assert.Eventually(t, func() bool {
+		// activity next to resolv.conf makes the monitor retry watching the target
+		require.NoError(t, os.WriteFile(monitor.backupPath, []byte(testOriginalResolvConf), 0644))
+		require.NoError(t, os.WriteFile(target, []byte("nameserver 8.8.8.8\n"), 0644))
+		return len(analytics.getOverwrittenEvents()) > 0
+	}, time.Second, 50*time.Millisecond)
+}
+
+func Test_ResolvConfMonitorWatchPaths(t *testing.T) {
+	category.Set(t, category.File)
+
+	analytics := &mockAnalytics{}
+	runDir := filepath.Join(t.TempDir(), "resolve")
+	require.NoError(t, os.Mkdir(runDir, 0755))
+	watchPath := filepath.Join(runDir, "resolv.conf")
+	monitor := newTestMonitor(t, analytics,
+		withWatchPaths(watchPath, filepath.Join(t.TempDir(), "missing", "resolv.conf")))
+	// resolv.conf was overwritten before the monitor was started, but it was not noticed
+	require.NoError(t, os.WriteFile(monitor.filePath, []byte("nameserver 8.8.8.8\n"), 0644))
+	require.NoError(t, monitor.Start(testVPNNameservers))
+	defer monitor.Stop()
+
+	// change of the additional file makes the monitor check resolv.conf
+	replaceFile(t, watchPath, "nameserver 127.0.0.53\n")
+	analytics.waitForEvent(t)
+	assert.Equal(t, []resolvConfDiff{{}}, analytics.getOverwrittenEvents())
+
+	replaceFile(t, monitor.filePath, "nameserver 1.1.1.1\n")
+	analytics.waitForEvent(t)
+	assert.Equal(t, []resolvConfDiff{{}, {
+		LinesAdded:         1,
+		LinesRemoved:       1,
+		NameserversAdded:   1,
+		NameserversRemoved: 1,
+	}}, analytics.getOverwrittenEvents())
+}
+
+func Test_NameserversFromResolvConf(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name        string
+		content     string
+		nameservers []string
+	}{
+		{
+			name:        "empty",
+			content:     "",
+			nameservers: []string{},
+		},
+		{
+			name:        "nordvpn file",
+			content:     testVPNResolvConf,
+			nameservers: testVPNNameservers,
+		},
+		{
+			name:        "with options and comments",
+			content:     "# comment\nnameserver 127.0.0.53\noptions edns0 trust-ad\nsearch lan\nnameserver ::1\n",
+			nameservers: []string{"127.0.0.53", "::1"},
+		},
+		{
+			name:        "crlf line endings",
+			content:     "nameserver 1.1.1.1\r\nnameserver 1.0.0.1\r\n",
+			nameservers: []string{"1.1.1.1", "1.0.0.1"},
+		},
+		{
+			name:        "leading and trailing whitespace",
+			content:     "  nameserver 1.1.1.1  \n\tnameserver 1.0.0.1\t\n",
+			nameservers: []string{"1.1.1.1", "1.0.0.1"},
+		},
+		{
+			name:        "tab delimited",
+			content:     "nameserver\t1.1.1.1\r\nnameserver \t 1.0.0.1\n",
+			nameservers: []string{"1.1.1.1", "1.0.0.1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.nameservers, nameserversFromResolvConf([]byte(test.content)))
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// resolvConfDirectives are the resolver settings of resolv.conf, which are carried forward when
+// NordVPN replaces the nameservers, because dropping them changes how names are resolved
+type resolvConfDirectives struct {
+	// options are the resolver options, e.g. edns0, rotate or ndots:2
+	options []string
+	// sortlist are the networks used for ordering the addresses returned by lookups
+	sortlist []string
+}
+
+// parseResolvConfDirectives returns the options and sortlist directives of resolv.conf content.
+// Options of all the lines are combined and a later value of the same option replaces the earlier
+// one, as in glibc. Comments are ignored.
+func parseResolvConfDirectives(content []byte) resolvConfDirectives {
+	directives := resolvConfDirectives{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := resolvConfFields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "options":
+			for _, option := range fields[1:] {
+				directives.options = slices.DeleteFunc(directives.options, func(existing string) bool {
+					return resolvConfOptionName(existing) == resolvConfOptionName(option)
+				})
+				directives.options = append(directives.options, option)
+			}
+		case "sortlist":
+			for _, network := range fields[1:] {
+				if !slices.Contains(directives.sortlist, network) {
+					directives.sortlist = append(directives.sortlist, network)
+				}
+			}
+		}
+	}
+	return directives
+}
+
+// resolvConfFields returns the fields of the resolv.conf line, up to a comment
+func resolvConfFields(line string) []string {
+	fields := strings.Fields(line)
+	for idx, field := range fields {
+		if strings.HasPrefix(field, "#") || strings.HasPrefix(field, ";") {
+			return fields[:idx]
+		}
+	}
+	return fields
+}
+
+// resolvConfOptionName returns the option name without its value, e.g. ndots for ndots:2
+func resolvConfOptionName(option string) string {
+	name, _, _ := strings.Cut(option, ":")
+	return name
+}
+
+// lines returns resolv.conf lines of the directives
+func (d resolvConfDirectives) lines() []string {
+	lines := []string{}
+	if len(d.options) > 0 {
+		lines = append(lines, optionsLine(d.options))
+	}
+	if len(d.sortlist) > 0 {
+		lines = append(lines, "sortlist "+strings.Join(d.sortlist, " "))
+	}
+	return lines
+}
+
+func optionsLine(options []string) string {
+	return "options " + strings.Join(options, " ")
+}
+
+// validateResolvConfOptions checks that every option can be written to the options line
+func validateResolvConfOptions(options []string) error {
+	for _, option := range options {
+		if option == "" || strings.ContainsAny(option, " \t\r\n#;") {
+			return fmt.Errorf("invalid resolv.conf option %q", option)
+		}
+	}
+	return nil
+}
+
+const (
+	// KeepResolvConfOption leaves ndots or timeout as they are in the written options
+	KeepResolvConfOption = -1
+	// maxResolvConfNdots is the biggest ndots value, glibc caps bigger ones to it
+	maxResolvConfNdots = 15
+	// maxResolvConfTimeout is the biggest timeout in seconds, glibc caps bigger ones to it
+	maxResolvConfTimeout = 30
+)
+
+// ndotsTimeoutOptions validates ndots and timeout and returns them as resolv.conf options, values
+// equal to KeepResolvConfOption are left out
+func ndotsTimeoutOptions(ndots int, timeout int) ([]string, error) {
+	options := []string{}
+	if ndots != KeepResolvConfOption {
+		if ndots < 0 || ndots > maxResolvConfNdots {
+			return nil, fmt.Errorf("ndots %d is out of range 0-%d", ndots, maxResolvConfNdots)
+		}
+		options = append(options, fmt.Sprintf("ndots:%d", ndots))
+	}
+	if timeout != KeepResolvConfOption {
+		if timeout < 1 || timeout > maxResolvConfTimeout {
+			return nil, fmt.Errorf("timeout %d is out of range 1-%d", timeout, maxResolvConfTimeout)
+		}
+		options = append(options, fmt.Sprintf("timeout:%d", timeout))
+	}
+	return options, nil
+}
+
+// overrideResolvConfOptions returns options with the overrides replacing the options of the same
+// name. When options is nil, the overrides are applied to the options of the original resolv.conf
+// content, so they are not lost.
+func overrideResolvConfOptions(original []byte, options []string, overrides []string) []string {
+	if len(overrides) == 0 {
+		return options
+	}
+	if options == nil {
+		options = parseResolvConfDirectives(original).options
+	}
+	options = slices.DeleteFunc(slices.Clone(options), func(option string) bool {
+		return slices.ContainsFunc(overrides, func(override string) bool {
+			return resolvConfOptionName(option) == resolvConfOptionName(override)
+		})
+	})
+	return append(options, overrides...)
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseResolvConfDirectives(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name       string
+		content    string
+		directives resolvConfDirectives
+	}{
+		{
+			name: "systemd-resolved stub",
+			content: "# This is /run/systemd/resolve/stub-resolv.conf managed by man:systemd-resolved(8).\n" +
+				"nameserver 127.0.0.53\noptions edns0 trust-ad\nsearch lan\n",
+			directives: resolvConfDirectives{options: []string{"edns0", "trust-ad"}},
+		},
+		{
+			name:       "kubernetes pod",
+			content:    "search default.svc.cluster.local svc.cluster.local cluster.local\nnameserver 10.96.0.10\noptions ndots:5\n",
+			directives: resolvConfDirectives{options: []string{"ndots:5"}},
+		},
+		{
+			name: "multiple options lines",
+			content: "nameserver 192.168.1.1\noptions rotate timeout:2\noptions\tattempts:3 ndots:1\n" +
+				"options timeout:1\n",
+			directives: resolvConfDirectives{options: []string{"rotate", "attempts:3", "ndots:1", "timeout:1"}},
+		},
+		{
+			name:    "sortlist",
+			content: "nameserver 192.168.1.1\nsortlist 130.155.160.0/255.255.240.0 130.155.0.0\nsortlist 10.0.0.0\n",
+			directives: resolvConfDirectives{
+				sortlist: []string{"130.155.160.0/255.255.240.0", "130.155.0.0", "10.0.0.0"},
+			},
+		},
+		{
+			name:       "comments",
+			content:    "# options rotate\n; sortlist 10.0.0.0\noptions edns0 # single-request\n",
+			directives: resolvConfDirectives{options: []string{"edns0"}},
+		},
+		{
+			name:       "crlf line endings",
+			content:    "nameserver 192.168.1.1\r\noptions edns0\r\n",
+			directives: resolvConfDirectives{options: []string{"edns0"}},
+		},
+		{
+			name:    "no directives",
+			content: "nameserver 192.168.1.1\noptions\n",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.directives, parseResolvConfDirectives([]byte(test.content)))
+		})
+	}
+}
+
+func Test_ValidateResolvConfOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.NoError(t, validateResolvConfOptions(nil))
+	assert.NoError(t, validateResolvConfOptions([]string{"edns0", "ndots:2"}))
+	assert.Error(t, validateResolvConfOptions([]string{""}))
+	assert.Error(t, validateResolvConfOptions([]string{"edns0 rotate"}))
+	assert.Error(t, validateResolvConfOptions([]string{"edns0\nnameserver 1.1.1.1"}))
+	assert.Error(t, validateResolvConfOptions([]string{"#edns0"}))
+}
+
+func Test_NdotsTimeoutOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name    string
+		ndots   int
+		timeout int
+		options []string
+		err     bool
+	}{
+		{name: "both kept", ndots: KeepResolvConfOption, timeout: KeepResolvConfOption, options: []string{}},
+		{name: "both set", ndots: 2, timeout: 5, options: []string{"ndots:2", "timeout:5"}},
+		{name: "lower bounds", ndots: 0, timeout: 1, options: []string{"ndots:0", "timeout:1"}},
+		{name: "upper bounds", ndots: 15, timeout: 30, options: []string{"ndots:15", "timeout:30"}},
+		{name: "only ndots", ndots: 3, timeout: KeepResolvConfOption, options: []string{"ndots:3"}},
+		{name: "only timeout", ndots: KeepResolvConfOption, timeout: 2, options: []string{"timeout:2"}},
+		{name: "ndots too big", ndots: 16, timeout: KeepResolvConfOption, err: true},
+		{name: "negative ndots", ndots: -2, timeout: KeepResolvConfOption, err: true},
+		{name: "zero timeout", ndots: KeepResolvConfOption, timeout: 0, err: true},
+		{name: "timeout too big", ndots: KeepResolvConfOption, timeout: 31, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := ndotsTimeoutOptions(test.ndots, test.timeout)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.options, options)
+		})
+	}
+}
+
+func Test_OverrideResolvConfOptions(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	original := []byte("nameserver 192.168.1.1\noptions edns0 ndots:1 attempts:3\n")
+	overrides := []string{"ndots:5", "timeout:2"}
+
+	assert.Equal(t, []string{"rotate"}, overrideResolvConfOptions(original, []string{"rotate"}, nil))
+	assert.Nil(t, overrideResolvConfOptions(original, nil, []string{}))
+	assert.Equal(t,
+		[]string{"edns0", "attempts:3", "ndots:5", "timeout:2"},
+		overrideResolvConfOptions(original, nil, overrides))
+	assert.Equal(t,
+		[]string{"rotate", "ndots:5", "timeout:2"},
+		overrideResolvConfOptions(original, []string{"timeout:9", "rotate"}, overrides))
+	assert.Equal(t, overrides, overrideResolvConfOptions(original, []string{}, overrides))
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/NordSecurity/nordvpn-linux/internal"
+)
+
+// ResolvConfWriteMode is the way resolv.conf is written when it is edited directly
+type ResolvConfWriteMode int
+
+const (
+	// AtomicRenameWriteMode writes a temporary file next to resolv.conf and renames it over
+	// resolv.conf, so that the readers never see a partially written file. The inode changes, so
+	// the tools watching the file or holding it open keep seeing the old one.
+	AtomicRenameWriteMode ResolvConfWriteMode = iota
+	// InPlaceWriteMode truncates and rewrites resolv.conf, which keeps its inode for the tools
+	// watching the file or holding it open, but a reader can see it partially written.
+	InPlaceWriteMode
+)
+
+func (m ResolvConfWriteMode) String() string {
+	switch m {
+	case AtomicRenameWriteMode:
+		return "atomic_rename"
+	case InPlaceWriteMode:
+		return "in_place"
+	default:
+		return fmt.Sprintf("%d", int(m))
+	}
+}
+
+// writeResolvConf writes the content to the file at path in the given mode
+func writeResolvConf(path string, content []byte, mode ResolvConfWriteMode) error {
+	if mode == InPlaceWriteMode {
+		return internal.FileWrite(path, content, internal.PermUserRWGroupROthersR)
+	}
+	return writeFileAtomically(path, content, internal.PermUserRWGroupROthersR)
+}
+
+// writeFileAtomically writes the content to a temporary file in the same directory and renames
+// it over the file. Symlinks are followed, so that the file they point to is replaced instead of
+// them. The temporary file is removed when writing fails.
+func writeFileAtomically(path string, content []byte, permissions os.FileMode) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("resolving %s: %w", path, err)
+		}
+		target = path
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	if err := writeTemporaryFile(file, content, permissions); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), target); err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+	return nil
+}
+
+// writeTemporaryFile writes the content to the file and closes it, the content is flushed to the
+// disk before the file is renamed, so that the file is never empty after a crash
+func writeTemporaryFile(file *os.File, content []byte, permissions os.FileMode) error {
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+	if err := file.Chmod(permissions); err != nil {
+		return fmt.Errorf("changing temporary file permissions: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("syncing temporary file: %w", err)
+	}
+	return file.Close()
+}
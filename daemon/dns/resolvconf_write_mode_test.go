@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inode returns the inode number of the file at path
+func inode(t *testing.T, path string) uint64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info.Sys().(*syscall.Stat_t).Ino
+}
+
+// dirEntries returns the names of the files in the directory
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func Test_WriteResolvConf(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		mode      ResolvConfWriteMode
+		sameInode bool
+	}{
+		{mode: AtomicRenameWriteMode, sameInode: false},
+		{mode: InPlaceWriteMode, sameInode: true},
+	}
+	for _, test := range tests {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "resolv.conf")
+			require.NoError(t, os.WriteFile(path, []byte(testOriginalResolvConf), 0600))
+			before := inode(t, path)
+
+			require.NoError(t, writeResolvConf(path, []byte(testVPNResolvConf), test.mode))
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, testVPNResolvConf, string(content))
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+			assert.Equal(t, test.sameInode, before == inode(t, path))
+			assert.Equal(t, []string{"resolv.conf"}, dirEntries(t, dir))
+		})
+	}
+}
+
+func Test_WriteResolvConfAtomicallyKeepsSymlink(t *testing.T) {
+	category.Set(t, category.File)
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "stub-resolv.conf")
+	link := filepath.Join(dir, "resolv.conf")
+	require.NoError(t, os.WriteFile(target, []byte(testOriginalResolvConf), 0644))
+	require.NoError(t, os.Symlink(target, link))
+
+	require.NoError(t, writeResolvConf(link, []byte(testVPNResolvConf), AtomicRenameWriteMode))
+
+	destination, err := os.Readlink(link)
+	require.NoError(t, err)
+	assert.Equal(t, target, destination)
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, testVPNResolvConf, string(content))
+	assert.ElementsMatch(t, []string{"resolv.conf", "stub-resolv.conf"}, dirEntries(t, dir))
+}
+
+func Test_WriteResolvConfAtomicallyRemovesTemporaryFileOnError(t *testing.T) {
+	category.Set(t, category.File)
+
+	dir := t.TempDir()
+	// files can't be renamed over directories
+	path := filepath.Join(dir, "resolv.conf")
+	require.NoError(t, os.Mkdir(path, 0755))
+
+	assert.Error(t, writeResolvConf(path, []byte(testVPNResolvConf), AtomicRenameWriteMode))
+	assert.Equal(t, []string{"resolv.conf"}, dirEntries(t, dir))
+}
+
+func Test_SetResolvConfWriteMode(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	file := &ResolvConfFile{logger: defaultLogger{}, analytics: &mockAnalytics{}}
+	setter := newTestSetter(&mockAnalytics{}, file)
+	assert.Equal(t, AtomicRenameWriteMode, file.writeMode)
+
+	assert.NoError(t, setter.SetResolvConfWriteMode(InPlaceWriteMode))
+	assert.Equal(t, InPlaceWriteMode, file.writeMode)
+	assert.Equal(t, "", writeModeApplied(file), "resolv.conf was not written")
+	file.written = []string{"103.86.96.100"}
+	assert.Equal(t, "in_place", writeModeApplied(file))
+
+	assert.Error(t, setter.SetResolvConfWriteMode(ResolvConfWriteMode(7)))
+	assert.Equal(t, InPlaceWriteMode, file.writeMode)
+}
+
+func Test_WriteResolvConfAtomicallySwapsNameservers(t *testing.T) {
+	category.Set(t, category.File)
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	previous := []byte("nameserver 192.168.1.1\n")
+	current := []byte(testVPNResolvConf)
+	require.NoError(t, os.WriteFile(path, previous, 0644))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	observed := [][]byte{}
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			content, err := os.ReadFile(path)
+			if err != nil || (string(content) != string(previous) && string(content) != string(current)) {
+				observed = append(observed, content)
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		content := current
+		if i%2 == 1 {
+			content = previous
+		}
+		require.NoError(t, writeResolvConf(path, content, AtomicRenameWriteMode))
+	}
+	close(done)
+	wg.Wait()
+
+	// readers see either the old or the new nameservers, never a missing or partial file
+	assert.Empty(t, observed)
+}
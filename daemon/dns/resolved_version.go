@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// execSystemctl defines systemctl executable
+	execSystemctl = "systemctl"
+	// unknownResolvedVersion is reported when systemd version can't be detected
+	unknownResolvedVersion = "unknown"
+)
+
+// detectResolvedVersion returns the version of systemd-resolved, which is released together
+// with systemd
+func detectResolvedVersion() string {
+	out, err := exec.Command(execSystemctl, "--version").Output()
+	if err != nil {
+		return unknownResolvedVersion
+	}
+	version, err := parseSystemdVersion(string(out))
+	if err != nil {
+		return unknownResolvedVersion
+	}
+	return version
+}
+
+// parseSystemdVersion extracts the version number from the systemctl --version output, e.g.
+// "systemd 255 (255.4-1ubuntu8)"
+func parseSystemdVersion(output string) (string, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "systemd" {
+		return "", fmt.Errorf("unexpected systemctl version output: %q", line)
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return "", fmt.Errorf("parsing systemd version %q: %w", fields[1], err)
+	}
+	return fields[1], nil
+}
@@ -0,0 +1,67 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseSystemdVersion(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name    string
+		output  string
+		version string
+		isErr   bool
+	}{
+		{
+			name:    "ubuntu",
+			output:  "systemd 255 (255.4-1ubuntu8.4)\n+PAM +AUDIT +SELINUX +APPARMOR +IMA +SMACK +SECCOMP +GCRYPT -GNUTLS\n",
+			version: "255",
+		},
+		{
+			name:    "debian",
+			output:  "systemd 252 (252.22-1~deb12u1)\n+PAM +AUDIT +SELINUX +APPARMOR +IMA\n",
+			version: "252",
+		},
+		{
+			name:    "rhel",
+			output:  "systemd 239 (239-78.el8)\n+PAM +AUDIT +SELINUX +IMA -APPARMOR +SMACK\n",
+			version: "239",
+		},
+		{
+			name:    "arch",
+			output:  "systemd 256 (256.5-1-arch)\n",
+			version: "256",
+		},
+		{
+			name:   "empty",
+			output: "",
+			isErr:  true,
+		},
+		{
+			name:   "not systemd",
+			output: "elogind 246.10\n",
+			isErr:  true,
+		},
+		{
+			name:   "invalid version",
+			output: "systemd v255\n",
+			isErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := parseSystemdVersion(test.output)
+			if test.isErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.version, version)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// resolverProbeTimeout is the default limit of probing a single nameserver, after which a
+	// silent nameserver is considered unreachable
+	resolverProbeTimeout = time.Second
+	// defaultProbeConcurrency is the default number of nameservers probed at once
+	defaultProbeConcurrency = 4
+)
+
+// nameserverQuery sends a single query to the nameserver at address
+type nameserverQuery func(
+	ctx context.Context,
+	address string,
+	name dnsmessage.Name,
+	qtype QueryType,
+) ([]netip.Addr, error)
+
+// ResolverProber is implemented by the setters which check if the nameservers they set respond
+type ResolverProber interface {
+	ProbeResolvers(ctx context.Context) (int, error)
+}
+
+// SetProbeConcurrency limits the number of nameservers probed at once by ProbeResolvers, e.g.
+// when many nameservers are set. The default limit is used when limit is lower than 1.
+func (d *DefaultSetter) SetProbeConcurrency(limit int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if limit < 1 {
+		limit = defaultProbeConcurrency
+	}
+	d.probeConcurrency = limit
+}
+
+// ProbeResolvers queries each of the nameservers set by NordVPN for the canary domain and
+// returns the number of unreachable ones, i.e. the ones which timed out or refused the
+// connection. Up to probeConcurrency nameservers are probed at once, each of them within
+// probeTimeout. Any response, including NXDOMAIN or SERVFAIL, means that the nameserver is
+// reachable. A non-critical resolver_unreachable error event is emitted when some of the
+// nameservers are unreachable.
+func (d *DefaultSetter) ProbeResolvers(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	nameservers := slices.Clone(d.applied)
+	port := d.dnsPort
+	domain := d.canaryDomain
+	timeout := d.probeTimeout
+	limit := d.probeConcurrency
+	query := d.queryNameserver
+	d.mu.Unlock()
+	if len(nameservers) == 0 {
+		return 0, errors.New("dns is not set")
+	}
+	name, err := dnsmessage.NewName(domain + ".")
+	if err != nil {
+		return 0, fmt.Errorf("invalid canary domain %q: %w", domain, err)
+	}
+
+	if limit < 1 {
+		limit = defaultProbeConcurrency
+	}
+	if query == nil {
+		query = queryNameserver
+	}
+
+	unreachable := make([]bool, len(nameservers))
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, nameserver := range nameservers {
+		select {
+		case <-ctx.Done():
+		case slots <- struct{}{}:
+		}
+		// remaining nameservers are not probed when the caller gave up
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			_, err := query(probeCtx, net.JoinHostPort(nameserver, port), name, QueryTypeA)
+			if isResolverUnreachable(err) {
+				d.logger.Debug(fmt.Sprintf("probing %s:", nameserver), err)
+				unreachable[i] = true
+			}
+		})
+	}
+	wg.Wait()
+	// timeouts are not the fault of the nameservers when the caller gave up, but the deadline of
+	// the caller is a shorter probe timeout
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return 0, fmt.Errorf("probing nameservers: %w", ctx.Err())
+	}
+
+	count := 0
+	for _, u := range unreachable {
+		if u {
+			count++
+		}
+	}
+	if count > 0 {
+		d.logger.Warn(fmt.Sprintf("%d of %d nameservers are unreachable", count, len(nameservers)))
+		d.analytics.emitResolversUnreachableEvent(ctx, count)
+	}
+	return count, nil
+}
+
+// isResolverUnreachable returns true when the query failed, because the nameserver did not
+// respond, refused the connection or there is no route to it
+func isResolverUnreachable(err error) bool {
+	return errors.Is(err, ErrLookupTimeout) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH)
+}
@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func Test_ProbeResolvers(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	// all of the servers listen on the same port, because it is shared by the nameservers
+	reachable := newMockDNSServer(t, rcode(dnsmessage.RCodeSuccess))
+	port := reachable.port()
+	// errors are answers as well, so the nameserver is reachable
+	newMockDNSServerAt(t, net.JoinHostPort("127.0.0.2", port), rcode(dnsmessage.RCodeServerFailure))
+	// nameserver which does not respond
+	newMockDNSServerAt(t, net.JoinHostPort("127.0.0.3", port), nil)
+
+	tests := []struct {
+		name        string
+		nameservers []string
+		unreachable int
+		errorEvents []mockErrorEvent
+	}{
+		{
+			name:        "all reachable",
+			nameservers: []string{"127.0.0.1", "127.0.0.2"},
+		},
+		{
+			name:        "timeout",
+			nameservers: []string{"127.0.0.1", "127.0.0.3"},
+			unreachable: 1,
+			errorEvents: []mockErrorEvent{{errorType: resolverUnreachableErrorType, resolversUnreachable: 1}},
+		},
+		{
+			name: "timeout and connection refused",
+			// nothing listens on 127.0.0.4
+			nameservers: []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4"},
+			unreachable: 2,
+			errorEvents: []mockErrorEvent{{errorType: resolverUnreachableErrorType, resolversUnreachable: 2}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics)
+			ds.applied = test.nameservers
+			ds.dnsPort = port
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			unreachable, err := ds.ProbeResolvers(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, test.unreachable, unreachable)
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+			// nameservers are probed in parallel
+			assert.Less(t, time.Since(start), 400*time.Millisecond)
+		})
+	}
+}
+
+func Test_ProbeResolversCanceled(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	server := newMockDNSServer(t, nil)
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics)
+	ds.applied = []string{"127.0.0.1"}
+	ds.dnsPort = server.port()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := ds.ProbeResolvers(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+func Test_ProbeResolversNotSet(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	_, err := newTestSetter(analytics).ProbeResolvers(context.Background())
+	assert.Error(t, err)
+	assert.Empty(t, analytics.getErrorEvents())
+}
+
+// countingQuery records the probed nameservers and the highest number of concurrent probes. Every
+// probe waits until release is closed.
+type countingQuery struct {
+	release chan struct{}
+	probed  []string
+	running int
+	peak    int
+	mu      sync.Mutex
+}
+
+func (q *countingQuery) query(
+	ctx context.Context,
+	address string,
+	_ dnsmessage.Name,
+	_ QueryType,
+) ([]netip.Addr, error) {
+	q.mu.Lock()
+	q.probed = append(q.probed, address)
+	q.running++
+	q.peak = max(q.peak, q.running)
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.running--
+		q.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ErrLookupTimeout
+	case <-q.release:
+		return nil, nil
+	}
+}
+
+func (q *countingQuery) probedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.probed)
+}
+
+func Test_ProbeResolversConcurrency(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	nameservers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+	query := &countingQuery{release: make(chan struct{})}
+	ds := newTestSetter(&mockAnalytics{})
+	ds.applied = nameservers
+	ds.dnsPort = defaultDNSPort
+	ds.queryNameserver = query.query
+	ds.SetProbeConcurrency(2)
+
+	time.AfterFunc(50*time.Millisecond, func() { close(query.release) })
+	unreachable, err := ds.ProbeResolvers(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, unreachable)
+	assert.Equal(t, 2, query.peak)
+
+	probed := slices.Clone(query.probed)
+	slices.Sort(probed)
+	expected := []string{}
+	for _, nameserver := range nameservers {
+		expected = append(expected, net.JoinHostPort(nameserver, defaultDNSPort))
+	}
+	assert.Equal(t, expected, probed)
+}
+
+func Test_ProbeResolversCanceledStopsRemaining(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	query := &countingQuery{release: make(chan struct{})}
+	ds := newTestSetter(&mockAnalytics{})
+	ds.applied = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	ds.queryNameserver = query.query
+	ds.SetProbeConcurrency(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err := ds.ProbeResolvers(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	// probe in flight is interrupted and the rest is never started
+	assert.Equal(t, 1, query.probedCount())
+}
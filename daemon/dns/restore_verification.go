@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+)
+
+// capturePreVPNResolvers saves the nameservers used by the system before DNS is set by NordVPN,
+// so that restoring them can be verified by Unset. Must be called with mu locked.
+func (d *DefaultSetter) capturePreVPNResolvers() {
+	resolvers, err := d.effectiveResolverAddresses()
+	if err != nil {
+		d.logger.Debug("reading pre-VPN resolvers, restoring them will not be verified:", err)
+		d.preVPNResolvers = nil
+		return
+	}
+	d.preVPNResolvers = resolvers
+}
+
+// effectiveResolverAddresses returns the addresses of the nameservers used by the system. Must
+// be called with mu locked.
+func (d *DefaultSetter) effectiveResolverAddresses() ([]string, error) {
+	resolvers, err := d.effectiveResolvers(context.Background(), d.resolvedMethod(), d.resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(resolvers))
+	for _, resolver := range resolvers {
+		addresses = append(addresses, resolver.Address.String())
+	}
+	return addresses, nil
+}
+
+// verifyRestore checks that the pre-VPN nameservers are used again after DNS was unset with the
+// method. Unsetting is retried once when they are not, e.g. because another DNS manager changed
+// the configuration in the meantime. Must be called with mu locked.
+func (d *DefaultSetter) verifyRestore(method Method, iface string) {
+	expected := d.preVPNResolvers
+	d.preVPNResolvers = nil
+	if expected == nil || d.isRestored(expected) {
+		return
+	}
+
+	d.logger.Warn("pre-VPN dns was not restored, unsetting dns again using:", method.Name())
+	if err := method.Unset(iface); err != nil {
+		d.logger.Error(fmt.Errorf("unsetting dns again with %s: %w", method.Name(), err))
+	}
+	if d.isRestored(expected) {
+		d.logger.Info("pre-VPN dns restored")
+		return
+	}
+	d.logger.Error("pre-VPN dns was not restored, nameservers were expected:", expected)
+	d.analytics.emitDNSConfigurationErrorEvent(context.Background(), restoreMismatchErrorType, severityByType)
+}
+
+// isRestored checks if the nameservers used by the system are the expected pre-VPN ones
+func (d *DefaultSetter) isRestored(expected []string) bool {
+	current, err := d.effectiveResolverAddresses()
+	if err != nil {
+		d.logger.Warn("reading resolvers after restoring dns:", err)
+		return false
+	}
+	return sameNameservers(current, expected)
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restoringMethod writes resolv.conf like the methods managing DNS. restored are the contents
+// written by the consecutive calls to Unset, the last one is repeated.
+type restoringMethod struct {
+	path     string
+	restored []string
+	unsets   int
+}
+
+func (m *restoringMethod) Set(iface string, nameservers []string) error {
+	return os.WriteFile(m.path, []byte(resolvConfFileContent(nameservers, nil, resolvConfDirectives{})), 0644)
+}
+
+func (m *restoringMethod) Unset(iface string) error {
+	content := m.restored[min(m.unsets, len(m.restored)-1)]
+	m.unsets++
+	return os.WriteFile(m.path, []byte(content), 0644)
+}
+
+func (m *restoringMethod) Name() string {
+	return "restoring"
+}
+
+func Test_UnsetVerifiesRestore(t *testing.T) {
+	category.Set(t, category.File)
+
+	const vpnResolvConf = "nameserver 103.86.96.100\n"
+	tests := []struct {
+		name        string
+		restored    []string
+		unsets      int
+		errorEvents []mockErrorEvent
+	}{
+		{
+			name:     "restored",
+			restored: []string{testOriginalResolvConf},
+			unsets:   1,
+		},
+		{
+			name:     "restored after retry",
+			restored: []string{vpnResolvConf, testOriginalResolvConf},
+			unsets:   2,
+		},
+		{
+			name:        "not restored",
+			restored:    []string{vpnResolvConf},
+			unsets:      2,
+			errorEvents: []mockErrorEvent{{errorType: restoreMismatchErrorType, critical: false}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "resolv.conf")
+			require.NoError(t, os.WriteFile(path, []byte(testOriginalResolvConf), 0644))
+			method := &restoringMethod{path: path, restored: test.restored}
+			analytics := &mockAnalytics{}
+			ds := newTestSetter(analytics, method)
+			ds.resolvConfPath = path
+
+			require.NoError(t, ds.Set("lo", []string{"103.86.96.100"}))
+			assert.Equal(t, nameserversFromResolvConf([]byte(testOriginalResolvConf)), ds.preVPNResolvers)
+			require.NoError(t, ds.Unset("lo"))
+
+			assert.Equal(t, test.unsets, method.unsets)
+			assert.Equal(t, test.errorEvents, analytics.getErrorEvents())
+			assert.Nil(t, ds.preVPNResolvers)
+		})
+	}
+}
+
+func Test_UnsetWithoutPreVPNResolvers(t *testing.T) {
+	category.Set(t, category.File)
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	method := &restoringMethod{path: path, restored: []string{"nameserver 103.86.96.100\n"}}
+	analytics := &mockAnalytics{}
+	ds := newTestSetter(analytics, method)
+	// resolv.conf does not exist, so the pre-VPN resolvers are not known
+	ds.resolvConfPath = path
+
+	require.NoError(t, ds.Set("lo", []string{"103.86.96.100"}))
+	require.NoError(t, ds.Unset("lo"))
+
+	assert.Equal(t, 1, method.unsets)
+	assert.Empty(t, analytics.getErrorEvents())
+}
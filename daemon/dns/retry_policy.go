@@ -0,0 +1,66 @@
+package dns
+
+import "time"
+
+// RetryPolicy gathers the timeouts and retries of the DNS operations, so that they are tuned in
+// one place. Zero values are replaced with the defaults, see DefaultRetryPolicy.
+type RetryPolicy struct {
+	// DBusTimeout limits the calls made to systemd-resolved by a single Set or Unset
+	DBusTimeout time.Duration
+	// SetRetries is the number of times setting DNS is retried when all of the methods fail,
+	// negative value disables retries
+	SetRetries int
+	// SetRetryDelay is the delay before the first retry, it doubles after every failed attempt
+	SetRetryDelay time.Duration
+	// ProbeTimeout limits probing of each of the nameservers after DNS was set
+	ProbeTimeout time.Duration
+	// IPv6ProbeTimeout limits the probe of an IPv6 nameserver before DNS is set
+	IPv6ProbeTimeout time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used when none is provided
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		DBusTimeout:      defaultDBusTimeout,
+		SetRetries:       defaultSetRetries,
+		SetRetryDelay:    setRetryBaseDelay,
+		ProbeTimeout:     resolverProbeTimeout,
+		IPv6ProbeTimeout: ipv6ProbeTimeout,
+	}
+}
+
+// withDefaults replaces zero values of the policy with the defaults
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	defaults := DefaultRetryPolicy()
+	if p.DBusTimeout <= 0 {
+		p.DBusTimeout = defaults.DBusTimeout
+	}
+	if p.SetRetries == 0 {
+		p.SetRetries = defaults.SetRetries
+	}
+	if p.SetRetryDelay <= 0 {
+		p.SetRetryDelay = defaults.SetRetryDelay
+	}
+	if p.ProbeTimeout <= 0 {
+		p.ProbeTimeout = defaults.ProbeTimeout
+	}
+	if p.IPv6ProbeTimeout <= 0 {
+		p.IPv6ProbeTimeout = defaults.IPv6ProbeTimeout
+	}
+	return p
+}
+
+// applyRetryPolicy configures all of the operations governed by the policy
+func (d *DefaultSetter) applyRetryPolicy(policy RetryPolicy) {
+	policy = policy.withDefaults()
+	d.SetDBusTimeout(policy.DBusTimeout)
+	d.SetRetries(policy.SetRetries)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retryDelay = exponentialRetryDelay(policy.SetRetryDelay)
+	d.probeTimeout = policy.ProbeTimeout
+	d.isIPv6Reachable = func(address string, domain string) bool {
+		return isIPv6NameserverReachable(address, domain, policy.IPv6ProbeTimeout)
+	}
+}
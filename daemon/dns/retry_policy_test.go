@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RetryPolicyWithDefaults(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, DefaultRetryPolicy(), RetryPolicy{}.withDefaults())
+
+	policy := RetryPolicy{SetRetries: -1, ProbeTimeout: time.Millisecond}.withDefaults()
+	assert.Equal(t, -1, policy.SetRetries)
+	assert.Equal(t, time.Millisecond, policy.ProbeTimeout)
+	assert.Equal(t, defaultDBusTimeout, policy.DBusTimeout)
+	assert.Equal(t, setRetryBaseDelay, policy.SetRetryDelay)
+	assert.Equal(t, ipv6ProbeTimeout, policy.IPv6ProbeTimeout)
+}
+
+func Test_ApplyRetryPolicy(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	// wedged systemd-resolved never responds
+	resolved.busctl = func(ctx context.Context, args ...string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	resolvectl := &Resolvectl{timeout: defaultDBusTimeout}
+	flaky := &flakyMethod{failures: 10}
+	ds := newTestSetter(analytics, resolved, resolvectl, flaky)
+	ds.applyRetryPolicy(RetryPolicy{
+		DBusTimeout:   10 * time.Millisecond,
+		SetRetries:    1,
+		SetRetryDelay: time.Millisecond,
+		ProbeTimeout:  20 * time.Millisecond,
+	})
+
+	assert.Equal(t, 10*time.Millisecond, resolved.timeout)
+	assert.Equal(t, 10*time.Millisecond, resolvectl.timeout)
+	assert.Equal(t, 4*time.Millisecond, ds.retryDelay(2))
+
+	// D-Bus calls are interrupted by the timeout of the policy and setting DNS is retried once
+	start := time.Now()
+	assert.ErrorIs(t, resolved.Set("lo", testVPNNameservers), errDBusTimeout)
+	assert.Less(t, time.Since(start), defaultDBusTimeout)
+	assert.Error(t, ds.Set("lo", testVPNNameservers))
+	assert.Equal(t, 2, flaky.attempts)
+
+	// silent nameserver is given up on after the probe timeout of the policy
+	server := newMockDNSServer(t, nil)
+	ds.applied = []string{"127.0.0.1"}
+	ds.dnsPort = server.port()
+	start = time.Now()
+	unreachable, err := ds.ProbeResolvers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, unreachable)
+	assert.Less(t, time.Since(start), resolverProbeTimeout)
+}
+
+func Test_ApplyRetryPolicyIPv6Probe(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	silent := newMockDNSServer(t, nil)
+	ds := newTestSetter(&mockAnalytics{})
+	ds.applyRetryPolicy(RetryPolicy{IPv6ProbeTimeout: 10 * time.Millisecond})
+
+	start := time.Now()
+	assert.False(t, ds.isIPv6Reachable(net.JoinHostPort("127.0.0.1", silent.port()), defaultCanaryDomain))
+	assert.Less(t, time.Since(start), ipv6ProbeTimeout)
+}
@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// catchAllDomain routes all of the domains to the link
+const catchAllDomain = "."
+
+// vpnLink names the VPN link in the route conflicts, the link itself is known only when DNS is
+// set
+const vpnLink = "the VPN interface"
+
+// errConflictingRoute means that a domain is routed to the nameservers of more than one link
+var errConflictingRoute = errors.New("conflicting routes")
+
+var domainLabelRegex = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?$`)
+
+// normalizeRoutingDomains validates the routing domains and brings them to the form used by
+// systemd-resolved. Domains are case insensitive, may have a trailing dot and the routing only
+// prefix "~", so e.g. "Example.com" and "~example.com." are the same domain. Both "~." and "."
+// stand for the catch-all domain.
+func normalizeRoutingDomains(domains map[string][]string) (map[string][]string, error) {
+	normalized := map[string][]string{}
+	for domain, nameservers := range domains {
+		name, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		if len(nameservers) == 0 {
+			return nil, fmt.Errorf("no nameservers provided for domain %q", domain)
+		}
+		if err := validateNameservers(nameservers); err != nil {
+			return nil, fmt.Errorf("domain %q: %w", domain, err)
+		}
+		if existing, ok := normalized[name]; ok && !sameNameservers(existing, nameservers) {
+			return nil, fmt.Errorf("conflicting nameservers provided for domain %q", name)
+		}
+		normalized[name] = slices.Clone(nameservers)
+	}
+	return normalized, nil
+}
+
+func normalizeDomain(domain string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(domain, "~"), "."))
+	if name == "" {
+		return catchAllDomain, nil
+	}
+	if len(name) > 253 {
+		return "", fmt.Errorf("domain %q is too long", domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 || !domainLabelRegex.MatchString(label) {
+			return "", fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return name, nil
+}
+
+// linkRoutingDomains returns sorted domains routed to the link. All of the domains are routed
+// if no routing domains are configured.
+func linkRoutingDomains(domains map[string][]string) []string {
+	if len(domains) == 0 {
+		return []string{catchAllDomain}
+	}
+	return slices.Sorted(maps.Keys(domains))
+}
+
+// linkNameservers returns nameservers of the link together with the nameservers of the routing
+// domains, without duplicates
+func linkNameservers(nameservers []string, domains map[string][]string) []string {
+	all := slices.Clone(nameservers)
+	for _, domain := range linkRoutingDomains(domains) {
+		for _, nameserver := range domains[domain] {
+			if !slices.Contains(all, nameserver) {
+				all = append(all, nameserver)
+			}
+		}
+	}
+	return all
+}
+
+// validateRoutes checks that every domain is routed to a single link. vpnDomains are the routing
+// domains of the VPN link and routes maps the other links to their routing domains. The other
+// links resolve only the domains dedicated to them, so the catch-all domain can't be routed to
+// them.
+func validateRoutes(vpnDomains map[string][]string, routes map[string]map[string][]string) error {
+	owners := map[string]string{}
+	for domain := range vpnDomains {
+		owners[domain] = vpnLink
+	}
+	for _, link := range slices.Sorted(maps.Keys(routes)) {
+		for _, domain := range slices.Sorted(maps.Keys(routes[link])) {
+			if domain == catchAllDomain {
+				return fmt.Errorf("%w: all domains can be routed only to %s, not to %s", errConflictingRoute, vpnLink, link)
+			}
+			if owner, ok := owners[domain]; ok {
+				return fmt.Errorf("%w: domain %q is routed to both %s and %s", errConflictingRoute, domain, owner, link)
+			}
+			owners[domain] = link
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NormalizeRoutingDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	tests := []struct {
+		name       string
+		domains    map[string][]string
+		normalized map[string][]string
+		isErr      bool
+	}{
+		{
+			name:       "no domains",
+			domains:    map[string][]string{},
+			normalized: map[string][]string{},
+		},
+		{
+			name: "domains are normalized",
+			domains: map[string][]string{
+				"~Corp.Example.com.": {"10.0.0.53"},
+				"nord":               {"100.64.0.1"},
+			},
+			normalized: map[string][]string{
+				"corp.example.com": {"10.0.0.53"},
+				"nord":             {"100.64.0.1"},
+			},
+		},
+		{
+			name: "catch-all domain",
+			domains: map[string][]string{
+				"~.":               {"103.86.96.100"},
+				"corp.example.com": {"10.0.0.53"},
+			},
+			normalized: map[string][]string{
+				".":                {"103.86.96.100"},
+				"corp.example.com": {"10.0.0.53"},
+			},
+		},
+		{
+			name: "same domain with the same nameservers",
+			domains: map[string][]string{
+				"example.com":  {"10.0.0.53", "10.0.0.54"},
+				"~example.com": {"10.0.0.54", "10.0.0.53"},
+			},
+			normalized: map[string][]string{
+				"example.com": {"10.0.0.53", "10.0.0.54"},
+			},
+		},
+		{
+			name: "conflicting catch-all domains",
+			domains: map[string][]string{
+				"~.": {"103.86.96.100"},
+				".":  {"10.0.0.53"},
+			},
+			isErr: true,
+		},
+		{
+			name: "conflicting domains",
+			domains: map[string][]string{
+				"example.com":  {"10.0.0.53"},
+				"EXAMPLE.COM.": {"10.0.0.54"},
+			},
+			isErr: true,
+		},
+		{
+			name:    "no nameservers",
+			domains: map[string][]string{"example.com": {}},
+			isErr:   true,
+		},
+		{
+			name:    "invalid nameserver",
+			domains: map[string][]string{"example.com": {"dns.example.com"}},
+			isErr:   true,
+		},
+		{
+			name:    "invalid domain",
+			domains: map[string][]string{"exa mple.com": {"10.0.0.53"}},
+			isErr:   true,
+		},
+		{
+			name:    "empty label",
+			domains: map[string][]string{"example..com": {"10.0.0.53"}},
+			isErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := normalizeRoutingDomains(test.domains)
+			if test.isErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, normalized, len(test.normalized))
+			for domain, nameservers := range test.normalized {
+				assert.ElementsMatch(t, nameservers, normalized[domain])
+			}
+		})
+	}
+}
+
+func Test_LinkRoutingDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, []string{"."}, linkRoutingDomains(nil))
+	domains := map[string][]string{
+		"example.com": {"10.0.0.53"},
+		".":           {"103.86.96.100"},
+		"corp":        {"10.0.0.53", "10.0.0.54"},
+	}
+	assert.Equal(t, []string{".", "corp", "example.com"}, linkRoutingDomains(domains))
+	assert.Equal(t,
+		[]string{"103.86.96.100", "10.0.0.53", "10.0.0.54"},
+		linkNameservers([]string{"103.86.96.100"}, domains))
+}
+
+func Test_ValidateRoutes(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	meshnet := map[string][]string{"nord": {"100.64.0.1"}}
+	tests := []struct {
+		name       string
+		vpnDomains map[string][]string
+		routes     map[string]map[string][]string
+		isErr      bool
+	}{
+		{name: "no routes"},
+		{
+			name:   "all other domains routed to vpn",
+			routes: map[string]map[string][]string{"nordlynx": meshnet},
+		},
+		{
+			name:       "distinct domains",
+			vpnDomains: map[string][]string{"example.com": {"10.0.0.53"}, ".": {"103.86.96.100"}},
+			routes: map[string]map[string][]string{
+				"nordlynx": meshnet,
+				"eth0":     {"corp": {"192.168.1.1"}},
+			},
+		},
+		{
+			name:       "subdomain of vpn domain",
+			vpnDomains: map[string][]string{"nord": {"103.86.96.100"}},
+			routes:     map[string]map[string][]string{"nordlynx": {"mesh.nord": {"100.64.0.1"}}},
+		},
+		{
+			name:       "domain routed to vpn",
+			vpnDomains: map[string][]string{"nord": {"103.86.96.100"}},
+			routes:     map[string]map[string][]string{"nordlynx": meshnet},
+			isErr:      true,
+		},
+		{
+			name: "domain routed to two interfaces",
+			routes: map[string]map[string][]string{
+				"nordlynx": meshnet,
+				"eth0":     {"nord": {"192.168.1.1"}},
+			},
+			isErr: true,
+		},
+		{
+			name:   "all domains routed to interface",
+			routes: map[string]map[string][]string{"nordlynx": {".": {"100.64.0.1"}}},
+			isErr:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateRoutes(test.vpnDomains, test.routes)
+			if test.isErr {
+				assert.ErrorIs(t, err, errConflictingRoute)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// maxSearchDomains is the number of search domains used by glibc (MAXDNSRCH)
+	maxSearchDomains = 6
+	// maxSearchListLength is the length of the search list accepted by glibc, including the
+	// spaces separating the domains
+	maxSearchListLength = 256
+)
+
+// normalizeSearchDomains validates the search domains and removes duplicates. Domains are case
+// insensitive and may have a trailing dot. The domains over the glibc limits are not rejected,
+// because systemd-resolved uses all of them, they are left out when resolv.conf is written.
+func normalizeSearchDomains(domains []string) ([]string, error) {
+	normalized := []string{}
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "~") {
+			return nil, fmt.Errorf("search domain %q can't be routing only", domain)
+		}
+		name, err := normalizeDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		if name == catchAllDomain {
+			return nil, fmt.Errorf("invalid search domain %q", domain)
+		}
+		if !slices.Contains(normalized, name) {
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized, nil
+}
+
+// limitSearchDomains returns the search domains used by glibc, the rest of them are ignored
+func limitSearchDomains(domains []string) []string {
+	limited := []string{}
+	for _, domain := range domains[:min(len(domains), maxSearchDomains)] {
+		if len(strings.Join(append(limited, domain), " ")) > maxSearchListLength {
+			break
+		}
+		limited = append(limited, domain)
+	}
+	return limited
+}
+
+// mergeSearchDomains returns the search domains of the original resolv.conf followed by the
+// added ones, without duplicates
+func mergeSearchDomains(original []byte, searchDomains []string) []string {
+	domains := []string{}
+	for _, domain := range append(searchDomainsFromResolvConf(original), searchDomains...) {
+		if !slices.Contains(domains, domain) {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// searchDomainCount returns the number of search domains applied by the method
+func searchDomainCount(method Method) int {
+	return len(appliedSearchDomains(method))
+}
+
+// appliedSearchDomains returns the search domains applied by the method
+func appliedSearchDomains(method Method) []string {
+	switch method := method.(type) {
+	case *Resolved:
+		return method.searchDomains
+	case *ResolvConfFile:
+		return method.searchDomains
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NormalizeSearchDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	// 4 domains of 63 characters and the separating spaces make exactly 255 characters
+	longDomains := []string{
+		strings.Repeat("a", 63), strings.Repeat("b", 63), strings.Repeat("c", 63), strings.Repeat("d", 63),
+	}
+
+	tests := []struct {
+		name       string
+		domains    []string
+		normalized []string
+		isErr      bool
+	}{
+		{
+			name:       "valid domains",
+			domains:    []string{"Corp.Example.com.", "lan", "corp.example.com", "my_host-1.internal"},
+			normalized: []string{"corp.example.com", "lan", "my_host-1.internal"},
+		},
+		{name: "no domains", normalized: []string{}},
+		{
+			name:       "count limit",
+			domains:    []string{"a", "b", "c", "d", "e", "f"},
+			normalized: []string{"a", "b", "c", "d", "e", "f"},
+		},
+		{
+			name:       "over count limit",
+			domains:    []string{"a", "b", "c", "d", "e", "f", "g"},
+			normalized: []string{"a", "b", "c", "d", "e", "f", "g"},
+		},
+		{name: "length limit", domains: longDomains, normalized: longDomains},
+		{name: "over length limit", domains: append(longDomains, "e"), normalized: append(longDomains, "e")},
+		{name: "label too long", domains: []string{strings.Repeat("a", 64) + ".com"}, isErr: true},
+		{name: "invalid label", domains: []string{"corp.-example.com"}, isErr: true},
+		{name: "empty label", domains: []string{"corp..com"}, isErr: true},
+		{name: "space in label", domains: []string{"corp example"}, isErr: true},
+		{name: "root domain", domains: []string{"."}, isErr: true},
+		{name: "routing only domain", domains: []string{"~example.com"}, isErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			normalized, err := normalizeSearchDomains(test.domains)
+			if test.isErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.normalized, normalized)
+		})
+	}
+}
+
+func Test_SetSearchDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	analytics := &mockAnalytics{}
+	resolved := newResolved(analytics, defaultLogger{})
+	file := &ResolvConfFile{logger: defaultLogger{}}
+	setter := newTestSetter(analytics, resolved, file)
+
+	assert.NoError(t, setter.SetSearchDomains([]string{"Example.com"}))
+	assert.Equal(t, []string{"example.com"}, resolved.searchDomains)
+	assert.Equal(t, []string{"example.com"}, file.searchDomains)
+	assert.Equal(t, 1, searchDomainCount(resolved))
+	assert.Equal(t, 1, searchDomainCount(file))
+	assert.Equal(t, 0, searchDomainCount(&MockMethod{}))
+
+	// invalid configuration does not change the previous one
+	assert.Error(t, setter.SetSearchDomains([]string{"example..com"}))
+	assert.Equal(t, []string{"example.com"}, resolved.searchDomains)
+
+	assert.NoError(t, setter.SetSearchDomains(nil))
+	assert.Empty(t, resolved.searchDomains)
+	assert.Equal(t, 0, searchDomainCount(file))
+}
+
+func Test_LimitSearchDomains(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	// 4 domains of 63 characters and the separating spaces make exactly 255 characters
+	longDomains := []string{
+		strings.Repeat("a", 63), strings.Repeat("b", 63), strings.Repeat("c", 63), strings.Repeat("d", 63),
+	}
+
+	tests := []struct {
+		name    string
+		domains []string
+		limited []string
+	}{
+		{name: "no domains", limited: []string{}},
+		{name: "count limit", domains: []string{"a", "b", "c", "d", "e", "f"}, limited: []string{"a", "b", "c", "d", "e", "f"}},
+		{name: "over count limit", domains: []string{"a", "b", "c", "d", "e", "f", "g"}, limited: []string{"a", "b", "c", "d", "e", "f"}},
+		{name: "length limit", domains: longDomains, limited: longDomains},
+		{name: "over length limit", domains: append(slices.Clone(longDomains), "e"), limited: longDomains},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.limited, limitSearchDomains(test.domains))
+		})
+	}
+}
+
+func Test_ResolvConfFileReportsSearchLimit(t *testing.T) {
+	category.Set(t, category.File)
+
+	tests := []struct {
+		name          string
+		original      string
+		searchDomains []string
+		appendMode    bool
+		written       []string
+		reported      bool
+	}{
+		{
+			name:          "within limits",
+			searchDomains: []string{"a", "b"},
+			written:       []string{"a", "b"},
+		},
+		{
+			name:          "over count limit",
+			searchDomains: []string{"a", "b", "c", "d", "e", "f", "g"},
+			written:       []string{"a", "b", "c", "d", "e", "f"},
+			reported:      true,
+		},
+		{
+			name:          "over count limit with the original domains in append mode",
+			original:      "nameserver 192.168.1.1\nsearch lan home corp\n",
+			searchDomains: []string{"a", "b", "c", "d"},
+			appendMode:    true,
+			written:       []string{"lan", "home", "corp", "a", "b", "c"},
+			reported:      true,
+		},
+		{
+			name:       "original domains only in append mode",
+			original:   "nameserver 192.168.1.1\nsearch a b c d e f g\n",
+			appendMode: true,
+			written:    []string{"a", "b", "c", "d", "e", "f", "g"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTemporaryNetnsDirs(t)
+			path := namespaceResolvConfPath("vrf-blue")
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte(test.original), 0644))
+
+			analytics := &mockAnalytics{}
+			file := &ResolvConfFile{
+				logger:        defaultLogger{},
+				analytics:     analytics,
+				namespace:     "vrf-blue",
+				searchDomains: test.searchDomains,
+				appendMode:    test.appendMode,
+			}
+			require.NoError(t, file.Set("lo", testVPNNameservers))
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, test.written, searchDomainsFromResolvConf(content))
+
+			var expected []mockErrorEvent
+			if test.reported {
+				expected = []mockErrorEvent{{errorType: searchLimitExceededErrorType}}
+			}
+			assert.Equal(t, expected, analytics.getErrorEvents())
+		})
+	}
+}
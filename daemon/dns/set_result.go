@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ResultSetter is implemented by the setters which describe what was applied
+type ResultSetter interface {
+	SetWithResult(iface string, nameservers []string) (SetResult, error)
+}
+
+// SetResult describes the DNS configuration applied by SetWithResult
+type SetResult struct {
+	// ManagementService is the service managing DNS on the host, e.g. systemd-resolved
+	ManagementService string
+	// Method is the name of the method which set DNS
+	Method string
+	// ResolverCount is the number of nameservers in use. In append mode, it includes the
+	// pre-VPN nameservers.
+	ResolverCount int
+	// Fallback is true when the preferred methods failed and a later one was used
+	Fallback bool
+	// IPv4Only is true when IPv6 nameservers were skipped after setting them failed
+	IPv4Only bool
+	// Skipped is true when the configuration was already in place, so it was not written again
+	Skipped bool
+	// Truncated is true when some of the nameservers were not written because of the resolv.conf
+	// nameserver limit
+	Truncated bool
+	// AppendMode is true when the nameservers were added after the pre-VPN ones
+	AppendMode bool
+	// SearchDomains are the search domains set together with the nameservers
+	SearchDomains []string
+}
+
+func (r SetResult) String() string {
+	flags := []string{}
+	for _, flag := range []struct {
+		name string
+		set  bool
+	}{
+		{name: "skipped", set: r.Skipped},
+		{name: "fallback", set: r.Fallback},
+		{name: "ipv4 only", set: r.IPv4Only},
+		{name: "truncated", set: r.Truncated},
+		{name: "append mode", set: r.AppendMode},
+	} {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	summary := fmt.Sprintf("%d resolvers set with %s (%s)", r.ResolverCount, r.Method, r.ManagementService)
+	if len(flags) > 0 {
+		summary += ", " + strings.Join(flags, ", ")
+	}
+	if len(r.SearchDomains) > 0 {
+		summary += ", search domains: " + strings.Join(r.SearchDomains, " ")
+	}
+	return summary
+}
+
+// isTruncated checks if some of the nameservers are missing from the written ones
+func isTruncated(nameservers []string, written []string) bool {
+	for _, nameserver := range nameservers {
+		if !slices.Contains(written, nameserver) {
+			return true
+		}
+	}
+	return false
+}
+
+// setResult describes the configuration set with the method. applied are the nameservers set
+// with the method out of the usable nameservers.
+func (d *DefaultSetter) setResult(method Method, applied []string, nameservers []string) SetResult {
+	result := SetResult{
+		ManagementService: managementServiceForMethod(method).String(),
+		Method:            method.Name(),
+		ResolverCount:     len(applied),
+		Fallback:          method != d.methods[0],
+		IPv4Only:          len(applied) != len(nameservers),
+		AppendMode:        isAppendModeApplied(method),
+		SearchDomains:     slices.Clone(appliedSearchDomains(method)),
+	}
+	// resolv.conf is not written when it is locked by the user. In append mode, it contains
+	// pre-VPN nameservers as well.
+	if file, ok := method.(*ResolvConfFile); ok {
+		result.ResolverCount = len(file.written)
+		result.Truncated = file.written != nil && isTruncated(applied, file.written)
+	}
+	return result
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SetWithResult(t *testing.T) {
+	category.Set(t, category.File)
+
+	nameservers := []string{"103.86.96.100", "2001:db8::1"}
+	tests := []struct {
+		name    string
+		methods func(t *testing.T) []Method
+		result  SetResult
+	}{
+		{
+			name: "systemd-resolved",
+			methods: func(t *testing.T) []Method {
+				resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+				resolved.busctl = (&mockBusctl{}).run
+				resolved.searchDomains = []string{"corp.example.com"}
+				return []Method{resolved}
+			},
+			result: SetResult{
+				ManagementService: "systemd-resolved",
+				Method:            "resolved",
+				ResolverCount:     2,
+				SearchDomains:     []string{"corp.example.com"},
+			},
+		},
+		{
+			name: "resolvconf after systemd-resolved failed",
+			methods: func(t *testing.T) []Method {
+				resolved := newResolved(&mockAnalytics{}, defaultLogger{})
+				resolved.busctl = (&mockBusctl{failing: []string{"SetLinkDNS"}}).run
+				resolvconf, _ := newTestResolvconf(t)
+				return []Method{resolved, resolvconf}
+			},
+			result: SetResult{
+				ManagementService: "resolvconf",
+				Method:            "resolvconf",
+				ResolverCount:     2,
+				Fallback:          true,
+			},
+		},
+		{
+			name: "ipv6 nameservers skipped",
+			methods: func(t *testing.T) []Method {
+				return []Method{&recordingMethod{
+					name:    "method",
+					ipv6Err: errors.New("ipv6 not supported"),
+					calls:   &[]string{},
+				}}
+			},
+			result: SetResult{
+				ManagementService: "unknown",
+				Method:            "method",
+				ResolverCount:     1,
+				IPv4Only:          true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := newTestSetter(&mockAnalytics{}, test.methods(t)...)
+			result, err := ds.SetWithResult("lo", nameservers)
+			require.NoError(t, err)
+			assert.Equal(t, test.result, result)
+		})
+	}
+}
+
+func Test_SetWithResultFailure(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	ds := newTestSetter(&mockAnalytics{}, &MockMethod{err: errors.New("failed")})
+	result, err := ds.SetWithResult("nordlynx", []string{"103.86.96.100"})
+	assert.Error(t, err)
+	assert.Equal(t, SetResult{}, result)
+}
+
+func Test_IsTruncated(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	nameservers := []string{"103.86.96.100", "103.86.99.100"}
+	assert.False(t, isTruncated(nameservers, nameservers))
+	// pre-VPN nameservers are written as well in append mode
+	assert.False(t, isTruncated(nameservers, append([]string{"127.0.0.1"}, nameservers...)))
+	assert.True(t, isTruncated(nameservers, []string{"192.168.1.1", "127.0.0.1", "103.86.96.100"}))
+}
+
+func Test_SetResultString(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	assert.Equal(t, "2 resolvers set with resolved (systemd-resolved)", SetResult{
+		ManagementService: "systemd-resolved",
+		Method:            "resolved",
+		ResolverCount:     2,
+	}.String())
+	assert.Equal(t,
+		"3 resolvers set with resolv.conf, default (unmanaged), fallback, truncated, append mode, "+
+			"search domains: corp.example.com lan",
+		SetResult{
+			ManagementService: "unmanaged",
+			Method:            "resolv.conf, default",
+			ResolverCount:     3,
+			Fallback:          true,
+			Truncated:         true,
+			AppendMode:        true,
+			SearchDomains:     []string{"corp.example.com", "lan"},
+		}.String())
+}
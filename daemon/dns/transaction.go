@@ -0,0 +1,55 @@
+package dns
+
+import "fmt"
+
+// transactionStep is a single change of the DNS configuration
+type transactionStep struct {
+	name  string
+	apply func() error
+	// rollback reverts the change, it can be nil if the change is reverted by the rollback of
+	// one of the previous steps or it can't be reverted
+	rollback func() error
+}
+
+// transaction applies DNS configuration step by step. When a step fails, the steps which were
+// already applied are rolled back in reverse order, so that the system is not left half
+// configured.
+type transaction struct {
+	logger  Logger
+	applied []transactionStep
+	// rolledBack is set when a step failed after some of the steps were applied
+	rolledBack bool
+}
+
+func newTransaction(logger Logger) *transaction {
+	return &transaction{logger: logger}
+}
+
+// apply the step, rolling back the transaction if it fails. Error of the step is returned as is.
+func (t *transaction) apply(step transactionStep) error {
+	if err := step.apply(); err != nil {
+		t.rollback()
+		return err
+	}
+	t.applied = append(t.applied, step)
+	return nil
+}
+
+// rollback is best effort, failed steps are logged and the remaining ones are still rolled back
+func (t *transaction) rollback() {
+	if len(t.applied) == 0 {
+		return
+	}
+	for i := len(t.applied) - 1; i >= 0; i-- {
+		step := t.applied[i]
+		if step.rollback == nil {
+			continue
+		}
+		t.logger.Info("rolling back:", step.name)
+		if err := step.rollback(); err != nil {
+			t.logger.Error(fmt.Sprintf("rolling back %s:", step.name), err)
+		}
+	}
+	t.applied = nil
+	t.rolledBack = true
+}
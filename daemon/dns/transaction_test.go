@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NordSecurity/nordvpn-linux/test/category"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TransactionRollsBackAppliedSteps(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	errStep := errors.New("step failed")
+	tests := []struct {
+		name       string
+		failing    string
+		rollbacks  []string
+		rolledBack bool
+	}{
+		{
+			name:      "all steps succeed",
+			rollbacks: []string{},
+		},
+		{
+			name:      "first step fails",
+			failing:   "first",
+			rollbacks: []string{},
+		},
+		{
+			name:       "second step fails",
+			failing:    "second",
+			rollbacks:  []string{"first"},
+			rolledBack: true,
+		},
+		{
+			name:       "third step fails",
+			failing:    "third",
+			rollbacks:  []string{"second", "first"},
+			rolledBack: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rollbacks := []string{}
+			step := func(name string) transactionStep {
+				return transactionStep{
+					name: name,
+					apply: func() error {
+						if name == test.failing {
+							return errStep
+						}
+						return nil
+					},
+					rollback: func() error {
+						rollbacks = append(rollbacks, name)
+						return nil
+					},
+				}
+			}
+
+			tx := newTransaction(defaultLogger{})
+			var err error
+			for _, name := range []string{"first", "second", "third"} {
+				if err = tx.apply(step(name)); err != nil {
+					break
+				}
+			}
+			if test.failing != "" {
+				assert.ErrorIs(t, err, errStep)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.rollbacks, rollbacks)
+			assert.Equal(t, test.rolledBack, tx.rolledBack)
+		})
+	}
+}
+
+func Test_TransactionRollbackIsBestEffort(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	logger := &mockLogger{}
+	tx := newTransaction(logger)
+	rollbacks := []string{}
+	assert.NoError(t, tx.apply(transactionStep{
+		name:  "first",
+		apply: func() error { return nil },
+		rollback: func() error {
+			rollbacks = append(rollbacks, "first")
+			return nil
+		},
+	}))
+	// step without rollback is skipped
+	assert.NoError(t, tx.apply(transactionStep{
+		name:  "second",
+		apply: func() error { return nil },
+	}))
+	assert.NoError(t, tx.apply(transactionStep{
+		name:  "third",
+		apply: func() error { return nil },
+		rollback: func() error {
+			rollbacks = append(rollbacks, "third")
+			return errors.New("rollback failed")
+		},
+	}))
+	assert.Error(t, tx.apply(transactionStep{
+		name:  "fourth",
+		apply: func() error { return errors.New("step failed") },
+	}))
+
+	assert.Equal(t, []string{"third", "first"}, rollbacks)
+	assert.True(t, tx.rolledBack)
+	messages := logger.getMessages()
+	require.Len(t, messages, 3)
+	assert.Equal(t, "error", messages[1].level)
+	assert.Contains(t, messages[1].message, "rolling back third: rollback failed")
+}
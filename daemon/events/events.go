@@ -43,6 +43,7 @@ func NewEventsEmpty() *Events {
 		&subs.Subject[bool]{},
 		&subs.Subject[events.DebuggerEvent]{},
 		&subs.Subject[any]{},
+		&subs.Subject[bool]{},
 	)
 }
 
@@ -73,6 +74,7 @@ func NewEvents(
 	mfa events.PublishSubcriber[bool],
 	devLogs events.PublishSubcriber[events.DebuggerEvent],
 	appFirstTimeOpened events.PublishSubcriber[any],
+	analyticsConsent events.PublishSubcriber[bool],
 ) *Events {
 	return &Events{
 		Settings: &SettingsEvents{
@@ -92,6 +94,7 @@ func NewEvents(
 			LANDiscovery:         lanDiscovery,
 			VirtualLocation:      virtualLocation,
 			PostquantumVPN:       postquantumVpn,
+			AnalyticsConsent:     analyticsConsent,
 		},
 		Service: &ServiceEvents{
 			Connect:         connect,
@@ -162,6 +165,8 @@ type SettingsEvents struct {
 	LANDiscovery         events.PublishSubcriber[bool]
 	VirtualLocation      events.PublishSubcriber[bool]
 	PostquantumVPN       events.PublishSubcriber[bool]
+	// AnalyticsConsent is true unless the user denied the consent to analytics
+	AnalyticsConsent events.PublishSubcriber[bool]
 }
 
 func (s *SettingsEvents) Subscribe(to SettingsPublisher) {
@@ -228,6 +233,7 @@ func (s *SettingsEvents) Publish(cfg config.Config) {
 	s.LANDiscovery.Publish(cfg.LanDiscovery)
 	s.VirtualLocation.Publish(cfg.VirtualLocation.Get())
 	s.PostquantumVPN.Publish(cfg.AutoConnectData.PostquantumVpn)
+	s.AnalyticsConsent.Publish(cfg.AnalyticsConsent != config.ConsentDenied)
 }
 
 // debugger events
@@ -61,6 +61,7 @@ func (r *RPC) SetAnalytics(ctx context.Context, in *pb.SetGenericRequest) (*pb.P
 			Type: internal.CodeConfigError,
 		}, nil
 	}
+	r.events.Settings.AnalyticsConsent.Publish(in.GetEnabled())
 
 	return &pb.Payload{Type: internal.CodeSuccess}, nil
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/NordSecurity/nordvpn-linux/config"
+	daemonevents "github.com/NordSecurity/nordvpn-linux/daemon/events"
 	"github.com/NordSecurity/nordvpn-linux/daemon/pb"
 	"github.com/NordSecurity/nordvpn-linux/internal"
 	"github.com/NordSecurity/nordvpn-linux/test/category"
@@ -122,9 +123,16 @@ func TestSetAnalytics(t *testing.T) {
 			analyticsMock.EnableErr = test.enableErr
 			analyticsMock.DisablErr = test.disableErr
 
+			events := daemonevents.NewEventsEmpty()
+			published := []bool{}
+			events.Settings.AnalyticsConsent.Subscribe(func(enabled bool) error {
+				published = append(published, enabled)
+				return nil
+			})
 			r := RPC{
 				analytics: &analyticsMock,
 				cm:        cfgMock,
+				events:    events,
 			}
 
 			response, err := r.SetAnalytics(context.Background(), &pb.SetGenericRequest{
@@ -134,6 +142,11 @@ func TestSetAnalytics(t *testing.T) {
 			assert.NoError(t, err, "Unexpected error when making the RPC request.")
 			assert.Equal(t, test.expectedResponse, response.Type, "Unexpected response to the RPC.")
 			assert.Equal(t, test.expectedConsentLevel, test.expectedConsentLevel, analyticsMock.State)
+			if test.expectedResponse == internal.CodeSuccess {
+				assert.Equal(t, []bool{test.requestedConsentLevel}, published)
+			} else {
+				assert.Empty(t, published, "consent must be published only after it was saved")
+			}
 		})
 	}
 }
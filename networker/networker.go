@@ -559,13 +559,39 @@ func (netw *Combined) SetDNS(nameservers []string) error {
 }
 
 func (netw *Combined) setDNS(nameservers []string) error {
-	err := netw.dnsSetter.Set(netw.vpnet.Tun().Interface().Name, nameservers)
+	iface := netw.vpnet.Tun().Interface().Name
+	if setter, ok := netw.dnsSetter.(dns.ResultSetter); ok {
+		result, err := setter.SetWithResult(iface, nameservers)
+		if err != nil {
+			return fmt.Errorf("networker setting dns: %w", err)
+		}
+		log.Println(internal.InfoPrefix, "dns set:", result)
+		netw.checkDNS()
+		return nil
+	}
+	err := netw.dnsSetter.Set(iface, nameservers)
 	if err != nil {
 		return fmt.Errorf("networker setting dns: %w", err)
 	}
+	netw.checkDNS()
 	return nil
 }
 
+// checkDNS probes the nameservers and, when the leak check is enabled, checks for DNS leaks after
+// DNS was set. The checks must not hold up connecting, problems are only reported.
+func (netw *Combined) checkDNS() {
+	if prober, ok := netw.dnsSetter.(dns.ResolverProber); ok {
+		go func() { _, _ = prober.ProbeResolvers(context.Background()) }()
+	}
+	if checker, ok := netw.dnsSetter.(dns.LeakChecker); ok && checker.IsLeakCheckEnabled() {
+		go func() {
+			if _, err := checker.CheckDNSLeak(context.Background()); err != nil {
+				log.Println(internal.WarningPrefix, "checking dns leak:", err)
+			}
+		}()
+	}
+}
+
 // UnsetDNS to original settings.
 func (netw *Combined) UnsetDNS() error {
 	netw.mu.Lock()
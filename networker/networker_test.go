@@ -79,6 +79,20 @@ type workingDNS struct{ setDNS []string }
 func (w *workingDNS) Set(_ string, dns []string) error { w.setDNS = dns; return nil }
 func (w *workingDNS) Unset(string) error               { w.setDNS = nil; return nil }
 
+// leakCheckingDNS reports every leak check on checked
+type leakCheckingDNS struct {
+	workingDNS
+	enabled bool
+	checked chan struct{}
+}
+
+func (l *leakCheckingDNS) IsLeakCheckEnabled() bool { return l.enabled }
+
+func (l *leakCheckingDNS) CheckDNSLeak(context.Context) (bool, error) {
+	l.checked <- struct{}{}
+	return false, nil
+}
+
 type failingDNS struct{}
 
 func (failingDNS) Set(string, []string) error { return mock.ErrOnPurpose }
@@ -624,6 +638,45 @@ func TestCombined_SetDNS(t *testing.T) {
 	}
 }
 
+func TestCombined_SetDNSChecksLeak(t *testing.T) {
+	category.Set(t, category.Unit)
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled %t", enabled), func(t *testing.T) {
+			setter := &leakCheckingDNS{enabled: enabled, checked: make(chan struct{}, 1)}
+			netw := NewCombined(
+				nil,
+				nil,
+				workingGateway{},
+				&subs.Subject[string]{},
+				workingRouter{},
+				setter,
+				&workingFirewall{},
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				nil,
+				0,
+				false,
+				&workingIpv6{},
+				false,
+				&mock.SysctlSetterMock{},
+			)
+			netw.vpnet = &mock.WorkingVPN{}
+			assert.NoError(t, netw.setDNS([]string{"103.86.96.100"}))
+			select {
+			case <-setter.checked:
+				assert.True(t, enabled, "dns leak must not be checked without the leak check hostname")
+			case <-time.After(100 * time.Millisecond):
+				assert.False(t, enabled, "dns leak was not checked after dns was set")
+			}
+		})
+	}
+}
+
 func TestCombined_UnsetDNS(t *testing.T) {
 	category.Set(t, category.Unit)
 